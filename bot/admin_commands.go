@@ -9,6 +9,7 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/twitch"
 	"github.com/sirupsen/logrus"
 )
 
@@ -269,7 +270,7 @@ func (b *NiaBot) handleAddReactionManagedRoleMessage(roleID string, opts string,
 			//Add reaction
 			err := b.DiscordSession().MessageReactionAdd(*chanID, *msgID, *emoteID)
 			if err != nil {
-				logrus.Error("Failed to add initial emote %v to message %v due to error %v", emoteID, msgID, err)
+				logrus.Errorf("Failed to add initial emote %v to message %v due to error %v", *emoteID, *msgID, err)
 			}
 		case "noremove":
 			noRemove = true
@@ -385,7 +386,7 @@ func (b *NiaBot) HandleInitReactionsMessage(msg *discordgo.MessageCreate) {
 				emoteID := role.RoleAssignment.ReactionRoleData.EmojiID
 				err := b.DiscordSession().MessageReactionAdd(chanID, msgID, emoteID)
 				if err != nil {
-					logrus.Error("Failed to add initial emote %v to message %v due to error %v", emoteID, msgID, err)
+					logrus.Errorf("Failed to add initial emote %v to message %v due to error %v", emoteID, msgID, err)
 				}
 			}
 		}
@@ -545,20 +546,9 @@ type failedRoleRuleReset struct {
 //Returns a list of members whose role could not be removed
 func (b *NiaBot) doRolePurge(msg *discordgo.Message, role *discordgo.Role) ([]failedRoleRemoval, []failedRoleRuleReset, error) {
 	//Get list of members with that role
-	var relevantMembers []*discordgo.Member
-	for member := range b.DiscordConnection.GuildMembersIter(msg.GuildID) {
-		if member.Error != nil {
-			return nil, nil, member.Error
-		} else if member.Member != nil {
-			userRoles := member.Member.Roles
-			for _, roleID := range userRoles {
-				//If user has the role
-				if roleID == role.ID {
-					relevantMembers = append(relevantMembers, member.Member)
-					break
-				}
-			}
-		}
+	relevantMembers, err := b.DiscordSession().GuildMembersWithRole(msg.GuildID, role.ID)
+	if err != nil {
+		return nil, nil, err
 	}
 	//Remove role from each member
 	var errs []failedRoleRemoval
@@ -593,108 +583,6 @@ func (b *NiaBot) doRolePurge(msg *discordgo.Message, role *discordgo.Role) ([]fa
 	return errs, failedRuleResets, nil
 }
 
-const handleSetNotificationChannelSyntax = "```" +
-	`!setnotificationchannel <notification_type> <channel>
-	currently the only supported <notification_type> is 'twitch'
-	<channel> can either be the name of a channel or a link to the channel (eg. #channel)` +
-	"```"
-
-var setnotificationchannelRegex = regexp.MustCompile(`^\s*(twitch)\s*("?(?:<#(?:\d+)>)|#?(?:[\w_-]+)"?\s*)`)
-
-//HandleSetNotificationChannel handles a message from an admin setting a certain channel as the target for
-//alert messages
-func (b *NiaBot) HandleSetNotificationChannel(msg *discordgo.MessageCreate) {
-	commandName := "!setnotificationchannel"
-	var result NiaResponse
-	//Check sender is admin
-	isFromAdmin, err := b.isFromAdmin(msg.Member, msg.Author, msg.GuildID)
-	if err != nil {
-		errorTxt := fmt.Sprintf("Failed to check if message came from admin due to error %v", err)
-		result = NiaResponseInternalError{
-			command:     commandName,
-			commandMsg:  msg.Content,
-			description: errorTxt,
-			timestamp:   time.Now(),
-		}
-	} else if !isFromAdmin {
-		errorTxt := "The !setnotificationchannel command can only be run by admins."
-		result = NiaResponseNotAllowed{
-			command:     commandName,
-			commandMsg:  msg.Content,
-			description: errorTxt,
-			timestamp:   time.Now(),
-		}
-	} else {
-		argString := strings.TrimPrefix(msg.Content, "!setnotificationchannel")
-		argString = strings.TrimLeft(argString, " ")
-		matches := setnotificationchannelRegex.FindStringSubmatch(argString)
-		if matches == nil {
-			result = NiaResponseSyntaxError{
-				command:     commandName,
-				commandMsg:  msg.Content,
-				description: fmt.Sprintf("*%v* doesn't seem to be the correct syntax for an !setnotificationchannel command", argString),
-				syntax:      handleSetNotificationChannelSyntax,
-				timestamp:   time.Now(),
-			}
-		} else {
-			ch, err := b.interpretChannelString(matches[2], msg.GuildID)
-			if err != nil {
-				result = NiaResponseInternalError{
-					command:     commandName,
-					commandMsg:  msg.Content,
-					description: fmt.Sprintf("Something unexpected went wrong whilst trying to read %v as a channel", matches[2]),
-					data:        map[string]string{"Error": err.Error()},
-					timestamp:   time.Now(),
-				}
-			} else {
-				switch matches[1] {
-				case "twitch":
-					channels := guildmodels.NotificationChannels{
-						StreamNotificationsChannel: &ch.ID,
-					}
-					err := b.DBConnection.UpdateGuildNotificationChannels(msg.GuildID, channels)
-					if err != nil {
-						result = NiaResponseInternalError{
-							command:     commandName,
-							commandMsg:  msg.Content,
-							description: "Something unexpected went wrong whilst trying to write update to database",
-							data:        map[string]string{"Error": err.Error()},
-							timestamp:   time.Now(),
-						}
-					} else {
-						result = NiaResponseSuccess{
-							command:    commandName,
-							commandMsg: msg.Content,
-							timestamp:  time.Now(),
-						}
-					}
-				default:
-					result = NiaResponseSyntaxError{
-						command:     commandName,
-						commandMsg:  msg.Content,
-						description: fmt.Sprintf("%v is not a valid notification channel type.", matches[1]),
-						syntax:      handleSetNotificationChannelSyntax,
-						timestamp:   time.Now(),
-					}
-				}
-			}
-		}
-	}
-	//Respond
-	result.WriteToLog()
-	resp := result.DiscordResponse()
-	msgRef := discordgo.MessageReference{
-		MessageID: msg.ID,
-		ChannelID: msg.ChannelID,
-		GuildID:   msg.GuildID,
-	}
-	resp.Reference = &msgRef
-	_, err = b.DiscordSession().ChannelMessageSendComplex(msg.ChannelID, resp)
-	if err != nil {
-		logrus.Errorf("Failed to send response to command due to error %v", err)
-	}
-}
-
 //HandleResetTwitchEventsub unsubscribes from all twitch eventsub events then recreates subscriptions for every
 //subscribed stream in the database
 func (b *NiaBot) HandleResetTwitchEventsub(msg *discordgo.MessageCreate) {
@@ -703,7 +591,7 @@ func (b *NiaBot) HandleResetTwitchEventsub(msg *discordgo.MessageCreate) {
 	//Check sender is admin
 	isFromAdmin := isDev(msg.Author.ID)
 	if !isFromAdmin {
-		errorTxt := "The !setnotificationchannel command can only be run by admins."
+		errorTxt := "The !resettwitcheventsub command can only be run by the bot developer."
 		result = NiaResponseNotAllowed{
 			command:     commandName,
 			commandMsg:  msg.Content,
@@ -725,7 +613,7 @@ func (b *NiaBot) HandleResetTwitchEventsub(msg *discordgo.MessageCreate) {
 					timestamp:   time.Now(),
 				}
 			} else {
-				twitchUIDs, err := b.DBConnection.GetAllTwitchUIDs()
+				twitchUIDs, err := b.DBConnection.GetAllStreamChannelIDs(twitch.ProviderName)
 				logrus.Debugf("Reinitializing twitch subscriptions for UIDs %v", twitchUIDs)
 				if err != nil {
 					result = NiaResponseInternalError{
@@ -775,6 +663,30 @@ func (b *NiaBot) HandleResetTwitchEventsub(msg *discordgo.MessageCreate) {
 /     Utility Functions
 /**************************/
 
+//checkAdmin returns nil if the provided message was sent by an admin, or a response explaining why the
+//command cannot be run otherwise.
+func (b *NiaBot) checkAdmin(commandName string, msg *discordgo.Message) NiaResponse {
+	isFromAdmin, err := b.isFromAdmin(msg.Member, msg.Author, msg.GuildID)
+	if err != nil {
+		errorTxt := fmt.Sprintf("Failed to check if message came from admin due to error %v", err)
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: errorTxt,
+			timestamp:   time.Now(),
+		}
+	} else if !isFromAdmin {
+		errorTxt := fmt.Sprintf("The %v command can only be run by admins.", commandName)
+		return NiaResponseNotAllowed{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: errorTxt,
+			timestamp:   time.Now(),
+		}
+	}
+	return nil
+}
+
 func (b *NiaBot) isFromAdmin(member *discordgo.Member, user *discordgo.User, guildID string) (bool, error) {
 	//Works if from dev
 	if isDev(user.ID) {
@@ -0,0 +1,375 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+)
+
+const handleAddAlertRouteSyntax = "```" +
+	`!addalertroute <channel> [filters]
+	<channel> can either be the name of a channel or a link to the channel (eg. #channel)
+	[filters] can be any number of the following:
+		game:"<game>": Only post streams of this game or category (may be repeated)
+		member:@<member>: Only post streams linked to this member (may be repeated)
+		role:@<role>: Only post streams linked to members with this role (may be repeated)
+		mature:<allow|deny|only>: Whether streams marked as mature should be posted
+		fallback: Only post here if no other route matched the stream
+	For example: !addalertroute #ffxiv-streams game:"Final Fantasy XIV Online"` +
+	"```"
+
+const handleRemoveAlertRouteSyntax = "```" +
+	`!removealertroute <id>
+	Route IDs can be found using !listalertroutes` +
+	"```"
+
+var alertRouteOptRegex = regexp.MustCompile(`(\w+):("[^"]*"|\S+)|(\S+)`)
+
+//HandleAddAlertRoute handles a message from an admin adding a new channel that stream alerts should be routed to
+//command format: !addalertroute <channel> [filters]
+func (b *NiaBot) HandleAddAlertRoute(msg *discordgo.MessageCreate) {
+	commandName := "!addalertroute"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.addAlertRoute(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) addAlertRoute(msg *discordgo.Message) NiaResponse {
+	commandName := "!addalertroute"
+	argString := strings.TrimSpace(strings.TrimPrefix(msg.Content, commandName))
+	tokens := alertRouteOptRegex.FindAllStringSubmatch(argString, -1)
+	if len(tokens) == 0 || tokens[0][3] == "" {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "You need to provide a channel for the alerts to be posted in",
+			syntax:      handleAddAlertRouteSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	ch, err := b.interpretChannelString(tokens[0][3], msg.GuildID)
+	if err != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("I couldn't work out which channel %v is: %v", tokens[0][3], err),
+			syntax:      handleAddAlertRouteSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	route := guildmodels.StreamAlertRoute{
+		RouteID:   newRouteID(),
+		ChannelID: ch.ID,
+	}
+	for _, token := range tokens[1:] {
+		key, value := token[1], strings.Trim(token[2], `"`)
+		switch {
+		case token[3] == "fallback":
+			route.Fallback = true
+		case key == "game" && value != "":
+			route.Games = append(route.Games, value)
+		case key == "member":
+			member, err := b.interpretMemberString(value, msg.GuildID)
+			if err != nil {
+				return NiaResponseSyntaxError{
+					command:     commandName,
+					commandMsg:  msg.Content,
+					description: fmt.Sprintf("I couldn't find the member %v", value),
+					syntax:      handleAddAlertRouteSyntax,
+					timestamp:   time.Now(),
+				}
+			}
+			route.MemberIDs = append(route.MemberIDs, member.User.ID)
+		case key == "role":
+			role, err := b.interpretRoleString(value, msg.GuildID)
+			if err != nil || role == nil {
+				return NiaResponseSyntaxError{
+					command:     commandName,
+					commandMsg:  msg.Content,
+					description: fmt.Sprintf("%v does not seem to be a valid role", value),
+					syntax:      handleAddAlertRouteSyntax,
+					timestamp:   time.Now(),
+				}
+			}
+			route.RoleIDs = append(route.RoleIDs, role.ID)
+		case key == "mature" && (value == guildmodels.MatureFilterAllow || value == guildmodels.MatureFilterDeny || value == guildmodels.MatureFilterOnly):
+			route.Mature = value
+		default:
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: fmt.Sprintf("I didn't understand the filter %v", token[0]),
+				syntax:      handleAddAlertRouteSyntax,
+				timestamp:   time.Now(),
+			}
+		}
+	}
+
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	//This also carries over any channel set up before routes existed
+	routes := append(guild.NotificationChannels.AlertRoutes(), route)
+	err = b.DBConnection.SetGuildAlertRoutes(msg.GuildID, routes)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Something unexpected went wrong whilst trying to write update to database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//HandleRemoveAlertRoute handles a message from an admin removing one of the guild's stream alert routes
+//command format: !removealertroute <id>
+func (b *NiaBot) HandleRemoveAlertRoute(msg *discordgo.MessageCreate) {
+	commandName := "!removealertroute"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.removeAlertRoute(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) removeAlertRoute(msg *discordgo.Message) NiaResponse {
+	commandName := "!removealertroute"
+	routeID := strings.TrimSpace(strings.TrimPrefix(msg.Content, commandName))
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	oldRoutes := guild.NotificationChannels.AlertRoutes()
+	newRoutes := make([]guildmodels.StreamAlertRoute, 0, len(oldRoutes))
+	for _, route := range oldRoutes {
+		if route.RouteID != routeID {
+			newRoutes = append(newRoutes, route)
+		}
+	}
+	if len(newRoutes) == len(oldRoutes) {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("There isn't an alert route with ID %v", routeID),
+			syntax:      handleRemoveAlertRouteSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	err = b.DBConnection.SetGuildAlertRoutes(msg.GuildID, newRoutes)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Something unexpected went wrong whilst trying to write update to database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//HandleListAlertRoutes handles a message from an admin asking for a list of the guild's stream alert routes
+//command format: !listalertroutes
+func (b *NiaBot) HandleListAlertRoutes(msg *discordgo.MessageCreate) {
+	commandName := "!listalertroutes"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.listAlertRoutes(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) listAlertRoutes(msg *discordgo.Message) NiaResponse {
+	commandName := "!listalertroutes"
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	routes := guild.NotificationChannels.AlertRoutes()
+	fields := make([]*discordgo.MessageEmbedField, 0, len(routes))
+	for _, route := range routes {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("Route %v", route.RouteID),
+			Value: describeAlertRoute(route),
+		})
+	}
+	description := fmt.Sprintf("This server has %d stream alert route(s).", len(routes))
+	if len(routes) == 0 {
+		description = "This server doesn't have any stream alert routes yet. Add one with !addalertroute."
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       "Stream alert routes",
+		description: description,
+		fields:      fields,
+		timestamp:   time.Now(),
+	}
+}
+
+/**************************
+/     Utility Functions
+/**************************/
+
+//matchAlertRoutes returns the IDs of the channels that an alert for a stream should be posted in. memberRoles may
+//be nil if the stream isn't linked to a member.
+func matchAlertRoutes(routes []guildmodels.StreamAlertRoute, game string, isMature bool, memberID string, memberRoles []string) []string {
+	var matched, fallbacks []string
+	for _, route := range routes {
+		if !alertRouteMatches(route, game, isMature, memberID, memberRoles) {
+			continue
+		}
+		if route.Fallback {
+			fallbacks = appendUnique(fallbacks, route.ChannelID)
+		} else {
+			matched = appendUnique(matched, route.ChannelID)
+		}
+	}
+	if len(matched) == 0 {
+		return fallbacks
+	}
+	return matched
+}
+
+func alertRouteMatches(route guildmodels.StreamAlertRoute, game string, isMature bool, memberID string, memberRoles []string) bool {
+	switch route.Mature {
+	case guildmodels.MatureFilterDeny:
+		if isMature {
+			return false
+		}
+	case guildmodels.MatureFilterOnly:
+		if !isMature {
+			return false
+		}
+	}
+	if len(route.Games) > 0 {
+		found := false
+		for _, g := range route.Games {
+			if strings.EqualFold(g, game) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(route.MemberIDs) > 0 && !containsString(route.MemberIDs, memberID) {
+		return false
+	}
+	if len(route.RoleIDs) > 0 {
+		found := false
+		for _, roleID := range memberRoles {
+			if containsString(route.RoleIDs, roleID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func routesNeedMemberRoles(routes []guildmodels.StreamAlertRoute) bool {
+	for _, route := range routes {
+		if len(route.RoleIDs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func describeAlertRoute(route guildmodels.StreamAlertRoute) string {
+	lines := []string{fmt.Sprintf("Channel: <#%v>", route.ChannelID)}
+	if len(route.Games) > 0 {
+		lines = append(lines, fmt.Sprintf("Games: %v", strings.Join(route.Games, ", ")))
+	}
+	if len(route.MemberIDs) > 0 {
+		lines = append(lines, fmt.Sprintf("Members: %v", mentionMembers(route.MemberIDs)))
+	}
+	if len(route.RoleIDs) > 0 {
+		mentions := make([]string, len(route.RoleIDs))
+		for i, roleID := range route.RoleIDs {
+			mentions[i] = fmt.Sprintf("<@&%v>", roleID)
+		}
+		lines = append(lines, fmt.Sprintf("Roles: %v", strings.Join(mentions, ", ")))
+	}
+	if route.Mature != "" {
+		lines = append(lines, fmt.Sprintf("Mature streams: %v", route.Mature))
+	}
+	if route.Fallback {
+		lines = append(lines, "Only used when no other route matches")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func newRouteID() string {
+	buf := make([]byte, 3)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(s []string, v string) []string {
+	if containsString(s, v) {
+		return s
+	}
+	return append(s, v)
+}
+
+func removeString(s []string, v string) []string {
+	var res []string
+	for _, e := range s {
+		if e != v {
+			res = append(res, e)
+		}
+	}
+	return res
+}
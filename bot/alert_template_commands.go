@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+const handleSetAlertTemplateSyntax = "```" +
+	`!setalerttemplate <part> <value>
+	<part> can be one of the following:
+		title <template>: Sets the title of the alert embed
+		description <template>: Sets the main text of the alert embed
+		content <template>: Sets the text posted above the alert embed
+		colour <#rrggbb|default>: Sets the colour of the alert embed
+		ping <role|here|none>: Pings a role or @here with each alert
+		addfield [inline] "<name>" <template>: Adds a field to the alert embed
+		clearfields: Removes all fields from the alert embed
+		reset: Reverts to the default alert
+	Templates use Go template syntax and can contain the following placeholders:
+		{{.Streamer}}, {{.Title}}, {{.Game}}, {{.Viewers}}, {{.Member}}, {{.URL}} and {{.Platform}}
+	For example: !setalerttemplate description {{.Member}} is live playing {{.Game}}!` +
+	"```"
+
+var setAlertTemplateRegex = regexp.MustCompile(`(?s)^\s*(title|description|content|colou?r|ping|addfield|clearfields|reset)\b\s*(.*?)\s*$`)
+var addAlertFieldRegex = regexp.MustCompile(`(?s)^(inline\s+)?"([^"]+)"\s+(.+)$`)
+var colourRegex = regexp.MustCompile(`^#?([0-9a-fA-F]{6})$`)
+
+//HandleSetAlertTemplate handles a message from an admin changing part of the template used for stream alerts
+//command format: !setalerttemplate <part> <value>
+func (b *NiaBot) HandleSetAlertTemplate(msg *discordgo.MessageCreate) {
+	commandName := "!setalerttemplate"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.setAlertTemplate(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) setAlertTemplate(msg *discordgo.Message) NiaResponse {
+	commandName := "!setalerttemplate"
+	argString := strings.TrimPrefix(msg.Content, commandName)
+	matches := setAlertTemplateRegex.FindStringSubmatch(argString)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("*%v* doesn't seem to be the correct syntax for a !setalerttemplate command", argString),
+			syntax:      handleSetAlertTemplateSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	var tmpl guildmodels.AlertTemplate
+	if guild.StreamAlertTemplate != nil {
+		tmpl = *guild.StreamAlertTemplate
+	}
+
+	part, value := matches[1], matches[2]
+	switch part {
+	case "title":
+		tmpl.Title = value
+	case "description":
+		tmpl.Description = value
+	case "content":
+		tmpl.Content = value
+	case "colour", "color":
+		colourMatches := colourRegex.FindStringSubmatch(value)
+		switch {
+		case value == "default":
+			tmpl.Colour = nil
+		case colourMatches == nil:
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: fmt.Sprintf("%v doesn't look like a hex colour code (eg. #6441a5)", value),
+				syntax:      handleSetAlertTemplateSyntax,
+				timestamp:   time.Now(),
+			}
+		default:
+			colour, _ := strconv.ParseInt(colourMatches[1], 16, 32)
+			colourInt := int(colour)
+			tmpl.Colour = &colourInt
+		}
+	case "ping":
+		switch value {
+		case "here", "@here":
+			tmpl.PingHere = true
+			tmpl.PingRoleID = ""
+		case "none", "":
+			tmpl.PingHere = false
+			tmpl.PingRoleID = ""
+		default:
+			role, err := b.interpretRoleString(value, msg.GuildID)
+			if err != nil {
+				return NiaResponseInternalError{
+					command:     commandName,
+					commandMsg:  msg.Content,
+					description: fmt.Sprintf("Something unexpected went wrong whilst trying to read %v as a role", value),
+					data:        map[string]string{"Error": err.Error()},
+					timestamp:   time.Now(),
+				}
+			} else if role == nil {
+				return NiaResponseSyntaxError{
+					command:     commandName,
+					commandMsg:  msg.Content,
+					description: fmt.Sprintf("%v does not seem to be a valid role", value),
+					syntax:      handleSetAlertTemplateSyntax,
+					timestamp:   time.Now(),
+				}
+			}
+			tmpl.PingHere = false
+			tmpl.PingRoleID = role.ID
+		}
+	case "addfield":
+		fieldMatches := addAlertFieldRegex.FindStringSubmatch(value)
+		if fieldMatches == nil {
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: "Fields need a name in double quotation marks followed by a value",
+				syntax:      handleSetAlertTemplateSyntax,
+				timestamp:   time.Now(),
+			}
+		}
+		tmpl.Fields = append(tmpl.Fields, guildmodels.AlertTemplateField{
+			Name:   fieldMatches[2],
+			Value:  fieldMatches[3],
+			Inline: fieldMatches[1] != "",
+		})
+	case "clearfields":
+		tmpl.Fields = nil
+	case "reset":
+		tmpl = guildmodels.AlertTemplate{}
+	}
+
+	//Make sure the new template can actually be used before saving it
+	_, err = renderAlertTemplate(guildAlertTemplate(&guildmodels.DiscordGuild{StreamAlertTemplate: &tmpl}), sampleAlertData(msg.Author.Mention()))
+	if err != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("That template doesn't work: %v", err),
+			syntax:      handleSetAlertTemplateSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	err = b.DBConnection.UpdateGuildAlertTemplate(msg.GuildID, &tmpl)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Something unexpected went wrong whilst trying to write update to database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//HandlePreviewAlert handles a message from an admin asking to see what a stream alert would look like using the
+//current template. Nobody will be pinged by the preview.
+//command format: !previewalert
+func (b *NiaBot) HandlePreviewAlert(msg *discordgo.MessageCreate) {
+	commandName := "!previewalert"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result != nil {
+		b.respondToCommand(msg.Message, result)
+		return
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		b.respondToCommand(msg.Message, NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		})
+		return
+	}
+	preview, err := renderAlertTemplate(guildAlertTemplate(guild), sampleAlertData(msg.Author.Mention()))
+	if err != nil {
+		b.respondToCommand(msg.Message, NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("The current alert template doesn't work: %v", err),
+			syntax:      handleSetAlertTemplateSyntax,
+			timestamp:   time.Now(),
+		})
+		return
+	}
+	preview.Embed.Timestamp = time.Now().Format(time.RFC3339)
+	preview.Embed.Author = &discordgo.MessageEmbedAuthor{
+		URL:  preview.Embed.URL,
+		Name: sampleAlertData("").Streamer,
+	}
+	preview.AllowedMentions = &discordgo.MessageAllowedMentions{}
+	preview.Reference = &discordgo.MessageReference{
+		MessageID: msg.ID,
+		ChannelID: msg.ChannelID,
+		GuildID:   msg.GuildID,
+	}
+	_, err = b.DiscordSession().ChannelMessageSendComplex(msg.ChannelID, preview)
+	if err != nil {
+		logrus.Errorf("Failed to send alert preview due to error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+)
+
+//streamAlertData contains the values which can be used as placeholders in a guild's stream alert template
+type streamAlertData struct {
+	//Display name of the streamer
+	Streamer string
+	//Title of the stream
+	Title string
+	//Name of the game or category being streamed
+	Game string
+	//Current number of viewers
+	Viewers int
+	//A mention for the discord member the stream is linked to, or an empty string if there isn't one
+	Member string
+	//Link to the stream
+	URL string
+	//Name of the platform being streamed on, eg. Twitch
+	Platform string
+}
+
+//sampleAlertData returns some made up stream details for previewing and validating alert templates
+func sampleAlertData(memberMention string) streamAlertData {
+	return streamAlertData{
+		Streamer: "NiaTheStreamer",
+		Title:    "Savage raid prog, send help",
+		Game:     "Final Fantasy XIV Online",
+		Viewers:  42,
+		Member:   memberMention,
+		URL:      "https://twitch.tv/niathestreamer",
+		Platform: "Twitch",
+	}
+}
+
+//guildAlertTemplate returns the alert template to be used for the provided guild, filling in any blanks from the
+//default template.
+func guildAlertTemplate(guild *guildmodels.DiscordGuild) guildmodels.AlertTemplate {
+	res := guildmodels.DefaultAlertTemplate()
+	if guild == nil || guild.StreamAlertTemplate == nil {
+		return res
+	}
+	custom := *guild.StreamAlertTemplate
+	if custom.Title == "" {
+		custom.Title = res.Title
+	}
+	if custom.Description == "" {
+		custom.Description = res.Description
+	}
+	return custom
+}
+
+//renderAlertTemplate builds an alert post from the provided template and stream details. The embed thumbnail,
+//author and timestamp are left for the caller to fill in.
+func renderAlertTemplate(tmpl guildmodels.AlertTemplate, data streamAlertData) (*discordgo.MessageSend, error) {
+	content, err := executeAlertTemplateString("content", tmpl.Content, data)
+	if err != nil {
+		return nil, err
+	}
+	title, err := executeAlertTemplateString("title", tmpl.Title, data)
+	if err != nil {
+		return nil, err
+	}
+	description, err := executeAlertTemplateString("description", tmpl.Description, data)
+	if err != nil {
+		return nil, err
+	}
+	colour := twitchColourHex
+	if tmpl.Colour != nil {
+		colour = *tmpl.Colour
+	}
+	var fields []*discordgo.MessageEmbedField
+	for i, field := range tmpl.Fields {
+		name, err := executeAlertTemplateString(fmt.Sprintf("field %d name", i+1), field.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		value, err := executeAlertTemplateString(fmt.Sprintf("field %d value", i+1), field.Value, data)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   name,
+			Value:  value,
+			Inline: field.Inline,
+		})
+	}
+
+	//Work out who should be pinged
+	allowedMentions := discordgo.MessageAllowedMentions{}
+	switch {
+	case tmpl.PingHere:
+		content = "@here " + content
+		allowedMentions.Parse = []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeEveryone}
+	case tmpl.PingRoleID != "":
+		content = fmt.Sprintf("<@&%v> %v", tmpl.PingRoleID, content)
+		allowedMentions.Roles = []string{tmpl.PingRoleID}
+	}
+
+	embed := discordgo.MessageEmbed{
+		Title:       title,
+		Type:        discordgo.EmbedTypeRich,
+		Description: description,
+		URL:         data.URL,
+		Color:       colour,
+		Fields:      fields,
+	}
+	msg := discordgo.MessageSend{
+		Content:         content,
+		Embed:           &embed,
+		AllowedMentions: &allowedMentions,
+	}
+	return &msg, nil
+}
+
+func executeAlertTemplateString(name, tmplStr string, data streamAlertData) (string, error) {
+	if tmplStr == "" {
+		return "", nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(tmplStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse alert %v template: %v", name, err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to fill in alert %v template: %v", name, err)
+	}
+	return buf.String(), nil
+}
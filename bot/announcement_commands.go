@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+)
+
+const handleSetAnnouncementSyntax = "```" +
+	`!setannouncement <event> <channel> [options] [template]
+	<event> can be raid, follow or subscribe
+	<channel> can either be the name of a channel or a link to the channel (eg. #channel)
+	[options] can be any of the following:
+		every:<n>: Follows only; announce each time a follower count reaches a multiple of n (default 100)
+		membersonly: Raids only; only announce raids where the raided channel is also linked to a member
+	[template] is the text of the announcement, using Go template syntax with the following placeholders:
+		{{.Streamer}}, {{.Member}} and {{.URL}} for every event
+		{{.Target}}, {{.TargetMember}} and {{.Viewers}} for raids
+		{{.User}} and {{.Followers}} for follows
+		{{.User}}, {{.Tier}} and {{.Gift}} for subscriptions
+	For example: !setannouncement raid #raids membersonly {{.Member}} raided {{.TargetMember}}!` +
+	"```"
+
+const handleRemoveAnnouncementSyntax = "```" +
+	`!removeannouncement <event>
+	<event> can be raid, follow or subscribe` +
+	"```"
+
+var setAnnouncementRegex = regexp.MustCompile(`(?s)^!setannouncement\s+(?P<event>raid|follow|subscribe)\s+(?P<channel>\S+)(?P<opts>(?:\s+(?:every:\d+|membersonly))*)\s*(?P<template>.*?)\s*$`)
+var removeAnnouncementRegex = regexp.MustCompile(`^!removeannouncement\s+(?P<event>raid|follow|subscribe)\s*$`)
+
+//HandleSetAnnouncement handles a message from an admin setting where and how a twitch community event is announced
+//command format: !setannouncement <event> <channel> [options] [template]
+func (b *NiaBot) HandleSetAnnouncement(msg *discordgo.MessageCreate) {
+	commandName := "!setannouncement"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.setAnnouncement(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) setAnnouncement(msg *discordgo.Message) NiaResponse {
+	commandName := "!setannouncement"
+	matches := setAnnouncementRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleSetAnnouncementSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	eventType := matches[setAnnouncementRegex.SubexpIndex("event")]
+	channelStr := matches[setAnnouncementRegex.SubexpIndex("channel")]
+	ch, err := b.interpretChannelString(channelStr, msg.GuildID)
+	if err != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("I couldn't work out which channel %v is: %v", channelStr, err),
+			syntax:      handleSetAnnouncementSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	announcement := guildmodels.Announcement{
+		ChannelID: ch.ID,
+		Template:  matches[setAnnouncementRegex.SubexpIndex("template")],
+	}
+	for _, opt := range strings.Fields(matches[setAnnouncementRegex.SubexpIndex("opts")]) {
+		switch {
+		case opt == "membersonly" && eventType == guildmodels.AnnouncementRaid:
+			announcement.MembersOnly = true
+		case strings.HasPrefix(opt, "every:") && eventType == guildmodels.AnnouncementFollow:
+			step, err := strconv.Atoi(strings.TrimPrefix(opt, "every:"))
+			if err != nil || step <= 0 {
+				return NiaResponseSyntaxError{
+					command:     commandName,
+					commandMsg:  msg.Content,
+					description: fmt.Sprintf("%v should be a positive number of followers", opt),
+					syntax:      handleSetAnnouncementSyntax,
+					timestamp:   time.Now(),
+				}
+			}
+			announcement.MilestoneStep = step
+		default:
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: fmt.Sprintf("The %v option can't be used with %v announcements", opt, eventType),
+				syntax:      handleSetAnnouncementSyntax,
+				timestamp:   time.Now(),
+			}
+		}
+	}
+	//Make sure the template works before saving it
+	preview, err := renderAnnouncement(eventType, &announcement, sampleCommunityEventData(msg.Author.Mention()))
+	if err != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: err.Error(),
+			syntax:      handleSetAnnouncementSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	err = b.DBConnection.SetGuildAnnouncement(msg.GuildID, eventType, &announcement)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Something unexpected went wrong whilst trying to write update to database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       fmt.Sprintf("%v announcements will be posted in #%v", strings.Title(eventType), ch.Name),
+		description: fmt.Sprintf("They will look something like this:\n%v", preview),
+		timestamp:   time.Now(),
+	}
+}
+
+//HandleRemoveAnnouncement handles a message from an admin who no longer wants a twitch community event announced
+//command format: !removeannouncement <event>
+func (b *NiaBot) HandleRemoveAnnouncement(msg *discordgo.MessageCreate) {
+	commandName := "!removeannouncement"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.removeAnnouncement(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) removeAnnouncement(msg *discordgo.Message) NiaResponse {
+	commandName := "!removeannouncement"
+	matches := removeAnnouncementRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleRemoveAnnouncementSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	err := b.DBConnection.SetGuildAnnouncement(msg.GuildID, matches[removeAnnouncementRegex.SubexpIndex("event")], nil)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Something unexpected went wrong whilst trying to write update to database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
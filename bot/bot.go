@@ -2,57 +2,146 @@ package bot
 
 import (
 	"net/url"
+	"os"
+	"strconv"
+	"sync"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/callummance/nia/db"
 	"github.com/callummance/nia/discord"
+	"github.com/callummance/nia/dispatch"
+	"github.com/callummance/nia/streaming"
 	"github.com/callummance/nia/twitch"
+	"github.com/callummance/nia/youtube"
 	"github.com/prometheus/common/log"
 	"github.com/sirupsen/logrus"
 )
 
+//eventWorkersEnvVar sets how many discord and stream events can be handled at once
+const eventWorkersEnvVar string = "NIA_EVENT_WORKERS"
+const defaultEventWorkers = 8
+
+//eventQueueSizeEnvVar sets how many events can be waiting to be handled before new events have to wait for room
+const eventQueueSizeEnvVar string = "NIA_EVENT_QUEUE_SIZE"
+const defaultEventQueueSize = 1000
+
 //NiaBot represents an instance of the discord bot, containing handles to the various external connections.
 type NiaBot struct {
 	DiscordConnection *discord.EventSource
-	DBConnection      *db.Connection
+	DBConnection      db.Store
 	TwitchConnection  *twitch.EventSource
+	//discordAPI is used for everything the bot does in discord. It is normally DiscordConnection, but can be replaced
+	//with a fake.
+	discordAPI discord.API
+	//streamProviders contains every streaming provider which has been enabled, keyed by provider name
+	streamProviders     map[string]streaming.Provider
+	streamProvidersLock sync.RWMutex
+	//pendingVerifications contains links waiting for their owner to log in to the provider, keyed by OAuth state
+	pendingVerifications     map[string]*pendingVerification
+	pendingVerificationsLock sync.Mutex
+	//presence keeps track of members who are streaming according to their discord presence
+	presence *presenceProvider
+	//scheduleSyncLock prevents guilds' scheduled events from being synced by more than one thing at once
+	scheduleSyncLock sync.Mutex
+	//teamSyncLock prevents followed twitch teams' rosters from being updated by more than one thing at once
+	teamSyncLock sync.Mutex
+	//events runs handlers for discord and stream events, keeping those for the same guild member or stream in order
+	events *dispatch.Dispatcher
+	//stop is closed when the bot is shutting down to stop any background tasks
+	stop chan struct{}
 }
 
 //Init creates a new NiaBot instance
 func Init() (*NiaBot, error) {
-	var res NiaBot
+	res := newNiaBot()
 	//Start database connection
-	db, err := db.Init()
+	db, err := db.Open()
 	if err != nil {
 		logrus.Errorf("Cannot start bot due to error initializing database connection: %v", err)
 		return nil, err
 	}
+	res.DBConnection = db
 
 	//Start discord connection
-	disc, err := discord.StartDiscordListener(&res)
+	disc, err := discord.StartDiscordListener(res, res.events)
 	if err != nil {
 		logrus.Errorf("Cannot start bot due to error initializing discord connection: %v", err)
 		return nil, err
 	}
+	res.DiscordConnection = disc
+	res.discordAPI = disc
 
 	//Try to start twitch connection
-	db.WaitTablesRead()
-	twitchUIDs, err := db.GetAllTwitchUIDs()
+	if disc.PresencesEnabled() {
+		res.clearPresenceStreams()
+	}
+	twitchUIDs, err := db.GetAllStreamChannelIDs(twitch.ProviderName)
 	if err != nil {
 		logrus.Errorf("Failed to initialize twitch listener due to error %v. Continuing without twitch functionality.", err)
 	} else {
-		twitch, err := twitch.StartTwitchListener(&res, twitchUIDs)
+		t, err := twitch.StartTwitchListener(res, twitchUIDs, res.events)
 		if err != nil {
 			logrus.Errorf("Failed to initialize twitch listener due to error %v. Continuing without twitch functionality.", err)
 		} else {
-			res.TwitchConnection = twitch
+			res.TwitchConnection = t
+			res.addStreamProvider(t)
 		}
 	}
 
-	res.DiscordConnection = disc
-	res.DBConnection = db
+	//Try to start youtube connection
+	youtubeChannels, err := db.GetAllStreamChannelIDs(youtube.ProviderName)
+	if err != nil {
+		logrus.Errorf("Failed to initialize youtube listener due to error %v. Continuing without youtube functionality.", err)
+	} else {
+		y, err := youtube.StartYoutubeListener(res, youtubeChannels, res.events)
+		if err != nil {
+			logrus.Errorf("Failed to initialize youtube listener due to error %v. Continuing without youtube functionality.", err)
+		} else {
+			res.addStreamProvider(y)
+		}
+	}
+
+	go res.runSessionSampler(res.stop)
+	go res.runScheduleSync(res.stop)
+	go res.runClipsFeed(res.stop)
+	go res.runTeamSync(res.stop)
+	go res.runGuildPurge(res.stop)
 
-	return &res, nil
+	return res, nil
+}
+
+//New creates a NiaBot which acts on discord through the provided API and stores its data in the provided database. It
+//doesn't connect to the discord gateway or any streaming providers, or start any background tasks; events are handled
+//by calling its handler methods directly. This allows the bot to be run against a fake discord such as
+//fakediscord.API, and an in-memory store such as db.MemoryStore.
+func New(api discord.API, database db.Store) *NiaBot {
+	res := newNiaBot()
+	res.discordAPI = api
+	res.DBConnection = database
+	return res
+}
+
+func newNiaBot() *NiaBot {
+	return &NiaBot{
+		streamProviders:      make(map[string]streaming.Provider),
+		pendingVerifications: make(map[string]*pendingVerification),
+		presence:             newPresenceProvider(),
+		events:               dispatch.New(envInt(eventWorkersEnvVar, defaultEventWorkers), envInt(eventQueueSizeEnvVar, defaultEventQueueSize)),
+		stop:                 make(chan struct{}),
+	}
+}
+
+//addStreamProvider enables a streaming provider
+func (b *NiaBot) addStreamProvider(p streaming.Provider) {
+	b.streamProvidersLock.Lock()
+	defer b.streamProvidersLock.Unlock()
+	b.streamProviders[p.Info().Name] = p
+}
+
+//streamProvider returns the enabled provider with the given name, or nil if it isn't enabled
+func (b *NiaBot) streamProvider(name string) streaming.Provider {
+	b.streamProvidersLock.RLock()
+	defer b.streamProvidersLock.RUnlock()
+	return b.streamProviders[name]
 }
 
 //BotAddURL generates a URL that can be used to add the bot to a server
@@ -60,14 +149,38 @@ func (b *NiaBot) BotAddURL() (*url.URL, error) {
 	return b.DiscordConnection.BotAddURL()
 }
 
-//DiscordSession returns a handle to the underlying discord session
-func (b *NiaBot) DiscordSession() *discordgo.Session {
-	return b.DiscordConnection.Session()
+//DiscordSession returns the API used to act on discord
+func (b *NiaBot) DiscordSession() discord.API {
+	return b.discordAPI
 }
 
 //Close cleanly terminates the bot instance
 func (b *NiaBot) Close() {
 	log.Info("Terminating bot...")
-	b.DiscordConnection.Close()
+	close(b.stop)
+	if b.DiscordConnection != nil {
+		b.DiscordConnection.Close()
+	}
+	b.streamProvidersLock.RLock()
+	for _, p := range b.streamProviders {
+		p.Close()
+	}
+	b.streamProvidersLock.RUnlock()
+	//Let any events which have already been received finish before the database connection goes away
+	b.events.Close()
 	b.DBConnection.Close()
 }
+
+//envInt reads a positive integer from an environment variable, falling back to def if it is unset or invalid
+func envInt(envVar string, def int) int {
+	str, exists := os.LookupEnv(envVar)
+	if !exists {
+		return def
+	}
+	val, err := strconv.Atoi(str)
+	if err != nil || val <= 0 {
+		logrus.Warnf("Invalid value `%v` for %v, falling back to default of %v", str, envVar, def)
+		return def
+	}
+	return val
+}
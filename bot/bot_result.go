@@ -160,7 +160,10 @@ type NiaResponseInternalError struct {
 //DiscordResponse builds a MessageSend object which can be sent back to whoever sent a command message.
 func (r NiaResponseInternalError) DiscordResponse() *discordgo.MessageSend {
 	description := fmt.Sprintf("Oops! I encountered an unexpected error whilst running your %v command. Please try again later or file a bug report.", r.command)
-	dataWithDescription := r.data
+	dataWithDescription := make(map[string]string, len(r.data)+1)
+	for k, v := range r.data {
+		dataWithDescription[k] = v
+	}
 	dataWithDescription["Error"] = r.description
 	embed := discordgo.MessageEmbed{
 		Title:       "Oops, something went wrong ;w;",
@@ -271,9 +274,68 @@ func (r NiaResponseFeatureNotEnabled) WriteToLog() {
 	logrus.Infof("%v Rejected command `%v` as required feature %v is not loaded", logLineLabel(r.timestamp), r.commandMsg, r.disabledFeature)
 }
 
+//NiaResponseInfo will be returned by commands which look up and display some information
+type NiaResponseInfo struct {
+	//The base command name
+	command string
+	//The entire text contents of the message
+	commandMsg string
+	//The title of the embed
+	title string
+	//A human-readable summary of the information
+	description string
+	//Embed fields containing the information, in the order they should be displayed
+	fields []*discordgo.MessageEmbedField
+	//The time the response was created at
+	timestamp time.Time
+}
+
+//DiscordResponse builds a MessageSend object which can be sent back to whoever sent a command message.
+func (r NiaResponseInfo) DiscordResponse() *discordgo.MessageSend {
+	embed := discordgo.MessageEmbed{
+		Title:       r.title,
+		Type:        discordgo.EmbedTypeRich,
+		Description: r.description,
+		Timestamp:   r.timestamp.Format(time.RFC3339),
+		Color:       successMessageColour,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Log ID: %d", r.timestamp.UnixNano()),
+		},
+		Fields: r.fields,
+	}
+	msg := discordgo.MessageSend{
+		Embed: &embed,
+		TTS:   false,
+		Files: []*discordgo.File{},
+	}
+	return &msg
+}
+
+//WriteToLog dumps data on a discord command response to the log
+func (r NiaResponseInfo) WriteToLog() {
+	logrus.Infof("%v Completed command %v successfully with %d fields.", logLineLabel(r.timestamp), r.commandMsg, len(r.fields))
+}
+
 /////////////////////
 //Utility Functions//
 /////////////////////
+
+//respondToCommand logs a command result and sends it as a reply to the message containing the command
+func (b *NiaBot) respondToCommand(msg *discordgo.Message, result NiaResponse) {
+	result.WriteToLog()
+	resp := result.DiscordResponse()
+	msgRef := discordgo.MessageReference{
+		MessageID: msg.ID,
+		ChannelID: msg.ChannelID,
+		GuildID:   msg.GuildID,
+	}
+	resp.Reference = &msgRef
+	_, err := b.DiscordSession().ChannelMessageSendComplex(msg.ChannelID, resp)
+	if err != nil {
+		logrus.Errorf("Failed to send response to command due to error %v", err)
+	}
+}
+
 func writeLogRef(t time.Time) string {
 	return fmt.Sprintf("More details can be found on log line %v", t.UnixNano())
 }
@@ -294,3 +356,27 @@ func stringMapToFields(fields map[string]string) []*discordgo.MessageEmbedField
 	}
 	return res
 }
+
+//linesToFields packs the provided lines into as few embed fields as possible without going over discord's limit on
+//field length. Any fields after the first will have their name suffixed with (cont.)
+func linesToFields(name string, lines []string) []*discordgo.MessageEmbedField {
+	const maxFieldLength = 1024
+	var res []*discordgo.MessageEmbedField
+	fieldName := name
+	current := ""
+	for _, line := range lines {
+		if current != "" && len(current)+len(line)+1 > maxFieldLength {
+			res = append(res, &discordgo.MessageEmbedField{Name: fieldName, Value: current})
+			fieldName = name + " (cont.)"
+			current = ""
+		}
+		if current != "" {
+			current += "\n"
+		}
+		current += line
+	}
+	if current != "" {
+		res = append(res, &discordgo.MessageEmbedField{Name: fieldName, Value: current})
+	}
+	return res
+}
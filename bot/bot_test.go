@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/callummance/nia/db"
+	"github.com/callummance/nia/discord/fakediscord"
+)
+
+const testBotUserID = "700000000000000001"
+
+//testGuild is a guild in a fake discord, along with a bot acting on it which stores its data in memory
+type testGuild struct {
+	bot   *NiaBot
+	api   *fakediscord.API
+	store db.Store
+	gid   string
+}
+
+//newTestGuild creates a bot with a fake discord containing a single guild. The bot is closed when the test finishes.
+func newTestGuild(t *testing.T) *testGuild {
+	api := fakediscord.New(testBotUserID)
+	store := db.NewMemoryStore()
+	b := New(api, store)
+	t.Cleanup(b.Close)
+	g := api.AddGuild("Test Guild", "700000000000000002")
+	return &testGuild{
+		bot:   b,
+		api:   api,
+		store: store,
+		gid:   g.ID,
+	}
+}
+
+func (g *testGuild) addRole(t *testing.T, name string) string {
+	t.Helper()
+	role, err := g.api.AddRole(g.gid, name)
+	if err != nil {
+		t.Fatalf("failed to add role %v: %v", name, err)
+	}
+	return role.ID
+}
+
+func (g *testGuild) addChannel(t *testing.T, name string) string {
+	t.Helper()
+	ch, err := g.api.AddChannel(g.gid, name)
+	if err != nil {
+		t.Fatalf("failed to add channel %v: %v", name, err)
+	}
+	return ch.ID
+}
+
+func (g *testGuild) addMember(t *testing.T, uid, name string) {
+	t.Helper()
+	_, err := g.api.AddMember(g.gid, uid, name)
+	if err != nil {
+		t.Fatalf("failed to add member %v: %v", name, err)
+	}
+}
+
+//memberHasRole returns true if a member currently has a role according to the fake discord
+func (g *testGuild) memberHasRole(t *testing.T, uid, roleID string) bool {
+	t.Helper()
+	member, err := g.api.GuildMember(g.gid, uid)
+	if err != nil {
+		t.Fatalf("failed to look up member %v: %v", uid, err)
+	}
+	for _, r := range member.Roles {
+		if r == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+//messages returns the messages in a channel of the fake discord
+func (g *testGuild) messages(t *testing.T, channelID string) []string {
+	t.Helper()
+	msgs, err := g.api.Messages(channelID)
+	if err != nil {
+		t.Fatalf("failed to look up messages in channel %v: %v", channelID, err)
+	}
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
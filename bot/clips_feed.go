@@ -0,0 +1,261 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/twitch"
+	"github.com/sirupsen/logrus"
+)
+
+//clipPollIntervalEnvVar sets how often linked broadcasters are checked for new clips
+const clipPollIntervalEnvVar string = "NIA_TWITCH_CLIP_POLL_INTERVAL"
+const defaultClipPollInterval = 15 * time.Minute
+
+//clipLookback is how old a clip can be and still be posted, giving clips time to pick up views
+const clipLookback = 7 * 24 * time.Hour
+
+//defaultClipMinViews is the view threshold used if an admin doesn't provide one
+const defaultClipMinViews = 10
+
+const handleClipsFeedSyntax = "```" +
+	`!clipsfeed <channel> [minviews]
+!clipsfeed off
+	<channel> can either be the name of a channel or a link to the channel (eg. #channel)
+	[minviews] is the number of views a clip needs before it is posted, and defaults to ` + "10" +
+	"```"
+
+var clipsFeedRegex = regexp.MustCompile(`^!clipsfeed\s+(?:(?P<off>off)|(?P<channel>\S+)(?:\s+(?P<minviews>\d+))?)\s*$`)
+
+//runClipsFeed periodically posts new clips to every guild's clips feed until stop is closed
+func (b *NiaBot) runClipsFeed(stop <-chan struct{}) {
+	interval := defaultClipPollInterval
+	if intervalStr, exists := os.LookupEnv(clipPollIntervalEnvVar); exists {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			logrus.Warnf("Invalid clip poll interval `%v`, falling back to default of %v", intervalStr, defaultClipPollInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.pollClips()
+		}
+	}
+}
+
+//pollClips posts any clips of linked broadcasters which have reached each guild's view threshold and haven't yet been
+//posted there
+func (b *NiaBot) pollClips() {
+	t := b.TwitchConnection
+	if t == nil {
+		return
+	}
+	guilds, err := b.DBConnection.GetGuildsWithClipsFeed()
+	if err != nil {
+		logrus.Errorf("Failed to look up guilds with a clips feed due to error %v", err)
+		return
+	}
+	now := time.Now()
+	//Clips are cached so that broadcasters linked in several guilds are only looked up once
+	clipsByBroadcaster := make(map[string][]twitch.Clip)
+	for _, guild := range guilds {
+		feed := guild.ClipsFeed
+		since := now.Add(-clipLookback)
+		if feed.Since.After(since) {
+			since = feed.Since
+		}
+		members, err := b.DBConnection.GetGuildStreamLinks(guild.DiscordGID)
+		if err != nil {
+			logrus.Errorf("Failed to look up linked members of guild %v whilst polling clips due to error %v", guild.DiscordGID, err)
+			continue
+		}
+		//Maps each linked broadcaster to the members they are linked to
+		broadcasters := make(map[string][]string)
+		for _, member := range members {
+			if twitchUID := member.Connections.StreamLinks[twitch.ProviderName]; twitchUID != "" {
+				broadcasters[twitchUID] = append(broadcasters[twitchUID], member.UserID)
+			}
+		}
+		var candidates []twitch.Clip
+		for twitchUID := range broadcasters {
+			clips, fetched := clipsByBroadcaster[twitchUID]
+			if !fetched {
+				clips, err = t.GetClips(twitchUID, now.Add(-clipLookback))
+				if err != nil {
+					logrus.Warnf("Failed to fetch clips for twitch user %v due to error %v", twitchUID, err)
+				}
+				clipsByBroadcaster[twitchUID] = clips
+			}
+			for _, clip := range clips {
+				if !clip.CreatedAt.Before(since) && clip.ViewCount >= feed.MinViews {
+					candidates = append(candidates, clip)
+				}
+			}
+		}
+		b.postClips(guild.DiscordGID, feed, candidates, broadcasters)
+	}
+	err = b.DBConnection.PrunePostedClips(now.Add(-clipLookback - 24*time.Hour))
+	if err != nil {
+		logrus.Warnf("Failed to prune old posted clips due to error %v", err)
+	}
+}
+
+//postClips posts each of the provided clips which hasn't already been posted to a guild's clips feed, oldest first
+func (b *NiaBot) postClips(gid string, feed *guildmodels.ClipsFeed, clips []twitch.Clip, broadcasters map[string][]string) {
+	if len(clips) == 0 {
+		return
+	}
+	clipIDs := make([]string, len(clips))
+	for i, clip := range clips {
+		clipIDs[i] = clip.ID
+	}
+	posted, err := b.DBConnection.GetPostedClips(gid, clipIDs)
+	if err != nil {
+		//Better to miss clips than to post them twice
+		return
+	}
+	sort.Slice(clips, func(i, j int) bool {
+		return clips[i].CreatedAt.Before(clips[j].CreatedAt)
+	})
+	for _, clip := range clips {
+		if posted[clip.ID] {
+			continue
+		}
+		msg, err := b.DiscordSession().ChannelMessageSendComplex(feed.ChannelID, &discordgo.MessageSend{
+			Embed: clipEmbed(&clip, broadcasters[clip.BroadcasterID]),
+		})
+		if err != nil {
+			logrus.Warnf("Failed to post clip %v to channel %v in guild %v due to error %v", clip.ID, feed.ChannelID, gid, err)
+			continue
+		}
+		err = b.DBConnection.AddPostedClip(&guildmodels.PostedClip{
+			GuildID:   gid,
+			ClipID:    clip.ID,
+			CreatedAt: clip.CreatedAt,
+			Post: guildmodels.MessageRef{
+				GuildID:   gid,
+				ChannelID: feed.ChannelID,
+				MessageID: msg.ID,
+			},
+		})
+		if err != nil {
+			logrus.Warnf("Failed to take note of posted clip %v due to error %v", clip.ID, err)
+		}
+	}
+}
+
+//clipEmbed builds the embed used to post a clip
+func clipEmbed(clip *twitch.Clip, memberIDs []string) *discordgo.MessageEmbed {
+	streamer := clip.BroadcasterName
+	if len(memberIDs) > 0 {
+		streamer = fmt.Sprintf("%v (%v)", clip.BroadcasterName, mentionMembers(memberIDs))
+	}
+	return &discordgo.MessageEmbed{
+		Title:       clip.Title,
+		URL:         clip.URL,
+		Type:        discordgo.EmbedTypeRich,
+		Description: fmt.Sprintf("Clipped by %v from %v's stream", clip.CreatorName, streamer),
+		Timestamp:   clip.CreatedAt.Format(time.RFC3339),
+		Color:       twitchColourHex,
+		Image:       &discordgo.MessageEmbedImage{URL: clip.ThumbnailURL},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Views", Value: strconv.Itoa(clip.ViewCount), Inline: true},
+			{Name: "Length", Value: fmt.Sprintf("%.0fs", clip.Duration), Inline: true},
+		},
+	}
+}
+
+//HandleClipsFeed handles a message from an admin setting up or turning off the clips feed for their guild
+//command format: !clipsfeed <channel> [minviews] or !clipsfeed off
+func (b *NiaBot) HandleClipsFeed(msg *discordgo.MessageCreate) {
+	commandName := "!clipsfeed"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.setClipsFeed(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) setClipsFeed(msg *discordgo.Message) NiaResponse {
+	commandName := "!clipsfeed"
+	if _, errResp := b.getTwitchClient(commandName, msg.Content); errResp != nil {
+		return *errResp
+	}
+	matches := clipsFeedRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleClipsFeedSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	var feed *guildmodels.ClipsFeed
+	var ch *discordgo.Channel
+	if matches[clipsFeedRegex.SubexpIndex("off")] == "" {
+		channelStr := matches[clipsFeedRegex.SubexpIndex("channel")]
+		var err error
+		ch, err = b.interpretChannelString(channelStr, msg.GuildID)
+		if err != nil {
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: fmt.Sprintf("I couldn't work out which channel %v is: %v", channelStr, err),
+				syntax:      handleClipsFeedSyntax,
+				timestamp:   time.Now(),
+			}
+		}
+		minViews := defaultClipMinViews
+		if minViewsStr := matches[clipsFeedRegex.SubexpIndex("minviews")]; minViewsStr != "" {
+			minViews, _ = strconv.Atoi(minViewsStr)
+		}
+		feed = &guildmodels.ClipsFeed{
+			ChannelID: ch.ID,
+			MinViews:  minViews,
+			Since:     time.Now(),
+		}
+		//Keep the original start time if the feed is just being changed, so that clips made since then aren't missed
+		guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+		if err == nil && guild.ClipsFeed != nil {
+			feed.Since = guild.ClipsFeed.Since
+		}
+	}
+	err := b.DBConnection.SetGuildClipsFeed(msg.GuildID, feed)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Something unexpected went wrong whilst trying to write update to database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	if feed == nil {
+		return NiaResponseSuccess{
+			command:    commandName,
+			commandMsg: msg.Content,
+			timestamp:  time.Now(),
+		}
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       fmt.Sprintf("Clips will be posted in #%v", ch.Name),
+		description: fmt.Sprintf("New clips from linked members' streams will be posted once they reach %d views.", feed.MinViews),
+		timestamp:   time.Now(),
+	}
+}
@@ -13,6 +13,8 @@ func (b *NiaBot) HandleMessage(msg *discordgo.MessageCreate) {
 		words := strings.SplitN(msg.Content, " ", 2)
 		command := strings.TrimLeft(words[0], "!")
 		switch command {
+		case "setup":
+			b.HandleSetupCommandMessage(msg)
 		case "addadminrole":
 			b.HandleAddAdminMessage(msg)
 		case "addmanagedrole":
@@ -23,10 +25,62 @@ func (b *NiaBot) HandleMessage(msg *discordgo.MessageCreate) {
 			b.HandlePurgeRoleMessage(msg)
 		case "registertwitch":
 			b.HandleRegisterTwitchCommandMessage(msg)
-		case "setnotificationchannel":
-			b.HandleSetNotificationChannel(msg)
+		case "unregistertwitch":
+			b.HandleUnregisterTwitchCommandMessage(msg)
+		case "registeryoutube":
+			b.HandleRegisterYoutubeCommandMessage(msg)
+		case "unregisteryoutube":
+			b.HandleUnregisterYoutubeCommandMessage(msg)
+		case "liststreams":
+			b.HandleListStreamsCommandMessage(msg)
+		case "listtwitch":
+			b.HandleListTwitchCommandMessage(msg)
+		case "verifiedlinks":
+			b.HandleVerifiedLinks(msg)
+		case "presencestreaming":
+			b.HandlePresenceStreaming(msg)
+		case "streamstats":
+			b.HandleStreamStats(msg)
+		case "streamleaderboard":
+			b.HandleStreamLeaderboard(msg)
+		case "scheduledevents":
+			b.HandleScheduledEvents(msg)
+		case "shareschedule":
+			b.HandleShareSchedule(msg)
+		case "clipsfeed":
+			b.HandleClipsFeed(msg)
+		case "followstream":
+			b.HandleFollowStreamCommandMessage(msg)
+		case "unfollowstream":
+			b.HandleUnfollowStreamCommandMessage(msg)
+		case "followteam":
+			b.HandleFollowTeamCommandMessage(msg)
+		case "unfollowteam":
+			b.HandleUnfollowTeamCommandMessage(msg)
+		case "followcategory":
+			b.HandleFollowCategoryCommandMessage(msg)
+		case "unfollowcategory":
+			b.HandleUnfollowCategoryCommandMessage(msg)
+		case "addalertroute":
+			b.HandleAddAlertRoute(msg)
+		case "removealertroute":
+			b.HandleRemoveAlertRoute(msg)
+		case "listalertroutes":
+			b.HandleListAlertRoutes(msg)
+		case "setannouncement":
+			b.HandleSetAnnouncement(msg)
+		case "removeannouncement":
+			b.HandleRemoveAnnouncement(msg)
 		case "resettwitcheventsub":
 			b.HandleResetTwitchEventsub(msg)
+		case "twitchstatus":
+			b.HandleTwitchStatus(msg)
+		case "discordstatus":
+			b.HandleDiscordStatus(msg)
+		case "setalerttemplate":
+			b.HandleSetAlertTemplate(msg)
+		case "previewalert":
+			b.HandlePreviewAlert(msg)
 		}
 
 	}
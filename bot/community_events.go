@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/twitch"
+	"github.com/sirupsen/logrus"
+)
+
+//communityEventData contains the values which can be used as placeholders in announcement templates
+type communityEventData struct {
+	//Display name of the linked broadcaster the event happened to
+	Streamer string
+	//Mentions for the discord members the broadcaster is linked to
+	Member string
+	//Raids only: display name of the raided channel, and mentions for its linked members if it has any
+	Target       string
+	TargetMember string
+	//Raids only: number of viewers who came along
+	Viewers int
+	//Follows and subscriptions only: display name of the user who followed or subscribed
+	User string
+	//Follows only: the follower milestone which was reached
+	Followers int
+	//Subscriptions only: the subscription tier (1, 2 or 3) and whether it was gifted
+	Tier string
+	Gift bool
+	//Link to the broadcaster's channel, or the raided channel for raids
+	URL string
+}
+
+//defaultAnnouncementTemplates are used for any announcement which doesn't have a template set
+var defaultAnnouncementTemplates = map[string]string{
+	guildmodels.AnnouncementRaid:      `{{.Streamer}} ({{.Member}}) just raided {{if .TargetMember}}fellow member {{.Target}} ({{.TargetMember}}){{else}}{{.Target}}{{end}} with {{.Viewers}} viewers! {{.URL}}`,
+	guildmodels.AnnouncementFollow:    `{{.Streamer}} ({{.Member}}) just reached {{.Followers}} followers on Twitch! {{.URL}}`,
+	guildmodels.AnnouncementSubscribe: `{{.User}} just {{if .Gift}}received a gifted{{else}}bought a{{end}} tier {{.Tier}} subscription to {{.Streamer}} ({{.Member}})!`,
+}
+
+//sampleCommunityEventData returns some made up event details for previewing and validating announcement templates
+func sampleCommunityEventData(memberMention string) communityEventData {
+	return communityEventData{
+		Streamer:     "NiaTheStreamer",
+		Member:       memberMention,
+		Target:       "AnotherStreamer",
+		TargetMember: memberMention,
+		Viewers:      42,
+		User:         "NiaFan",
+		Followers:    1000,
+		Tier:         "1",
+		URL:          "https://twitch.tv/niathestreamer",
+	}
+}
+
+//HandleTwitchRaid announces raids by linked broadcasters in every guild they are linked in
+func (b *NiaBot) HandleTwitchRaid(ev *twitch.RaidEvent) {
+	raiders, err := b.linkedMembersByGuild(ev.FromUID)
+	if err != nil {
+		logrus.Errorf("Failed to look up members linked to twitch user %v due to error %v", ev.FromUID, err)
+		return
+	}
+	for gid, raiderIDs := range raiders {
+		announcement := b.guildAnnouncement(gid, guildmodels.AnnouncementRaid)
+		if announcement == nil {
+			continue
+		}
+		targetIDs, err := b.DBConnection.GetMembersByStream(twitch.ProviderName, ev.ToUID, &gid)
+		if err != nil {
+			logrus.Errorf("Failed to look up members linked to twitch user %v in guild %v due to error %v", ev.ToUID, gid, err)
+			continue
+		}
+		targetMember := ""
+		if len(targetIDs) > 0 {
+			uids := make([]string, len(targetIDs))
+			for i, member := range targetIDs {
+				uids[i] = member.UserID
+			}
+			targetMember = mentionMembers(uids)
+		}
+		if announcement.MembersOnly && targetMember == "" {
+			continue
+		}
+		b.postAnnouncement(gid, guildmodels.AnnouncementRaid, announcement, communityEventData{
+			Streamer:     ev.FromName,
+			Member:       mentionMembers(raiderIDs),
+			Target:       ev.ToName,
+			TargetMember: targetMember,
+			Viewers:      ev.Viewers,
+			URL:          fmt.Sprintf("https://twitch.tv/%v", ev.ToLogin),
+		})
+	}
+}
+
+//HandleTwitchFollow announces follower milestones reached by linked broadcasters
+func (b *NiaBot) HandleTwitchFollow(ev *twitch.FollowEvent) {
+	if ev.Followers <= 0 {
+		logrus.Debugf("Ignoring follow of %v as their follower count is unknown", ev.BroadcasterName)
+		return
+	}
+	previous, err := b.DBConnection.SetStreamChannelFollowers(twitch.ProviderName, ev.BroadcasterUID, ev.Followers)
+	if err != nil {
+		return
+	}
+	//Without an earlier count we can't tell whether a milestone has just been passed
+	if previous <= 0 {
+		return
+	}
+	members, err := b.linkedMembersByGuild(ev.BroadcasterUID)
+	if err != nil {
+		logrus.Errorf("Failed to look up members linked to twitch user %v due to error %v", ev.BroadcasterUID, err)
+		return
+	}
+	for gid, uids := range members {
+		announcement := b.guildAnnouncement(gid, guildmodels.AnnouncementFollow)
+		if announcement == nil {
+			continue
+		}
+		step := announcement.MilestoneStep
+		if step <= 0 {
+			step = guildmodels.DefaultFollowMilestoneStep
+		}
+		if ev.Followers/step <= previous/step {
+			continue
+		}
+		b.postAnnouncement(gid, guildmodels.AnnouncementFollow, announcement, communityEventData{
+			Streamer:  ev.BroadcasterName,
+			Member:    mentionMembers(uids),
+			User:      ev.UserName,
+			Followers: (ev.Followers / step) * step,
+			URL:       fmt.Sprintf("https://twitch.tv/%v", ev.BroadcasterLogin),
+		})
+	}
+}
+
+//HandleTwitchSubscribe announces new subscriptions to linked broadcasters
+func (b *NiaBot) HandleTwitchSubscribe(ev *twitch.SubscribeEvent) {
+	members, err := b.linkedMembersByGuild(ev.BroadcasterUID)
+	if err != nil {
+		logrus.Errorf("Failed to look up members linked to twitch user %v due to error %v", ev.BroadcasterUID, err)
+		return
+	}
+	tier := strings.TrimSuffix(ev.Tier, "000")
+	for gid, uids := range members {
+		announcement := b.guildAnnouncement(gid, guildmodels.AnnouncementSubscribe)
+		if announcement == nil {
+			continue
+		}
+		b.postAnnouncement(gid, guildmodels.AnnouncementSubscribe, announcement, communityEventData{
+			Streamer: ev.BroadcasterName,
+			Member:   mentionMembers(uids),
+			User:     ev.UserName,
+			Tier:     tier,
+			Gift:     ev.IsGift,
+			URL:      fmt.Sprintf("https://twitch.tv/%v", ev.BroadcasterLogin),
+		})
+	}
+}
+
+//linkedMembersByGuild returns the IDs of every member linked to a twitch channel, keyed by guild ID
+func (b *NiaBot) linkedMembersByGuild(twitchUID string) (map[string][]string, error) {
+	members, err := b.DBConnection.GetMembersByStream(twitch.ProviderName, twitchUID, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string][]string)
+	for _, member := range members {
+		res[member.GuildID] = append(res[member.GuildID], member.UserID)
+	}
+	return res, nil
+}
+
+//guildAnnouncement returns a guild's announcement settings for an event type, or nil if it isn't announced
+func (b *NiaBot) guildAnnouncement(gid, eventType string) *guildmodels.Announcement {
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		logrus.Errorf("Failed to fetch guild %v from database due to error %v", gid, err)
+		return nil
+	}
+	return guild.Announcements[eventType]
+}
+
+//postAnnouncement fills in an announcement template and posts it to the announcement channel
+func (b *NiaBot) postAnnouncement(gid, eventType string, announcement *guildmodels.Announcement, data communityEventData) {
+	content, err := renderAnnouncement(eventType, announcement, data)
+	if err != nil {
+		logrus.Warnf("Failed to render %v announcement for guild %v due to error %v", eventType, gid, err)
+		return
+	}
+	_, err = b.DiscordSession().ChannelMessageSendComplex(announcement.ChannelID, &discordgo.MessageSend{
+		Content: content,
+		//Members are mentioned so that their names show up, but shouldn't be pinged
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		logrus.Warnf("Failed to post %v announcement to channel %v in guild %v due to error %v", eventType, announcement.ChannelID, gid, err)
+	}
+}
+
+//renderAnnouncement fills in the template for an announcement, falling back to the default for its event type
+func renderAnnouncement(eventType string, announcement *guildmodels.Announcement, data communityEventData) (string, error) {
+	tmplStr := defaultAnnouncementTemplates[eventType]
+	if announcement != nil && announcement.Template != "" {
+		tmplStr = announcement.Template
+	}
+	tmpl, err := template.New(eventType).Option("missingkey=error").Parse(tmplStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %v announcement template: %v", eventType, err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to fill in %v announcement template: %v", eventType, err)
+	}
+	return buf.String(), nil
+}
+
+func mentionMembers(uids []string) string {
+	mentions := make([]string, len(uids))
+	for i, uid := range uids {
+		mentions[i] = fmt.Sprintf("<@%v>", uid)
+	}
+	return strings.Join(mentions, ", ")
+}
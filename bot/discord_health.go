@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/discord"
+)
+
+//HandleDiscordStatus handles a message from the developer asking for the current state of each discord gateway shard
+//command format: !discordstatus
+func (b *NiaBot) HandleDiscordStatus(msg *discordgo.MessageCreate) {
+	commandName := "!discordstatus"
+	var result NiaResponse
+	if !isDev(msg.Author.ID) {
+		result = NiaResponseNotAllowed{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "The !discordstatus command can only be run by the bot developer.",
+			timestamp:   time.Now(),
+		}
+	} else if b.DiscordConnection == nil {
+		result = NiaResponseFeatureNotEnabled{
+			command:         commandName,
+			commandMsg:      msg.Content,
+			disabledFeature: "discord_gateway",
+			timestamp:       time.Now(),
+		}
+	} else {
+		result = describeShardStatuses(commandName, msg.Content, b.DiscordConnection.ShardStatuses())
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func describeShardStatuses(commandName, commandMsg string, statuses []discord.ShardStatus) NiaResponse {
+	connected := 0
+	lines := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		state := "disconnected"
+		if status.Connected {
+			state = "connected"
+			connected++
+		}
+		since := "never"
+		if !status.Since.IsZero() {
+			since = status.Since.Format(time.RFC1123)
+		}
+		lines = append(lines, fmt.Sprintf("Shard %d: %v since %v, %d guild(s), %d reconnect(s)", status.ID, state, since, status.Guilds, status.Reconnects))
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  commandMsg,
+		title:       "Discord gateway shards",
+		description: fmt.Sprintf("%d of %d shard(s) are connected.", connected, len(statuses)),
+		fields:      linesToFields("Shards", lines),
+		timestamp:   time.Now(),
+	}
+}
@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+)
+
+const handleFollowCategorySyntax string = "```" +
+	`!followcategory "<category>"
+	<category> is the name of a twitch category, such as Final Fantasy XIV Online
+	Once any categories are followed, followed streams and teams will only be announced when they go live in one of them.
+	Streams linked to members of this server are always announced.` +
+	"```"
+
+const handleUnfollowCategorySyntax string = "```" +
+	`!unfollowcategory "<category>"
+	<category> is the name of a followed twitch category` +
+	"```"
+
+var followCategoryRegex = regexp.MustCompile(`^!(?:un)?followcategory\s+"?(?P<category>[^"]+?)"?\s*$`)
+
+//HandleFollowCategoryCommandMessage handles a message from an admin asking for followed streams to be announced when
+//they go live in a twitch category
+//command format: !followcategory <category>
+func (b *NiaBot) HandleFollowCategoryCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!followcategory"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.followCategory(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) followCategory(msg *discordgo.Message) NiaResponse {
+	commandName := "!followcategory"
+	t, errResp := b.getTwitchClient(commandName, msg.Content)
+	if errResp != nil {
+		return *errResp
+	}
+	matches := followCategoryRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleFollowCategorySyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	categoryName := matches[followCategoryRegex.SubexpIndex("category")]
+	category, err := t.GetCategory(categoryName)
+	if err != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("I couldn't find any twitch category called %v", categoryName),
+			syntax:      handleFollowCategorySyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	noUpdated, err := b.DBConnection.AddGuildFollowedCategory(msg.GuildID, &guildmodels.FollowedCategory{
+		ID:   category.ID,
+		Name: category.Name,
+	})
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst following the category",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if noUpdated == 0 {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server already follows %v", category.Name),
+			syntax:      handleFollowCategorySyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseSuccess{
+			command:    commandName,
+			commandMsg: msg.Content,
+			timestamp:  time.Now(),
+		}
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       fmt.Sprintf("Now following %v", category.Name),
+		description: fmt.Sprintf("Followed streams and teams will be announced when they go live in any of: %v", describeFollowedCategories(guild.FollowedCategories)),
+		timestamp:   time.Now(),
+	}
+}
+
+//HandleUnfollowCategoryCommandMessage handles a message from an admin asking for a twitch category to be unfollowed
+//command format: !unfollowcategory <category>
+func (b *NiaBot) HandleUnfollowCategoryCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!unfollowcategory"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.unfollowCategory(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) unfollowCategory(msg *discordgo.Message) NiaResponse {
+	commandName := "!unfollowcategory"
+	matches := followCategoryRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleUnfollowCategorySyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	categoryName := matches[followCategoryRegex.SubexpIndex("category")]
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	//Categories are matched by name here so that they can still be unfollowed if twitch is unavailable
+	var category *guildmodels.FollowedCategory
+	for i, followed := range guild.FollowedCategories {
+		if strings.EqualFold(followed.Name, categoryName) {
+			category = &guild.FollowedCategories[i]
+		}
+	}
+	if category == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server doesn't follow any category called %v", categoryName),
+			syntax:      handleUnfollowCategorySyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	_, err = b.DBConnection.RemoveGuildFollowedCategory(msg.GuildID, category.ID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst unfollowing the category",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+func describeFollowedCategories(categories []guildmodels.FollowedCategory) string {
+	names := make([]string, len(categories))
+	for i, category := range categories {
+		names[i] = category.Name
+	}
+	return strings.Join(names, ", ")
+}
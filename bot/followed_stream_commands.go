@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
+	"github.com/callummance/nia/twitch"
+	"github.com/sirupsen/logrus"
+)
+
+const handleFollowStreamSyntax string = "```" +
+	`!followstream [provider] "<channel>"
+	[provider] is the platform the channel streams on, and defaults to twitch
+	<channel> can be a username or channel URL
+	Alerts will be posted whenever the stream goes live, even if it isn't linked to a member of this server` +
+	"```"
+
+const handleUnfollowStreamSyntax string = "```" +
+	`!unfollowstream [provider] "<channel>"
+	[provider] is the platform the channel streams on, and defaults to twitch
+	<channel> can be a username or channel URL` +
+	"```"
+
+var followStreamRegex = regexp.MustCompile(`^!(?:un)?followstream\s+(?:(?P<provider>[a-z]+)\s+)?"?(?P<channel>[^"\s]+)"?\s*$`)
+
+//defaultFollowProvider is used by !followstream and !unfollowstream when no provider is given
+const defaultFollowProvider = twitch.ProviderName
+
+//HandleFollowStreamCommandMessage handles a message from an admin asking for alerts to be posted for a stream which
+//isn't linked to any member of the guild
+//command format: !followstream [provider] <channel>
+func (b *NiaBot) HandleFollowStreamCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!followstream"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.followStream(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) followStream(msg *discordgo.Message) NiaResponse {
+	commandName := "!followstream"
+	p, channel, errResp := b.resolveFollowedStream(commandName, msg, handleFollowStreamSyntax)
+	if errResp != nil {
+		return errResp
+	}
+	provider := p.Info().Name
+	//Make sure the stream exists in the DB so that alert posts can be tracked
+	_, err := b.DBConnection.GetStreamChannel(provider, channel.ID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst saving stream details",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	err = b.DBConnection.SetStreamChannelLogin(provider, channel.ID, channel.Login)
+	if err != nil {
+		logrus.Warnf("Failed to save login name %v for %v channel %v due to error %v", channel.Login, provider, channel.ID, err)
+	}
+	noUpdated, err := b.DBConnection.AddGuildFollowedStream(msg.GuildID, guildmodels.StreamKey(provider, channel.ID))
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst following the stream",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if noUpdated == 0 {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server already follows %v", channel.DisplayName),
+			syntax:      handleFollowStreamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	err = p.Subscribe(channel.ID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("Encountered error whilst subscribing to %v updates. Please try again later or contact a developer.", p.Info().DisplayName),
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	//Post an alert straight away if the stream is already live
+	live, err := p.GetLiveStreams([]string{channel.ID})
+	if err != nil {
+		return NiaResponsePartialSuccess{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch current state of the provided stream. Alerts should still be posted the next time it goes live.",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if live[channel.ID] != nil {
+		err := b.makeFollowedStreamAlertPosts(p, channel.ID, msg.GuildID)
+		if err != nil {
+			return NiaResponsePartialSuccess{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: "Failed to post an alert for the stream. Alerts should still be posted the next time it goes live.",
+				data:        map[string]string{"Error": err.Error()},
+				timestamp:   time.Now(),
+			}
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//HandleUnfollowStreamCommandMessage handles a message from an admin asking for alerts for a followed stream to be
+//stopped
+//command format: !unfollowstream [provider] <channel>
+func (b *NiaBot) HandleUnfollowStreamCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!unfollowstream"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.unfollowStream(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) unfollowStream(msg *discordgo.Message) NiaResponse {
+	commandName := "!unfollowstream"
+	p, channel, errResp := b.resolveFollowedStream(commandName, msg, handleUnfollowStreamSyntax)
+	if errResp != nil {
+		return errResp
+	}
+	provider := p.Info().Name
+	noUpdated, err := b.DBConnection.RemoveGuildFollowedStream(msg.GuildID, guildmodels.StreamKey(provider, channel.ID))
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst unfollowing the stream",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if noUpdated == 0 {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server doesn't follow %v", channel.DisplayName),
+			syntax:      handleUnfollowStreamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	stream, err := b.DBConnection.GetStreamChannel(provider, channel.ID)
+	if err != nil {
+		return NiaResponsePartialSuccess{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Stopped following the stream, but failed to clean up any existing alert posts",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	b.removeUnusedGuildAlertPosts(msg.GuildID, stream)
+	b.releaseStream(p, stream)
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//resolveFollowedStream works out which provider and channel a !followstream or !unfollowstream command refers to
+func (b *NiaBot) resolveFollowedStream(commandName string, msg *discordgo.Message, syntax string) (streaming.Provider, *streaming.Channel, NiaResponse) {
+	matches := followStreamRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return nil, nil, NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      syntax,
+			timestamp:   time.Now(),
+		}
+	}
+	provider := matches[followStreamRegex.SubexpIndex("provider")]
+	if provider == "" {
+		provider = defaultFollowProvider
+	}
+	p, errResp := b.getStreamProvider(commandName, msg.Content, provider)
+	if errResp != nil {
+		return nil, nil, *errResp
+	}
+	channelName := matches[followStreamRegex.SubexpIndex("channel")]
+	channel, err := p.ResolveChannel(channelName)
+	if err != nil {
+		return nil, nil, NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("I couldn't find any %v channel called %v", p.Info().DisplayName, channelName),
+			syntax:      syntax,
+			timestamp:   time.Now(),
+		}
+	}
+	return p, channel, nil
+}
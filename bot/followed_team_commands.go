@@ -0,0 +1,359 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/twitch"
+	"github.com/sirupsen/logrus"
+)
+
+//teamSyncIntervalEnvVar sets how often followed twitch teams' rosters are checked for changes
+const teamSyncIntervalEnvVar string = "NIA_TWITCH_TEAM_SYNC_INTERVAL"
+const defaultTeamSyncInterval = time.Hour
+
+const handleFollowTeamSyntax string = "```" +
+	`!followteam <team>
+	<team> is the name of the twitch team, as used in its URL (eg. twitch.tv/team/<team>)
+	Alerts will be posted whenever any member of the team goes live, and the roster is kept up to date automatically` +
+	"```"
+
+const handleUnfollowTeamSyntax string = "```" +
+	`!unfollowteam <team>
+	<team> is the name of the twitch team, as used in its URL (eg. twitch.tv/team/<team>)` +
+	"```"
+
+var followTeamRegex = regexp.MustCompile(`^!(?:un)?followteam\s+"?(?:https?://)?(?:www\.)?(?:twitch\.tv/team/)?(?P<team>[a-zA-Z0-9_]+)"?\s*$`)
+
+//HandleFollowTeamCommandMessage handles a message from an admin asking for alerts to be posted whenever any member of
+//a twitch team goes live
+//command format: !followteam <team>
+func (b *NiaBot) HandleFollowTeamCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!followteam"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.followTeam(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) followTeam(msg *discordgo.Message) NiaResponse {
+	commandName := "!followteam"
+	t, errResp := b.getTwitchClient(commandName, msg.Content)
+	if errResp != nil {
+		return *errResp
+	}
+	matches := followTeamRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleFollowTeamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	teamName := matches[followTeamRegex.SubexpIndex("team")]
+	team, err := t.GetTeam(teamName)
+	if err != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("I couldn't find any twitch team called %v", teamName),
+			syntax:      handleFollowTeamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	b.teamSyncLock.Lock()
+	defer b.teamSyncLock.Unlock()
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if guild.FollowedTeam(team.Name) != nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server already follows %v", team.DisplayName),
+			syntax:      handleFollowTeamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	keys, failed := b.addTeamStreams(t, team.Members)
+	err = b.DBConnection.SetGuildFollowedTeam(msg.GuildID, &guildmodels.FollowedTeam{
+		Name:        team.Name,
+		DisplayName: team.DisplayName,
+		Streams:     keys,
+	})
+	if err != nil {
+		//Don't leave subscriptions behind for streams nobody wants
+		b.releaseTeamStreams(t, msg.GuildID, keys)
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst following the team",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	b.postTeamAlerts(t, msg.GuildID, team.Members)
+	if len(failed) > 0 {
+		return NiaResponsePartialSuccess{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("Now following %v, but failed to subscribe to updates for some of its members. Alerts may not be posted when they go live.", team.DisplayName),
+			data:        failed,
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       fmt.Sprintf("Now following %v", team.DisplayName),
+		description: fmt.Sprintf("Alerts will be posted whenever any of the team's %d member(s) go live. Members who join or leave the team will be picked up automatically.", len(team.Members)),
+		timestamp:   time.Now(),
+	}
+}
+
+//HandleUnfollowTeamCommandMessage handles a message from an admin asking for alerts for a followed twitch team to be
+//stopped
+//command format: !unfollowteam <team>
+func (b *NiaBot) HandleUnfollowTeamCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!unfollowteam"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.unfollowTeam(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) unfollowTeam(msg *discordgo.Message) NiaResponse {
+	commandName := "!unfollowteam"
+	t, errResp := b.getTwitchClient(commandName, msg.Content)
+	if errResp != nil {
+		return *errResp
+	}
+	matches := followTeamRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleUnfollowTeamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	teamName := matches[followTeamRegex.SubexpIndex("team")]
+	b.teamSyncLock.Lock()
+	defer b.teamSyncLock.Unlock()
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	team := guild.FollowedTeam(teamName)
+	if team == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server doesn't follow any team called %v", teamName),
+			syntax:      handleUnfollowTeamSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	_, err = b.DBConnection.RemoveGuildFollowedTeam(msg.GuildID, team.Name)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst unfollowing the team",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	b.releaseTeamStreams(t, msg.GuildID, team.Streams)
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//addTeamStreams makes sure each of the provided team members' streams is in the DB and subscribed to, returning their
+//stream keys along with the error encountered subscribing to each member which failed, keyed by login
+func (b *NiaBot) addTeamStreams(t *twitch.EventSource, members []twitch.TeamMember) ([]string, map[string]string) {
+	keys := make([]string, 0, len(members))
+	failed := make(map[string]string)
+	for _, member := range members {
+		_, err := b.DBConnection.GetStreamChannel(twitch.ProviderName, member.ID)
+		if err != nil {
+			logrus.Warnf("Failed to save details of twitch channel %v whilst following team due to error %v", member.Login, err)
+			failed[member.Login] = err.Error()
+			continue
+		}
+		err = b.DBConnection.SetStreamChannelLogin(twitch.ProviderName, member.ID, member.Login)
+		if err != nil {
+			logrus.Warnf("Failed to save login name %v for twitch channel %v due to error %v", member.Login, member.ID, err)
+		}
+		keys = append(keys, guildmodels.StreamKey(twitch.ProviderName, member.ID))
+		err = t.Subscribe(member.ID)
+		if err != nil {
+			logrus.Warnf("Failed to subscribe to twitch channel %v whilst following team due to error %v", member.Login, err)
+			failed[member.Login] = err.Error()
+		}
+	}
+	return keys, failed
+}
+
+//releaseTeamStreams cleans up after a guild has stopped following the provided streams as part of a team, removing
+//any alert posts the guild no longer wants and unsubscribing from streams nobody uses any more
+func (b *NiaBot) releaseTeamStreams(t *twitch.EventSource, gid string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	streams, err := b.DBConnection.GetStreamChannels(keys)
+	if err != nil {
+		logrus.Errorf("Failed to look up streams which are no longer followed by guild %v due to error %v", gid, err)
+		return
+	}
+	for _, stream := range streams {
+		b.removeUnusedGuildAlertPosts(gid, stream)
+		b.releaseStream(t, stream)
+	}
+}
+
+//postTeamAlerts makes alert posts in a guild for any of the provided team members who are already live
+func (b *NiaBot) postTeamAlerts(t *twitch.EventSource, gid string, members []twitch.TeamMember) {
+	if len(members) == 0 {
+		return
+	}
+	uids := make([]string, len(members))
+	for i, member := range members {
+		uids[i] = member.ID
+	}
+	live, err := t.GetLiveStreams(uids)
+	if err != nil {
+		logrus.Warnf("Failed to fetch current state of team members' streams in guild %v due to error %v", gid, err)
+		return
+	}
+	for uid := range live {
+		err := b.makeFollowedStreamAlertPosts(t, uid, gid)
+		if err != nil {
+			logrus.Warnf("Failed to post alert for twitch channel %v in guild %v due to error %v", uid, gid, err)
+		}
+	}
+}
+
+//runTeamSync periodically brings the rosters of every followed twitch team up to date until stop is closed
+func (b *NiaBot) runTeamSync(stop <-chan struct{}) {
+	interval := defaultTeamSyncInterval
+	if intervalStr, exists := os.LookupEnv(teamSyncIntervalEnvVar); exists {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			logrus.Warnf("Invalid team sync interval `%v`, falling back to default of %v", intervalStr, defaultTeamSyncInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.syncTeams()
+		}
+	}
+}
+
+//syncTeams fetches the current roster of every followed twitch team, following any new members and releasing any
+//which have left
+func (b *NiaBot) syncTeams() {
+	t := b.TwitchConnection
+	if t == nil {
+		return
+	}
+	guilds, err := b.DBConnection.GetGuildsFollowingTeams()
+	if err != nil {
+		logrus.Errorf("Failed to look up guilds following twitch teams due to error %v", err)
+		return
+	}
+	//Teams are cached so that teams followed in several guilds are only looked up once
+	teams := make(map[string]*twitch.Team)
+	for _, guild := range guilds {
+		for _, followed := range guild.FollowedTeams {
+			team, fetched := teams[followed.Name]
+			if !fetched {
+				team, err = t.GetTeam(followed.Name)
+				if err != nil {
+					logrus.Warnf("Failed to fetch roster of twitch team %v due to error %v", followed.Name, err)
+				}
+				teams[followed.Name] = team
+			}
+			if team != nil {
+				b.syncGuildTeam(t, guild.DiscordGID, &followed, team)
+			}
+		}
+	}
+}
+
+//syncGuildTeam updates a guild's copy of a team's roster to match the current one
+func (b *NiaBot) syncGuildTeam(t *twitch.EventSource, gid string, followed *guildmodels.FollowedTeam, team *twitch.Team) {
+	b.teamSyncLock.Lock()
+	defer b.teamSyncLock.Unlock()
+	oldKeys := make(map[string]bool, len(followed.Streams))
+	for _, key := range followed.Streams {
+		oldKeys[key] = true
+	}
+	var joined []twitch.TeamMember
+	newKeys := make(map[string]bool, len(team.Members))
+	for _, member := range team.Members {
+		key := guildmodels.StreamKey(twitch.ProviderName, member.ID)
+		newKeys[key] = true
+		if !oldKeys[key] {
+			joined = append(joined, member)
+		}
+	}
+	var left []string
+	for key := range oldKeys {
+		if !newKeys[key] {
+			left = append(left, key)
+		}
+	}
+	if len(joined) == 0 && len(left) == 0 && followed.DisplayName == team.DisplayName {
+		return
+	}
+	logrus.Infof("Twitch team %v in guild %v gained %d and lost %d member(s)", team.Name, gid, len(joined), len(left))
+	keys, _ := b.addTeamStreams(t, joined)
+	for _, key := range followed.Streams {
+		if newKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	err := b.DBConnection.SetGuildFollowedTeam(gid, &guildmodels.FollowedTeam{
+		Name:        followed.Name,
+		DisplayName: team.DisplayName,
+		Streams:     keys,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to save updated roster of twitch team %v in guild %v due to error %v", team.Name, gid, err)
+		return
+	}
+	b.releaseTeamStreams(t, gid, left)
+	b.postTeamAlerts(t, gid, joined)
+}
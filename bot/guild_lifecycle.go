@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+//HandleGuildCreate is called when the bot joins a guild, or when a guild becomes available after connecting. Guilds
+//which are new to the bot, or which it is rejoining, are sent the setup wizard.
+func (b *NiaBot) HandleGuildCreate(g *discordgo.GuildCreate) {
+	logrus.Infof("Guild %v (%v) is available", g.Name, g.ID)
+	created, err := b.DBConnection.CreateGuild(g.ID)
+	if err != nil {
+		logrus.Errorf("Failed to create guild %v in database due to error %v", g.ID, err)
+		return
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(g.ID)
+	if err != nil {
+		logrus.Errorf("Failed to fetch guild %v from database due to error %v", g.ID, err)
+		return
+	}
+	rejoined := !guild.Active()
+	if rejoined {
+		b.reactivateGuild(guild)
+	}
+	if created || rejoined {
+		logrus.Infof("Joined guild %v (%v)", g.Name, g.ID)
+		b.postSetupWizard(g.Guild, guild)
+	}
+}
+
+//HandleGuildDelete is called when the bot is removed from a guild, or when a guild becomes unavailable due to an
+//outage
+func (b *NiaBot) HandleGuildDelete(g *discordgo.GuildDelete) {
+	if g.Unavailable {
+		logrus.Warnf("Guild %v has become unavailable", g.ID)
+		return
+	}
+	logrus.Infof("Removed from guild %v", g.ID)
+	b.leaveGuild(g.ID)
+}
+
+//HandleGuildMemberAdd is called when a member joins a guild. Members who rejoin whilst one of their linked streams is
+//live are given the guild's live roles straight away, rather than when they next go live.
+func (b *NiaBot) HandleGuildMemberAdd(m *discordgo.GuildMemberAdd) {
+	logrus.Debugf("User %v joined guild %v", m.User.ID, m.GuildID)
+	uid, gid := m.User.ID, m.GuildID
+	live, err := b.memberHasLiveStream(gid, uid)
+	if err != nil {
+		logrus.Warnf("Failed to check whether user %v who joined guild %v is live due to error %v", uid, gid, err)
+		return
+	}
+	if live {
+		err = b.assignLiveRoles(uid, gid)
+		if err != nil {
+			logrus.Errorf("Failed to assign stream live role to user %v who joined guild %v due to error %v", uid, gid, err)
+		}
+	}
+}
+
+//HandleGuildMemberRemove is called when a member leaves or is removed from a guild. Any alerts made from their discord
+//presence there are removed, as they won't receive a presence update telling us that they have stopped streaming.
+func (b *NiaBot) HandleGuildMemberRemove(m *discordgo.GuildMemberRemove) {
+	logrus.Debugf("User %v left guild %v", m.User.ID, m.GuildID)
+	uid := m.User.ID
+	b.presence.streamsLock.Lock()
+	current := b.presence.streams[uid]
+	announced := current != nil && current.guilds[m.GuildID]
+	if current != nil {
+		delete(current.guilds, m.GuildID)
+	}
+	b.presence.streamsLock.Unlock()
+	if !announced {
+		return
+	}
+	stream, err := b.DBConnection.GetStreamChannel(presenceProviderName, uid)
+	if err != nil {
+		logrus.Warnf("Failed to look up presence stream for user %v due to error %v", uid, err)
+		return
+	}
+	b.removeGuildAlertPosts(m.GuildID, stream)
+}
+
+//HandleGuildRoleDelete is called when a role is deleted from a guild, and removes any configuration which refers to it
+func (b *NiaBot) HandleGuildRoleDelete(r *discordgo.GuildRoleDelete) {
+	gid, roleID := r.GuildID, r.RoleID
+	_, err := b.DBConnection.RemoveAdminRole(gid, roleID)
+	if err != nil {
+		logrus.Errorf("Failed to remove deleted role %v from admin roles of guild %v due to error %v", roleID, gid, err)
+	}
+	deleted, err := b.DBConnection.DeleteRoleRules(gid, roleID)
+	if err != nil {
+		logrus.Errorf("Failed to remove rules for deleted role %v in guild %v due to error %v", roleID, gid, err)
+	} else if deleted > 0 {
+		logrus.Infof("Removed %d managed role rule(s) for deleted role %v in guild %v", deleted, roleID, gid)
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		logrus.Errorf("Failed to fetch guild %v to clean up deleted role %v due to error %v", gid, roleID, err)
+		return
+	}
+	//A route filtered to only deleted roles can't match anyone, so it is removed rather than left without a filter,
+	//which would match every stream
+	routes := guild.NotificationChannels.AlertRoutes()
+	var keptRoutes []guildmodels.StreamAlertRoute
+	changed := false
+	for _, route := range routes {
+		if !containsString(route.RoleIDs, roleID) {
+			keptRoutes = append(keptRoutes, route)
+			continue
+		}
+		changed = true
+		route.RoleIDs = removeString(route.RoleIDs, roleID)
+		if len(route.RoleIDs) > 0 {
+			keptRoutes = append(keptRoutes, route)
+		}
+	}
+	if changed {
+		err = b.DBConnection.SetGuildAlertRoutes(gid, keptRoutes)
+		if err != nil {
+			logrus.Errorf("Failed to remove deleted role %v from alert routes of guild %v due to error %v", roleID, gid, err)
+		}
+	}
+	if guild.StreamAlertTemplate != nil && guild.StreamAlertTemplate.PingRoleID == roleID {
+		tmpl := *guild.StreamAlertTemplate
+		tmpl.PingRoleID = ""
+		err = b.DBConnection.UpdateGuildAlertTemplate(gid, &tmpl)
+		if err != nil {
+			logrus.Errorf("Failed to remove deleted role %v from alert template of guild %v due to error %v", roleID, gid, err)
+		}
+	}
+}
+
+//HandleChannelDelete is called when a channel is deleted from a guild, and stops anything being posted to it
+func (b *NiaBot) HandleChannelDelete(c *discordgo.ChannelDelete) {
+	gid, channelID := c.GuildID, c.ID
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		logrus.Errorf("Failed to fetch guild %v to clean up deleted channel %v due to error %v", gid, channelID, err)
+		return
+	}
+	routes := guild.NotificationChannels.AlertRoutes()
+	var keptRoutes []guildmodels.StreamAlertRoute
+	for _, route := range routes {
+		if route.ChannelID != channelID {
+			keptRoutes = append(keptRoutes, route)
+		}
+	}
+	if len(keptRoutes) != len(routes) {
+		logrus.Infof("Removing %d stream alert route(s) for deleted channel %v in guild %v", len(routes)-len(keptRoutes), channelID, gid)
+		err = b.DBConnection.SetGuildAlertRoutes(gid, keptRoutes)
+		if err != nil {
+			logrus.Errorf("Failed to remove alert routes for deleted channel %v in guild %v due to error %v", channelID, gid, err)
+		}
+	}
+	for eventType, announcement := range guild.Announcements {
+		if announcement == nil || announcement.ChannelID != channelID {
+			continue
+		}
+		err = b.DBConnection.SetGuildAnnouncement(gid, eventType, nil)
+		if err != nil {
+			logrus.Errorf("Failed to turn off %v announcements for deleted channel %v in guild %v due to error %v", eventType, channelID, gid, err)
+		}
+	}
+	if guild.ClipsFeed != nil && guild.ClipsFeed.ChannelID == channelID {
+		err = b.DBConnection.SetGuildClipsFeed(gid, nil)
+		if err != nil {
+			logrus.Errorf("Failed to turn off clips feed for deleted channel %v in guild %v due to error %v", channelID, gid, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	setupStepDone    = "✅"
+	setupStepPending = "⬜"
+)
+
+//HandleSetupCommandMessage handles a message from an admin asking to see the setup wizard, showing which steps have
+//been completed so far
+//command format: !setup
+func (b *NiaBot) HandleSetupCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!setup"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result != nil {
+		b.respondToCommand(msg.Message, result)
+		return
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		b.respondToCommand(msg.Message, NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		})
+		return
+	}
+	_, err = b.DiscordSession().ChannelMessageSendComplex(msg.ChannelID, &discordgo.MessageSend{
+		Embed: b.setupWizardEmbed(guild, msg.ChannelID),
+		Reference: &discordgo.MessageReference{
+			MessageID: msg.ID,
+			ChannelID: msg.ChannelID,
+			GuildID:   msg.GuildID,
+		},
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send setup wizard in response to command due to error %v", err)
+	}
+}
+
+//postSetupWizard posts the setup wizard after the bot joins a guild. It is posted in the guild's system channel if
+//there is one, or otherwise the first text channel the bot can post in.
+func (b *NiaBot) postSetupWizard(g *discordgo.Guild, guild *guildmodels.DiscordGuild) {
+	channelID := b.setupWizardChannel(g)
+	if channelID == "" {
+		logrus.Warnf("Couldn't find anywhere to post the setup wizard in guild %v", g.ID)
+		return
+	}
+	_, err := b.DiscordSession().ChannelMessageSendEmbed(channelID, b.setupWizardEmbed(guild, channelID))
+	if err != nil {
+		logrus.Errorf("Failed to post setup wizard in channel %v of guild %v due to error %v", channelID, g.ID, err)
+	}
+}
+
+func (b *NiaBot) setupWizardChannel(g *discordgo.Guild) string {
+	if g.SystemChannelID != "" {
+		return g.SystemChannelID
+	}
+	for _, ch := range g.Channels {
+		if ch.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+		missing, err := b.DiscordSession().MissingPermissions(g.ID, ch.ID)
+		if err == nil && !containsString(missing, "Send Messages") && !containsString(missing, "View Channel") {
+			return ch.ID
+		}
+	}
+	return ""
+}
+
+//setupWizardEmbed builds the setup wizard for a guild, marking off any steps which have already been completed
+func (b *NiaBot) setupWizardEmbed(guild *guildmodels.DiscordGuild, channelID string) *discordgo.MessageEmbed {
+	adminStep := setupStepPending
+	adminDesc := "Server owners can always run my admin commands. To let anyone else run them, pick an admin role with " +
+		"`!addadminrole @<role>`."
+	if len(guild.AdminRoles) > 0 {
+		adminStep = setupStepDone
+		mentions := make([]string, len(guild.AdminRoles))
+		for i, roleID := range guild.AdminRoles {
+			mentions[i] = fmt.Sprintf("<@&%v>", roleID)
+		}
+		adminDesc += fmt.Sprintf("\nCurrent admin roles: %v", strings.Join(mentions, ", "))
+	}
+	channelStep := setupStepPending
+	channelDesc := "Choose the channel stream alerts should be posted in with `!addalertroute #<channel>`. Alerts can be " +
+		"split between channels by game, member or role; see `!addalertroute` for details."
+	if routes := guild.NotificationChannels.AlertRoutes(); len(routes) > 0 {
+		channelStep = setupStepDone
+		channels := make([]string, 0, len(routes))
+		for _, route := range routes {
+			channels = appendUnique(channels, fmt.Sprintf("<#%v>", route.ChannelID))
+		}
+		channelDesc += fmt.Sprintf("\nAlerts are currently posted in: %v", strings.Join(channels, ", "))
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{
+			Name:  fmt.Sprintf("%v 1. Choose admin roles", adminStep),
+			Value: adminDesc,
+		},
+		{
+			Name:  fmt.Sprintf("%v 2. Choose where stream alerts go", channelStep),
+			Value: channelDesc,
+		},
+		{
+			Name: "3. Add some streams",
+			Value: "Members can link their own channels with `!registertwitch \"<twitch>\"` or `!registeryoutube \"<youtube>\"`, " +
+				"and admins can follow any other channel with `!followstream \"<channel>\"`.",
+		},
+	}
+	missing, err := b.DiscordSession().MissingPermissions(guild.DiscordGID, channelID)
+	if err != nil {
+		logrus.Warnf("Failed to check permissions in channel %v due to error %v", channelID, err)
+	} else if len(missing) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "⚠️ Missing permissions",
+			Value: fmt.Sprintf("Some features won't work until I'm given the following permissions: %v", strings.Join(missing, ", ")),
+		})
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "Setting up Nia",
+		Description: "Thanks for adding me! Here's how to get stream alerts set up. Run `!setup` at any time to see this again.",
+		Color:       discordColourHex,
+		Fields:      fields,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+}
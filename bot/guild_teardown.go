@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"os"
+	"time"
+
+	"github.com/callummance/nia/discord"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+//guildPurgeGracePeriodEnvVar sets how long a guild's data is kept after the bot is removed from it, in case the bot is
+//added back
+const guildPurgeGracePeriodEnvVar string = "NIA_GUILD_PURGE_GRACE_PERIOD"
+const defaultGuildPurgeGracePeriod = 7 * 24 * time.Hour
+
+//guildPurgeCheckInterval is how often guilds which the bot has left are checked to see if they are due to be purged
+const guildPurgeCheckInterval = time.Hour
+
+//HandleShardReady is called when a discord shard connects, with the list of guilds it handles. Any of those which are
+//still stored as active but aren't in the list must have removed the bot whilst it was disconnected, so are left.
+func (b *NiaBot) HandleShardReady(r *discord.ShardReady) {
+	active, err := b.DBConnection.GetActiveGuildIDs()
+	if err != nil {
+		logrus.Errorf("Failed to look up active guilds to check against shard %d due to error %v", r.ShardID, err)
+		return
+	}
+	present := make(map[string]bool, len(r.GuildIDs))
+	for _, gid := range r.GuildIDs {
+		present[gid] = true
+	}
+	for _, gid := range active {
+		if present[gid] || !r.HandlesGuild(gid) {
+			continue
+		}
+		logrus.Infof("Removed from guild %v whilst disconnected", gid)
+		b.leaveGuild(gid)
+	}
+}
+
+//leaveGuild marks a guild as inactive after the bot is removed from it, and unsubscribes from any of its streams which
+//no other active guild uses. Its data is kept until the grace period has passed, in case the bot is added back.
+func (b *NiaBot) leaveGuild(gid string) {
+	now := time.Now()
+	err := b.DBConnection.SetGuildLeftAt(gid, &now)
+	if err != nil {
+		logrus.Errorf("Failed to mark guild %v as inactive due to error %v", gid, err)
+		return
+	}
+	//Presence updates won't be received for the guild any more
+	b.presence.streamsLock.Lock()
+	for _, s := range b.presence.streams {
+		delete(s.guilds, gid)
+	}
+	b.presence.streamsLock.Unlock()
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		logrus.Errorf("Failed to fetch guild %v to clean up after leaving due to error %v", gid, err)
+		return
+	}
+	streams, err := b.guildStreams(guild)
+	if err != nil {
+		logrus.Errorf("Failed to look up streams used by guild %v due to error %v", gid, err)
+		return
+	}
+	inactive, err := b.DBConnection.GetInactiveGuildIDs()
+	if err != nil {
+		logrus.Errorf("Failed to look up inactive guilds due to error %v", err)
+		return
+	}
+	for _, stream := range streams {
+		//Alert posts in the guild can no longer be edited or removed, so stop keeping track of them
+		for _, post := range stream.DiscordStatusPosts {
+			if post.GuildID != gid {
+				continue
+			}
+			err := b.DBConnection.RemoveDiscordStatusPost(stream.Provider, stream.ChannelID, &post)
+			if err != nil {
+				logrus.Warnf("Failed to remove record of alert post %v due to error %v", post, err)
+			}
+		}
+		used, err := b.streamUsedByActiveGuild(stream, inactive)
+		if err != nil {
+			logrus.Errorf("Failed to check whether stream %v is still in use due to error %v", stream.Key, err)
+			continue
+		} else if used {
+			continue
+		}
+		p := b.streamProvider(stream.Provider)
+		if p == nil {
+			continue
+		}
+		logrus.Infof("Unsubscribing from stream %v as it is no longer used by any active guild", stream.Key)
+		err = p.Unsubscribe(stream.ChannelID)
+		if err != nil {
+			logrus.Warnf("Failed to unsubscribe from alerts for stream %v due to error %v", stream.Key, err)
+		}
+	}
+}
+
+//reactivateGuild marks a guild as active again after the bot is added back to it before its data was purged, and
+//resubscribes to its streams
+func (b *NiaBot) reactivateGuild(guild *guildmodels.DiscordGuild) {
+	gid := guild.DiscordGID
+	err := b.DBConnection.SetGuildLeftAt(gid, nil)
+	if err != nil {
+		logrus.Errorf("Failed to mark guild %v as active due to error %v", gid, err)
+	}
+	streams, err := b.guildStreams(guild)
+	if err != nil {
+		logrus.Errorf("Failed to look up streams used by guild %v due to error %v", gid, err)
+		return
+	}
+	for _, stream := range streams {
+		p := b.streamProvider(stream.Provider)
+		if p == nil {
+			continue
+		}
+		err := p.Subscribe(stream.ChannelID)
+		if err != nil {
+			logrus.Warnf("Failed to resubscribe to alerts for stream %v due to error %v", stream.Key, err)
+		}
+	}
+}
+
+//guildStreams returns every stream which is linked to a member of a guild or followed by it
+func (b *NiaBot) guildStreams(guild *guildmodels.DiscordGuild) (map[string]*guildmodels.StreamChannel, error) {
+	var keys []string
+	members, err := b.DBConnection.GetGuildStreamLinks(guild.DiscordGID)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		for provider, channelID := range member.Connections.StreamLinks {
+			keys = appendUnique(keys, guildmodels.StreamKey(provider, channelID))
+		}
+	}
+	for _, key := range guild.FollowedStreams {
+		keys = appendUnique(keys, key)
+	}
+	for _, team := range guild.FollowedTeams {
+		for _, key := range team.Streams {
+			keys = appendUnique(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return b.DBConnection.GetStreamChannels(keys)
+}
+
+//streamUsedByActiveGuild returns true if the provided stream is linked to a member of, or followed by, any guild which
+//isn't in the provided set of inactive guilds
+func (b *NiaBot) streamUsedByActiveGuild(stream *guildmodels.StreamChannel, inactive map[string]bool) (bool, error) {
+	members, err := b.DBConnection.GetMembersByStream(stream.Provider, stream.ChannelID, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if !inactive[member.GuildID] {
+			return true, nil
+		}
+	}
+	guilds, err := b.DBConnection.GetGuildsFollowingStream(stream.Key)
+	if err != nil {
+		return false, err
+	}
+	for _, guild := range guilds {
+		if !inactive[guild.DiscordGID] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//runGuildPurge periodically deletes the data of guilds which the bot left more than the grace period ago, until stop
+//is closed
+func (b *NiaBot) runGuildPurge(stop <-chan struct{}) {
+	gracePeriod := defaultGuildPurgeGracePeriod
+	if gracePeriodStr, exists := os.LookupEnv(guildPurgeGracePeriodEnvVar); exists {
+		parsed, err := time.ParseDuration(gracePeriodStr)
+		if err != nil || parsed < 0 {
+			logrus.Warnf("Invalid guild purge grace period `%v`, falling back to default of %v", gracePeriodStr, defaultGuildPurgeGracePeriod)
+		} else {
+			gracePeriod = parsed
+		}
+	}
+	ticker := time.NewTicker(guildPurgeCheckInterval)
+	defer ticker.Stop()
+	for {
+		b.purgeInactiveGuilds(gracePeriod)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *NiaBot) purgeInactiveGuilds(gracePeriod time.Duration) {
+	guilds, err := b.DBConnection.GetGuildsLeftBefore(time.Now().Add(-gracePeriod))
+	if err != nil {
+		logrus.Errorf("Failed to look up guilds due to be purged due to error %v", err)
+		return
+	}
+	for i := range guilds {
+		b.purgeGuild(&guilds[i])
+	}
+}
+
+//purgeGuild deletes all data stored for a guild, along with any streams which are no longer used by anyone
+func (b *NiaBot) purgeGuild(guild *guildmodels.DiscordGuild) {
+	gid := guild.DiscordGID
+	streams, err := b.guildStreams(guild)
+	if err != nil {
+		logrus.Errorf("Failed to look up streams used by guild %v due to error %v", gid, err)
+		return
+	}
+	err = b.DBConnection.PurgeGuild(gid)
+	if err != nil {
+		logrus.Errorf("Failed to purge data for guild %v due to error %v", gid, err)
+		return
+	}
+	logrus.Infof("Purged data for guild %v, which was left at %v", gid, guild.LeftAt)
+	for _, stream := range streams {
+		p := b.streamProvider(stream.Provider)
+		if p == nil {
+			continue
+		}
+		b.releaseStream(p, stream)
+	}
+}
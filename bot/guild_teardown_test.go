@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/callummance/nia/discord"
+)
+
+//shardGuildID returns a guild ID which belongs to shard n%count of count
+func shardGuildID(n uint64) string {
+	return strconv.FormatUint(n<<22, 10)
+}
+
+func TestShardReadyLeavesMissingGuilds(t *testing.T) {
+	g := newTestGuild(t)
+	kept, removed, otherShard, alreadyLeft := shardGuildID(2), shardGuildID(4), shardGuildID(5), shardGuildID(6)
+	for _, gid := range []string{kept, removed, otherShard, alreadyLeft} {
+		_, err := g.store.CreateGuild(gid)
+		if err != nil {
+			t.Fatalf("failed to create guild %v: %v", gid, err)
+		}
+	}
+	g.bot.leaveGuild(alreadyLeft)
+	left, err := g.store.GetOrCreateGuild(alreadyLeft)
+	if err != nil {
+		t.Fatalf("failed to look up guild %v: %v", alreadyLeft, err)
+	}
+
+	//Shard 0 of 2 only receives events for guilds with even IDs, so otherShard's absence says nothing about it
+	g.bot.HandleShardReady(&discord.ShardReady{ShardID: 0, ShardCount: 2, GuildIDs: []string{kept}})
+
+	inactive, err := g.store.GetInactiveGuildIDs()
+	if err != nil {
+		t.Fatalf("failed to look up inactive guilds: %v", err)
+	}
+	if len(inactive) != 2 || !inactive[removed] || !inactive[alreadyLeft] {
+		t.Errorf("expected only %v and %v to be inactive, got %v", removed, alreadyLeft, inactive)
+	}
+	//Guilds which were already left shouldn't have their grace period restarted
+	stillLeft, err := g.store.GetOrCreateGuild(alreadyLeft)
+	if err != nil {
+		t.Fatalf("failed to look up guild %v: %v", alreadyLeft, err)
+	}
+	if !stillLeft.LeftAt.Equal(*left.LeftAt) {
+		t.Errorf("expected guild to still have been left at %v, got %v", left.LeftAt, stillLeft.LeftAt)
+	}
+}
@@ -0,0 +1,248 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/discord"
+	"github.com/callummance/nia/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+//linkVerificationTimeout is how long members have to log in after being sent a verification link
+const linkVerificationTimeout = 15 * time.Minute
+
+const handleVerifiedLinksSyntax string = "```" +
+	`!verifiedlinks <on|off>
+	When on, members must log in to prove they own a channel before linking it to their account
+	Admins can still link channels for other members without verification` +
+	"```"
+
+var verifiedLinksRegex = regexp.MustCompile(`^!verifiedlinks\s+(?P<setting>on|off)\s*$`)
+
+//pendingVerification is a link which will be saved once the member proves they own the channel
+type pendingVerification struct {
+	cmd     streamLinkCommand
+	msg     *discordgo.Message
+	channel *streaming.Channel
+	expires time.Time
+}
+
+//HandleVerifiedLinks handles a message from an admin turning verified links on or off for their guild
+//command format: !verifiedlinks <on|off>
+func (b *NiaBot) HandleVerifiedLinks(msg *discordgo.MessageCreate) {
+	commandName := "!verifiedlinks"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.setVerifiedLinks(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) setVerifiedLinks(msg *discordgo.Message) NiaResponse {
+	commandName := "!verifiedlinks"
+	matches := verifiedLinksRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleVerifiedLinksSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	enabled := matches[verifiedLinksRegex.SubexpIndex("setting")] == "on"
+	err := b.DBConnection.SetGuildVerifiedLinks(msg.GuildID, enabled)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst saving server settings",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	if enabled {
+		//Let the admin know if some of the enabled providers can't verify anything
+		var unverifiable []string
+		b.streamProvidersLock.RLock()
+		for _, p := range b.streamProviders {
+			if v, ok := p.(streaming.OwnershipVerifier); !ok {
+				unverifiable = append(unverifiable, p.Info().DisplayName)
+			} else if _, err := v.VerificationURL(""); err != nil {
+				unverifiable = append(unverifiable, p.Info().DisplayName)
+			}
+		}
+		b.streamProvidersLock.RUnlock()
+		if len(unverifiable) > 0 {
+			return NiaResponsePartialSuccess{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: "Verified links are now required, but some channels can't be verified. Members won't be able to link these themselves, but admins can still link them on their behalf.",
+				data:        map[string]string{"Unverifiable providers": fmt.Sprintf("%v", unverifiable)},
+				timestamp:   time.Now(),
+			}
+		}
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//requestLinkVerification DMs the sender of a register command a link to log in to the provider. The channel will be
+//linked once HandleVerification confirms they logged in as its owner.
+func (b *NiaBot) requestLinkVerification(cmd streamLinkCommand, msg *discordgo.Message, p streaming.Provider, channel *streaming.Channel) NiaResponse {
+	commandName := cmd.name
+	providerName := p.Info().DisplayName
+	verifier, ok := p.(streaming.OwnershipVerifier)
+	if !ok {
+		return NiaResponseNotAllowed{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("This server requires channels to be verified before they are linked, but %v channels can't be verified. Please ask an admin to link your channel for you.", providerName),
+			timestamp:   time.Now(),
+		}
+	}
+	state, err := newVerificationState()
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to generate a verification link",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	verifyURL, err := verifier.VerificationURL(state)
+	if err != nil {
+		return NiaResponseFeatureNotEnabled{
+			command:         commandName,
+			commandMsg:      msg.Content,
+			disabledFeature: fmt.Sprintf("%v_verification", p.Info().Name),
+			timestamp:       time.Now(),
+		}
+	}
+	b.addPendingVerification(state, &pendingVerification{
+		cmd:     cmd,
+		msg:     msg,
+		channel: channel,
+		expires: time.Now().Add(linkVerificationTimeout),
+	})
+
+	guildName := msg.GuildID
+	if guild, err := b.DiscordSession().Guild(msg.GuildID); err == nil {
+		guildName = guild.Name
+	}
+	dm, err := b.DiscordSession().UserChannelCreate(msg.Author.ID)
+	if err == nil {
+		_, err = b.DiscordSession().ChannelMessageSendEmbed(dm.ID, &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("Confirm your %v channel", providerName),
+			URL:         verifyURL,
+			Type:        discordgo.EmbedTypeRich,
+			Description: fmt.Sprintf("To link %v to your account in %v, [log in to %v](%v) as %v within the next %v.", channel.DisplayName, guildName, providerName, verifyURL, channel.Login, linkVerificationTimeout),
+			Color:       p.Info().Colour,
+		})
+	}
+	if err != nil {
+		b.takePendingVerification(state)
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't send you a direct message. Please make sure you allow direct messages from members of this server, then try again.",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       "Check your direct messages",
+		description: fmt.Sprintf("I've sent you a link to confirm that you own %v. Your channel will be linked once you've logged in.", channel.DisplayName),
+		timestamp:   time.Now(),
+	}
+}
+
+//HandleVerification is called by a provider once a member has logged in through a verification link. If they logged
+//in as the owner of the channel they asked to link, the link is saved and the result is posted in reply to their
+//original command.
+func (b *NiaBot) HandleVerification(v *streaming.Verification) (string, error) {
+	pending := b.takePendingVerification(v.State)
+	if pending == nil {
+		return "", fmt.Errorf("this link has expired or has already been used; please run the command again to get a new one")
+	}
+	if v.Provider != pending.channel.Provider || v.Channel.ID != pending.channel.ID {
+		logrus.Infof("User %v tried to link %v channel %v but logged in as %v", pending.msg.Author.ID, v.Provider, pending.channel.Login, v.Channel.Login)
+		b.respondToCommand(pending.msg, NiaResponseNotAllowed{
+			command:     pending.cmd.name,
+			commandMsg:  pending.msg.Content,
+			description: fmt.Sprintf("You logged in as %v, which isn't the owner of %v.", v.Channel.Login, pending.channel.Login),
+			timestamp:   time.Now(),
+		})
+		return "", fmt.Errorf("you logged in as %v, but asked to link %v; please log in to the right account and run the command again", v.Channel.Login, pending.channel.Login)
+	}
+	p := b.streamProvider(v.Provider)
+	if p == nil {
+		return "", fmt.Errorf("%v integration is no longer enabled", v.Provider)
+	}
+	//Link the stream alongside the member's other commands in their guild, so that it can't race with them
+	var result NiaResponse
+	done := make(chan struct{})
+	err := b.events.Submit(discord.EventKey(pending.msg.GuildID, pending.msg.Author.ID), func() {
+		defer close(done)
+		result = b.linkStream(pending.cmd, pending.msg, p, pending.msg.Author.ID, pending.channel)
+		b.respondToCommand(pending.msg, result)
+	})
+	if err != nil {
+		logrus.Warnf("Failed to queue verified link of %v channel %v due to error %v", v.Provider, pending.channel.Login, err)
+		return "", fmt.Errorf("your channel was verified, but the bot is shutting down; please try again later")
+	}
+	<-done
+	switch result.(type) {
+	case NiaResponseSuccess, NiaResponsePartialSuccess:
+		return fmt.Sprintf("%v has been linked to your discord account. You can close this page.", pending.channel.DisplayName), nil
+	default:
+		return "", fmt.Errorf("your channel was verified, but something went wrong whilst linking it; check discord for details")
+	}
+}
+
+//addPendingVerification stores a link awaiting verification, clearing out any which have expired
+func (b *NiaBot) addPendingVerification(state string, pending *pendingVerification) {
+	b.pendingVerificationsLock.Lock()
+	defer b.pendingVerificationsLock.Unlock()
+	now := time.Now()
+	for k, v := range b.pendingVerifications {
+		if now.After(v.expires) {
+			delete(b.pendingVerifications, k)
+		}
+	}
+	b.pendingVerifications[state] = pending
+}
+
+//takePendingVerification removes and returns the link awaiting verification with the given state, or nil if there
+//isn't one or it has expired
+func (b *NiaBot) takePendingVerification(state string) *pendingVerification {
+	b.pendingVerificationsLock.Lock()
+	defer b.pendingVerificationsLock.Unlock()
+	pending, exists := b.pendingVerifications[state]
+	delete(b.pendingVerifications, state)
+	if !exists || time.Now().After(pending.expires) {
+		return nil
+	}
+	return pending
+}
+
+//newVerificationState generates an unguessable state parameter for a verification link
+func newVerificationState() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
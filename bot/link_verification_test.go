@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/discord"
+	"github.com/callummance/nia/streaming"
+)
+
+func TestHandleVerificationRunsWithMemberEvents(t *testing.T) {
+	g := newTestGuild(t)
+	const uid = "700000000000000010"
+	g.addMember(t, uid, "streamer")
+	g.bot.addStreamProvider(newFakeProvider())
+	channel := &streaming.Channel{Provider: testProviderName, ID: "1001", Login: "streamer", DisplayName: "Streamer"}
+	cmd, err := g.api.SendMessage(g.addChannel(t, "commands"), uid, "!registerfake streamer")
+	if err != nil {
+		t.Fatalf("failed to send command: %v", err)
+	}
+	msg := cmd.Message
+	g.bot.addPendingVerification("state", &pendingVerification{
+		cmd:     streamLinkCommand{name: "!registerfake", provider: testProviderName},
+		msg:     msg,
+		channel: channel,
+		expires: time.Now().Add(time.Minute),
+	})
+
+	//Hold up the member's events, so the link can't be saved until earlier ones have been handled
+	started := make(chan struct{})
+	release := make(chan struct{})
+	g.bot.events.Submit(discord.EventKey(g.gid, uid), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	type verificationResult struct {
+		msg string
+		err error
+	}
+	results := make(chan verificationResult, 1)
+	go func() {
+		msg, err := g.bot.HandleVerification(&streaming.Verification{Provider: testProviderName, State: "state", Channel: *channel})
+		results <- verificationResult{msg, err}
+	}()
+	select {
+	case res := <-results:
+		t.Fatalf("verification finished before the member's earlier events were handled: %+v", res)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if link, _ := g.store.GetStreamLink(g.gid, uid, testProviderName); link != nil {
+		t.Fatalf("stream was linked before the member's earlier events were handled")
+	}
+
+	close(release)
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatalf("expected verification to succeed, got %v", res.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("verification never finished")
+	}
+	link, err := g.store.GetStreamLink(g.gid, uid, testProviderName)
+	if err != nil || link == nil || link.ChannelID != "1001" {
+		t.Errorf("expected channel 1001 to be linked, got %+v (error %v)", link, err)
+	}
+	if len(g.messages(t, msg.ChannelID)) != 2 {
+		t.Errorf("expected the result to be posted in reply to the original command")
+	}
+}
@@ -0,0 +1,390 @@
+package bot
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+//presenceProviderName is used in place of a streaming provider name for streams detected from discord presences
+const presenceProviderName = "discord"
+
+const discordColourHex = 0x5865f2
+
+const handlePresenceStreamingSyntax string = "```" +
+	`!presencestreaming <on|off>
+	When on, members who show as streaming in discord get the same roles and alerts as members with a linked channel
+	Members with a linked channel will still only be announced once` +
+	"```"
+
+var presenceStreamingRegex = regexp.MustCompile(`^!presencestreaming\s+(?P<setting>on|off)\s*$`)
+
+//presenceStream is a stream which a member is showing in their discord activity
+type presenceStream struct {
+	stream    streaming.LiveStream
+	avatarURL string
+	//guilds contains each guild the member was seen streaming in. It is true if the stream was announced there, or
+	//false if the guild hasn't opted in or the stream is already covered by one of the member's linked channels.
+	guilds map[string]bool
+}
+
+//presenceProvider keeps track of streams detected from discord presences. It isn't a real streaming provider, and is
+//never added to the bot's stream providers, but implements streaming.Provider so that the usual alert flow can be
+//used for these streams. Channel IDs are discord user IDs.
+type presenceProvider struct {
+	streams     map[string]*presenceStream
+	streamsLock sync.RWMutex
+}
+
+func newPresenceProvider() *presenceProvider {
+	return &presenceProvider{
+		streams: make(map[string]*presenceStream),
+	}
+}
+
+//Info returns static details about discord presence streams
+func (p *presenceProvider) Info() streaming.ProviderInfo {
+	return streaming.ProviderInfo{
+		Name:        presenceProviderName,
+		DisplayName: "Discord",
+		Colour:      discordColourHex,
+	}
+}
+
+//ResolveChannel looks up a member who is currently streaming from their stream URL
+func (p *presenceProvider) ResolveChannel(nameOrURL string) (*streaming.Channel, error) {
+	p.streamsLock.RLock()
+	defer p.streamsLock.RUnlock()
+	for uid, s := range p.streams {
+		if s.stream.ChannelURL == nameOrURL {
+			return &streaming.Channel{
+				Provider:        presenceProviderName,
+				ID:              uid,
+				Login:           s.stream.ChannelName,
+				DisplayName:     s.stream.ChannelName,
+				URL:             s.stream.ChannelURL,
+				ProfileImageURL: s.avatarURL,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no member is currently streaming at %v", nameOrURL)
+}
+
+//Subscribe does nothing, as presence updates are received for every member regardless
+func (p *presenceProvider) Subscribe(channelID string) error {
+	return nil
+}
+
+//Unsubscribe does nothing, as presence updates are received for every member regardless
+func (p *presenceProvider) Unsubscribe(channelID string) error {
+	return nil
+}
+
+//GetLiveStreams returns the stream each of the provided members is showing in discord
+func (p *presenceProvider) GetLiveStreams(channelIDs []string) (map[string]*streaming.LiveStream, error) {
+	p.streamsLock.RLock()
+	defer p.streamsLock.RUnlock()
+	res := make(map[string]*streaming.LiveStream)
+	for _, uid := range channelIDs {
+		if s, exists := p.streams[uid]; exists {
+			stream := s.stream
+			res[uid] = &stream
+		}
+	}
+	return res, nil
+}
+
+//ForceUpdate does nothing, as the current presence of a member can't be requested
+func (p *presenceProvider) ForceUpdate(channelID string) error {
+	return nil
+}
+
+//Close does nothing
+func (p *presenceProvider) Close() error {
+	return nil
+}
+
+//HandlePresenceUpdate is called whenever a member's discord presence changes. Members who start or stop showing a
+//streaming activity are treated like a linked channel going online or offline in guilds which have opted in.
+func (b *NiaBot) HandlePresenceUpdate(p *discordgo.PresenceUpdate) {
+	var activity *discordgo.Activity
+	for _, a := range p.Activities {
+		if a != nil && a.Type == discordgo.ActivityTypeStreaming {
+			activity = a
+			break
+		}
+	}
+	uid, gid := p.User.ID, p.GuildID
+
+	b.presence.streamsLock.Lock()
+	current := b.presence.streams[uid]
+	announced, seen := false, false
+	if current != nil {
+		announced, seen = current.guilds[gid]
+	}
+	switch {
+	case activity != nil && seen:
+		//Still streaming, so just keep the details up to date for any alerts made in other guilds
+		current.stream.Title = activity.Details
+		current.stream.Game = activity.State
+		b.presence.streamsLock.Unlock()
+		return
+	case activity == nil && !seen:
+		b.presence.streamsLock.Unlock()
+		return
+	case activity == nil:
+		delete(current.guilds, gid)
+		finished := len(current.guilds) == 0
+		if finished {
+			delete(b.presence.streams, uid)
+		}
+		b.presence.streamsLock.Unlock()
+		if announced {
+			b.endPresenceStream(uid, gid)
+		}
+		if finished {
+			err := b.DBConnection.DeleteStreamChannel(presenceProviderName, uid)
+			if err != nil {
+				logrus.Warnf("Failed to forget presence stream for user %v due to error %v", uid, err)
+			}
+		}
+		return
+	}
+	b.presence.streamsLock.Unlock()
+
+	//The member has just started streaming in this guild
+	announce := b.shouldAnnouncePresenceStream(gid, uid, activity.URL)
+	//Presence updates don't always include the full user, so look up the member if they will be announced
+	name, avatarURL := p.User.Username, p.User.AvatarURL("")
+	if announce {
+		member, err := b.DiscordSession().GuildMember(gid, uid)
+		if err != nil {
+			logrus.Warnf("Failed to look up member %v in guild %v whilst handling presence update due to error %v", uid, gid, err)
+		} else {
+			name, avatarURL = member.Nick, member.User.AvatarURL("")
+			if name == "" {
+				name = member.User.Username
+			}
+		}
+	}
+	b.presence.streamsLock.Lock()
+	current = b.presence.streams[uid]
+	if current == nil {
+		current = &presenceStream{
+			stream: streaming.LiveStream{
+				Provider:    presenceProviderName,
+				ChannelID:   uid,
+				ChannelName: name,
+				Title:       activity.Details,
+				Game:        activity.State,
+				StartedAt:   time.Now(),
+				URL:         activity.URL,
+				ChannelURL:  activity.URL,
+				Platform:    activity.Name,
+			},
+			avatarURL: avatarURL,
+			guilds:    make(map[string]bool),
+		}
+		b.presence.streams[uid] = current
+	}
+	current.guilds[gid] = announce
+	b.presence.streamsLock.Unlock()
+	if !announce {
+		return
+	}
+
+	logrus.Infof("Member %v in guild %v started streaming at %v according to their discord presence", uid, gid, activity.URL)
+	err := b.assignLiveRoles(uid, gid)
+	if err != nil {
+		logrus.Errorf("Failed to assign user id %v role because %v.", uid, err)
+	}
+	err = b.makeGuildAlertPosts(b.presence, uid, uid, gid)
+	if err != nil {
+		logrus.Errorf("Failed to make guild alert posts for GID %v in response to presence stream by %v due to error %v", gid, uid, err)
+	}
+}
+
+//shouldAnnouncePresenceStream returns true if a guild has opted in to presence streaming and the member's stream
+//won't already be announced through one of their linked channels
+func (b *NiaBot) shouldAnnouncePresenceStream(gid, uid, streamURL string) bool {
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		logrus.Errorf("Failed to fetch guild %v from database due to error %v", gid, err)
+		return false
+	} else if !guild.PresenceStreaming {
+		return false
+	}
+	member, err := b.DBConnection.GetMemberData(gid, uid)
+	if err != nil {
+		logrus.Errorf("Failed to look up linked streams of user %v in guild %v due to error %v", uid, gid, err)
+		return false
+	} else if member == nil || len(member.Connections.StreamLinks) == 0 {
+		return true
+	}
+	keys := make([]string, 0, len(member.Connections.StreamLinks))
+	for provider, channelID := range member.Connections.StreamLinks {
+		keys = append(keys, guildmodels.StreamKey(provider, channelID))
+	}
+	streams, err := b.DBConnection.GetStreamChannels(keys)
+	if err != nil {
+		return false
+	}
+	//Discord presences often update before the provider's online event arrives, so a linked channel matching the
+	//stream URL counts even if it isn't live yet
+	login := path.Base(strings.TrimRight(streamURL, "/"))
+	for _, stream := range streams {
+		if stream.IsLive || (stream.Login != "" && strings.EqualFold(stream.Login, login)) {
+			logrus.Debugf("Ignoring presence stream by %v in guild %v as it is covered by linked stream %v", uid, gid, stream.Key)
+			return false
+		}
+	}
+	return true
+}
+
+//endPresenceStream removes the alert posts made for a member's presence stream in a guild, along with their now live
+//roles unless one of their linked streams is live
+func (b *NiaBot) endPresenceStream(uid, gid string) {
+	stream, err := b.DBConnection.GetStreamChannel(presenceProviderName, uid)
+	if err != nil {
+		logrus.Warnf("Failed to look up presence stream for user %v due to error %v", uid, err)
+	} else {
+		b.removeGuildAlertPosts(gid, stream)
+	}
+	stillLive, err := b.memberHasLiveStream(gid, uid)
+	if err != nil {
+		logrus.Errorf("Failed to check whether user id %v has any other live streams because %v.", uid, err)
+	} else if !stillLive {
+		err = b.unassignLiveRoles(uid, gid)
+		if err != nil {
+			logrus.Errorf("Failed to unassign user id %v role because %v.", uid, err)
+		}
+	}
+}
+
+//supersedePresenceStream removes any alerts made from a member's discord presence in a guild, so that a linked
+//channel going live isn't announced twice. Their now live roles are left alone, as the linked channel is live.
+func (b *NiaBot) supersedePresenceStream(uid, gid string) {
+	b.presence.streamsLock.Lock()
+	current := b.presence.streams[uid]
+	announced := current != nil && current.guilds[gid]
+	if announced {
+		current.guilds[gid] = false
+	}
+	b.presence.streamsLock.Unlock()
+	if !announced {
+		return
+	}
+	stream, err := b.DBConnection.GetStreamChannel(presenceProviderName, uid)
+	if err != nil {
+		logrus.Warnf("Failed to look up presence stream for user %v due to error %v", uid, err)
+		return
+	}
+	b.removeGuildAlertPosts(gid, stream)
+}
+
+//resetGuildPresenceStreams is called when presence streaming is turned on or off in a guild. Turning it off ends every
+//presence stream announced in the guild, whilst turning it on forgets streams which were previously ignored so that
+//they are announced on the member's next presence update.
+func (b *NiaBot) resetGuildPresenceStreams(gid string, enabled bool) {
+	var ended []string
+	b.presence.streamsLock.Lock()
+	for uid, s := range b.presence.streams {
+		announced, seen := s.guilds[gid]
+		if !enabled && announced {
+			s.guilds[gid] = false
+			ended = append(ended, uid)
+		} else if enabled && seen && !announced {
+			delete(s.guilds, gid)
+		}
+	}
+	b.presence.streamsLock.Unlock()
+	for _, uid := range ended {
+		b.endPresenceStream(uid, gid)
+	}
+}
+
+//clearPresenceStreams removes alerts and roles left over from presence streams from before the bot was restarted, as
+//there is no way to tell whether those members are still streaming
+func (b *NiaBot) clearPresenceStreams() {
+	uids, err := b.DBConnection.GetAllStreamChannelIDs(presenceProviderName)
+	if err != nil {
+		logrus.Warnf("Failed to look up leftover presence streams due to error %v", err)
+		return
+	}
+	for _, uid := range uids {
+		stream, err := b.DBConnection.GetStreamChannel(presenceProviderName, uid)
+		if err != nil {
+			continue
+		}
+		guilds := make(map[string]bool)
+		for _, post := range stream.DiscordStatusPosts {
+			guilds[post.GuildID] = true
+		}
+		for gid := range guilds {
+			b.endPresenceStream(uid, gid)
+		}
+		err = b.DBConnection.DeleteStreamChannel(presenceProviderName, uid)
+		if err != nil {
+			logrus.Warnf("Failed to forget presence stream for user %v due to error %v", uid, err)
+		}
+	}
+}
+
+//HandlePresenceStreaming handles a message from an admin turning presence based stream alerts on or off for their
+//guild
+//command format: !presencestreaming <on|off>
+func (b *NiaBot) HandlePresenceStreaming(msg *discordgo.MessageCreate) {
+	commandName := "!presencestreaming"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.setPresenceStreaming(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) setPresenceStreaming(msg *discordgo.Message) NiaResponse {
+	commandName := "!presencestreaming"
+	matches := presenceStreamingRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handlePresenceStreamingSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	enabled := matches[presenceStreamingRegex.SubexpIndex("setting")] == "on"
+	if enabled && !b.DiscordSession().PresencesEnabled() {
+		return NiaResponseFeatureNotEnabled{
+			command:         commandName,
+			commandMsg:      msg.Content,
+			disabledFeature: "discord_presences",
+			timestamp:       time.Now(),
+		}
+	}
+	err := b.DBConnection.SetGuildPresenceStreaming(msg.GuildID, enabled)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst saving server settings",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	b.resetGuildPresenceStreams(msg.GuildID, enabled)
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
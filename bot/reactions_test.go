@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/callummance/nia/guildmodels"
+)
+
+const testMemberID = "700000000000000010"
+
+//setUpReactionRole creates a message with a reaction role rule for the provided emoji, returning the channel, message
+//and role IDs
+func setUpReactionRole(t *testing.T, g *testGuild, emojiID string, opts guildmodels.ReactionRoleAssign) (string, string, string) {
+	t.Helper()
+	roleID := g.addRole(t, "Gamer")
+	chanID := g.addChannel(t, "roles")
+	g.addMember(t, testMemberID, "member")
+	msg, err := g.api.SendMessage(chanID, testMemberID, "react to get roles")
+	if err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	opts.MsgID = msg.ID
+	opts.ChanID = chanID
+	opts.EmojiID = emojiID
+	err = g.store.AddManagedRoleRule(guildmodels.ManagedRoleRule{
+		RoleID:  roleID,
+		GuildID: g.gid,
+		RoleAssignment: guildmodels.RoleAssignment{
+			AssignmentType:   "reaction",
+			ReactionRoleData: &opts,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to add role rule: %v", err)
+	}
+	return chanID, msg.ID, roleID
+}
+
+//react adds and then removes a member's reaction to a message, passing each event to the bot. It returns whether the
+//member had the role after each.
+func react(t *testing.T, g *testGuild, chanID, msgID, emojiID, roleID string) (bool, bool) {
+	t.Helper()
+	ev, err := g.api.AddReaction(chanID, msgID, testMemberID, emojiID)
+	if err != nil {
+		t.Fatalf("failed to add reaction: %v", err)
+	}
+	g.bot.HandleReactionAdd(ev)
+	afterAdd := g.memberHasRole(t, testMemberID, roleID)
+	ev, err = g.api.RemoveReaction(chanID, msgID, testMemberID, emojiID)
+	if err != nil {
+		t.Fatalf("failed to remove reaction: %v", err)
+	}
+	g.bot.HandleReactionRemove(ev)
+	return afterAdd, g.memberHasRole(t, testMemberID, roleID)
+}
+
+func TestReactionRoles(t *testing.T) {
+	t.Run("AddAndRemove", func(t *testing.T) {
+		g := newTestGuild(t)
+		chanID, msgID, roleID := setUpReactionRole(t, g, "🎮", guildmodels.ReactionRoleAssign{})
+		added, removed := react(t, g, chanID, msgID, "🎮", roleID)
+		if !added {
+			t.Errorf("member did not get role after reacting")
+		}
+		if removed {
+			t.Errorf("member kept role after removing their reaction")
+		}
+		rules, err := g.store.GetRoleRules(g.gid, roleID)
+		if err != nil || len(rules) != 1 {
+			t.Errorf("expected role rule to still be stored, got %v, %v", rules, err)
+		}
+	})
+
+	t.Run("CustomEmoji", func(t *testing.T) {
+		g := newTestGuild(t)
+		chanID, msgID, roleID := setUpReactionRole(t, g, "nia:700000000000000020", guildmodels.ReactionRoleAssign{})
+		added, removed := react(t, g, chanID, msgID, "nia:700000000000000020", roleID)
+		if !added || removed {
+			t.Errorf("expected role to be added then removed, got added %v, still present %v", added, removed)
+		}
+	})
+
+	t.Run("OtherEmoji", func(t *testing.T) {
+		g := newTestGuild(t)
+		chanID, msgID, roleID := setUpReactionRole(t, g, "🎮", guildmodels.ReactionRoleAssign{})
+		added, _ := react(t, g, chanID, msgID, "🎲", roleID)
+		if added {
+			t.Errorf("member got role after reacting with a different emoji")
+		}
+	})
+
+	t.Run("DisallowRemoval", func(t *testing.T) {
+		g := newTestGuild(t)
+		chanID, msgID, roleID := setUpReactionRole(t, g, "🎮", guildmodels.ReactionRoleAssign{DisallowRoleRemoveal: true})
+		added, removed := react(t, g, chanID, msgID, "🎮", roleID)
+		if !added || !removed {
+			t.Errorf("expected role to be added and kept, got added %v, still present %v", added, removed)
+		}
+	})
+
+	t.Run("ClearReaction", func(t *testing.T) {
+		g := newTestGuild(t)
+		chanID, msgID, roleID := setUpReactionRole(t, g, "🎮", guildmodels.ReactionRoleAssign{ShouldClear: true})
+		ev, err := g.api.AddReaction(chanID, msgID, testMemberID, "🎮")
+		if err != nil {
+			t.Fatalf("failed to add reaction: %v", err)
+		}
+		g.bot.HandleReactionAdd(ev)
+		if !g.memberHasRole(t, testMemberID, roleID) {
+			t.Errorf("member did not get role after reacting")
+		}
+		users, err := g.api.Reactions(chanID, msgID, "🎮")
+		if err != nil || len(users) != 0 {
+			t.Errorf("expected reaction to be cleared, got %v, %v", users, err)
+		}
+		//Clearing the reaction generates a removal event, which mustn't take the role away again
+		g.bot.HandleReactionRemove(ev)
+		if !g.memberHasRole(t, testMemberID, roleID) {
+			t.Errorf("member lost role after their reaction was cleared")
+		}
+	})
+}
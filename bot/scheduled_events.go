@@ -0,0 +1,386 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/discord"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+//scheduleSyncIntervalEnvVar sets how often linked members' stream schedules are synced to scheduled events
+const scheduleSyncIntervalEnvVar string = "NIA_SCHEDULE_SYNC_INTERVAL"
+const defaultScheduleSyncInterval = time.Hour
+
+//scheduleSyncHorizon is how far ahead scheduled events are created
+const scheduleSyncHorizon = 7 * 24 * time.Hour
+
+//Discord's limits on the length of scheduled event names and descriptions
+const (
+	maxScheduledEventNameLength        = 100
+	maxScheduledEventDescriptionLength = 1000
+)
+
+const handleScheduledEventsSyntax string = "```" +
+	`!scheduledevents <on|off>
+	When on, streams that linked members have scheduled in the next week are added to this server's events
+	Members can leave their own schedule out with !shareschedule off` +
+	"```"
+
+const handleShareScheduleSyntax string = "```" +
+	`!shareschedule <on|off> [member]
+	Sets whether your stream schedule is added to this server's events, if the server has scheduled events turned on
+	[member] is optional, and defaults to yourself. Only admins can change the setting for other members` +
+	"```"
+
+var scheduledEventsRegex = regexp.MustCompile(`^!scheduledevents\s+(?P<setting>on|off)\s*$`)
+var shareScheduleRegex = regexp.MustCompile(`^!shareschedule\s+(?P<setting>on|off)(?:\s+(?P<member>\S+))?\s*$`)
+
+//scheduleFetcher returns the schedule for a stream, caching it so that streams linked in several guilds are only
+//looked up once per sync
+type scheduleFetcher struct {
+	bot       *NiaBot
+	until     time.Time
+	schedules map[string][]streaming.ScheduledStream
+	errs      map[string]error
+}
+
+func (b *NiaBot) newScheduleFetcher() *scheduleFetcher {
+	return &scheduleFetcher{
+		bot:       b,
+		until:     time.Now().Add(scheduleSyncHorizon),
+		schedules: make(map[string][]streaming.ScheduledStream),
+		errs:      make(map[string]error),
+	}
+}
+
+//fetch returns the schedule for a channel. The bool is false if the provider doesn't support schedules.
+func (f *scheduleFetcher) fetch(provider, channelID string) ([]streaming.ScheduledStream, bool, error) {
+	reader, ok := f.bot.streamProvider(provider).(streaming.ScheduleReader)
+	if !ok {
+		return nil, false, nil
+	}
+	key := guildmodels.StreamKey(provider, channelID)
+	if err, failed := f.errs[key]; failed {
+		return nil, true, err
+	}
+	if schedule, exists := f.schedules[key]; exists {
+		return schedule, true, nil
+	}
+	schedule, err := reader.GetSchedule(channelID, f.until)
+	if err != nil {
+		logrus.Warnf("Failed to fetch schedule for %v due to error %v", key, err)
+		f.errs[key] = err
+		return nil, true, err
+	}
+	f.schedules[key] = schedule
+	return schedule, true, nil
+}
+
+//runScheduleSync periodically syncs the scheduled events of every guild which has them turned on until stop is
+//closed
+func (b *NiaBot) runScheduleSync(stop <-chan struct{}) {
+	interval := defaultScheduleSyncInterval
+	if intervalStr, exists := os.LookupEnv(scheduleSyncIntervalEnvVar); exists {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			logrus.Warnf("Invalid schedule sync interval `%v`, falling back to default of %v", intervalStr, defaultScheduleSyncInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.syncAllSchedules()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//syncAllSchedules syncs the scheduled events of every guild which has them turned on
+func (b *NiaBot) syncAllSchedules() {
+	guilds, err := b.DBConnection.GetGuildsWithScheduledEvents()
+	if err != nil {
+		logrus.Errorf("Failed to look up guilds to sync scheduled events for due to error %v", err)
+		return
+	}
+	fetcher := b.newScheduleFetcher()
+	for _, guild := range guilds {
+		b.syncGuildSchedule(guild.DiscordGID, fetcher)
+	}
+}
+
+//syncGuildSchedule creates, edits and cancels a guild's scheduled events to match the schedules of its linked members
+func (b *NiaBot) syncGuildSchedule(gid string, fetcher *scheduleFetcher) {
+	b.scheduleSyncLock.Lock()
+	defer b.scheduleSyncLock.Unlock()
+	members, err := b.DBConnection.GetGuildStreamLinks(gid)
+	if err != nil {
+		logrus.Errorf("Failed to look up linked members of guild %v whilst syncing scheduled events due to error %v", gid, err)
+		return
+	}
+	existingLinks, err := b.DBConnection.GetGuildScheduledEvents(gid)
+	if err != nil {
+		logrus.Errorf("Failed to look up scheduled events of guild %v due to error %v", gid, err)
+		return
+	}
+	existing := make(map[string]*guildmodels.ScheduledEventLink, len(existingLinks))
+	for i := range existingLinks {
+		existing[existingLinks[i].ScheduleKey] = &existingLinks[i]
+	}
+
+	//Work out which events the guild should have
+	wanted := make(map[string]*guildmodels.ScheduledEventLink)
+	//Streams whose schedules couldn't be fetched, whose events should be left alone until next time
+	failed := make(map[string]bool)
+	for _, member := range members {
+		if member.HideSchedule {
+			continue
+		}
+		for provider, channelID := range member.Connections.StreamLinks {
+			schedule, supported, err := fetcher.fetch(provider, channelID)
+			streamKey := guildmodels.StreamKey(provider, channelID)
+			if !supported {
+				continue
+			} else if err != nil {
+				failed[streamKey] = true
+				continue
+			}
+			for _, stream := range schedule {
+				key := guildmodels.ScheduleKey(provider, channelID, stream.ID)
+				wanted[key] = scheduledEventLink(gid, key, streamKey, b.streamProvider(provider).Info(), &stream)
+			}
+		}
+	}
+
+	now := time.Now()
+	for key, want := range wanted {
+		have := existing[key]
+		switch {
+		case have == nil || (have.Cancelled && !want.Cancelled):
+			//Cancelled events can't be brought back, so a new event is needed if a cancelled stream is reinstated
+			if want.Cancelled || !want.StartTime.After(now) {
+				continue
+			}
+			ev, err := b.DiscordSession().GuildScheduledEventCreate(gid, scheduledEvent(want))
+			if err != nil {
+				logrus.Warnf("Failed to create scheduled event for %v in guild %v due to error %v", key, gid, err)
+				continue
+			}
+			want.EventID = ev.ID
+		case have.Cancelled:
+			continue
+		case want.Cancelled:
+			err := b.DiscordSession().GuildScheduledEventSetStatus(gid, have.EventID, discord.ScheduledEventStatusCancelled)
+			if err != nil {
+				logrus.Warnf("Failed to cancel scheduled event %v for %v in guild %v due to error %v", have.EventID, key, gid, err)
+				continue
+			}
+			want.EventID = have.EventID
+		case scheduledEventChanged(have, want) && want.StartTime.After(now):
+			_, err := b.DiscordSession().GuildScheduledEventEdit(gid, have.EventID, scheduledEvent(want))
+			if err != nil {
+				logrus.Warnf("Failed to edit scheduled event %v for %v in guild %v due to error %v", have.EventID, key, gid, err)
+				continue
+			}
+			want.EventID = have.EventID
+		default:
+			continue
+		}
+		err := b.DBConnection.SetScheduledEventLink(want)
+		if err != nil {
+			logrus.Warnf("Failed to take note of scheduled event for %v in guild %v due to error %v", key, gid, err)
+		}
+	}
+
+	//Cancel any upcoming events for streams which are no longer scheduled, or whose member has left the schedule
+	for key, have := range existing {
+		if _, stillWanted := wanted[key]; stillWanted || failed[have.StreamKey] {
+			continue
+		}
+		b.removeScheduledEvent(have, now)
+	}
+}
+
+//clearGuildSchedule cancels every upcoming scheduled event which has been created in a guild
+func (b *NiaBot) clearGuildSchedule(gid string) {
+	b.scheduleSyncLock.Lock()
+	defer b.scheduleSyncLock.Unlock()
+	links, err := b.DBConnection.GetGuildScheduledEvents(gid)
+	if err != nil {
+		logrus.Errorf("Failed to look up scheduled events of guild %v due to error %v", gid, err)
+		return
+	}
+	now := time.Now()
+	for i := range links {
+		b.removeScheduledEvent(&links[i], now)
+	}
+}
+
+//removeScheduledEvent cancels a scheduled event if it hasn't started yet, then forgets about it
+func (b *NiaBot) removeScheduledEvent(link *guildmodels.ScheduledEventLink, now time.Time) {
+	if !link.Cancelled && link.StartTime.After(now) {
+		err := b.DiscordSession().GuildScheduledEventSetStatus(link.GuildID, link.EventID, discord.ScheduledEventStatusCancelled)
+		if err != nil {
+			logrus.Warnf("Failed to cancel scheduled event %v in guild %v due to error %v", link.EventID, link.GuildID, err)
+			return
+		}
+	}
+	err := b.DBConnection.DeleteScheduledEventLink(link.GuildID, link.ScheduleKey)
+	if err != nil {
+		logrus.Warnf("Failed to forget scheduled event %v in guild %v due to error %v", link.EventID, link.GuildID, err)
+	}
+}
+
+//scheduledEventLink builds the scheduled event which should exist in a guild for a scheduled stream
+func scheduledEventLink(gid, key, streamKey string, info streaming.ProviderInfo, stream *streaming.ScheduledStream) *guildmodels.ScheduledEventLink {
+	name := stream.Title
+	if name == "" {
+		name = fmt.Sprintf("%v is streaming", stream.ChannelName)
+	}
+	description := fmt.Sprintf("%v is streaming on %v", stream.ChannelName, info.DisplayName)
+	if stream.Game != "" {
+		description = fmt.Sprintf("%v is streaming %v on %v", stream.ChannelName, stream.Game, info.DisplayName)
+	}
+	return &guildmodels.ScheduledEventLink{
+		GuildID:     gid,
+		ScheduleKey: key,
+		StreamKey:   streamKey,
+		Name:        truncate(name, maxScheduledEventNameLength),
+		Description: truncate(description, maxScheduledEventDescriptionLength),
+		Location:    stream.ChannelURL,
+		StartTime:   stream.StartTime,
+		EndTime:     stream.EndTime,
+		Cancelled:   stream.Cancelled,
+	}
+}
+
+//scheduledEvent converts a stored scheduled event into the form used by the discord API
+func scheduledEvent(link *guildmodels.ScheduledEventLink) *discord.ScheduledEvent {
+	return &discord.ScheduledEvent{
+		Name:        link.Name,
+		Description: link.Description,
+		Location:    link.Location,
+		StartTime:   link.StartTime,
+		EndTime:     link.EndTime,
+	}
+}
+
+//scheduledEventChanged returns true if a scheduled event needs editing to match the schedule
+func scheduledEventChanged(have, want *guildmodels.ScheduledEventLink) bool {
+	return have.Name != want.Name ||
+		have.Description != want.Description ||
+		have.Location != want.Location ||
+		!have.StartTime.Equal(want.StartTime) ||
+		!have.EndTime.Equal(want.EndTime)
+}
+
+//truncate shortens a string to at most n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+//HandleScheduledEvents handles a message from an admin turning scheduled events for linked members' stream schedules
+//on or off
+//command format: !scheduledevents <on|off>
+func (b *NiaBot) HandleScheduledEvents(msg *discordgo.MessageCreate) {
+	commandName := "!scheduledevents"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.setScheduledEvents(msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func (b *NiaBot) setScheduledEvents(msg *discordgo.Message) NiaResponse {
+	commandName := "!scheduledevents"
+	matches := scheduledEventsRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleScheduledEventsSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	enabled := matches[scheduledEventsRegex.SubexpIndex("setting")] == "on"
+	err := b.DBConnection.SetGuildScheduledEvents(msg.GuildID, enabled)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst saving server settings",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	if enabled {
+		go b.syncGuildSchedule(msg.GuildID, b.newScheduleFetcher())
+	} else {
+		go b.clearGuildSchedule(msg.GuildID)
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//HandleShareSchedule handles a message from a member choosing whether their stream schedule is added to the guild's
+//scheduled events
+//command format: !shareschedule <on|off> [member]
+func (b *NiaBot) HandleShareSchedule(msg *discordgo.MessageCreate) {
+	b.respondToCommand(msg.Message, b.shareSchedule(msg.Message))
+}
+
+func (b *NiaBot) shareSchedule(msg *discordgo.Message) NiaResponse {
+	commandName := "!shareschedule"
+	matches := shareScheduleRegex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      handleShareScheduleSyntax,
+			timestamp:   time.Now(),
+		}
+	}
+	uid, resp := b.streamCommandTarget(commandName, msg, matches[shareScheduleRegex.SubexpIndex("member")], handleShareScheduleSyntax)
+	if resp != nil {
+		return resp
+	}
+	share := matches[shareScheduleRegex.SubexpIndex("setting")] == "on"
+	err := b.DBConnection.SetMemberHideSchedule(msg.GuildID, uid, !share)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst saving member settings",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err == nil && guild.ScheduledEvents {
+		go b.syncGuildSchedule(msg.GuildID, b.newScheduleFetcher())
+	}
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
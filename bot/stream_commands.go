@@ -3,138 +3,178 @@ package bot
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
 	"github.com/callummance/nia/twitch"
+	"github.com/callummance/nia/youtube"
 	"github.com/sirupsen/logrus"
 )
 
 const handleRegisterTwitchSyntax string = "```" +
 	`!registertwitch "<twitch>"
-	<twitch> can be a twitch username or channel URL` +
+	<twitch> can be a twitch username or channel URL
+	Admins can also link a stream for another member using !registertwitch @<member> "<twitch>"` +
 	"```"
 
-var broadcasterURLRegex = regexp.MustCompile(`!registertwitch\s+"?(?:(?:https?://)?(?:(?:www|go|m)\.)?twitch\.tv/)?(?P<username>[a-zA-Z0-9_]{4,25})"?`)
+const handleUnregisterTwitchSyntax string = "```" +
+	`!unregistertwitch
+	Removes the twitch stream linked to your account
+	Admins can also remove another member's stream using !unregistertwitch @<member>` +
+	"```"
+
+const handleRegisterYoutubeSyntax string = "```" +
+	`!registeryoutube "<youtube>"
+	<youtube> can be a youtube handle, channel ID or channel URL
+	Admins can also link a channel for another member using !registeryoutube @<member> "<youtube>"` +
+	"```"
+
+const handleUnregisterYoutubeSyntax string = "```" +
+	`!unregisteryoutube
+	Removes the youtube channel linked to your account
+	Admins can also remove another member's channel using !unregisteryoutube @<member>` +
+	"```"
+
+var registerTwitchRegex = regexp.MustCompile(`^!registertwitch\s+(?:(?P<member><@!?\d+>)\s+)?"?(?P<channel>[^"\s]+)"?\s*$`)
+var unregisterTwitchRegex = regexp.MustCompile(`^!unregistertwitch\s*(?P<member><@!?\d+>)?\s*$`)
+var registerYoutubeRegex = regexp.MustCompile(`^!registeryoutube\s+(?:(?P<member><@!?\d+>)\s+)?"?(?P<channel>[^"\s]+)"?\s*$`)
+var unregisterYoutubeRegex = regexp.MustCompile(`^!unregisteryoutube\s*(?P<member><@!?\d+>)?\s*$`)
+
+//streamLinkCommand describes a command which links or unlinks a member's channel on a single provider
+type streamLinkCommand struct {
+	name     string
+	provider string
+	regex    *regexp.Regexp
+	syntax   string
+}
+
+var registerTwitchCommand = streamLinkCommand{
+	name:     "!registertwitch",
+	provider: twitch.ProviderName,
+	regex:    registerTwitchRegex,
+	syntax:   handleRegisterTwitchSyntax,
+}
+
+var unregisterTwitchCommand = streamLinkCommand{
+	name:     "!unregistertwitch",
+	provider: twitch.ProviderName,
+	regex:    unregisterTwitchRegex,
+	syntax:   handleUnregisterTwitchSyntax,
+}
+
+var registerYoutubeCommand = streamLinkCommand{
+	name:     "!registeryoutube",
+	provider: youtube.ProviderName,
+	regex:    registerYoutubeRegex,
+	syntax:   handleRegisterYoutubeSyntax,
+}
+
+var unregisterYoutubeCommand = streamLinkCommand{
+	name:     "!unregisteryoutube",
+	provider: youtube.ProviderName,
+	regex:    unregisterYoutubeRegex,
+	syntax:   handleUnregisterYoutubeSyntax,
+}
 
 //HandleRegisterTwitchCommandMessage takes a message from any server member and registers a twitch channel for them
 func (b *NiaBot) HandleRegisterTwitchCommandMessage(msg *discordgo.MessageCreate) {
-	result := b.registerTwitch(msg.Message)
-	result.WriteToLog()
-	resp := result.DiscordResponse()
-	msgRef := discordgo.MessageReference{
-		MessageID: msg.ID,
-		ChannelID: msg.ChannelID,
-		GuildID:   msg.GuildID,
-	}
-	resp.Reference = &msgRef
-	_, err := b.DiscordSession().ChannelMessageSendComplex(msg.ChannelID, resp)
-	if err != nil {
-		logrus.Errorf("Failed to send response to command due to error %v", err)
-	}
+	result := b.registerStream(registerTwitchCommand, msg.Message)
+	b.respondToCommand(msg.Message, result)
 }
 
-func (b *NiaBot) registerTwitch(msg *discordgo.Message) NiaResponse {
-	commandName := "!registertwitch"
-	t, errResp := b.getTwitchClient(commandName, msg.Content)
+//registerStream links the channel named in a register command to the sender, or to the member they mention
+func (b *NiaBot) registerStream(cmd streamLinkCommand, msg *discordgo.Message) NiaResponse {
+	commandName := cmd.name
+	p, errResp := b.getStreamProvider(commandName, msg.Content, cmd.provider)
 	if errResp != nil {
 		return *errResp
 	}
-	matches := broadcasterURLRegex.FindStringSubmatch(msg.Content)
-	unameIdx := broadcasterURLRegex.SubexpIndex("username")
-	if matches == nil || len(matches) <= unameIdx {
+	matches := cmd.regex.FindStringSubmatch(msg.Content)
+	if matches == nil {
 		//no match
 		return NiaResponseSyntaxError{
 			command:     commandName,
 			commandMsg:  msg.Content,
 			description: "I couldn't understand that",
-			syntax:      handleRegisterTwitchSyntax,
+			syntax:      cmd.syntax,
 			timestamp:   time.Now(),
 		}
 	}
-	username := matches[unameIdx]
-	//Check username is valid
-	broadcaster, err := t.GetBroadcasterDeets(username)
+	//Work out who the stream should be linked to
+	uid, targetErr := b.streamCommandTarget(commandName, msg, matches[cmd.regex.SubexpIndex("member")], cmd.syntax)
+	if targetErr != nil {
+		return targetErr
+	}
+	channelName := matches[cmd.regex.SubexpIndex("channel")]
+	//Check channel is valid
+	channel, err := p.ResolveChannel(channelName)
 	if err != nil {
-		//Could not find anyone with that username
+		//Could not find anyone with that name
 		return NiaResponseSyntaxError{
 			command:     commandName,
 			commandMsg:  msg.Content,
-			description: fmt.Sprintf("I couldn't find any user with the username %v", username),
-			syntax:      handleRegisterTwitchSyntax,
+			description: fmt.Sprintf("I couldn't find any %v channel called %v", p.Info().DisplayName, channelName),
+			syntax:      cmd.syntax,
 			timestamp:   time.Now(),
 		}
 	}
-	//We have a valid broadcaster, so save it to the database and register a subscription
-	oldStream, newStream, err := b.DBConnection.SetTwitchConnectionData(msg.GuildID, msg.Author.ID, broadcaster.ID)
+	//Members linking their own channel may need to prove they own it first
+	if uid == msg.Author.ID {
+		guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+		if err != nil {
+			return NiaResponseInternalError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: "Encountered internal database error whilst retrieving server settings",
+				data:        map[string]string{"Error": err.Error()},
+				timestamp:   time.Now(),
+			}
+		} else if guild.VerifiedLinks {
+			return b.requestLinkVerification(cmd, msg, p, channel)
+		}
+	}
+	return b.linkStream(cmd, msg, p, uid, channel)
+}
+
+//linkStream saves a link between a member and a channel, subscribes to the channel and updates the member's roles and
+//alerts to match its current state
+func (b *NiaBot) linkStream(cmd streamLinkCommand, msg *discordgo.Message, p streaming.Provider, uid string, channel *streaming.Channel) NiaResponse {
+	commandName := cmd.name
+	oldStream, newStream, err := b.DBConnection.SetStreamLink(msg.GuildID, uid, cmd.provider, channel.ID)
 	if err != nil {
 		//DB error of some kind
 		return NiaResponseInternalError{
 			command:     commandName,
 			commandMsg:  msg.Content,
-			description: "Encountered internal database error whilst saving twitch connection details",
+			description: fmt.Sprintf("Encountered internal database error whilst saving %v connection details", p.Info().DisplayName),
 			data:        map[string]string{"Error": err.Error()},
 			timestamp:   time.Now(),
 		}
 	}
+	err = b.DBConnection.SetStreamChannelLogin(cmd.provider, channel.ID, channel.Login)
+	if err != nil {
+		logrus.Warnf("Failed to save login name %v for %v channel %v due to error %v", channel.Login, cmd.provider, channel.ID, err)
+	}
 	//If there is an oldStream, we need to do some more cleaning up
-	if oldStream != nil && oldStream.TwitchUID != newStream.TwitchUID {
-		//Check if there are any others in the guild linked to the same stream
-		linkedMembers, err := b.DBConnection.GetMemberByConnection(guildmodels.MemberConnections{TwitchConnection: oldStream}, &msg.GuildID, nil)
-		if err != nil {
-			logrus.Errorf("Failed to look up remaining members linked to twitch stream ID %v in guild %v due to error %v", oldStream.TwitchUID, msg.GuildID, err)
-		} else {
-			if linkedMembers != nil && len(linkedMembers) >= 0 {
-				//There are other members in the guild with the same stream linked, so no need to remove anything else
-				logrus.Debugf("No need to remove any posts as there still exists at least one linked member in the same guild")
-			} else {
-				postsToRemove := make([]guildmodels.MessageRef, 0)
-				for _, post := range oldStream.DiscordStatusPosts {
-					if post.GuildID == msg.GuildID {
-						postsToRemove = append(postsToRemove, post)
-					}
-				}
-				//Remove alert posts as that user was the only one in the guild with that channel linked
-				b.removeAlertPosts(postsToRemove)
-			}
-		}
-		//Remove now streaming roles from user if their new stream is not also streaming
-		if !newStream.IsLive {
-			b.unassignLiveRoles(msg.Author.ID, msg.GuildID)
-		}
-		//If there are no other members with the same stream linked, we should remove it from the DB and unsubscribe from twitch alerts
-		globalLinkedMembers, err := b.DBConnection.GetMemberByConnection(guildmodels.MemberConnections{TwitchConnection: oldStream}, nil, nil)
-		if err != nil {
-			logrus.Errorf("Failed to look up remaining members linked to twitch stream ID %v in globally due to error %v", oldStream.TwitchUID, err)
-		} else {
-			if globalLinkedMembers == nil || len(globalLinkedMembers) == 0 {
-				//Unsubscribe from eventsub notifications
-				err := t.UnsubscribeFromStream(oldStream.TwitchUID)
-				if err != nil {
-					logrus.Errorf("Failed to unsubscribe from twitch alerts for stream uid %v due to error %v", oldStream.TwitchUID, err)
-				}
-				//Delete twitch stream from DB
-				err = b.DBConnection.DeleteTwitchStream(oldStream.TwitchUID)
-				if err != nil {
-					logrus.Errorf("Failed to remove twitch uid %v from DB due to error %v", oldStream.TwitchUID, err)
-				}
-			}
-		}
+	if oldStream != nil && oldStream.Key != newStream.Key {
+		b.cleanupStreamLink(p, msg.GuildID, uid, oldStream)
 	}
-	err = t.SubscribeToStream(newStream.TwitchUID)
+	err = p.Subscribe(newStream.ChannelID)
 	if err != nil {
 		return NiaResponseInternalError{
 			command:     commandName,
 			commandMsg:  msg.Content,
-			description: "Encountered error whilst subscribing to twitch updates. Please try again later or contact a developer.",
+			description: fmt.Sprintf("Encountered error whilst subscribing to %v updates. Please try again later or contact a developer.", p.Info().DisplayName),
 			data:        map[string]string{"Error": err.Error()},
 			timestamp:   time.Now(),
 		}
 	}
 	if !newStream.IsLive {
 		//update newly connected stream
-		err := t.ForceStreamUpdate(newStream.TwitchUID)
+		err := p.ForceUpdate(newStream.ChannelID)
 		if err != nil {
 			return NiaResponsePartialSuccess{
 				command:     commandName,
@@ -146,7 +186,7 @@ func (b *NiaBot) registerTwitch(msg *discordgo.Message) NiaResponse {
 		}
 	} else {
 		//assign roles and make post as needed
-		err := b.SetUserStreaming(newStream.TwitchUID, msg.Author.ID, msg.GuildID)
+		err := b.SetUserStreaming(p, newStream.ChannelID, uid, msg.GuildID)
 		if err != nil {
 			return NiaResponsePartialSuccess{
 				command:     commandName,
@@ -164,6 +204,388 @@ func (b *NiaBot) registerTwitch(msg *discordgo.Message) NiaResponse {
 	}
 }
 
+//HandleUnregisterTwitchCommandMessage takes a message from any server member and removes their linked twitch channel
+func (b *NiaBot) HandleUnregisterTwitchCommandMessage(msg *discordgo.MessageCreate) {
+	result := b.unregisterStream(unregisterTwitchCommand, msg.Message)
+	b.respondToCommand(msg.Message, result)
+}
+
+//unregisterStream removes the sender's link to a provider, or the link of the member they mention
+func (b *NiaBot) unregisterStream(cmd streamLinkCommand, msg *discordgo.Message) NiaResponse {
+	commandName := cmd.name
+	p, errResp := b.getStreamProvider(commandName, msg.Content, cmd.provider)
+	if errResp != nil {
+		return *errResp
+	}
+	matches := cmd.regex.FindStringSubmatch(msg.Content)
+	if matches == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "I couldn't understand that",
+			syntax:      cmd.syntax,
+			timestamp:   time.Now(),
+		}
+	}
+	uid, targetErr := b.streamCommandTarget(commandName, msg, matches[cmd.regex.SubexpIndex("member")], cmd.syntax)
+	if targetErr != nil {
+		return targetErr
+	}
+	oldStream, err := b.DBConnection.RemoveStreamLink(msg.GuildID, uid, cmd.provider)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("Encountered internal database error whilst removing %v connection details", p.Info().DisplayName),
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if oldStream == nil {
+		return NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("<@%v> doesn't have a %v stream linked", uid, p.Info().DisplayName),
+			syntax:      cmd.syntax,
+			timestamp:   time.Now(),
+		}
+	}
+	b.cleanupStreamLink(p, msg.GuildID, uid, oldStream)
+	return NiaResponseSuccess{
+		command:    commandName,
+		commandMsg: msg.Content,
+		timestamp:  time.Now(),
+	}
+}
+
+//HandleRegisterYoutubeCommandMessage takes a message from any server member and registers a youtube channel for them
+func (b *NiaBot) HandleRegisterYoutubeCommandMessage(msg *discordgo.MessageCreate) {
+	result := b.registerStream(registerYoutubeCommand, msg.Message)
+	b.respondToCommand(msg.Message, result)
+}
+
+//HandleUnregisterYoutubeCommandMessage takes a message from any server member and removes their linked youtube channel
+func (b *NiaBot) HandleUnregisterYoutubeCommandMessage(msg *discordgo.MessageCreate) {
+	result := b.unregisterStream(unregisterYoutubeCommand, msg.Message)
+	b.respondToCommand(msg.Message, result)
+}
+
+//HandleListStreamsCommandMessage takes a message from an admin and replies with every member of the guild who has a
+//stream linked and every stream the guild follows, along with whether they are currently live.
+//command format: !liststreams
+func (b *NiaBot) HandleListStreamsCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!liststreams"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.listStreams(commandName, msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+//HandleListTwitchCommandMessage is kept as an alias of !liststreams from before other providers were supported
+//command format: !listtwitch
+func (b *NiaBot) HandleListTwitchCommandMessage(msg *discordgo.MessageCreate) {
+	commandName := "!listtwitch"
+	result := b.checkAdmin(commandName, msg.Message)
+	if result == nil {
+		result = b.listStreams(commandName, msg.Message)
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+//linkedStream pairs a member with one of the channels they have linked
+type linkedStream struct {
+	userID string
+	key    string
+}
+
+func (b *NiaBot) listStreams(commandName string, msg *discordgo.Message) NiaResponse {
+	links, err := b.DBConnection.GetGuildStreamLinks(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to look up linked streams in the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch guild details from the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	if len(links) == 0 && len(guild.FollowedStreams) == 0 && len(guild.FollowedTeams) == 0 {
+		return NiaResponseInfo{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			title:       "Linked streams",
+			description: "Nobody in this server has linked a stream yet.",
+			timestamp:   time.Now(),
+		}
+	}
+	//Collect every channel we need details on
+	memberStreams := make([]linkedStream, 0, len(links))
+	keys := make([]string, 0, len(links)+len(guild.FollowedStreams))
+	for _, link := range links {
+		providers := make([]string, 0, len(link.Connections.StreamLinks))
+		for provider := range link.Connections.StreamLinks {
+			providers = append(providers, provider)
+		}
+		sort.Strings(providers)
+		for _, provider := range providers {
+			key := guildmodels.StreamKey(provider, link.Connections.StreamLinks[provider])
+			memberStreams = append(memberStreams, linkedStream{userID: link.UserID, key: key})
+			keys = append(keys, key)
+		}
+	}
+	keys = append(keys, guild.FollowedStreams...)
+	streams, err := b.DBConnection.GetStreamChannels(keys)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to look up stream details in the database",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	liveStreams, err := b.getLiveStreams(streams)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Failed to fetch the current state of linked streams",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	memberLines := make([]string, 0, len(memberStreams))
+	noLive := 0
+	for _, link := range memberStreams {
+		live, isLive := liveStreams[link.key]
+		if isLive {
+			noLive++
+		}
+		memberLines = append(memberLines, fmt.Sprintf("<@%v>: %v", link.userID, b.describeStreamState(link.key, streams[link.key], live)))
+	}
+	followedLines := make([]string, 0, len(guild.FollowedStreams))
+	for _, key := range guild.FollowedStreams {
+		followedLines = append(followedLines, b.describeStreamState(key, streams[key], liveStreams[key]))
+	}
+	teamLines := make([]string, 0, len(guild.FollowedTeams))
+	for _, team := range guild.FollowedTeams {
+		teamLines = append(teamLines, fmt.Sprintf("%v: %d member(s)", team.DisplayName, len(team.Streams)))
+	}
+	fields := append(linesToFields("Members", memberLines), linesToFields("Followed streams", followedLines)...)
+	fields = append(fields, linesToFields("Followed teams", teamLines)...)
+	if len(guild.FollowedCategories) > 0 {
+		fields = append(fields, linesToFields("Followed categories", []string{describeFollowedCategories(guild.FollowedCategories)})...)
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       "Linked streams",
+		description: fmt.Sprintf("%d member(s) have linked a stream, of which %d are currently live. This server also follows %d stream(s).", len(links), noLive, len(guild.FollowedStreams)),
+		fields:      fields,
+		timestamp:   time.Now(),
+	}
+}
+
+//getLiveStreams asks each provider which of the provided streams are currently live, returning details on each live
+//stream keyed by stream key. Streams from providers which aren't enabled are treated as offline.
+func (b *NiaBot) getLiveStreams(streams map[string]*guildmodels.StreamChannel) (map[string]*streaming.LiveStream, error) {
+	byProvider := make(map[string][]string)
+	for _, stream := range streams {
+		byProvider[stream.Provider] = append(byProvider[stream.Provider], stream.ChannelID)
+	}
+	res := make(map[string]*streaming.LiveStream)
+	for provider, channelIDs := range byProvider {
+		p := b.streamProvider(provider)
+		if p == nil {
+			continue
+		}
+		live, err := p.GetLiveStreams(channelIDs)
+		if err != nil {
+			return nil, err
+		}
+		for channelID, stream := range live {
+			res[guildmodels.StreamKey(provider, channelID)] = stream
+		}
+	}
+	return res, nil
+}
+
+//describeStreamState returns a short description of a stream, including what is being streamed if live is non-nil.
+//stream may be nil if it could not be found in the database.
+func (b *NiaBot) describeStreamState(key string, stream *guildmodels.StreamChannel, live *streaming.LiveStream) string {
+	if stream == nil {
+		return fmt.Sprintf("unknown stream %v", key)
+	}
+	providerName := stream.Provider
+	if p := b.streamProvider(stream.Provider); p != nil {
+		providerName = p.Info().DisplayName
+	}
+	var streamDesc string
+	switch {
+	case live != nil:
+		streamDesc = fmt.Sprintf("[%v](%v) on %v", live.ChannelName, live.URL, providerName)
+	case stream.Login != "":
+		streamDesc = fmt.Sprintf("%v on %v", stream.Login, providerName)
+	default:
+		streamDesc = fmt.Sprintf("%v channel ID %v", providerName, stream.ChannelID)
+	}
+	if live != nil {
+		return fmt.Sprintf("%v, **live** playing %v for %d viewers", streamDesc, live.Game, live.Viewers)
+	}
+	return fmt.Sprintf("%v, offline", streamDesc)
+}
+
+//streamCommandTarget works out which member a stream link command should apply to. If memberStr is empty, it will
+//be the sender of the message; otherwise the sender must be an admin.
+func (b *NiaBot) streamCommandTarget(commandName string, msg *discordgo.Message, memberStr string, syntax string) (string, NiaResponse) {
+	if memberStr == "" {
+		return msg.Author.ID, nil
+	}
+	if resp := b.checkAdmin(commandName, msg); resp != nil {
+		return "", resp
+	}
+	member, err := b.interpretMemberString(memberStr, msg.GuildID)
+	if err != nil {
+		return "", NiaResponseSyntaxError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: fmt.Sprintf("I couldn't find the member %v", memberStr),
+			syntax:      syntax,
+			timestamp:   time.Now(),
+		}
+	}
+	return member.User.ID, nil
+}
+
+//cleanupStreamLink tidies up after a member's link to oldStream has been removed from the database. It removes alert
+//posts for the stream if the guild no longer has any use for it, removes the member's now live roles unless another of
+//their linked streams is live, and unsubscribes from the stream entirely if it is no longer used by anyone.
+func (b *NiaBot) cleanupStreamLink(p streaming.Provider, gid, uid string, oldStream *guildmodels.StreamChannel) {
+	b.removeUnusedGuildAlertPosts(gid, oldStream)
+	//Remove now streaming roles from user if none of their remaining streams are live
+	if oldStream.IsLive {
+		stillLive, err := b.memberHasLiveStream(gid, uid)
+		if err != nil {
+			logrus.Errorf("Failed to check whether user %v in guild %v has any other live streams due to error %v", uid, gid, err)
+		} else if !stillLive {
+			err := b.unassignLiveRoles(uid, gid)
+			if err != nil {
+				logrus.Errorf("Failed to remove now live roles from user %v in guild %v due to error %v", uid, gid, err)
+			}
+		}
+	}
+	b.releaseStream(p, oldStream)
+}
+
+//removeUnusedGuildAlertPosts removes any alert posts for the provided stream in a guild, as long as nobody in the
+//guild has the stream linked and the guild doesn't follow it.
+func (b *NiaBot) removeUnusedGuildAlertPosts(gid string, stream *guildmodels.StreamChannel) {
+	inUse, err := b.guildUsesStream(gid, stream)
+	if err != nil {
+		logrus.Errorf("Failed to check whether stream %v is still used in guild %v due to error %v", stream.Key, gid, err)
+		return
+	} else if inUse {
+		//There are other members in the guild with the same stream linked, so no need to remove anything else
+		logrus.Debugf("No need to remove any posts as the stream is still linked or followed in the same guild")
+		return
+	}
+	//Remove alert posts as nobody else in the guild wants them
+	b.removeGuildAlertPosts(gid, stream)
+}
+
+//removeGuildAlertPosts removes all of the alert posts made for the provided stream in a guild
+func (b *NiaBot) removeGuildAlertPosts(gid string, stream *guildmodels.StreamChannel) {
+	postsToRemove := make([]guildmodels.MessageRef, 0)
+	for _, post := range stream.DiscordStatusPosts {
+		if post.GuildID == gid {
+			postsToRemove = append(postsToRemove, post)
+		}
+	}
+	b.removeAlertPosts(postsToRemove)
+	for _, post := range postsToRemove {
+		err := b.DBConnection.RemoveDiscordStatusPost(stream.Provider, stream.ChannelID, &post)
+		if err != nil {
+			logrus.Warnf("Failed to remove record of alert post %v due to error %v", post, err)
+		}
+	}
+}
+
+//releaseStream unsubscribes from alerts for a stream and removes it from the DB, as long as no members have it linked
+//and no guilds follow it.
+func (b *NiaBot) releaseStream(p streaming.Provider, stream *guildmodels.StreamChannel) {
+	inUse, err := b.streamInUse(stream)
+	if err != nil {
+		logrus.Errorf("Failed to check whether stream %v is still in use due to error %v", stream.Key, err)
+		return
+	} else if inUse {
+		return
+	}
+	//Stop listening for events
+	err = p.Unsubscribe(stream.ChannelID)
+	if err != nil {
+		logrus.Errorf("Failed to unsubscribe from alerts for stream %v due to error %v", stream.Key, err)
+	}
+	//Delete stream from DB
+	err = b.DBConnection.DeleteStreamChannel(stream.Provider, stream.ChannelID)
+	if err != nil {
+		logrus.Errorf("Failed to remove stream %v from DB due to error %v", stream.Key, err)
+	}
+}
+
+//guildUsesStream returns true if any members of a guild have the provided stream linked or if the guild follows it,
+//either directly or as part of a team
+func (b *NiaBot) guildUsesStream(gid string, stream *guildmodels.StreamChannel) (bool, error) {
+	linkedMembers, err := b.DBConnection.GetMembersByStream(stream.Provider, stream.ChannelID, &gid)
+	if err != nil {
+		return false, err
+	} else if len(linkedMembers) > 0 {
+		return true, nil
+	}
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		return false, err
+	}
+	return guild.FollowsStream(stream.Key), nil
+}
+
+//streamInUse returns true if the provided stream is linked to any member or followed by any guild
+func (b *NiaBot) streamInUse(stream *guildmodels.StreamChannel) (bool, error) {
+	globalLinkedMembers, err := b.DBConnection.GetMembersByStream(stream.Provider, stream.ChannelID, nil)
+	if err != nil {
+		return false, err
+	} else if len(globalLinkedMembers) > 0 {
+		return true, nil
+	}
+	followingGuilds, err := b.DBConnection.GetGuildsFollowingStream(stream.Key)
+	if err != nil {
+		return false, err
+	}
+	return len(followingGuilds) > 0, nil
+}
+
+func (b *NiaBot) getStreamProvider(command, msgContent, name string) (streaming.Provider, *NiaResponseFeatureNotEnabled) {
+	p := b.streamProvider(name)
+	if p == nil {
+		return nil, &NiaResponseFeatureNotEnabled{
+			command:         command,
+			commandMsg:      msgContent,
+			disabledFeature: fmt.Sprintf("%v_integration", name),
+			timestamp:       time.Now(),
+		}
+	}
+	return p, nil
+}
+
 func (b *NiaBot) getTwitchClient(command, msgContent string) (*twitch.EventSource, *NiaResponseFeatureNotEnabled) {
 	if b.TwitchConnection == nil {
 		return nil, &NiaResponseFeatureNotEnabled{
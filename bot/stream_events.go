@@ -2,116 +2,161 @@ package bot
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/callummance/nazuna/messages"
 	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
 	"github.com/sirupsen/logrus"
 )
 
 const twitchColourHex = 0x6441a5
 
-//HandleTwitchStreamOffline handles a streamoffline event generated by the Twitch EventSub API
-func (b *NiaBot) HandleTwitchStreamOffline(e *messages.StreamOfflineEvent) {
-	//Lookup which member(s) have this stream registered for them
-	connectionLookup := guildmodels.MemberConnections{
-		TwitchConnection: &guildmodels.TwitchStream{
-			TwitchUID: e.BroadcasterUID,
-		},
+//HandleStreamOffline handles an offline event generated by any of the streaming providers
+func (b *NiaBot) HandleStreamOffline(e *streaming.OfflineEvent) {
+	err := b.DBConnection.SetStreamChannelLive(e.Provider, e.ChannelID, false)
+	if err != nil {
+		logrus.Errorf("Failed to record stream offline event %v due to error %v", e, err)
 	}
-	matchingMembers, err := b.DBConnection.GetMemberByConnection(connectionLookup, nil, nil)
+	b.recordSessionEnd(e.Provider, e.ChannelID)
+	//Lookup which member(s) have this stream registered for them
+	matchingMembers, err := b.DBConnection.GetMembersByStream(e.Provider, e.ChannelID, nil)
 	if err != nil {
-		logrus.Errorf("Failed to fetch members for streamoffline event %v due to error %v", e, err)
+		logrus.Errorf("Failed to fetch members for stream offline event %v due to error %v", e, err)
 	}
-	//Lookup any relevant role assignments in each members' guild
-	guildUpdates := make(map[string][]string) //Maps each guild to a slice of userIDs which need to be updated
+	//Update each of the members, unless they are still live on another of their linked streams
 	for _, member := range matchingMembers {
-		guildUpdates[member.GuildID] = append(guildUpdates[member.GuildID], member.UserID)
-	}
-	//Update each of the members
-	for guild, members := range guildUpdates {
-		for _, member := range members {
-			err := b.unassignLiveRoles(member, guild)
-			if err != nil {
-				logrus.Errorf("Failed to unassign user id %v role because %v.", member, err)
-			}
+		stillLive, err := b.memberHasLiveStream(member.GuildID, member.UserID)
+		if err != nil {
+			logrus.Errorf("Failed to check whether user id %v has any other live streams because %v.", member.UserID, err)
+		} else if stillLive {
+			continue
+		}
+		err = b.unassignLiveRoles(member.UserID, member.GuildID)
+		if err != nil {
+			logrus.Errorf("Failed to unassign user id %v role because %v.", member.UserID, err)
 		}
 	}
 	//Delete alert posts
-	err = b.removeStreamAlertPosts(e.BroadcasterUID)
+	err = b.removeStreamAlertPosts(e.Provider, e.ChannelID)
 	if err != nil {
-		logrus.Errorf("Failed to remove guild alert posts for twitch event %v due to error %v", e, err)
+		logrus.Errorf("Failed to remove guild alert posts for stream offline event %v due to error %v", e, err)
 	}
 }
 
-//HandleTwitchStreamOnline handles a streamonline event generated by the Twitch EventSub API
-func (b *NiaBot) HandleTwitchStreamOnline(e *messages.StreamOnlineEvent) {
+//HandleStreamOnline handles an online event generated by any of the streaming providers
+func (b *NiaBot) HandleStreamOnline(e *streaming.OnlineEvent) {
+	p := b.streamProvider(e.Provider)
+	if p == nil {
+		logrus.Errorf("Received stream online event %v from provider %v which is not enabled", e, e.Provider)
+		return
+	}
+	err := b.DBConnection.SetStreamChannelLive(e.Provider, e.ChannelID, true)
+	if err != nil {
+		logrus.Errorf("Failed to record stream online event %v due to error %v", e, err)
+	}
+	b.recordSessionStart(p, e.ChannelID)
 	//Lookup which member(s) have this stream registered for them
-	connectionLookup := guildmodels.MemberConnections{
-		TwitchConnection: &guildmodels.TwitchStream{
-			TwitchUID: e.BroadcasterUID,
-		},
+	matchingMembers, err := b.DBConnection.GetMembersByStream(e.Provider, e.ChannelID, nil)
+	if err != nil {
+		logrus.Errorf("Failed to fetch members for stream online event %v due to error %v", e, err)
 	}
-	matchingMembers, err := b.DBConnection.GetMemberByConnection(connectionLookup, nil, nil)
+	//Guilds which the bot has been removed from can't be posted in
+	inactiveGuilds, err := b.DBConnection.GetInactiveGuildIDs()
 	if err != nil {
-		logrus.Errorf("Failed to fetch members for streamonline event %v due to error %v", e, err)
+		logrus.Warnf("Failed to look up inactive guilds for stream online event %v due to error %v", e, err)
 	}
 	//Lookup any relevant role assignments in each members' guild
 	guildUpdates := make(map[string][]string) //Maps each guild to a slice of userIDs which need to be updated
 	for _, member := range matchingMembers {
+		if inactiveGuilds[member.GuildID] {
+			continue
+		}
 		guildUpdates[member.GuildID] = append(guildUpdates[member.GuildID], member.UserID)
 	}
 	//Update each of the members
 	for guild, members := range guildUpdates {
 		for _, member := range members {
+			b.supersedePresenceStream(member, guild)
 			err := b.assignLiveRoles(member, guild)
 			if err != nil {
 				logrus.Errorf("Failed to assign user id %v role because %v.", member, err)
 			}
 		}
 		//Make alert posts
-		err := b.makeGuildAlertPosts(e.BroadcasterUID, e.BroadcasterUserName, guild)
+		err := b.makeGuildAlertPosts(p, e.ChannelID, members[0], guild)
 		if err != nil {
-			logrus.Errorf("Failed to make guild alert posts for GID %v in response to twitch event %v due to error %v", guild, e, err)
+			logrus.Errorf("Failed to make guild alert posts for GID %v in response to stream online event %v due to error %v", guild, e, err)
+		}
+	}
+	//Make alert posts in guilds which follow the stream without having it linked to a member
+	followingGuilds, err := b.DBConnection.GetGuildsFollowingStream(guildmodels.StreamKey(e.Provider, e.ChannelID))
+	if err != nil {
+		logrus.Errorf("Failed to fetch guilds following stream for stream online event %v due to error %v", e, err)
+	}
+	for _, guild := range followingGuilds {
+		if _, alreadyPosted := guildUpdates[guild.DiscordGID]; alreadyPosted || !guild.Active() {
+			continue
+		}
+		err := b.makeFollowedStreamAlertPosts(p, e.ChannelID, guild.DiscordGID)
+		if err != nil {
+			logrus.Errorf("Failed to make guild alert posts for GID %v in response to stream online event %v due to error %v", guild.DiscordGID, e, err)
 		}
 	}
 }
 
 //SetUserStreaming assigns the provided correct role and makes an announcement post (if needed) for the
 //given stream in the given discord guild
-func (b *NiaBot) SetUserStreaming(tuid, uid, gid string) error {
-	//Fetch stream data from twitch
-	t, errResp := b.getTwitchClient("", "")
-	if errResp != nil {
-		return fmt.Errorf("twitch support is not enabled on this bot instance")
-	}
-	stream, err := t.GetStream(tuid)
+func (b *NiaBot) SetUserStreaming(p streaming.Provider, channelID, uid, gid string) error {
+	//Check the stream is actually live
+	live, err := p.GetLiveStreams([]string{channelID})
 	if err != nil {
-		logrus.Warnf("Failed to get stream data for twitch user %v due to error %v", tuid, err)
+		logrus.Warnf("Failed to get stream data for %v channel %v due to error %v", p.Info().Name, channelID, err)
 		return err
-	} else if stream == nil {
+	} else if live[channelID] == nil {
 		logrus.Warnf("Attempted to SetUserStreaming on a streamer that is not currently live.")
-		return fmt.Errorf("twitch user %v does not appear to be live currently", tuid)
+		return fmt.Errorf("%v channel %v does not appear to be live currently", p.Info().Name, channelID)
 	}
+	b.supersedePresenceStream(uid, gid)
 	//Assign role
 	err = b.assignLiveRoles(uid, gid)
 	if err != nil {
 		logrus.Errorf("Failed to assign stream live role to user %v in guild %v due to error %v", uid, gid, err)
 	}
 	//Make alert post if needed
-	err = b.makeGuildAlertPosts(tuid, stream.UserName, gid)
+	err = b.makeGuildAlertPosts(p, channelID, uid, gid)
 	if err != nil {
-		logrus.Errorf("Failed to make stream announcement post for stream %v in guild %v due to error %v", tuid, gid, err)
+		logrus.Errorf("Failed to make stream announcement post for %v channel %v in guild %v due to error %v", p.Info().Name, channelID, gid, err)
 	}
 	return nil
 }
 
+//memberHasLiveStream returns true if any of the streams linked to a member are currently live
+func (b *NiaBot) memberHasLiveStream(gid, uid string) (bool, error) {
+	member, err := b.DBConnection.GetMemberData(gid, uid)
+	if err != nil || member == nil {
+		return false, err
+	}
+	keys := make([]string, 0, len(member.Connections.StreamLinks))
+	for provider, channelID := range member.Connections.StreamLinks {
+		keys = append(keys, guildmodels.StreamKey(provider, channelID))
+	}
+	streams, err := b.DBConnection.GetStreamChannels(keys)
+	if err != nil {
+		return false, err
+	}
+	for _, stream := range streams {
+		if stream.IsLive {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 //assignLiveRoles assigns any roles specified to be assigned when a stream is live from the provided
 //member
 func (b *NiaBot) assignLiveRoles(uid, gid string) error {
-	//Get roles which will be assigned via twitch stream live alert
+	//Get roles which will be assigned via stream live alert
 	roles, err := b.DBConnection.LookupNowLiveRoles(gid)
 	if err != nil {
 		logrus.Errorf("Failed to lookup stream roles for guild %v due to error %v", gid, err)
@@ -119,7 +164,7 @@ func (b *NiaBot) assignLiveRoles(uid, gid string) error {
 	}
 	//Assign each of the roles we found
 	for _, role := range roles {
-		logrus.Infof("Adding role %v for user %v based as they have gone online on twitch.", role, uid)
+		logrus.Infof("Adding role %v for user %v based as they have gone live.", role, uid)
 		err := b.DiscordSession().GuildMemberRoleAdd(gid, uid, role.RoleID)
 		if err != nil {
 			logrus.Errorf("Failed to assign user id %v role %v because %v.", uid, role.RoleID, err)
@@ -131,7 +176,7 @@ func (b *NiaBot) assignLiveRoles(uid, gid string) error {
 //assignLiveRoles removes any roles specified to be assigned when a stream is live from the provided
 //member
 func (b *NiaBot) unassignLiveRoles(uid, gid string) error {
-	//Get roles which have been assigned via twitch stream live alert
+	//Get roles which have been assigned via stream live alert
 	roles, err := b.DBConnection.LookupNowLiveRoles(gid)
 	if err != nil {
 		logrus.Errorf("Failed to lookup stream roles for guild %v due to error %v", gid, err)
@@ -139,7 +184,7 @@ func (b *NiaBot) unassignLiveRoles(uid, gid string) error {
 	}
 	//Remove each of the roles we found
 	for _, role := range roles {
-		logrus.Infof("Removing role %v for user %v as they have gone offline on twitch.", role, uid)
+		logrus.Infof("Removing role %v for user %v as they have gone offline.", role, uid)
 		err := b.DiscordSession().GuildMemberRoleRemove(gid, uid, role.RoleID)
 		if err != nil {
 			logrus.Errorf("Failed to assign user id %v role %v because %v.", uid, role.RoleID, err)
@@ -149,15 +194,16 @@ func (b *NiaBot) unassignLiveRoles(uid, gid string) error {
 }
 
 //removeStreamAlertPosts attempts to remove all alert posts created for the provided stream
-func (b *NiaBot) removeStreamAlertPosts(twitchUID string) error {
-	stream, err := b.DBConnection.GetTwitchStream(twitchUID)
+func (b *NiaBot) removeStreamAlertPosts(provider, channelID string) error {
+	stream, err := b.DBConnection.GetStreamChannel(provider, channelID)
 	if err != nil {
-		logrus.Warnf("Failed to look up data on twitch stream %v in DB due to error %v", err)
+		logrus.Warnf("Failed to look up data on %v stream %v in DB due to error %v", provider, channelID, err)
 		return err
 	}
 	statusPosts := stream.DiscordStatusPosts
 	b.removeAlertPosts(statusPosts)
-	return nil
+	//Forget about the removed posts so that alerts will be made next time the stream goes live
+	return b.DBConnection.ClearDiscordStatusPosts(provider, channelID)
 }
 
 //removeAlertPosts attempts to remove all of the provided discord posts.
@@ -171,20 +217,21 @@ func (b *NiaBot) removeAlertPosts(posts []guildmodels.MessageRef) {
 	}
 }
 
-//makeGuildAlertPosts makes a post in the channel assigned for stream notifications in a guild, announcing the stream with UID provided.
-//It then adds a message reference to the DB
-func (b *NiaBot) makeGuildAlertPosts(twitchUID, twitchName, gid string) error {
+//makeGuildAlertPosts makes a post in each of the channels a guild's alert routes select for the channel with the ID
+//provided. It then adds a message reference to the DB. uid should be the ID of the discord member linked to the stream.
+func (b *NiaBot) makeGuildAlertPosts(p streaming.Provider, channelID, uid, gid string) error {
+	provider := p.Info().Name
 	guild, err := b.DBConnection.GetOrCreateGuild(gid)
 	if err != nil {
 		logrus.Warnf("Failed to look up guild details for gid %v when trying to make stream alert posts due to error %v", gid, err)
 		return err
 	}
-	stream, err := b.DBConnection.GetTwitchStream(twitchUID)
+	dbStream, err := b.DBConnection.GetStreamChannel(provider, channelID)
 	var statusPosts []guildmodels.MessageRef
-	if err == nil {
+	if err != nil {
 		statusPosts = []guildmodels.MessageRef{}
 	} else {
-		statusPosts = stream.DiscordStatusPosts
+		statusPosts = dbStream.DiscordStatusPosts
 	}
 	//Return early if we have already made a status post in the provided discord guild
 	for _, post := range statusPosts {
@@ -193,70 +240,130 @@ func (b *NiaBot) makeGuildAlertPosts(twitchUID, twitchName, gid string) error {
 			return nil
 		}
 	}
-	if guild.NotificationChannels != nil && guild.NotificationChannels.StreamNotificationsChannel != nil {
-		chans := []string{*guild.NotificationChannels.StreamNotificationsChannel}
-		msgIDs, err := b.postAlerts(twitchUID, twitchName, chans)
+	routes := guild.NotificationChannels.AlertRoutes()
+	if len(routes) == 0 {
+		return nil
+	}
+	live, err := p.GetLiveStreams([]string{channelID})
+	if err != nil {
+		logrus.Warnf("Failed to fetch stream details for %v channel %v due to error %v", provider, channelID, err)
+		return err
+	}
+	stream := live[channelID]
+	if stream == nil {
+		logrus.Warnf("%v channel %v seems to have gone offline, skipping notification posts.", provider, channelID)
+		return fmt.Errorf("stream %v was offline", guildmodels.StreamKey(provider, channelID))
+	}
+	//Work out which channels the alert should go to
+	var memberRoles []string
+	if uid != "" && routesNeedMemberRoles(routes) {
+		member, err := b.DiscordSession().GuildMember(gid, uid)
 		if err != nil {
-			logrus.Warnf("Failed to make stream alert posts for twitch stream %v in guild %v due to error %v", twitchName, gid, err)
+			logrus.Warnf("Failed to look up roles for member %v in guild %v whilst routing stream alerts due to error %v", uid, gid, err)
+		} else {
+			memberRoles = member.Roles
 		}
-		for _, msgID := range msgIDs {
-			msgRef := guildmodels.MessageRef{
-				GuildID:   gid,
-				ChannelID: chans[0],
-				MessageID: msgID,
-			}
-			err := b.DBConnection.AddDiscordStatusPost(twitchUID, &msgRef)
-			if err != nil {
-				logrus.Warnf("Failed to take note of stream alert posts due to error %v", err)
-				return err
-			}
+	}
+	chans := matchAlertRoutes(routes, stream.Game, stream.IsMature, uid, memberRoles)
+	if len(chans) == 0 {
+		logrus.Debugf("No alert routes in guild %v matched %v channel %v", gid, provider, channelID)
+		return nil
+	}
+	msgIDs, err := b.postAlerts(p, stream, uid, guildAlertTemplate(guild), chans)
+	if err != nil {
+		logrus.Warnf("Failed to make stream alert posts for %v channel %v in guild %v due to error %v", provider, stream.ChannelName, gid, err)
+	}
+	for i, msgID := range msgIDs {
+		if msgID == "" {
+			continue
+		}
+		msgRef := guildmodels.MessageRef{
+			GuildID:   gid,
+			ChannelID: chans[i],
+			MessageID: msgID,
+		}
+		err := b.DBConnection.AddDiscordStatusPost(provider, channelID, &msgRef)
+		if err != nil {
+			logrus.Warnf("Failed to take note of stream alert posts due to error %v", err)
+			return err
 		}
 	}
 	return nil
 }
 
-//postAlerts makes posts accouncing the twitchUID stream has gone online in each of the provided channels. If successful,
-//it returns messageIDs for each of the created posts
-func (b *NiaBot) postAlerts(twitchUID, twitchName string, channels []string) ([]string, error) {
-	msgIDs := make([]string, len(channels))
-	t, res := b.getTwitchClient("", "")
-	if res != nil {
-		return nil, fmt.Errorf("twitch client features are not enabled, so twitch alert cannot be enabled")
-	}
-	stream, err := t.GetStream(twitchUID)
+//makeFollowedStreamAlertPosts makes alert posts in a guild for a stream which it follows rather than having linked to
+//a member, as long as the stream is in one of the categories the guild follows
+func (b *NiaBot) makeFollowedStreamAlertPosts(p streaming.Provider, channelID, gid string) error {
+	guild, err := b.DBConnection.GetOrCreateGuild(gid)
+	if err != nil {
+		logrus.Warnf("Failed to look up guild details for gid %v when trying to make stream alert posts due to error %v", gid, err)
+		return err
+	}
+	if len(guild.FollowedCategories) > 0 {
+		live, err := p.GetLiveStreams([]string{channelID})
+		if err != nil {
+			logrus.Warnf("Failed to fetch stream details for %v channel %v due to error %v", p.Info().Name, channelID, err)
+			return err
+		} else if stream := live[channelID]; stream != nil && !guild.WantsCategory(stream.Game) {
+			logrus.Debugf("Skipping alert posts for %v channel %v in guild %v as %v isn't a followed category", p.Info().Name, channelID, gid, stream.Game)
+			return nil
+		}
+	}
+	return b.makeGuildAlertPosts(p, channelID, "", gid)
+}
+
+//postAlerts makes posts accouncing the provided stream has gone online in each of the provided channels, using the
+//provided alert template. If successful, it returns messageIDs for each of the created posts, with an empty string
+//for any channels which could not be posted in.
+func (b *NiaBot) postAlerts(p streaming.Provider, stream *streaming.LiveStream, uid string, tmpl guildmodels.AlertTemplate, channels []string) ([]string, error) {
+	msgIDs := make([]string, 0, len(channels))
+	info := p.Info()
+	var profileImageURL string
+	channel, err := p.ResolveChannel(stream.ChannelURL)
 	if err != nil {
-		logrus.Warnf("Failed to fetch stream details for twitch stream %v due to error %v", twitchUID, err)
-		return nil, err
-	} else if stream == nil {
-		logrus.Warnf("Twitch stream %v seems to have gone offline, skipping notification posts.", twitchUID)
-		return nil, fmt.Errorf("stream %v was offline", twitchUID)
+		//Not worth missing the alert over
+		logrus.Warnf("Failed to fetch channel details for %v stream %v due to error %v", info.Name, stream.ChannelName, err)
+	} else {
+		profileImageURL = channel.ProfileImageURL
+	}
+	alertData := streamAlertData{
+		Streamer: stream.ChannelName,
+		Title:    stream.Title,
+		Game:     stream.Game,
+		Viewers:  stream.Viewers,
+		URL:      stream.URL,
+		Platform: info.DisplayName,
+	}
+	if stream.Platform != "" {
+		alertData.Platform = stream.Platform
+	}
+	if uid != "" {
+		alertData.Member = fmt.Sprintf("<@%v>", uid)
 	}
-	broadcaster, err := t.GetBroadcasterDeets(twitchName)
+	notification, err := renderAlertTemplate(tmpl, alertData)
 	if err != nil {
-		logrus.Warnf("Failed to fetch user details for twitch broadcaster %v due to error %v", twitchName, err)
-		return nil, err
-	}
-	thumb := strings.Replace(stream.ThumbnailURL, "{width}", "1920", 1)
-	thumb = strings.Replace(thumb, "{height}", "1080", 1)
-	notificationEmbed := discordgo.MessageEmbed{
-		Title:       stream.Title,
-		Type:        "rich",
-		Description: fmt.Sprintf("%v is streaming %v for %d users", twitchName, stream.GameName, stream.ViewerCount),
-		URL:         fmt.Sprintf("https://twitch.tv/%v", twitchName),
-		Timestamp:   stream.StartedAt.Format(time.RFC3339),
-		Color:       twitchColourHex,
-		Image:       &discordgo.MessageEmbedImage{URL: thumb},
-		Author: &discordgo.MessageEmbedAuthor{
-			URL:     fmt.Sprintf("https://twitch.tv/%v", twitchName),
-			Name:    twitchName,
-			IconURL: broadcaster.ProfileImageURL,
-		},
+		//Fall back to the default template so that a broken template doesn't stop alerts altogether
+		logrus.Warnf("Failed to render stream alert template %#v due to error %v; falling back to default template.", tmpl, err)
+		notification, err = renderAlertTemplate(guildmodels.DefaultAlertTemplate(), alertData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tmpl.Colour == nil {
+		notification.Embed.Color = info.Colour
+	}
+	notification.Embed.Timestamp = stream.StartedAt.Format(time.RFC3339)
+	notification.Embed.Image = &discordgo.MessageEmbedImage{URL: stream.ThumbnailURL}
+	notification.Embed.Author = &discordgo.MessageEmbedAuthor{
+		URL:     stream.ChannelURL,
+		Name:    stream.ChannelName,
+		IconURL: profileImageURL,
 	}
 
 	for _, tgtChan := range channels {
-		msg, err := b.DiscordSession().ChannelMessageSendEmbed(tgtChan, &notificationEmbed)
+		msg, err := b.DiscordSession().ChannelMessageSendComplex(tgtChan, notification)
 		if err != nil {
-			logrus.Errorf("Failed to post notification message %v in channel %v due to error %v", notificationEmbed, tgtChan, err)
+			logrus.Errorf("Failed to post notification message %v in channel %v due to error %v", notification, tgtChan, err)
 			msgIDs = append(msgIDs, "")
 		} else {
 			msgIDs = append(msgIDs, msg.ID)
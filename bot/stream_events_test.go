@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
+)
+
+const testProviderName = "fakestream"
+
+//fakeProvider is a streaming provider whose streams are set live and offline by the test
+type fakeProvider struct {
+	lock sync.Mutex
+	live map[string]*streaming.LiveStream
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{live: make(map[string]*streaming.LiveStream)}
+}
+
+func (p *fakeProvider) Info() streaming.ProviderInfo {
+	return streaming.ProviderInfo{
+		Name:        testProviderName,
+		DisplayName: "Fake Stream",
+		Colour:      0x123456,
+	}
+}
+
+func (p *fakeProvider) ResolveChannel(nameOrURL string) (*streaming.Channel, error) {
+	return &streaming.Channel{
+		Provider:    testProviderName,
+		ID:          nameOrURL,
+		Login:       nameOrURL,
+		DisplayName: nameOrURL,
+	}, nil
+}
+
+func (p *fakeProvider) Subscribe(channelID string) error   { return nil }
+func (p *fakeProvider) Unsubscribe(channelID string) error { return nil }
+func (p *fakeProvider) ForceUpdate(channelID string) error { return nil }
+func (p *fakeProvider) Close() error                       { return nil }
+
+func (p *fakeProvider) GetLiveStreams(channelIDs []string) (map[string]*streaming.LiveStream, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	res := make(map[string]*streaming.LiveStream)
+	for _, id := range channelIDs {
+		if stream := p.live[id]; stream != nil {
+			streamCopy := *stream
+			res[id] = &streamCopy
+		}
+	}
+	return res, nil
+}
+
+//goLive marks a channel as live playing a game, then passes the online event to the bot
+func (p *fakeProvider) goLive(b *NiaBot, channelID, game string) {
+	p.lock.Lock()
+	p.live[channelID] = &streaming.LiveStream{
+		Provider:    testProviderName,
+		ChannelID:   channelID,
+		ChannelName: "streamer_" + channelID,
+		Title:       "Testing " + game,
+		Game:        game,
+		Viewers:     42,
+		StartedAt:   time.Now(),
+		URL:         "https://fakestream.example/" + channelID,
+		ChannelURL:  "https://fakestream.example/" + channelID,
+	}
+	p.lock.Unlock()
+	b.HandleStreamOnline(&streaming.OnlineEvent{
+		Provider:    testProviderName,
+		ChannelID:   channelID,
+		ChannelName: "streamer_" + channelID,
+	})
+}
+
+//goOffline marks a channel as offline, then passes the offline event to the bot
+func (p *fakeProvider) goOffline(b *NiaBot, channelID string) {
+	p.lock.Lock()
+	delete(p.live, channelID)
+	p.lock.Unlock()
+	b.HandleStreamOffline(&streaming.OfflineEvent{
+		Provider:  testProviderName,
+		ChannelID: channelID,
+	})
+}
+
+//newStreamTestGuild creates a test guild with the fake provider enabled and a member linked to a channel on it
+func newStreamTestGuild(t *testing.T, channelID string) (*testGuild, *fakeProvider) {
+	t.Helper()
+	g := newTestGuild(t)
+	p := newFakeProvider()
+	g.bot.addStreamProvider(p)
+	g.addMember(t, testMemberID, "streamer")
+	_, _, err := g.store.SetStreamLink(g.gid, testMemberID, testProviderName, channelID)
+	if err != nil {
+		t.Fatalf("failed to link stream: %v", err)
+	}
+	return g, p
+}
+
+//streamState returns the stored state of a stream channel
+func streamState(t *testing.T, g *testGuild, channelID string) *guildmodels.StreamChannel {
+	t.Helper()
+	stream, err := g.store.GetStreamChannel(testProviderName, channelID)
+	if err != nil {
+		t.Fatalf("failed to look up stream %v: %v", channelID, err)
+	}
+	return stream
+}
+
+func TestLiveRoles(t *testing.T) {
+	g, p := newStreamTestGuild(t, "1001")
+	roleID := g.addRole(t, "Live")
+	err := g.store.AddManagedRoleRule(guildmodels.ManagedRoleRule{
+		RoleID:         roleID,
+		GuildID:        g.gid,
+		RoleAssignment: guildmodels.RoleAssignment{AssignmentType: "nowlive"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add role rule: %v", err)
+	}
+	key := guildmodels.StreamKey(testProviderName, "1001")
+
+	p.goLive(g.bot, "1001", "Minecraft")
+	if !g.memberHasRole(t, testMemberID, roleID) {
+		t.Errorf("member did not get live role when their stream went online")
+	}
+	if !streamState(t, g, "1001").IsLive {
+		t.Errorf("stream was not stored as live")
+	}
+	sessions, err := g.store.GetOpenStreamSessions(&key)
+	if err != nil || len(sessions) != 1 || sessions[0].Games[0] != "Minecraft" {
+		t.Errorf("expected one open session playing Minecraft, got %+v, %v", sessions, err)
+	}
+
+	//A second linked stream going offline mustn't take the role away whilst the first is still live
+	_, _, err = g.store.SetStreamLink(g.gid, testMemberID, "other", "2002")
+	if err != nil {
+		t.Fatalf("failed to link second stream: %v", err)
+	}
+	g.bot.HandleStreamOffline(&streaming.OfflineEvent{Provider: "other", ChannelID: "2002"})
+	if !g.memberHasRole(t, testMemberID, roleID) {
+		t.Errorf("member lost live role when a stream which wasn't live went offline")
+	}
+
+	p.goOffline(g.bot, "1001")
+	if g.memberHasRole(t, testMemberID, roleID) {
+		t.Errorf("member kept live role after their stream went offline")
+	}
+	if streamState(t, g, "1001").IsLive {
+		t.Errorf("stream was still stored as live")
+	}
+	sessions, err = g.store.GetOpenStreamSessions(&key)
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("expected session to be ended, got %+v, %v", sessions, err)
+	}
+}
+
+func TestAlertRoutes(t *testing.T) {
+	g, p := newStreamTestGuild(t, "1001")
+	minecraft := g.addChannel(t, "minecraft-streams")
+	general := g.addChannel(t, "general-streams")
+	err := g.store.SetGuildAlertRoutes(g.gid, []guildmodels.StreamAlertRoute{
+		{RouteID: "minecraft", ChannelID: minecraft, Games: []string{"minecraft"}},
+		{RouteID: "general", ChannelID: general, Fallback: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to set alert routes: %v", err)
+	}
+
+	//Streams matching a route are only posted there, not in the fallback channel
+	p.goLive(g.bot, "1001", "Minecraft")
+	posted := g.messages(t, minecraft)
+	if len(posted) != 1 || len(g.messages(t, general)) != 0 {
+		t.Fatalf("expected a single alert in the minecraft channel, got %v and %v", posted, g.messages(t, general))
+	}
+	msgs, _ := g.api.Messages(minecraft)
+	if embeds := msgs[0].Embeds; len(embeds) != 1 || !strings.Contains(embeds[0].Description, "streamer_1001 is streaming Minecraft") {
+		t.Errorf("unexpected alert embeds %+v", embeds)
+	}
+	posts := streamState(t, g, "1001").DiscordStatusPosts
+	if len(posts) != 1 || posts[0].ChannelID != minecraft || posts[0].MessageID != posted[0] || posts[0].GuildID != g.gid {
+		t.Errorf("alert post was not stored, got %+v", posts)
+	}
+
+	//Going offline deletes the alert and forgets about it
+	p.goOffline(g.bot, "1001")
+	if len(g.messages(t, minecraft)) != 0 {
+		t.Errorf("alert post was not deleted when stream went offline")
+	}
+	if posts := streamState(t, g, "1001").DiscordStatusPosts; len(posts) != 0 {
+		t.Errorf("alert posts were not cleared, got %+v", posts)
+	}
+
+	//Streams which don't match any other route go to the fallback channel
+	p.goLive(g.bot, "1001", "Chess")
+	if len(g.messages(t, minecraft)) != 0 || len(g.messages(t, general)) != 1 {
+		t.Errorf("expected a single alert in the fallback channel, got %v and %v", g.messages(t, minecraft), g.messages(t, general))
+	}
+}
+
+func TestAlertPostedOncePerGuild(t *testing.T) {
+	g, p := newStreamTestGuild(t, "1001")
+	alerts := g.addChannel(t, "streams")
+	err := g.store.SetGuildAlertRoutes(g.gid, []guildmodels.StreamAlertRoute{
+		{RouteID: "general", ChannelID: alerts, Fallback: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to set alert routes: %v", err)
+	}
+
+	p.goLive(g.bot, "1001", "Minecraft")
+	p.goLive(g.bot, "1001", "Minecraft")
+	if posted := g.messages(t, alerts); len(posted) != 1 {
+		t.Errorf("expected a single alert after repeated online events, got %v", posted)
+	}
+	if posts := streamState(t, g, "1001").DiscordStatusPosts; len(posts) != 1 {
+		t.Errorf("expected a single stored alert post, got %+v", posts)
+	}
+}
+
+func TestLiveRolesOnRejoin(t *testing.T) {
+	g, p := newStreamTestGuild(t, "1001")
+	roleID := g.addRole(t, "Live")
+	err := g.store.AddManagedRoleRule(guildmodels.ManagedRoleRule{
+		RoleID:         roleID,
+		GuildID:        g.gid,
+		RoleAssignment: guildmodels.RoleAssignment{AssignmentType: "nowlive"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add role rule: %v", err)
+	}
+	p.goLive(g.bot, "1001", "Minecraft")
+
+	//Members who leave and come back whilst still live should get the live role back
+	err = g.api.RemoveMember(g.gid, testMemberID)
+	if err != nil {
+		t.Fatalf("failed to remove member: %v", err)
+	}
+	member, err := g.api.AddMember(g.gid, testMemberID, "streamer")
+	if err != nil {
+		t.Fatalf("failed to add member back: %v", err)
+	}
+	g.bot.HandleGuildMemberAdd(&discordgo.GuildMemberAdd{Member: member})
+	if !g.memberHasRole(t, testMemberID, roleID) {
+		t.Errorf("member who rejoined whilst live did not get the live role")
+	}
+
+	//Members who aren't live shouldn't be given it
+	p.goOffline(g.bot, "1001")
+	g.api.RemoveMember(g.gid, testMemberID)
+	member, _ = g.api.AddMember(g.gid, testMemberID, "streamer")
+	g.bot.HandleGuildMemberAdd(&discordgo.GuildMemberAdd{Member: member})
+	if g.memberHasRole(t, testMemberID, roleID) {
+		t.Errorf("member who rejoined whilst offline was given the live role")
+	}
+}
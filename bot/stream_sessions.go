@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+//streamSampleIntervalEnvVar sets how often the viewer counts of live streams are sampled for their session history
+const streamSampleIntervalEnvVar string = "NIA_STREAM_SAMPLE_INTERVAL"
+const defaultStreamSampleInterval = 10 * time.Minute
+
+//recordSessionStart starts a new session in a stream's history, sampling its current title, game and viewers
+func (b *NiaBot) recordSessionStart(p streaming.Provider, channelID string) {
+	provider := p.Info().Name
+	startedAt := time.Now()
+	title := ""
+	sample := guildmodels.ViewerSample{Time: startedAt}
+	live, err := p.GetLiveStreams([]string{channelID})
+	if err != nil {
+		//Better to have a session with no details than none at all
+		logrus.Warnf("Failed to fetch stream details for %v channel %v whilst starting its session due to error %v", provider, channelID, err)
+	} else if stream := live[channelID]; stream != nil {
+		if !stream.StartedAt.IsZero() {
+			startedAt = stream.StartedAt
+		}
+		title = stream.Title
+		sample.Viewers = stream.Viewers
+		sample.Game = stream.Game
+	}
+	err = b.DBConnection.StartStreamSession(provider, channelID, startedAt, title, &sample)
+	if err != nil {
+		logrus.Errorf("Failed to record start of session for %v channel %v due to error %v", provider, channelID, err)
+	}
+}
+
+//recordSessionEnd ends a stream's current session
+func (b *NiaBot) recordSessionEnd(provider, channelID string) {
+	err := b.DBConnection.EndStreamSession(provider, channelID, time.Now())
+	if err != nil {
+		logrus.Errorf("Failed to record end of session for %v channel %v due to error %v", provider, channelID, err)
+	}
+}
+
+//runSessionSampler periodically samples every stream with an open session until stop is closed
+func (b *NiaBot) runSessionSampler(stop <-chan struct{}) {
+	interval := defaultStreamSampleInterval
+	if intervalStr, exists := os.LookupEnv(streamSampleIntervalEnvVar); exists {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			logrus.Warnf("Invalid stream sample interval `%v`, falling back to default of %v", intervalStr, defaultStreamSampleInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.sampleStreamSessions(interval)
+		}
+	}
+}
+
+//sampleStreamSessions adds a viewer sample to every open session. Sessions for streams which have been offline since
+//the last sample (because an offline event was missed) are ended at the time of their last sample.
+func (b *NiaBot) sampleStreamSessions(interval time.Duration) {
+	sessions, err := b.DBConnection.GetOpenStreamSessions(nil)
+	if err != nil {
+		logrus.Errorf("Failed to look up open stream sessions to sample due to error %v", err)
+		return
+	}
+	byProvider := make(map[string][]guildmodels.StreamSession)
+	for _, session := range sessions {
+		byProvider[session.Provider] = append(byProvider[session.Provider], session)
+	}
+	now := time.Now()
+	for provider, sessions := range byProvider {
+		p := b.streamProvider(provider)
+		if p == nil {
+			continue
+		}
+		channelIDs := make([]string, len(sessions))
+		for i, session := range sessions {
+			channelIDs[i] = session.ChannelID
+		}
+		live, err := p.GetLiveStreams(channelIDs)
+		if err != nil {
+			logrus.Warnf("Failed to sample live %v streams due to error %v", provider, err)
+			continue
+		}
+		for _, session := range sessions {
+			stream := live[session.ChannelID]
+			if stream != nil {
+				err = b.DBConnection.AddStreamSessionSample(provider, session.ChannelID, stream.Title, &guildmodels.ViewerSample{
+					Time:    now,
+					Viewers: stream.Viewers,
+					Game:    stream.Game,
+				})
+				if err != nil {
+					logrus.Warnf("Failed to sample session for %v due to error %v", session.StreamKey, err)
+				}
+				continue
+			}
+			//Streams which have only just gone live may not show up straight away, so give them a chance first
+			lastSeen := session.StartedAt
+			if len(session.Samples) > 0 {
+				lastSeen = session.Samples[len(session.Samples)-1].Time
+			}
+			if now.Sub(lastSeen) > 2*interval {
+				logrus.Infof("Ending session for %v as it has not been live since %v", session.StreamKey, lastSeen)
+				err = b.DBConnection.EndStreamSession(provider, session.ChannelID, lastSeen)
+				if err != nil {
+					logrus.Warnf("Failed to end stale session for %v due to error %v", session.StreamKey, err)
+				}
+			}
+		}
+	}
+}
+
+//streamStats summarises a set of stream sessions over a period
+type streamStats struct {
+	sessions     int
+	timeStreamed time.Duration
+	peakViewers  int
+	totalViewers int
+	samples      int
+	//gameSamples counts the number of viewer samples taken whilst each game was being played
+	gameSamples map[string]int
+}
+
+//summariseSessions works out stats for the portion of each of the provided sessions after since
+func summariseSessions(sessions []guildmodels.StreamSession, since time.Time) streamStats {
+	stats := streamStats{
+		gameSamples: make(map[string]int),
+	}
+	now := time.Now()
+	for _, session := range sessions {
+		start, end := session.StartedAt, now
+		if session.EndedAt != nil {
+			end = *session.EndedAt
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if !end.After(start) {
+			continue
+		}
+		stats.sessions++
+		stats.timeStreamed += end.Sub(start)
+		for _, sample := range session.Samples {
+			if sample.Time.Before(since) {
+				continue
+			}
+			stats.samples++
+			stats.totalViewers += sample.Viewers
+			if sample.Viewers > stats.peakViewers {
+				stats.peakViewers = sample.Viewers
+			}
+			if sample.Game != "" {
+				stats.gameSamples[sample.Game]++
+			}
+		}
+	}
+	return stats
+}
+
+//averageViewers returns the mean of the viewer samples
+func (s streamStats) averageViewers() int {
+	if s.samples == 0 {
+		return 0
+	}
+	return s.totalViewers / s.samples
+}
+
+//topGames returns up to n of the most played games, along with the fraction of samples they were played in
+func (s streamStats) topGames(n int) []string {
+	games := make([]string, 0, len(s.gameSamples))
+	total := 0
+	for game, count := range s.gameSamples {
+		games = append(games, game)
+		total += count
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if s.gameSamples[games[i]] != s.gameSamples[games[j]] {
+			return s.gameSamples[games[i]] > s.gameSamples[games[j]]
+		}
+		return games[i] < games[j]
+	})
+	if len(games) > n {
+		games = games[:n]
+	}
+	res := make([]string, len(games))
+	for i, game := range games {
+		res[i] = fmt.Sprintf("%d. %v (%d%%)", i+1, game, 100*s.gameSamples[game]/total)
+	}
+	return res
+}
+
+//formatHours formats a duration as a number of hours
+func formatHours(d time.Duration) string {
+	return fmt.Sprintf("%.1fh", d.Hours())
+}
@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/guildmodels"
+)
+
+//defaultStatsPeriod is used by !streamstats if no period is given
+const defaultStatsPeriod = "30d"
+
+//leaderboardPeriod is how far back !streamleaderboard looks
+const leaderboardPeriod = 7 * 24 * time.Hour
+
+//leaderboardSize is the number of members shown on the leaderboard
+const leaderboardSize = 10
+
+const handleStreamStatsSyntax = "```" +
+	`!streamstats [member] [period]
+	[member] is optional, and defaults to yourself. It can be either a user mention or a user ID
+	[period] is optional, and defaults to ` + defaultStatsPeriod + `. It can be a number of hours, days or weeks (eg. 24h, 7d or 2w) or "all"` +
+	"```"
+
+var statsPeriodRegex = regexp.MustCompile(`^(?:(?P<count>\d+)(?P<unit>[hdw])|all)$`)
+
+//HandleStreamStats handles a message asking for stats on a member's streams
+//command format: !streamstats [member] [period]
+func (b *NiaBot) HandleStreamStats(msg *discordgo.MessageCreate) {
+	b.respondToCommand(msg.Message, b.streamStats(msg.Message))
+}
+
+func (b *NiaBot) streamStats(msg *discordgo.Message) NiaResponse {
+	commandName := "!streamstats"
+	args := strings.Fields(msg.Content)[1:]
+	periodStr := defaultStatsPeriod
+	memberStr := ""
+	for _, arg := range args {
+		if statsPeriodRegex.MatchString(arg) {
+			periodStr = arg
+		} else if memberStr == "" {
+			memberStr = arg
+		} else {
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: fmt.Sprintf("I couldn't understand %v", arg),
+				syntax:      handleStreamStatsSyntax,
+				timestamp:   time.Now(),
+			}
+		}
+	}
+	since, periodName := parseStatsPeriod(periodStr)
+	uid := msg.Author.ID
+	if memberStr != "" {
+		member, err := b.interpretMemberString(memberStr, msg.GuildID)
+		if err != nil {
+			return NiaResponseSyntaxError{
+				command:     commandName,
+				commandMsg:  msg.Content,
+				description: fmt.Sprintf("I couldn't find the member %v", memberStr),
+				syntax:      handleStreamStatsSyntax,
+				timestamp:   time.Now(),
+			}
+		}
+		uid = member.User.ID
+	}
+
+	memberData, err := b.DBConnection.GetMemberData(msg.GuildID, uid)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst looking up linked streams",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	} else if memberData == nil || len(memberData.Connections.StreamLinks) == 0 {
+		return NiaResponseInfo{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			title:       "No linked streams",
+			description: fmt.Sprintf("<@%v> doesn't have any streams linked, so there are no stats to show.", uid),
+			timestamp:   time.Now(),
+		}
+	}
+	keys := make([]string, 0, len(memberData.Connections.StreamLinks))
+	for provider, channelID := range memberData.Connections.StreamLinks {
+		keys = append(keys, guildmodels.StreamKey(provider, channelID))
+	}
+	sessions, err := b.DBConnection.GetStreamSessions(keys, since)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst looking up stream history",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	stats := summariseSessions(sessions, since)
+	if stats.sessions == 0 {
+		return NiaResponseInfo{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			title:       "No streams found",
+			description: fmt.Sprintf("<@%v> hasn't streamed %v.", uid, periodName),
+			timestamp:   time.Now(),
+		}
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Streams", Value: strconv.Itoa(stats.sessions), Inline: true},
+		{Name: "Time streamed", Value: formatHours(stats.timeStreamed), Inline: true},
+		{Name: "Average viewers", Value: strconv.Itoa(stats.averageViewers()), Inline: true},
+		{Name: "Peak viewers", Value: strconv.Itoa(stats.peakViewers), Inline: true},
+	}
+	fields = append(fields, linesToFields("Top games", stats.topGames(5))...)
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       "Stream stats",
+		description: fmt.Sprintf("Stats for <@%v> %v", uid, periodName),
+		fields:      fields,
+		timestamp:   time.Now(),
+	}
+}
+
+//parseStatsPeriod returns the start of the period described by a string matching statsPeriodRegex, along with a
+//description of it such as "in the last 7 days"
+func parseStatsPeriod(periodStr string) (time.Time, string) {
+	matches := statsPeriodRegex.FindStringSubmatch(periodStr)
+	if matches == nil || periodStr == "all" {
+		return time.Time{}, "since stream history began"
+	}
+	count, _ := strconv.Atoi(matches[statsPeriodRegex.SubexpIndex("count")])
+	var unit time.Duration
+	var unitName string
+	switch matches[statsPeriodRegex.SubexpIndex("unit")] {
+	case "h":
+		unit, unitName = time.Hour, "hour"
+	case "d":
+		unit, unitName = 24*time.Hour, "day"
+	case "w":
+		unit, unitName = 7*24*time.Hour, "week"
+	}
+	if count != 1 {
+		unitName = fmt.Sprintf("%d %vs", count, unitName)
+	}
+	return time.Now().Add(-time.Duration(count) * unit), "in the last " + unitName
+}
+
+//HandleStreamLeaderboard handles a message asking for the members of a guild who have streamed the most this week
+//command format: !streamleaderboard
+func (b *NiaBot) HandleStreamLeaderboard(msg *discordgo.MessageCreate) {
+	b.respondToCommand(msg.Message, b.streamLeaderboard(msg.Message))
+}
+
+func (b *NiaBot) streamLeaderboard(msg *discordgo.Message) NiaResponse {
+	commandName := "!streamleaderboard"
+	members, err := b.DBConnection.GetGuildStreamLinks(msg.GuildID)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst looking up linked streams",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	//Maps each stream key to the members who have it linked
+	streamMembers := make(map[string][]string)
+	for _, member := range members {
+		for provider, channelID := range member.Connections.StreamLinks {
+			key := guildmodels.StreamKey(provider, channelID)
+			streamMembers[key] = append(streamMembers[key], member.UserID)
+		}
+	}
+	keys := make([]string, 0, len(streamMembers))
+	for key := range streamMembers {
+		keys = append(keys, key)
+	}
+	since := time.Now().Add(-leaderboardPeriod)
+	sessions, err := b.DBConnection.GetStreamSessions(keys, since)
+	if err != nil {
+		return NiaResponseInternalError{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "Encountered internal database error whilst looking up stream history",
+			data:        map[string]string{"Error": err.Error()},
+			timestamp:   time.Now(),
+		}
+	}
+	memberSessions := make(map[string][]guildmodels.StreamSession)
+	for _, session := range sessions {
+		for _, uid := range streamMembers[session.StreamKey] {
+			memberSessions[uid] = append(memberSessions[uid], session)
+		}
+	}
+	memberStats := make(map[string]streamStats, len(memberSessions))
+	uids := make([]string, 0, len(memberSessions))
+	for uid, sessions := range memberSessions {
+		stats := summariseSessions(sessions, since)
+		if stats.timeStreamed > 0 {
+			memberStats[uid] = stats
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		return NiaResponseInfo{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			title:       "Stream leaderboard",
+			description: "Nobody with a linked stream has streamed in the last week.",
+			timestamp:   time.Now(),
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return memberStats[uids[i]].timeStreamed > memberStats[uids[j]].timeStreamed
+	})
+	if len(uids) > leaderboardSize {
+		uids = uids[:leaderboardSize]
+	}
+	lines := make([]string, len(uids))
+	for i, uid := range uids {
+		stats := memberStats[uid]
+		lines[i] = fmt.Sprintf("%d. <@%v>: %v streamed, %d peak viewers", i+1, uid, formatHours(stats.timeStreamed), stats.peakViewers)
+	}
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  msg.Content,
+		title:       "Stream leaderboard",
+		description: "Members who have streamed the most in the last week",
+		fields:      linesToFields("Time streamed", lines),
+		timestamp:   time.Now(),
+	}
+}
@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/twitch"
+	"github.com/sirupsen/logrus"
+)
+
+//discordDevChannelEnvVar names a channel which problems needing a developer's attention will be posted to. If it is
+//not set, they will be sent as a direct message to the developer instead.
+const discordDevChannelEnvVar string = "NIA_DISCORD_DEV_CHANNEL"
+
+//HandleTwitchSubscriptionFailure handles a report from the twitch subscription monitor that a subscription has
+//repeatedly failed to be recreated, passing it on to the developer
+func (b *NiaBot) HandleTwitchSubscriptionFailure(f *twitch.SubscriptionFailure) {
+	streamName := f.BroadcasterUID
+	stream, err := b.DBConnection.GetStreamChannel(twitch.ProviderName, f.BroadcasterUID)
+	if err == nil && stream.Login != "" {
+		streamName = fmt.Sprintf("%v (%v)", stream.Login, f.BroadcasterUID)
+	}
+	lastError := "Subscription was recreated but has not become active"
+	if f.LastError != nil {
+		lastError = f.LastError.Error()
+	}
+	embed := discordgo.MessageEmbed{
+		Title:       "Twitch subscription failing",
+		Type:        discordgo.EmbedTypeRich,
+		Description: fmt.Sprintf("The %v subscription for %v is not active and could not be fixed after %d attempts. Alerts for this stream may be missed.", f.Type, streamName, f.Attempts),
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Color:       errorMessageColour,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Status", Value: f.Status, Inline: true},
+			{Name: "Next attempt", Value: f.NextAttempt.Format(time.RFC1123), Inline: true},
+			{Name: "Last error", Value: lastError},
+		},
+	}
+	err = b.notifyDev(&discordgo.MessageSend{Embed: &embed})
+	if err != nil {
+		logrus.Errorf("Failed to report failing twitch subscription %v to developer due to error %v", f, err)
+	}
+}
+
+//notifyDev posts a message to the developer channel, or directly to the developer if no channel has been set
+func (b *NiaBot) notifyDev(msg *discordgo.MessageSend) error {
+	channelID, exists := os.LookupEnv(discordDevChannelEnvVar)
+	if !exists || channelID == "" {
+		devUID, exists := os.LookupEnv(discordDevUIDEnvVar)
+		if !exists {
+			return fmt.Errorf("neither `%v` nor `%v` env variables were set", discordDevChannelEnvVar, discordDevUIDEnvVar)
+		}
+		channel, err := b.DiscordSession().UserChannelCreate(devUID)
+		if err != nil {
+			return err
+		}
+		channelID = channel.ID
+	}
+	_, err := b.DiscordSession().ChannelMessageSendComplex(channelID, msg)
+	return err
+}
+
+//HandleTwitchStatus handles a message from the developer asking for the current state of the twitch eventsub
+//subscriptions
+//command format: !twitchstatus
+func (b *NiaBot) HandleTwitchStatus(msg *discordgo.MessageCreate) {
+	commandName := "!twitchstatus"
+	var result NiaResponse
+	if !isDev(msg.Author.ID) {
+		result = NiaResponseNotAllowed{
+			command:     commandName,
+			commandMsg:  msg.Content,
+			description: "The !twitchstatus command can only be run by the bot developer.",
+			timestamp:   time.Now(),
+		}
+	} else if t, errResp := b.getTwitchClient(commandName, msg.Content); errResp != nil {
+		result = *errResp
+	} else {
+		result = describeSubscriptionHealth(commandName, msg.Content, t.SubscriptionHealth())
+	}
+	b.respondToCommand(msg.Message, result)
+}
+
+func describeSubscriptionHealth(commandName, commandMsg string, health twitch.SubscriptionHealth) NiaResponse {
+	if health.LastCheck.IsZero() {
+		return NiaResponseInfo{
+			command:     commandName,
+			commandMsg:  commandMsg,
+			title:       "Twitch EventSub subscriptions",
+			description: "Subscriptions haven't been checked yet.",
+			timestamp:   time.Now(),
+		}
+	}
+	statuses := make([]string, 0, len(health.StatusCounts))
+	for status := range health.StatusCounts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	statusLines := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		statusLines = append(statusLines, fmt.Sprintf("%v: %d", status, health.StatusCounts[status]))
+	}
+	failureLines := make([]string, 0, len(health.Failures))
+	for _, f := range health.Failures {
+		lastError := "recreated, awaiting confirmation"
+		if f.LastError != nil {
+			lastError = f.LastError.Error()
+		}
+		failureLines = append(failureLines, fmt.Sprintf("%v %v: %v after %d attempt(s) (%v)", f.BroadcasterUID, f.Type, f.Status, f.Attempts, lastError))
+	}
+	fields := append(linesToFields("Subscriptions by status", statusLines), linesToFields("Failing subscriptions", failureLines)...)
+	return NiaResponseInfo{
+		command:     commandName,
+		commandMsg:  commandMsg,
+		title:       "Twitch EventSub subscriptions",
+		description: fmt.Sprintf("Last checked %v. %d subscription(s) are currently failing.", health.LastCheck.Format(time.RFC1123), len(health.Failures)),
+		fields:      fields,
+		timestamp:   time.Now(),
+	}
+}
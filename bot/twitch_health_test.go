@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/twitch"
+)
+
+//setEnv sets an environment variable until the test finishes. An empty value unsets it.
+func setEnv(t *testing.T, key, value string) {
+	old, existed := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if existed {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func testSubscriptionFailure() *twitch.SubscriptionFailure {
+	return &twitch.SubscriptionFailure{
+		BroadcasterUID: "1001",
+		Type:           "stream.online",
+		Status:         "notification_failures_exceeded",
+		Attempts:       3,
+		LastError:      errors.New("twitch is having a bad day"),
+		NextAttempt:    time.Now().Add(4 * time.Minute),
+	}
+}
+
+func TestSubscriptionFailureReportedToDevChannel(t *testing.T) {
+	g := newTestGuild(t)
+	devChannel := g.addChannel(t, "dev")
+	setEnv(t, discordDevChannelEnvVar, devChannel)
+	setEnv(t, discordDevUIDEnvVar, "")
+	_, _, err := g.store.SetStreamLink(g.gid, "700000000000000010", twitch.ProviderName, "1001")
+	if err != nil {
+		t.Fatalf("failed to link stream: %v", err)
+	}
+	err = g.store.SetStreamChannelLogin(twitch.ProviderName, "1001", "streamer")
+	if err != nil {
+		t.Fatalf("failed to save stream login: %v", err)
+	}
+
+	g.bot.HandleTwitchSubscriptionFailure(testSubscriptionFailure())
+
+	msgs, err := g.api.Messages(devChannel)
+	if err != nil {
+		t.Fatalf("failed to look up messages: %v", err)
+	}
+	if len(msgs) != 1 || len(msgs[0].Embeds) != 1 {
+		t.Fatalf("expected a single embed to be posted to the dev channel, got %+v", msgs)
+	}
+	embed := msgs[0].Embeds[0]
+	if embed.Title != "Twitch subscription failing" {
+		t.Errorf("unexpected embed title %q", embed.Title)
+	}
+	for _, want := range []string{"stream.online", "streamer (1001)", "3 attempts"} {
+		if !strings.Contains(embed.Description, want) {
+			t.Errorf("expected description %q to contain %q", embed.Description, want)
+		}
+	}
+	fields := make(map[string]string, len(embed.Fields))
+	for _, f := range embed.Fields {
+		fields[f.Name] = f.Value
+	}
+	if fields["Status"] != "notification_failures_exceeded" || fields["Last error"] != "twitch is having a bad day" {
+		t.Errorf("expected status and last error fields, got %v", fields)
+	}
+}
+
+func TestSubscriptionFailureReportedToDev(t *testing.T) {
+	g := newTestGuild(t)
+	const devUID = "700000000000000099"
+	g.addMember(t, devUID, "dev")
+	setEnv(t, discordDevChannelEnvVar, "")
+	setEnv(t, discordDevUIDEnvVar, devUID)
+
+	failure := testSubscriptionFailure()
+	failure.LastError = nil
+	g.bot.HandleTwitchSubscriptionFailure(failure)
+
+	msgs := g.api.DirectMessages(devUID)
+	if len(msgs) != 1 || len(msgs[0].Embeds) != 1 {
+		t.Fatalf("expected a single embed to be sent to the developer, got %+v", msgs)
+	}
+	embed := msgs[0].Embeds[0]
+	//The stream's login isn't known, so just its ID should be given
+	if !strings.Contains(embed.Description, "for 1001 is not active") {
+		t.Errorf("expected description %q to name the stream by its ID", embed.Description)
+	}
+	for _, f := range embed.Fields {
+		if f.Name == "Last error" && f.Value != "Subscription was recreated but has not become active" {
+			t.Errorf("expected last error to explain that the subscription was recreated, got %q", f.Value)
+		}
+	}
+}
+
+func TestSubscriptionFailureWithoutDev(t *testing.T) {
+	g := newTestGuild(t)
+	setEnv(t, discordDevChannelEnvVar, "")
+	setEnv(t, discordDevUIDEnvVar, "")
+	err := g.bot.notifyDev(nil)
+	if err == nil {
+		t.Errorf("expected an error when there is nobody to notify")
+	}
+	//Reporting a failure with nobody to notify should only log
+	g.bot.HandleTwitchSubscriptionFailure(testSubscriptionFailure())
+}
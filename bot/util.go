@@ -91,6 +91,26 @@ func (b *NiaBot) interpretChannelString(chanStr string, guildID string) (*discor
 	}
 }
 
+//Allows @mentions or raw user IDs
+var memberRegex = regexp.MustCompile(`^\s*(?:<@!?(\d+)>|(\d{17,20}))\s*$`)
+
+func (b *NiaBot) interpretMemberString(memberStr string, guildID string) (*discordgo.Member, error) {
+	matches := memberRegex.FindStringSubmatch(memberStr)
+	if matches == nil {
+		return nil, fmt.Errorf("%v was not a valid member mention", memberStr)
+	}
+	uid := matches[1]
+	if uid == "" {
+		uid = matches[2]
+	}
+	member, err := b.DiscordSession().GuildMember(guildID, uid)
+	if err != nil {
+		logrus.Warnf("Failed to fetch member %v of guild %v whilst interpreting member specifier %v due to error %v", uid, guildID, memberStr, err)
+		return nil, err
+	}
+	return member, nil
+}
+
 //This is kind of a mess and waay too greedy but the symbol other category doesn't seem to work with RE2 so eh ¯\_(ツ)_/¯
 //TODO: replace this with something better
 const unicodeEmojiRegex = `(\S{1,4})`
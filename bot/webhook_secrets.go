@@ -0,0 +1,31 @@
+package bot
+
+import (
+	"github.com/callummance/nia/guildmodels"
+	"github.com/callummance/nia/twitch"
+)
+
+//LoadTwitchWebhookSecret retrieves the saved twitch eventsub webhook secret, returning nil if there isn't one yet
+func (b *NiaBot) LoadTwitchWebhookSecret() (*twitch.WebhookSecret, error) {
+	secret, err := b.DBConnection.GetWebhookSecret(guildmodels.TwitchWebhookSecretID)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	return &twitch.WebhookSecret{
+		Current:   secret.Current,
+		Previous:  secret.Previous,
+		CreatedAt: secret.CreatedAt,
+		Applied:   secret.Applied,
+	}, nil
+}
+
+//SaveTwitchWebhookSecret saves the twitch eventsub webhook secret so that it survives restarts
+func (b *NiaBot) SaveTwitchWebhookSecret(secret *twitch.WebhookSecret) error {
+	return b.DBConnection.SetWebhookSecret(&guildmodels.WebhookSecret{
+		ID:        guildmodels.TwitchWebhookSecretID,
+		Current:   secret.Current,
+		Previous:  secret.Previous,
+		CreatedAt: secret.CreatedAt,
+		Applied:   secret.Applied,
+	})
+}
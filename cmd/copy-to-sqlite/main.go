@@ -0,0 +1,53 @@
+//copy-to-sqlite copies all of nia's data from RethinkDB into a SQLite file, so that an existing bot can be moved over
+//to the sqlite storage backend. The RethinkDB connection is configured in the same way as for the bot, with
+//NIA_DB_ADDR and NIA_DB_NAME, but nothing in RethinkDB is changed. Stop the bot before running it, then start the bot
+//with:
+//
+//	NIA_DB_BACKEND=sqlite
+//	NIA_DB_PATH=<the file passed with -path>
+package main
+
+import (
+	"flag"
+	"sort"
+
+	"github.com/callummance/nia/db"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	path := flag.String("path", "nia.db", "sqlite file to copy the data into")
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		logrus.Warnf("Failed to load .env file due to error %v", err)
+	}
+
+	//Connect without creating tables or applying migrations, as the source database shouldn't be changed
+	src, err := db.Connect()
+	if err != nil {
+		logrus.Fatalf("Failed to connect to RethinkDB due to error %v", err)
+	}
+	defer src.Close()
+	dst, err := db.OpenSQLite(*path)
+	if err != nil {
+		logrus.Fatalf("Failed to open sqlite database %v due to error %v", *path, err)
+	}
+	defer dst.Close()
+
+	counts, err := db.CopyToSQLite(src, dst)
+	if err != nil {
+		logrus.Fatalf("Failed to copy data into %v due to error %v", *path, err)
+	}
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		logrus.Infof("Copied %v documents from the %v table", counts[table], table)
+	}
+	logrus.Infof("Finished copying data into %v", *path)
+}
@@ -0,0 +1,36 @@
+//mock-eventsub runs a local mock of the Twitch EventSub websocket service and helix API, so that nia can be run with
+//the websocket transport without access to Twitch. Start it, then run nia with:
+//
+//	NIA_TWITCH_EVENTSUB_TRANSPORT=websocket
+//	NIA_TWITCH_EVENTSUB_WS_URL=ws://localhost:8081/ws
+//	NIA_TWITCH_API_URL=http://localhost:8081/helix
+//	NIA_TWITCH_AUTH_URL=http://localhost:8081/oauth2
+//	NIA_TWITCH_USER_ACCESS_TOKEN=<anything>
+//	NIA_TWITCH_COMMUNITY_EVENTS=raid,follow,subscribe   (optional)
+//
+//To test channel verification, also set NIA_TWITCH_OAUTH_REDIRECT_URL=http://localhost:8080/twitchoauth and add
+//&login=<login> to the authorization link the bot sends to log in as that user.
+//
+//Streams can then be started and stopped with eg. `curl -X POST 'localhost:8081/mock/online?login=someone&game=Tetris'`
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/callummance/nia/twitch/mockeventsub"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	listen := flag.String("listen", ":8081", "address to listen on")
+	keepalive := flag.Duration("keepalive", 10*time.Second, "interval between keepalive messages")
+	flag.Parse()
+
+	logrus.Infof("Mock eventsub server listening on %v", *listen)
+	err := http.ListenAndServe(*listen, mockeventsub.New(*keepalive))
+	if err != nil {
+		logrus.Fatalf("Mock eventsub server stopped due to error %v", err)
+	}
+}
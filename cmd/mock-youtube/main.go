@@ -0,0 +1,30 @@
+//mock-youtube runs a local mock of the YouTube Data API, channel feeds and PubSubHubbub hub, so that nia's youtube
+//provider can be run without access to YouTube. Start it, then run nia with:
+//
+//	NIA_YOUTUBE_API_KEY=<anything>
+//	NIA_YOUTUBE_API_URL=http://localhost:8083/youtube/v3
+//	NIA_YOUTUBE_FEED_URL=http://localhost:8083/feeds/videos.xml
+//	NIA_YOUTUBE_HUB_URL=http://localhost:8083/hub
+//	NIA_YOUTUBE_CALLBACK_URL=http://localhost:8082/youtubehook
+//
+//Broadcasts can then be started and stopped with eg. `curl -X POST 'localhost:8083/mock/live?handle=someone&gaming=true'`
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/callummance/nia/youtube/mockyoutube"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	listen := flag.String("listen", ":8083", "address to listen on")
+	flag.Parse()
+
+	logrus.Infof("Mock youtube server listening on %v", *listen)
+	err := http.ListenAndServe(*listen, mockyoutube.New())
+	if err != nil {
+		logrus.Fatalf("Mock youtube server stopped due to error %v", err)
+	}
+}
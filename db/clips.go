@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+const postedClipsTable string = "posted_clips"
+
+//SetGuildClipsFeed sets where and when clips are posted in a guild. Passing a nil feed turns the clips feed off.
+func (db *Connection) SetGuildClipsFeed(gid string, feed *guildmodels.ClipsFeed) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	newVal := rethink.Literal()
+	if feed != nil {
+		newVal = rethink.Literal(feed)
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"clips_feed": newVal,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild clips feed: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild clips feed: %v", err)
+		return err
+	}
+	return nil
+}
+
+//GetGuildsWithClipsFeed returns every active guild which has a clips feed set up
+func (db *Connection) GetGuildsWithClipsFeed() ([]guildmodels.DiscordGuild, error) {
+	res, err := rethink.Table(guildsTable).Filter(func(guild rethink.Term) rethink.Term {
+		return guild.HasFields("clips_feed").And(activeGuild(guild))
+	}).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up guilds with a clips feed due to error %v", err)
+		return nil, err
+	}
+	defer res.Close()
+	var guilds []guildmodels.DiscordGuild
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&guilds)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve guilds with a clips feed due to error %v", err)
+		return nil, err
+	}
+	return guilds, nil
+}
+
+//GetPostedClips returns the set of the provided clip IDs which have already been posted in a guild
+func (db *Connection) GetPostedClips(gid string, clipIDs []string) (map[string]bool, error) {
+	posted := make(map[string]bool)
+	if len(clipIDs) == 0 {
+		return posted, nil
+	}
+	keys := make([]interface{}, 0, len(clipIDs))
+	for _, clipID := range clipIDs {
+		keys = append(keys, []string{gid, clipID})
+	}
+	res, err := rethink.Table(postedClipsTable).GetAll(keys...).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up posted clips in guild %v due to error %v", gid, err)
+		return nil, err
+	}
+	defer res.Close()
+	var clips []guildmodels.PostedClip
+	err = res.All(&clips)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve posted clips in guild %v due to error %v", gid, err)
+		return nil, err
+	}
+	for _, clip := range clips {
+		posted[clip.ClipID] = true
+	}
+	return posted, nil
+}
+
+//AddPostedClip records that a clip has been posted in a guild
+func (db *Connection) AddPostedClip(clip *guildmodels.PostedClip) error {
+	resp, err := rethink.Table(postedClipsTable).Insert(clip, rethink.InsertOpts{
+		Conflict: "replace",
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to record posted clip %v in guild %v due to error %v", clip.ClipID, clip.GuildID, err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Failed to record posted clip %v in guild %v due to error %v", clip.ClipID, clip.GuildID, err)
+		return err
+	}
+	return nil
+}
+
+//PrunePostedClips forgets about posted clips which were created before the provided time
+func (db *Connection) PrunePostedClips(before time.Time) error {
+	_, err := rethink.Table(postedClipsTable).Filter(func(clip rethink.Term) rethink.Term {
+		return clip.Field("created_at").Lt(before)
+	}).Delete().RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to prune old posted clips due to error %v", err)
+		return err
+	}
+	return nil
+}
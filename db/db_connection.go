@@ -17,10 +17,60 @@ const maxDbPoolConnections int = 20
 //Connection contains a handle to the database
 type Connection struct {
 	session *rethink.Session
+	name    string
 }
 
-//Init creates a new connection pool for the database at the address provided by the relevant environment variable
+//tables lists every table used by the bot, along with a description used in log messages
+var tables = []struct {
+	name        string
+	description string
+}{
+	{guildsTable, "guilds"},
+	{guildRolesTable, "role rules"},
+	{membersTable, "members"},
+	{streamsTable, "streams"},
+	{sessionsTable, "stream sessions"},
+	{scheduledEventsTable, "scheduled events"},
+	{postedClipsTable, "posted clips"},
+	{secretsTable, "secrets"},
+	{schemaMigrationsTable, "schema migrations"},
+}
+
+//Init creates a new connection pool for the database at the address provided by the relevant environment variable,
+//then creates any missing tables and applies any pending migrations
 func Init() (*Connection, error) {
+	res, err := Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	//Ensure database and required tables exist, and wait for it all to be ready
+	err = res.CreateDatabase(res.name)
+	if err == nil {
+		err = res.CreateTables()
+	}
+	if err != nil {
+		res.Close()
+		return nil, fmt.Errorf("failed to set up database and tables because %v", err)
+	}
+	_, err = res.Migrate(false)
+	if err != nil {
+		logrus.Errorf("Failed to apply database migrations because %v.", err)
+		res.Close()
+		return nil, fmt.Errorf("failed to apply database migrations because %v", err)
+	}
+	err = res.WaitTablesRead()
+	if err != nil {
+		res.Close()
+		return nil, fmt.Errorf("failed waiting for tables because %v", err)
+	}
+
+	return res, nil
+}
+
+//Connect creates a new connection pool for the database at the address provided by the relevant environment variable,
+//without making any changes to the database
+func Connect() (*Connection, error) {
 	rethink.SetVerbose(true)
 	//Get DB name from env
 	dbName, exists := os.LookupEnv(dbNameEnvVar)
@@ -46,15 +96,10 @@ func Init() (*Connection, error) {
 		return nil, fmt.Errorf("failed to create connection to rethinkdb instance at address %v because %v", rethinkDBAddr, err)
 	}
 
-	res := Connection{
+	return &Connection{
 		session: session,
-	}
-
-	//Ensure database and required tables exist, and wait for it all to be ready
-	res.CreateDatabase(dbName)
-	res.CreateTables()
-
-	return &res, nil
+		name:    dbName,
+	}, nil
 }
 
 //Close cleanly terminates the database connection
@@ -63,59 +108,80 @@ func (db *Connection) Close() {
 	_ = db.session.Close()
 }
 
-//CreateTables ensures all tables needed exist.
-func (db *Connection) CreateTables() {
-	//guilds table
-	_, err := rethink.TableCreate(guildsTable, rethink.TableCreateOpts{
-		PrimaryKey: "id",
-	}).RunWrite(db.session)
+//tableList returns the set of tables which currently exist in the database
+func (db *Connection) tableList() (map[string]bool, error) {
+	res, err := rethink.TableList().Run(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to create guilds table due to error %v", err)
+		return nil, err
 	}
-	//managed role rules table
-	_, err = rethink.TableCreate(guildRolesTable, rethink.TableCreateOpts{
-		PrimaryKey: "id",
-	}).RunWrite(db.session)
+	defer res.Close()
+	var names []string
+	err = res.All(&names)
 	if err != nil {
-		logrus.Warnf("Failed to create role rules table due to error %v", err)
+		return nil, err
 	}
-	//member data table
-	_, err = rethink.TableCreate(membersTable, rethink.TableCreateOpts{
-		PrimaryKey: "id",
-	}).RunWrite(db.session)
-	if err != nil {
-		logrus.Warnf("Failed to create members table due to error %v", err)
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
 	}
-	//twitch data table
-	_, err = rethink.TableCreate(twitchTable, rethink.TableCreateOpts{
-		PrimaryKey: "tid",
-	}).RunWrite(db.session)
+	return existing, nil
+}
+
+//CreateTables ensures all tables needed exist, creating any which are missing
+func (db *Connection) CreateTables() error {
+	existing, err := db.tableList()
 	if err != nil {
-		logrus.Warnf("Failed to create twitch streams table due to error %v", err)
+		logrus.Errorf("Failed to list existing tables due to error %v", err)
+		return err
+	}
+	for _, table := range tables {
+		if existing[table.name] {
+			continue
+		}
+		logrus.Infof("Creating %v table", table.description)
+		_, err := rethink.TableCreate(table.name, rethink.TableCreateOpts{
+			PrimaryKey: "id",
+		}).RunWrite(db.session)
+		if err != nil {
+			logrus.Errorf("Failed to create %v table due to error %v", table.description, err)
+			return err
+		}
 	}
 	//Wait for all tables
-	rethink.Table(guildsTable).Wait()
-	rethink.Table(guildRolesTable).Wait()
-	rethink.Table(membersTable).Wait()
-	rethink.Table(twitchTable).Wait()
+	for _, table := range tables {
+		err := rethink.Table(table.name).Wait().Exec(db.session)
+		if err != nil {
+			logrus.Errorf("Failed waiting for %v table to be ready due to error %v", table.description, err)
+			return err
+		}
+	}
+	return nil
 }
 
-func (db *Connection) WaitTablesRead() {
+//WaitTablesRead blocks until every table is ready for reads
+func (db *Connection) WaitTablesRead() error {
 	waitOpts := rethink.WaitOpts{
 		WaitFor: "ready_for_reads",
 	}
-	rethink.Table(guildsTable).Wait(waitOpts)
-	rethink.Table(guildRolesTable).Wait(waitOpts)
-	rethink.Table(membersTable).Wait(waitOpts)
-	rethink.Table(twitchTable).Wait(waitOpts)
-
+	for _, table := range tables {
+		err := rethink.Table(table.name).Wait(waitOpts).Exec(db.session)
+		if err != nil {
+			logrus.Errorf("Failed waiting for %v table to be ready for reads due to error %v", table.description, err)
+			return err
+		}
+	}
+	return nil
 }
 
 //CreateDatabase ensures the nia database exists
-func (db *Connection) CreateDatabase(dbName string) {
+func (db *Connection) CreateDatabase(dbName string) error {
 	_, err := rethink.DBCreate(dbName).RunWrite(db.session)
 	if err != nil {
 		logrus.Warnf("Failed to create %v DB due to error %v", dbName, err)
 	}
-	rethink.DB(dbName).Wait()
+	err = rethink.DB(dbName).Wait().Exec(db.session)
+	if err != nil {
+		logrus.Errorf("Failed waiting for %v DB to be ready due to error %v", dbName, err)
+	}
+	return err
 }
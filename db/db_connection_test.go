@@ -0,0 +1,125 @@
+package db_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/db"
+	"github.com/callummance/nia/db/dbtest"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+//TestRethinkStore runs the conformance tests against the RethinkDB instance at NIA_DB_ADDR. Each test uses a new
+//database, which is dropped once it finishes. The tests are skipped if NIA_DB_ADDR isn't set.
+func TestRethinkStore(t *testing.T) {
+	addr, exists := os.LookupEnv("NIA_DB_ADDR")
+	if !exists {
+		t.Skip("NIA_DB_ADDR is not set")
+	}
+	oldName, hadName := os.LookupEnv("NIA_DB_NAME")
+	defer func() {
+		if hadName {
+			os.Setenv("NIA_DB_NAME", oldName)
+		} else {
+			os.Unsetenv("NIA_DB_NAME")
+		}
+	}()
+
+	prefix := fmt.Sprintf("nia_test_%d", time.Now().UnixNano())
+	n := 0
+	dbtest.RunStoreTests(t, func(t *testing.T) db.Store {
+		n++
+		name := fmt.Sprintf("%v_%d", prefix, n)
+		os.Setenv("NIA_DB_NAME", name)
+		t.Cleanup(func() {
+			session, err := rethink.Connect(rethink.ConnectOpts{Address: addr})
+			if err != nil {
+				t.Errorf("failed to connect to drop test database %v: %v", name, err)
+				return
+			}
+			defer session.Close()
+			_, err = rethink.DBDrop(name).RunWrite(session)
+			if err != nil {
+				t.Errorf("failed to drop test database %v: %v", name, err)
+			}
+		})
+		conn, err := db.Init()
+		if err != nil {
+			t.Fatalf("failed to connect to rethinkdb at %v: %v", addr, err)
+		}
+		return conn
+	})
+}
+
+//TestCopyToSQLite copies a RethinkDB database which is missing most of its tables, checking that the tables which do
+//exist are copied and that nothing is created in RethinkDB. It is skipped if NIA_DB_ADDR isn't set.
+func TestCopyToSQLite(t *testing.T) {
+	addr, exists := os.LookupEnv("NIA_DB_ADDR")
+	if !exists {
+		t.Skip("NIA_DB_ADDR is not set")
+	}
+	oldName, hadName := os.LookupEnv("NIA_DB_NAME")
+	defer func() {
+		if hadName {
+			os.Setenv("NIA_DB_NAME", oldName)
+		} else {
+			os.Unsetenv("NIA_DB_NAME")
+		}
+	}()
+	name := fmt.Sprintf("nia_test_copy_%d", time.Now().UnixNano())
+	os.Setenv("NIA_DB_NAME", name)
+
+	session, err := rethink.Connect(rethink.ConnectOpts{Address: addr})
+	if err != nil {
+		t.Fatalf("failed to connect to rethinkdb at %v: %v", addr, err)
+	}
+	defer session.Close()
+	_, err = rethink.DBCreate(name).RunWrite(session)
+	if err != nil {
+		t.Fatalf("failed to create test database %v: %v", name, err)
+	}
+	defer rethink.DBDrop(name).RunWrite(session)
+	_, err = rethink.DB(name).TableCreate("guilds").RunWrite(session)
+	if err == nil {
+		_, err = rethink.DB(name).Table("guilds").Insert(map[string]interface{}{"id": "g1"}).RunWrite(session)
+	}
+	if err != nil {
+		t.Fatalf("failed to set up guilds table: %v", err)
+	}
+
+	src, err := db.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect to rethinkdb at %v: %v", addr, err)
+	}
+	defer src.Close()
+	dst, err := db.OpenSQLite(filepath.Join(t.TempDir(), "nia.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite database: %v", err)
+	}
+	defer dst.Close()
+	counts, err := db.CopyToSQLite(src, dst)
+	if err != nil {
+		t.Fatalf("failed to copy data: %v", err)
+	}
+	if counts["guilds"] != 1 {
+		t.Errorf("expected one guild to be copied, got counts %v", counts)
+	}
+	active, err := dst.GetActiveGuildIDs()
+	if err != nil || len(active) != 1 || active[0] != "g1" {
+		t.Errorf("expected guild g1 to be copied, got %v (error %v)", active, err)
+	}
+
+	res, err := rethink.DB(name).TableList().Run(session)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	defer res.Close()
+	var tables []string
+	err = res.All(&tables)
+	if err != nil || len(tables) != 1 {
+		t.Errorf("expected copying to leave RethinkDB alone, but it now has tables %v (error %v)", tables, err)
+	}
+}
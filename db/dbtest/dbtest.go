@@ -0,0 +1,737 @@
+//Package dbtest contains tests which every db.Store must pass, so that the storage backends behave the same way.
+//A backend runs them by calling RunStoreTests from its own tests:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		dbtest.RunStoreTests(t, func(t *testing.T) db.Store {
+//			return db.NewMemoryStore()
+//		})
+//	}
+package dbtest
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/db"
+	"github.com/callummance/nia/guildmodels"
+)
+
+//NewStoreFunc returns an empty store for a single test. The store is closed when the test finishes.
+type NewStoreFunc func(t *testing.T) db.Store
+
+//baseTime is used as the base for times in the tests. Backends may not store times more precisely than a millisecond,
+//so tests only use whole seconds.
+var baseTime = time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+//RunStoreTests runs every conformance test against stores created by newStore
+func RunStoreTests(t *testing.T, newStore NewStoreFunc) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s db.Store)
+	}{
+		{"GuildCreation", testGuildCreation},
+		{"AdminRoles", testAdminRoles},
+		{"GuildSettings", testGuildSettings},
+		{"FollowedStreams", testFollowedStreams},
+		{"FollowedTeams", testFollowedTeams},
+		{"FollowedCategories", testFollowedCategories},
+		{"GuildLifecycle", testGuildLifecycle},
+		{"PurgeGuild", testPurgeGuild},
+		{"RoleRules", testRoleRules},
+		{"StreamLinks", testStreamLinks},
+		{"Streams", testStreams},
+		{"StreamSessions", testStreamSessions},
+		{"ScheduledEvents", testScheduledEvents},
+		{"PostedClips", testPostedClips},
+		{"WebhookSecrets", testWebhookSecrets},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStore(t)
+			defer s.Close()
+			tc.test(t, s)
+		})
+	}
+}
+
+//must fails the test immediately if err is set
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+//expectCount checks the number of documents changed by an update
+func expectCount(t *testing.T, what string, got int, err error, want int) {
+	t.Helper()
+	must(t, err)
+	if got != want {
+		t.Errorf("%v changed %v documents, expected %v", what, got, want)
+	}
+}
+
+//expectSet checks that two lists contain the same strings, ignoring order
+func expectSet(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	got = append([]string(nil), got...)
+	want = append([]string(nil), want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Errorf("Expected %v to be %v, got %v", what, want, got)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v to be %v, got %v", what, want, got)
+			return
+		}
+	}
+}
+
+func guildIDs(guilds []guildmodels.DiscordGuild) []string {
+	var ids []string
+	for _, g := range guilds {
+		ids = append(ids, g.DiscordGID)
+	}
+	return ids
+}
+
+func memberIDs(members []guildmodels.MemberData) []string {
+	var ids []string
+	for _, m := range members {
+		ids = append(ids, m.GuildID+"/"+m.UserID)
+	}
+	return ids
+}
+
+func ruleRoleIDs(rules []guildmodels.ManagedRoleRule) []string {
+	var ids []string
+	for _, r := range rules {
+		ids = append(ids, r.RoleID)
+	}
+	return ids
+}
+
+func getGuild(t *testing.T, s db.Store, gid string) *guildmodels.DiscordGuild {
+	t.Helper()
+	g, err := s.GetOrCreateGuild(gid)
+	must(t, err)
+	if g == nil || g.DiscordGID != gid {
+		t.Fatalf("Expected guild %v, got %#v", gid, g)
+	}
+	return g
+}
+
+func testGuildCreation(t *testing.T, s db.Store) {
+	getGuild(t, s, "g1")
+
+	created, err := s.CreateGuild("g1")
+	must(t, err)
+	if created {
+		t.Errorf("CreateGuild created a guild which already existed")
+	}
+	created, err = s.CreateGuild("g2")
+	must(t, err)
+	if !created {
+		t.Errorf("CreateGuild didn't create a new guild")
+	}
+	g := getGuild(t, s, "g2")
+	if !g.Active() || len(g.AdminRoles) != 0 || len(g.FollowedStreams) != 0 {
+		t.Errorf("Expected new guild to be empty, got %#v", g)
+	}
+}
+
+func testAdminRoles(t *testing.T, s db.Store) {
+	n, err := s.AddAdminRole("g1", "r1")
+	expectCount(t, "Adding an admin role", n, err, 1)
+	n, err = s.AddAdminRole("g1", "r1")
+	expectCount(t, "Adding an existing admin role", n, err, 0)
+	n, err = s.AddAdminRole("g1", "r2")
+	expectCount(t, "Adding a second admin role", n, err, 1)
+	expectSet(t, "admin roles", getGuild(t, s, "g1").AdminRoles, "r1", "r2")
+
+	n, err = s.RemoveAdminRole("g1", "r1")
+	expectCount(t, "Removing an admin role", n, err, 1)
+	n, err = s.RemoveAdminRole("g1", "r1")
+	expectCount(t, "Removing a missing admin role", n, err, 0)
+	n, err = s.RemoveAdminRole("missing", "r1")
+	expectCount(t, "Removing an admin role from a missing guild", n, err, 0)
+	expectSet(t, "admin roles", getGuild(t, s, "g1").AdminRoles, "r2")
+}
+
+func testGuildSettings(t *testing.T, s db.Store) {
+	must(t, s.SetGuildVerifiedLinks("g1", true))
+	must(t, s.SetGuildPresenceStreaming("g1", true))
+	must(t, s.SetGuildScheduledEvents("g1", true))
+	must(t, s.SetGuildScheduledEvents("g2", true))
+	must(t, s.SetGuildScheduledEvents("g2", false))
+	g := getGuild(t, s, "g1")
+	if !g.VerifiedLinks || !g.PresenceStreaming || !g.ScheduledEvents {
+		t.Errorf("Expected guild settings to be turned on, got %#v", g)
+	}
+	guilds, err := s.GetGuildsWithScheduledEvents()
+	must(t, err)
+	expectSet(t, "guilds with scheduled events", guildIDs(guilds), "g1")
+
+	//Alert routes replace the legacy notification channel
+	routes := []guildmodels.StreamAlertRoute{{ChannelID: "c1"}, {ChannelID: "c2"}}
+	must(t, s.SetGuildAlertRoutes("g1", routes))
+	g = getGuild(t, s, "g1")
+	if g.NotificationChannels == nil || len(g.NotificationChannels.StreamAlertRoutes) != 2 ||
+		g.NotificationChannels.StreamNotificationsChannel != nil {
+		t.Errorf("Expected alert routes %v, got %#v", routes, g.NotificationChannels)
+	}
+
+	tmpl := guildmodels.DefaultAlertTemplate()
+	must(t, s.UpdateGuildAlertTemplate("g1", &tmpl))
+	if getGuild(t, s, "g1").StreamAlertTemplate == nil {
+		t.Errorf("Expected alert template to be set")
+	}
+	must(t, s.UpdateGuildAlertTemplate("g1", nil))
+	if getGuild(t, s, "g1").StreamAlertTemplate != nil {
+		t.Errorf("Expected alert template to be removed")
+	}
+
+	must(t, s.SetGuildAnnouncement("g1", guildmodels.AnnouncementRaid, &guildmodels.Announcement{ChannelID: "c1"}))
+	must(t, s.SetGuildAnnouncement("g1", guildmodels.AnnouncementFollow, &guildmodels.Announcement{ChannelID: "c2"}))
+	must(t, s.SetGuildAnnouncement("g1", guildmodels.AnnouncementFollow, nil))
+	g = getGuild(t, s, "g1")
+	if len(g.Announcements) != 1 || g.Announcements[guildmodels.AnnouncementRaid] == nil ||
+		g.Announcements[guildmodels.AnnouncementRaid].ChannelID != "c1" {
+		t.Errorf("Expected only the raid announcement to be set, got %#v", g.Announcements)
+	}
+
+	must(t, s.SetGuildClipsFeed("g1", &guildmodels.ClipsFeed{ChannelID: "c1", MinViews: 5, Since: baseTime}))
+	must(t, s.SetGuildClipsFeed("g2", &guildmodels.ClipsFeed{ChannelID: "c1"}))
+	must(t, s.SetGuildClipsFeed("g2", nil))
+	guilds, err = s.GetGuildsWithClipsFeed()
+	must(t, err)
+	expectSet(t, "guilds with a clips feed", guildIDs(guilds), "g1")
+	feed := guilds[0].ClipsFeed
+	if feed.ChannelID != "c1" || feed.MinViews != 5 || !feed.Since.Equal(baseTime) {
+		t.Errorf("Expected clips feed to be stored, got %#v", feed)
+	}
+}
+
+func testFollowedStreams(t *testing.T, s db.Store) {
+	n, err := s.AddGuildFollowedStream("g1", "twitch:1")
+	expectCount(t, "Following a stream", n, err, 1)
+	n, err = s.AddGuildFollowedStream("g1", "twitch:1")
+	expectCount(t, "Following a stream twice", n, err, 0)
+	_, err = s.AddGuildFollowedStream("g2", "twitch:1")
+	must(t, err)
+	_, err = s.AddGuildFollowedStream("g2", "twitch:2")
+	must(t, err)
+
+	guilds, err := s.GetGuildsFollowingStream("twitch:1")
+	must(t, err)
+	expectSet(t, "guilds following twitch:1", guildIDs(guilds), "g1", "g2")
+
+	n, err = s.RemoveGuildFollowedStream("g2", "twitch:1")
+	expectCount(t, "Unfollowing a stream", n, err, 1)
+	n, err = s.RemoveGuildFollowedStream("missing", "twitch:1")
+	expectCount(t, "Unfollowing a stream in a missing guild", n, err, 0)
+	guilds, err = s.GetGuildsFollowingStream("twitch:1")
+	must(t, err)
+	expectSet(t, "guilds following twitch:1", guildIDs(guilds), "g1")
+	expectSet(t, "followed streams", getGuild(t, s, "g2").FollowedStreams, "twitch:2")
+}
+
+func testFollowedTeams(t *testing.T, s db.Store) {
+	must(t, s.SetGuildFollowedTeam("g1", &guildmodels.FollowedTeam{Name: "team", Streams: []string{"twitch:1"}}))
+	must(t, s.SetGuildFollowedTeam("g1", &guildmodels.FollowedTeam{Name: "other", Streams: []string{"twitch:3"}}))
+	//Setting a team again replaces its roster
+	must(t, s.SetGuildFollowedTeam("g1", &guildmodels.FollowedTeam{Name: "team", Streams: []string{"twitch:2"}}))
+	g := getGuild(t, s, "g1")
+	if len(g.FollowedTeams) != 2 || g.FollowedTeam("team") == nil {
+		t.Fatalf("Expected two followed teams, got %#v", g.FollowedTeams)
+	}
+	expectSet(t, "team roster", g.FollowedTeam("team").Streams, "twitch:2")
+
+	guilds, err := s.GetGuildsFollowingStream("twitch:2")
+	must(t, err)
+	expectSet(t, "guilds following twitch:2", guildIDs(guilds), "g1")
+	guilds, err = s.GetGuildsFollowingStream("twitch:1")
+	must(t, err)
+	expectSet(t, "guilds following twitch:1", guildIDs(guilds))
+
+	must(t, s.SetGuildFollowedTeam("g2", &guildmodels.FollowedTeam{Name: "team"}))
+	leftAt := baseTime
+	must(t, s.SetGuildLeftAt("g2", &leftAt))
+	guilds, err = s.GetGuildsFollowingTeams()
+	must(t, err)
+	expectSet(t, "active guilds following teams", guildIDs(guilds), "g1")
+
+	n, err := s.RemoveGuildFollowedTeam("g1", "team")
+	expectCount(t, "Unfollowing a team", n, err, 1)
+	n, err = s.RemoveGuildFollowedTeam("missing", "team")
+	expectCount(t, "Unfollowing a team in a missing guild", n, err, 0)
+	g = getGuild(t, s, "g1")
+	if len(g.FollowedTeams) != 1 || g.FollowedTeam("other") == nil {
+		t.Errorf("Expected only one followed team to be left, got %#v", g.FollowedTeams)
+	}
+}
+
+func testFollowedCategories(t *testing.T, s db.Store) {
+	category := guildmodels.FollowedCategory{ID: "1", Name: "Just Chatting"}
+	n, err := s.AddGuildFollowedCategory("g1", &category)
+	expectCount(t, "Following a category", n, err, 1)
+	n, err = s.AddGuildFollowedCategory("g1", &category)
+	expectCount(t, "Following a category twice", n, err, 0)
+	n, err = s.AddGuildFollowedCategory("g1", &guildmodels.FollowedCategory{ID: "2", Name: "Art"})
+	expectCount(t, "Following a second category", n, err, 1)
+
+	n, err = s.RemoveGuildFollowedCategory("g1", "1")
+	expectCount(t, "Unfollowing a category", n, err, 1)
+	n, err = s.RemoveGuildFollowedCategory("missing", "1")
+	expectCount(t, "Unfollowing a category in a missing guild", n, err, 0)
+	g := getGuild(t, s, "g1")
+	if len(g.FollowedCategories) != 1 || g.FollowedCategories[0].ID != "2" {
+		t.Errorf("Expected only the second category to be followed, got %#v", g.FollowedCategories)
+	}
+}
+
+func testGuildLifecycle(t *testing.T, s db.Store) {
+	getGuild(t, s, "active")
+	early, late := baseTime, baseTime.Add(time.Hour)
+	must(t, s.SetGuildLeftAt("early", &early))
+	must(t, s.SetGuildLeftAt("late", &late))
+	must(t, s.SetGuildLeftAt("rejoined", &early))
+	must(t, s.SetGuildLeftAt("rejoined", nil))
+
+	inactive, err := s.GetInactiveGuildIDs()
+	must(t, err)
+	if len(inactive) != 2 || !inactive["early"] || !inactive["late"] {
+		t.Errorf("Expected early and late to be inactive, got %v", inactive)
+	}
+	active, err := s.GetActiveGuildIDs()
+	must(t, err)
+	expectSet(t, "active guilds", active, "active", "rejoined")
+	guilds, err := s.GetGuildsLeftBefore(baseTime.Add(time.Minute))
+	must(t, err)
+	expectSet(t, "guilds left before the cutoff", guildIDs(guilds), "early")
+	if !guilds[0].LeftAt.Equal(early) {
+		t.Errorf("Expected guild to have been left at %v, got %v", early, guilds[0].LeftAt)
+	}
+	if !getGuild(t, s, "rejoined").Active() {
+		t.Errorf("Expected rejoined guild to be active")
+	}
+}
+
+func testPurgeGuild(t *testing.T, s db.Store) {
+	for _, gid := range []string{"g1", "g2"} {
+		_, err := s.AddAdminRole(gid, "admin")
+		must(t, err)
+		_, _, err = s.SetStreamLink(gid, "u1", "twitch", "1")
+		must(t, err)
+		must(t, s.AddManagedRoleRule(nowLiveRule(gid, "r1")))
+		must(t, s.SetScheduledEventLink(&guildmodels.ScheduledEventLink{GuildID: gid, ScheduleKey: "twitch:1:s1"}))
+		must(t, s.AddPostedClip(&guildmodels.PostedClip{GuildID: gid, ClipID: "clip", CreatedAt: baseTime}))
+	}
+	must(t, s.PurgeGuild("g1"))
+
+	if g := getGuild(t, s, "g1"); len(g.AdminRoles) != 0 {
+		t.Errorf("Expected purged guild to be recreated empty, got %#v", g)
+	}
+	members, err := s.GetMembersByStream("twitch", "1", nil)
+	must(t, err)
+	expectSet(t, "members linked to twitch:1", memberIDs(members), "g2/u1")
+	for _, gid := range []string{"g1", "g2"} {
+		rules, err := s.GetRoleRules(gid, "r1")
+		must(t, err)
+		events, err := s.GetGuildScheduledEvents(gid)
+		must(t, err)
+		clips, err := s.GetPostedClips(gid, []string{"clip"})
+		must(t, err)
+		want := 1
+		if gid == "g1" {
+			want = 0
+		}
+		if len(rules) != want || len(events) != want || len(clips) != want {
+			t.Errorf("Expected %v rules, scheduled events and clips in %v, got %v, %v and %v", want, gid, len(rules), len(events), len(clips))
+		}
+	}
+	//Streams may still be linked elsewhere, so they are left alone
+	streams, err := s.GetStreamChannels([]string{"twitch:1"})
+	must(t, err)
+	if streams["twitch:1"] == nil {
+		t.Errorf("Expected stream to survive guild purge")
+	}
+}
+
+func nowLiveRule(gid, roleID string) guildmodels.ManagedRoleRule {
+	return guildmodels.ManagedRoleRule{
+		GuildID: gid,
+		RoleID:  roleID,
+		RoleAssignment: guildmodels.RoleAssignment{
+			AssignmentType: "nowlive",
+		},
+	}
+}
+
+func reactionRule(gid, roleID, emojiID string, botShouldReact bool) guildmodels.ManagedRoleRule {
+	return guildmodels.ManagedRoleRule{
+		GuildID: gid,
+		RoleID:  roleID,
+		RoleAssignment: guildmodels.RoleAssignment{
+			AssignmentType: "reaction",
+			ReactionRoleData: &guildmodels.ReactionRoleAssign{
+				MsgID:          "m1",
+				ChanID:         "c1",
+				EmojiID:        emojiID,
+				BotShouldReact: botShouldReact,
+			},
+		},
+	}
+}
+
+func testRoleRules(t *testing.T, s db.Store) {
+	must(t, s.AddManagedRoleRule(nowLiveRule("g1", "live")))
+	must(t, s.AddManagedRoleRule(nowLiveRule("g2", "live")))
+	must(t, s.AddManagedRoleRule(reactionRule("g1", "react", "e1", true)))
+	must(t, s.AddManagedRoleRule(reactionRule("g1", "quiet", "e2", false)))
+
+	rules, err := s.LookupNowLiveRoles("g1")
+	must(t, err)
+	expectSet(t, "now live roles", ruleRoleIDs(rules), "live")
+	rules, err = s.LookupRolesByEmote("m1", "c1", "g1", "e2")
+	must(t, err)
+	expectSet(t, "roles for emote e2", ruleRoleIDs(rules), "quiet")
+	if len(rules) == 1 && rules[0].RoleAssignment.ReactionRoleData.EmojiID != "e2" {
+		t.Errorf("Expected reaction options to be stored, got %#v", rules[0].RoleAssignment.ReactionRoleData)
+	}
+	rules, err = s.LookupRolesByEmote("m2", "c1", "g1", "e2")
+	must(t, err)
+	expectSet(t, "roles for emote on another message", ruleRoleIDs(rules))
+	rules, err = s.GetGuildRolesWithInitialReact("g1")
+	must(t, err)
+	expectSet(t, "roles with initial react", ruleRoleIDs(rules), "react")
+
+	managed, err := s.IsManagedRole("g1", "live")
+	must(t, err)
+	if !managed {
+		t.Errorf("Expected role with rules to be managed")
+	}
+	managed, err = s.IsManagedRole("g1", "unmanaged")
+	must(t, err)
+	if managed {
+		t.Errorf("Expected role without rules not to be managed")
+	}
+
+	must(t, s.AddManagedRoleRule(reactionRule("g1", "live", "e3", false)))
+	rules, err = s.GetRoleRules("g1", "live")
+	must(t, err)
+	if len(rules) != 2 {
+		t.Errorf("Expected 2 rules for role, got %v", rules)
+	}
+	n, err := s.DeleteRoleRules("g1", "live")
+	expectCount(t, "Deleting role rules", n, err, 2)
+	managed, err = s.IsManagedRole("g1", "live")
+	must(t, err)
+	if managed {
+		t.Errorf("Expected role to stop being managed once its rules are deleted")
+	}
+	rules, err = s.LookupNowLiveRoles("g2")
+	must(t, err)
+	expectSet(t, "now live roles in another guild", ruleRoleIDs(rules), "live")
+}
+
+func testStreamLinks(t *testing.T, s db.Store) {
+	member, err := s.GetMemberData("g1", "u1")
+	must(t, err)
+	if member != nil {
+		t.Errorf("Expected no data for new member, got %#v", member)
+	}
+
+	oldStream, stream, err := s.SetStreamLink("g1", "u1", "twitch", "1")
+	must(t, err)
+	if oldStream != nil || stream == nil || stream.Key != "twitch:1" || stream.ChannelID != "1" {
+		t.Errorf("Expected new link to twitch:1 with no old link, got %#v and %#v", stream, oldStream)
+	}
+	_, _, err = s.SetStreamLink("g1", "u1", "youtube", "y1")
+	must(t, err)
+	oldStream, stream, err = s.SetStreamLink("g1", "u1", "twitch", "2")
+	must(t, err)
+	if oldStream == nil || oldStream.Key != "twitch:1" || stream == nil || stream.Key != "twitch:2" {
+		t.Errorf("Expected link to move from twitch:1 to twitch:2, got %#v and %#v", oldStream, stream)
+	}
+	_, _, err = s.SetStreamLink("g2", "u1", "twitch", "2")
+	must(t, err)
+	must(t, s.SetMemberHideSchedule("g1", "u2", true))
+
+	member, err = s.GetMemberData("g1", "u1")
+	must(t, err)
+	if member == nil || member.Connections.StreamLinks["twitch"] != "2" || member.Connections.StreamLinks["youtube"] != "y1" {
+		t.Errorf("Expected member to be linked to twitch:2 and youtube:y1, got %#v", member)
+	}
+	linked, err := s.GetStreamLink("g1", "u1", "twitch")
+	must(t, err)
+	if linked == nil || linked.Key != "twitch:2" {
+		t.Errorf("Expected linked stream twitch:2, got %#v", linked)
+	}
+	members, err := s.GetMembersByStream("twitch", "2", nil)
+	must(t, err)
+	expectSet(t, "members linked to twitch:2", memberIDs(members), "g1/u1", "g2/u1")
+	gid := "g2"
+	members, err = s.GetMembersByStream("twitch", "2", &gid)
+	must(t, err)
+	expectSet(t, "members in g2 linked to twitch:2", memberIDs(members), "g2/u1")
+	members, err = s.GetGuildStreamLinks("g1")
+	must(t, err)
+	expectSet(t, "members with links in g1", memberIDs(members), "g1/u1")
+	member, err = s.GetMemberData("g1", "u2")
+	must(t, err)
+	if member == nil || !member.HideSchedule {
+		t.Errorf("Expected member to hide their schedule, got %#v", member)
+	}
+
+	removed, err := s.RemoveStreamLink("g1", "u1", "twitch")
+	must(t, err)
+	if removed == nil || removed.Key != "twitch:2" {
+		t.Errorf("Expected removed link to be twitch:2, got %#v", removed)
+	}
+	removed, err = s.RemoveStreamLink("g1", "u1", "twitch")
+	must(t, err)
+	if removed != nil {
+		t.Errorf("Expected no link to be removed the second time, got %#v", removed)
+	}
+	linked, err = s.GetStreamLink("g1", "u1", "twitch")
+	must(t, err)
+	if linked != nil {
+		t.Errorf("Expected link to have been removed, got %#v", linked)
+	}
+	linked, err = s.GetStreamLink("g1", "u1", "youtube")
+	must(t, err)
+	if linked == nil {
+		t.Errorf("Expected links to other providers to be kept")
+	}
+}
+
+func testStreams(t *testing.T, s db.Store) {
+	stream, err := s.GetStreamChannel("twitch", "1")
+	must(t, err)
+	if stream == nil || stream.Key != "twitch:1" || stream.Provider != "twitch" || stream.IsLive {
+		t.Fatalf("Expected new stream twitch:1, got %#v", stream)
+	}
+	_, err = s.GetStreamChannel("twitch", "2")
+	must(t, err)
+	_, err = s.GetStreamChannel("youtube", "1")
+	must(t, err)
+	ids, err := s.GetAllStreamChannelIDs("twitch")
+	must(t, err)
+	expectSet(t, "twitch channel IDs", ids, "1", "2")
+
+	//Updates to streams which don't exist do nothing
+	must(t, s.SetStreamChannelLive("twitch", "missing", true))
+	streams, err := s.GetStreamChannels([]string{"twitch:1", "twitch:missing"})
+	must(t, err)
+	if len(streams) != 1 || streams["twitch:1"] == nil {
+		t.Errorf("Expected only twitch:1 to exist, got %v", streams)
+	}
+
+	must(t, s.SetStreamChannelLogin("twitch", "1", "login"))
+	must(t, s.SetStreamChannelLive("twitch", "1", true))
+	old, err := s.SetStreamChannelFollowers("twitch", "1", 10)
+	must(t, err)
+	if old != 0 {
+		t.Errorf("Expected no previous follower count, got %v", old)
+	}
+	old, err = s.SetStreamChannelFollowers("twitch", "1", 20)
+	must(t, err)
+	if old != 10 {
+		t.Errorf("Expected previous follower count of 10, got %v", old)
+	}
+	post1 := guildmodels.MessageRef{GuildID: "g1", ChannelID: "c1", MessageID: "m1"}
+	post2 := guildmodels.MessageRef{GuildID: "g1", ChannelID: "c1", MessageID: "m2"}
+	must(t, s.AddDiscordStatusPost("twitch", "1", &post1))
+	must(t, s.AddDiscordStatusPost("twitch", "1", &post1))
+	must(t, s.AddDiscordStatusPost("twitch", "1", &post2))
+	stream, err = s.GetStreamChannel("twitch", "1")
+	must(t, err)
+	if stream.Login != "login" || !stream.IsLive || stream.Followers != 20 || len(stream.DiscordStatusPosts) != 2 {
+		t.Errorf("Expected stream updates to be stored, got %#v", stream)
+	}
+	must(t, s.RemoveDiscordStatusPost("twitch", "1", &post1))
+	stream, err = s.GetStreamChannel("twitch", "1")
+	must(t, err)
+	if len(stream.DiscordStatusPosts) != 1 || stream.DiscordStatusPosts[0] != post2 {
+		t.Errorf("Expected only the second status post to be left, got %v", stream.DiscordStatusPosts)
+	}
+	must(t, s.ClearDiscordStatusPosts("twitch", "1"))
+	stream, err = s.GetStreamChannel("twitch", "1")
+	must(t, err)
+	if len(stream.DiscordStatusPosts) != 0 {
+		t.Errorf("Expected status posts to be cleared, got %v", stream.DiscordStatusPosts)
+	}
+
+	//Deleting a stream also removes links to it
+	_, _, err = s.SetStreamLink("g1", "u1", "twitch", "1")
+	must(t, err)
+	must(t, s.DeleteStreamChannel("twitch", "1"))
+	ids, err = s.GetAllStreamChannelIDs("twitch")
+	must(t, err)
+	expectSet(t, "twitch channel IDs", ids, "2")
+	members, err := s.GetMembersByStream("twitch", "1", nil)
+	must(t, err)
+	expectSet(t, "members linked to deleted stream", memberIDs(members))
+}
+
+func testStreamSessions(t *testing.T, s db.Store) {
+	key1, key2 := "twitch:1", "twitch:2"
+	sample := func(minutes, viewers int, game string) *guildmodels.ViewerSample {
+		return &guildmodels.ViewerSample{
+			Time:    baseTime.Add(time.Duration(minutes) * time.Minute),
+			Viewers: viewers,
+			Game:    game,
+		}
+	}
+	must(t, s.StartStreamSession("twitch", "2", baseTime.Add(time.Minute), "other", sample(1, 5, "")))
+	must(t, s.StartStreamSession("twitch", "1", baseTime, "first", sample(0, 10, "Art")))
+	//Starting a session which is already open adds a sample instead
+	must(t, s.StartStreamSession("twitch", "1", baseTime, "first", sample(5, 20, "Art")))
+	must(t, s.AddStreamSessionSample("twitch", "1", "second", sample(10, 30, "Chess")))
+
+	open, err := s.GetOpenStreamSessions(&key1)
+	must(t, err)
+	if len(open) != 1 {
+		t.Fatalf("Expected one open session for %v, got %v", key1, open)
+	}
+	session := open[0]
+	if session.StreamKey != key1 || session.ID == "" || !session.StartedAt.Equal(baseTime) || session.EndedAt != nil {
+		t.Errorf("Expected open session for %v, got %#v", key1, session)
+	}
+	if len(session.Samples) != 3 || session.Samples[2].Viewers != 30 {
+		t.Errorf("Expected three viewer samples, got %v", session.Samples)
+	}
+	expectSet(t, "session titles", session.Titles, "first", "second")
+	expectSet(t, "session games", session.Games, "Art", "Chess")
+	open, err = s.GetOpenStreamSessions(nil)
+	must(t, err)
+	if len(open) != 2 {
+		t.Errorf("Expected two open sessions, got %v", open)
+	}
+
+	end := baseTime.Add(time.Hour)
+	must(t, s.EndStreamSession("twitch", "1", end))
+	//Samples aren't added to sessions which have ended
+	must(t, s.AddStreamSessionSample("twitch", "1", "", sample(70, 1, "")))
+	must(t, s.StartStreamSession("twitch", "1", baseTime.Add(2*time.Hour), "", sample(120, 1, "")))
+	open, err = s.GetOpenStreamSessions(&key1)
+	must(t, err)
+	if len(open) != 1 || !open[0].StartedAt.Equal(baseTime.Add(2*time.Hour)) {
+		t.Errorf("Expected a new session to be open, got %v", open)
+	}
+
+	sessions, err := s.GetStreamSessions([]string{key1, key2}, baseTime)
+	must(t, err)
+	if len(sessions) != 3 {
+		t.Fatalf("Expected three sessions, got %v", sessions)
+	}
+	if sessions[0].StreamKey != key1 || sessions[1].StreamKey != key2 || sessions[2].StreamKey != key1 {
+		t.Errorf("Expected sessions to be ordered by start time, got %v", sessions)
+	}
+	if sessions[0].EndedAt == nil || !sessions[0].EndedAt.Equal(end) || len(sessions[0].Samples) != 3 {
+		t.Errorf("Expected first session to have ended at %v with three samples, got %#v", end, sessions[0])
+	}
+	sessions, err = s.GetStreamSessions([]string{key1}, end.Add(time.Minute))
+	must(t, err)
+	if len(sessions) != 1 || sessions[0].EndedAt != nil {
+		t.Errorf("Expected only the open session to be live after the first ended, got %v", sessions)
+	}
+	sessions, err = s.GetStreamSessions(nil, baseTime)
+	must(t, err)
+	if len(sessions) != 0 {
+		t.Errorf("Expected no sessions for no streams, got %v", sessions)
+	}
+}
+
+func testScheduledEvents(t *testing.T, s db.Store) {
+	link := guildmodels.ScheduledEventLink{
+		GuildID:     "g1",
+		ScheduleKey: "twitch:1:s1",
+		StreamKey:   "twitch:1",
+		EventID:     "e1",
+		Name:        "Stream",
+		StartTime:   baseTime,
+		EndTime:     baseTime.Add(time.Hour),
+	}
+	must(t, s.SetScheduledEventLink(&link))
+	link.Name = "Renamed"
+	must(t, s.SetScheduledEventLink(&link))
+	link.ScheduleKey = "twitch:1:s2"
+	must(t, s.SetScheduledEventLink(&link))
+	link.GuildID = "g2"
+	must(t, s.SetScheduledEventLink(&link))
+
+	links, err := s.GetGuildScheduledEvents("g1")
+	must(t, err)
+	var keys []string
+	for _, l := range links {
+		keys = append(keys, l.ScheduleKey)
+		if l.Name != "Renamed" || l.EventID != "e1" || !l.StartTime.Equal(baseTime) {
+			t.Errorf("Expected scheduled event link to be replaced, got %#v", l)
+		}
+	}
+	expectSet(t, "scheduled events in g1", keys, "twitch:1:s1", "twitch:1:s2")
+
+	must(t, s.DeleteScheduledEventLink("g1", "twitch:1:s1"))
+	must(t, s.DeleteScheduledEventLink("g1", "missing"))
+	links, err = s.GetGuildScheduledEvents("g1")
+	must(t, err)
+	if len(links) != 1 || links[0].ScheduleKey != "twitch:1:s2" {
+		t.Errorf("Expected one scheduled event to be left, got %v", links)
+	}
+}
+
+func testPostedClips(t *testing.T, s db.Store) {
+	posted, err := s.GetPostedClips("g1", nil)
+	must(t, err)
+	if posted == nil || len(posted) != 0 {
+		t.Errorf("Expected an empty set of posted clips, got %v", posted)
+	}
+	must(t, s.AddPostedClip(&guildmodels.PostedClip{GuildID: "g1", ClipID: "old", CreatedAt: baseTime}))
+	must(t, s.AddPostedClip(&guildmodels.PostedClip{GuildID: "g1", ClipID: "new", CreatedAt: baseTime.Add(time.Hour)}))
+	must(t, s.AddPostedClip(&guildmodels.PostedClip{GuildID: "g2", ClipID: "other", CreatedAt: baseTime}))
+
+	posted, err = s.GetPostedClips("g1", []string{"old", "new", "other", "missing"})
+	must(t, err)
+	if len(posted) != 2 || !posted["old"] || !posted["new"] {
+		t.Errorf("Expected old and new clips to have been posted in g1, got %v", posted)
+	}
+	must(t, s.PrunePostedClips(baseTime.Add(time.Minute)))
+	posted, err = s.GetPostedClips("g1", []string{"old", "new"})
+	must(t, err)
+	if len(posted) != 1 || !posted["new"] {
+		t.Errorf("Expected only the new clip to be left after pruning, got %v", posted)
+	}
+}
+
+func testWebhookSecrets(t *testing.T, s db.Store) {
+	secret, err := s.GetWebhookSecret(guildmodels.TwitchWebhookSecretID)
+	must(t, err)
+	if secret != nil {
+		t.Errorf("Expected no secret before one is saved, got %#v", secret)
+	}
+	must(t, s.SetWebhookSecret(&guildmodels.WebhookSecret{
+		ID:        guildmodels.TwitchWebhookSecretID,
+		Current:   "one",
+		CreatedAt: baseTime,
+	}))
+	must(t, s.SetWebhookSecret(&guildmodels.WebhookSecret{
+		ID:        guildmodels.TwitchWebhookSecretID,
+		Current:   "two",
+		Previous:  "one",
+		CreatedAt: baseTime.Add(time.Hour),
+		Applied:   true,
+	}))
+	secret, err = s.GetWebhookSecret(guildmodels.TwitchWebhookSecretID)
+	must(t, err)
+	if secret == nil || secret.Current != "two" || secret.Previous != "one" || !secret.Applied ||
+		!secret.CreatedAt.Equal(baseTime.Add(time.Hour)) {
+		t.Errorf("Expected secret to be replaced, got %#v", secret)
+	}
+}
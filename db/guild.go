@@ -24,7 +24,7 @@ func (db *Connection) GetOrCreateGuild(id string) (*guildmodels.DiscordGuild, er
 	if res.IsNil() {
 		//Create new guild object
 		logrus.Infof("Inserting new guild id %v into database.", id)
-		guildObj := guildmodels.DefaultGuild(id)
+		guildObj = guildmodels.DefaultGuild(id)
 		resp, err := rethink.Table(guildsTable).Insert(guildObj).RunWrite(db.session)
 		if err != nil {
 			logrus.Errorf("Failed to insert new guild with id %v because: %v.", id, err)
@@ -51,7 +51,7 @@ func (db *Connection) AddAdminRole(gid string, roleID string) (int, error) {
 		return 0, err
 	}
 	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
-		"admin_roles": rethink.Row.Field("admin_roles").SetInsert(roleID),
+		"admin_roles": rethink.Row.Field("admin_roles").Default([]interface{}{}).SetInsert(roleID),
 	}).RunWrite(db.session)
 	if err != nil {
 		logrus.Warnf("Encountered error appending admin role to DB: %v", err)
@@ -64,22 +64,332 @@ func (db *Connection) AddAdminRole(gid string, roleID string) (int, error) {
 	return resp.Replaced, nil
 }
 
-//UpdateGuildNotificationChannels updates the notification channels assigned to a given guild stored in the database
-func (db *Connection) UpdateGuildNotificationChannels(gid string, notifChans guildmodels.NotificationChannels) error {
+//RemoveAdminRole removes a roleID from the list of AdminRoles for the given guild. It returns the number of updated
+//entries as well as any errors
+func (db *Connection) RemoveAdminRole(gid string, roleID string) (int, error) {
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"admin_roles": rethink.Row.Field("admin_roles").Default([]interface{}{}).SetDifference([]string{roleID}),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error removing admin role from DB: %v", err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error removing admin role from DB: %v", err)
+		return 0, err
+	}
+	return resp.Replaced, nil
+}
+
+//SetGuildAlertRoutes replaces the stream alert routes for a given guild stored in the database. This also removes
+//the legacy single stream notification channel, so routes should include it if it is still wanted.
+func (db *Connection) SetGuildAlertRoutes(gid string, routes []guildmodels.StreamAlertRoute) error {
 	err := db.ensureGuildExists(gid)
 	if err != nil {
 		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
 		return err
 	}
+	if routes == nil {
+		routes = []guildmodels.StreamAlertRoute{}
+	}
 	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
-		"notification_channels": notifChans,
+		"notification_channels": map[string]interface{}{
+			"stream_notification_channel": rethink.Literal(),
+			"stream_alert_routes":         rethink.Literal(routes),
+		},
 	}).RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Encountered error updating guild notification channels: %v", err)
+		logrus.Warnf("Encountered error updating guild alert routes: %v", err)
 		return err
 	} else if resp.Errors > 0 {
 		err := fmt.Errorf("%v", resp.FirstError)
-		logrus.Warnf("Encountered error updating guild notification channels: %v", err)
+		logrus.Warnf("Encountered error updating guild alert routes: %v", err)
+		return err
+	}
+	return nil
+}
+
+//AddGuildFollowedStream adds a stream key (see guildmodels.StreamKey) to the list of streams followed by the given
+//guild. It returns the number of updated entries as well as any errors
+func (db *Connection) AddGuildFollowedStream(gid, streamKey string) (int, error) {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return 0, err
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"followed_streams": rethink.Row.Field("followed_streams").Default([]interface{}{}).SetInsert(streamKey),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error adding followed stream to DB: %v", err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error adding followed stream to DB: %v", err)
+		return 0, err
+	}
+	return resp.Replaced, nil
+}
+
+//RemoveGuildFollowedStream removes a stream key from the list of streams followed by the given guild. It returns the
+//number of updated entries as well as any errors
+func (db *Connection) RemoveGuildFollowedStream(gid, streamKey string) (int, error) {
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"followed_streams": rethink.Row.Field("followed_streams").Default([]interface{}{}).SetDifference([]string{streamKey}),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error removing followed stream from DB: %v", err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error removing followed stream from DB: %v", err)
+		return 0, err
+	}
+	return resp.Replaced, nil
+}
+
+//GetGuildsFollowingStream returns every guild which follows the stream with the provided key, either directly or as
+//part of a team
+func (db *Connection) GetGuildsFollowingStream(streamKey string) ([]guildmodels.DiscordGuild, error) {
+	query := rethink.Table(guildsTable).Filter(func(guild rethink.Term) rethink.Term {
+		followsTeam := guild.Field("followed_teams").Default([]interface{}{}).Contains(func(team rethink.Term) rethink.Term {
+			return team.Field("streams").Default([]interface{}{}).Contains(streamKey)
+		})
+		return guild.Field("followed_streams").Default([]interface{}{}).Contains(streamKey).Or(followsTeam)
+	})
+	res, err := query.Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up guilds following stream %v due to error %v", streamKey, err)
+		return nil, err
+	}
+	defer res.Close()
+	var guilds []guildmodels.DiscordGuild
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&guilds)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve guilds following stream %v due to error %v", streamKey, err)
+		return nil, err
+	}
+	return guilds, nil
+}
+
+//SetGuildFollowedTeam adds a twitch team to the list of teams followed by the given guild, replacing the existing
+//entry for the team if there is one
+func (db *Connection) SetGuildFollowedTeam(gid string, team *guildmodels.FollowedTeam) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"followed_teams": rethink.Row.Field("followed_teams").Default([]interface{}{}).Filter(func(t rethink.Term) rethink.Term {
+			return t.Field("name").Ne(team.Name)
+		}).Append(team),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating followed team in DB: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating followed team in DB: %v", err)
+		return err
+	}
+	return nil
+}
+
+//RemoveGuildFollowedTeam removes a twitch team from the list of teams followed by the given guild. It returns the
+//number of updated entries as well as any errors
+func (db *Connection) RemoveGuildFollowedTeam(gid, teamName string) (int, error) {
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"followed_teams": rethink.Row.Field("followed_teams").Default([]interface{}{}).Filter(func(t rethink.Term) rethink.Term {
+			return t.Field("name").Ne(teamName)
+		}),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error removing followed team from DB: %v", err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error removing followed team from DB: %v", err)
+		return 0, err
+	}
+	return resp.Replaced, nil
+}
+
+//GetGuildsFollowingTeams returns every active guild which follows at least one twitch team
+func (db *Connection) GetGuildsFollowingTeams() ([]guildmodels.DiscordGuild, error) {
+	query := rethink.Table(guildsTable).Filter(func(guild rethink.Term) rethink.Term {
+		return guild.Field("followed_teams").Default([]interface{}{}).IsEmpty().Not().And(activeGuild(guild))
+	})
+	res, err := query.Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up guilds following twitch teams due to error %v", err)
+		return nil, err
+	}
+	defer res.Close()
+	var guilds []guildmodels.DiscordGuild
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&guilds)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve guilds following twitch teams due to error %v", err)
+		return nil, err
+	}
+	return guilds, nil
+}
+
+//AddGuildFollowedCategory adds a twitch category to the list of categories followed by the given guild. It returns
+//the number of updated entries as well as any errors
+func (db *Connection) AddGuildFollowedCategory(gid string, category *guildmodels.FollowedCategory) (int, error) {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return 0, err
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"followed_categories": rethink.Row.Field("followed_categories").Default([]interface{}{}).SetInsert(category),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error adding followed category to DB: %v", err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error adding followed category to DB: %v", err)
+		return 0, err
+	}
+	return resp.Replaced, nil
+}
+
+//RemoveGuildFollowedCategory removes the twitch category with the provided ID from the list of categories followed
+//by the given guild. It returns the number of updated entries as well as any errors
+func (db *Connection) RemoveGuildFollowedCategory(gid, categoryID string) (int, error) {
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"followed_categories": rethink.Row.Field("followed_categories").Default([]interface{}{}).Filter(func(c rethink.Term) rethink.Term {
+			return c.Field("id").Ne(categoryID)
+		}),
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error removing followed category from DB: %v", err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error removing followed category from DB: %v", err)
+		return 0, err
+	}
+	return resp.Replaced, nil
+}
+
+//SetGuildVerifiedLinks sets whether members of a guild must prove they own a channel before linking it
+func (db *Connection) SetGuildVerifiedLinks(gid string, enabled bool) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"verified_links": enabled,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild verified links setting: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild verified links setting: %v", err)
+		return err
+	}
+	return nil
+}
+
+//SetGuildPresenceStreaming sets whether discord streaming activity should trigger stream alerts in a guild
+func (db *Connection) SetGuildPresenceStreaming(gid string, enabled bool) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"presence_streaming": enabled,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild presence streaming setting: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild presence streaming setting: %v", err)
+		return err
+	}
+	return nil
+}
+
+//SetGuildScheduledEvents sets whether linked members' stream schedules should be synced to a guild's scheduled events
+func (db *Connection) SetGuildScheduledEvents(gid string, enabled bool) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"scheduled_events": enabled,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild scheduled events setting: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild scheduled events setting: %v", err)
+		return err
+	}
+	return nil
+}
+
+//GetGuildsWithScheduledEvents returns every active guild which has stream schedule syncing turned on
+func (db *Connection) GetGuildsWithScheduledEvents() ([]guildmodels.DiscordGuild, error) {
+	res, err := rethink.Table(guildsTable).Filter(func(guild rethink.Term) rethink.Term {
+		return guild.Field("scheduled_events").Default(false).Eq(true).And(activeGuild(guild))
+	}).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up guilds with scheduled events turned on due to error %v", err)
+		return nil, err
+	}
+	defer res.Close()
+	var guilds []guildmodels.DiscordGuild
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&guilds)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve guilds with scheduled events turned on due to error %v", err)
+		return nil, err
+	}
+	return guilds, nil
+}
+
+//SetGuildAnnouncement sets how a type of twitch community event should be announced in a guild. Passing a nil
+//announcement stops the event type from being announced.
+func (db *Connection) SetGuildAnnouncement(gid, eventType string, announcement *guildmodels.Announcement) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	newVal := rethink.Literal()
+	if announcement != nil {
+		newVal = rethink.Literal(announcement)
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"announcements": map[string]interface{}{
+			eventType: newVal,
+		},
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild announcements: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild announcements: %v", err)
 		return err
 	}
 	return nil
@@ -95,3 +405,30 @@ func (db *Connection) ensureGuildExists(gid string) error {
 	}).RunWrite(db.session)
 	return err
 }
+
+//UpdateGuildAlertTemplate replaces the stream alert template for a given guild. Passing a nil template
+//removes it, so the default alert text will be used.
+func (db *Connection) UpdateGuildAlertTemplate(gid string, tmpl *guildmodels.AlertTemplate) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	//Use a literal so that removed fields don't get merged back in from the old template
+	newVal := rethink.Literal()
+	if tmpl != nil {
+		newVal = rethink.Literal(tmpl)
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"stream_alert_template": newVal,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild alert template: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild alert template: %v", err)
+		return err
+	}
+	return nil
+}
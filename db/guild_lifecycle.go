@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+//activeGuild filters out guilds which the bot has been removed from
+func activeGuild(guild rethink.Term) rethink.Term {
+	return guild.HasFields("left_at").Not()
+}
+
+//CreateGuild creates a document for a guild if one doesn't exist yet, returning true if one was created
+func (db *Connection) CreateGuild(gid string) (bool, error) {
+	resp, err := rethink.Table(guildsTable).Insert(guildmodels.DefaultGuild(gid), rethink.InsertOpts{
+		Conflict: func(id, oldDoc, newDoc rethink.Term) interface{} {
+			return oldDoc
+		},
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error creating guild %v in DB: %v", gid, err)
+		return false, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error creating guild %v in DB: %v", gid, err)
+		return false, err
+	}
+	return resp.Inserted > 0, nil
+}
+
+//SetGuildLeftAt marks a guild as having been left by the bot at the given time. Passing nil marks the guild as active
+//again.
+func (db *Connection) SetGuildLeftAt(gid string, leftAt *time.Time) error {
+	err := db.ensureGuildExists(gid)
+	if err != nil {
+		logrus.Errorf("Failed to ensure creation of guild %v in database due to error %v", gid, err)
+		return err
+	}
+	newVal := rethink.Literal()
+	if leftAt != nil {
+		newVal = rethink.Literal(*leftAt)
+	}
+	resp, err := rethink.Table(guildsTable).Get(gid).Update(map[string]interface{}{
+		"left_at": newVal,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error updating guild left time: %v", err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error updating guild left time: %v", err)
+		return err
+	}
+	return nil
+}
+
+//GetActiveGuildIDs returns the IDs of every guild which the bot hasn't been removed from
+func (db *Connection) GetActiveGuildIDs() ([]string, error) {
+	res, err := rethink.Table(guildsTable).Filter(activeGuild).Field("id").Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up active guilds due to error %v", err)
+		return nil, err
+	}
+	defer res.Close()
+	var ids []string
+	err = res.All(&ids)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve active guilds due to error %v", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+//GetInactiveGuildIDs returns the set of guilds which the bot has been removed from but which haven't been purged yet
+func (db *Connection) GetInactiveGuildIDs() (map[string]bool, error) {
+	guilds, err := db.getInactiveGuilds(func(guild rethink.Term) rethink.Term {
+		return guild.HasFields("left_at")
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(guilds))
+	for _, guild := range guilds {
+		ids[guild.DiscordGID] = true
+	}
+	return ids, nil
+}
+
+//GetGuildsLeftBefore returns every guild which the bot was removed from before the given time
+func (db *Connection) GetGuildsLeftBefore(before time.Time) ([]guildmodels.DiscordGuild, error) {
+	return db.getInactiveGuilds(func(guild rethink.Term) rethink.Term {
+		return guild.HasFields("left_at").And(guild.Field("left_at").Lt(before))
+	})
+}
+
+func (db *Connection) getInactiveGuilds(filter func(rethink.Term) rethink.Term) ([]guildmodels.DiscordGuild, error) {
+	res, err := rethink.Table(guildsTable).Filter(filter).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up inactive guilds due to error %v", err)
+		return nil, err
+	}
+	defer res.Close()
+	var guilds []guildmodels.DiscordGuild
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&guilds)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve inactive guilds due to error %v", err)
+		return nil, err
+	}
+	return guilds, nil
+}
+
+//PurgeGuild deletes all data stored for a guild, including its members, managed roles, scheduled events and posted
+//clips. Streams are left alone, as they may still be used elsewhere.
+func (db *Connection) PurgeGuild(gid string) error {
+	inGuild := func(doc rethink.Term) rethink.Term {
+		return doc.Field("id").Nth(0).Eq(gid)
+	}
+	queries := []struct {
+		name  string
+		query rethink.Term
+	}{
+		{"members", rethink.Table(membersTable).Filter(inGuild)},
+		{"managed role rules", rethink.Table(guildRolesTable).Filter(map[string]interface{}{"guild_id": gid})},
+		{"scheduled events", rethink.Table(scheduledEventsTable).Filter(inGuild)},
+		{"posted clips", rethink.Table(postedClipsTable).Filter(inGuild)},
+		{"guild", rethink.Table(guildsTable).Get(gid)},
+	}
+	for _, q := range queries {
+		resp, err := q.query.Delete().RunWrite(db.session)
+		if err != nil {
+			logrus.Warnf("Encountered error purging %v for guild %v: %v", q.name, gid, err)
+			return err
+		} else if resp.Errors > 0 {
+			err := fmt.Errorf("%v", resp.FirstError)
+			logrus.Warnf("Encountered error purging %v for guild %v: %v", q.name, gid, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -10,170 +10,240 @@ import (
 )
 
 const membersTable string = "members"
-const twitchTable string = "twitch"
+const streamsTable string = "streams"
 
-//GetTwitchConnectionData returns exactly one twitch connection object for the given member if it exists.
-func (db *Connection) GetTwitchConnectionData(guildID, userID string) (*guildmodels.TwitchStream, error) {
+//GetMemberData returns the stored data for a given member, or nil if nothing has been stored for them
+func (db *Connection) GetMemberData(guildID, userID string) (*guildmodels.MemberData, error) {
 	id := []string{guildID, userID}
-	query := rethink.Table(membersTable).Get(id).Merge(func(p rethink.Term) interface{} {
-		return map[string]interface{}{
-			"connections": map[string]interface{}{
-				"twitch_link": rethink.Table(twitchTable).Get(p.Field("connections").Field("twitch_link")),
-			},
-		}
-	}).Field("connections").Field("twitch_link")
-	res, err := query.Run(db.session)
-	defer res.Close()
+	res, err := rethink.Table(membersTable).Get(id).Run(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to get twitch connection data for member %v:%v due to error %v", guildID, userID, err)
+		logrus.Warnf("Failed to get data for member %v:%v due to error %v", guildID, userID, err)
 		return nil, err
 	}
-	var data guildmodels.TwitchStream
+	defer res.Close()
 	if res.IsNil() {
 		return nil, nil
 	}
-	err = res.One(&data)
+	var member guildmodels.MemberData
+	err = res.One(&member)
 	if err != nil {
-		logrus.Warnf("Failed to retrieve document for twitch connection on member %v:%v due to error %v", guildID, userID, err)
+		logrus.Warnf("Failed to retrieve document for member %v:%v due to error %v", guildID, userID, err)
+		return nil, err
+	}
+	return &member, nil
+}
+
+//GetStreamLink returns the channel a given member has linked from the named provider, or nil if they have not
+//linked one.
+func (db *Connection) GetStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error) {
+	member, err := db.GetMemberData(guildID, userID)
+	if err != nil || member == nil {
+		return nil, err
+	}
+	channelID, exists := member.Connections.StreamLinks[provider]
+	if !exists || channelID == "" {
 		return nil, nil
 	}
-	return &data, nil
+	return db.GetStreamChannel(provider, channelID)
 }
 
-//GetAllTwitchUIDs returns a list of all twitch braodcaster UIDs that have been registered by members
-func (db *Connection) GetAllTwitchUIDs() ([]string, error) {
-	query := rethink.Table(twitchTable).Field("tid")
+//GetAllStreamChannelIDs returns a list of the IDs of every channel from the named provider that has been registered by
+//members or followed by guilds
+func (db *Connection) GetAllStreamChannelIDs(provider string) ([]string, error) {
+	query := rethink.Table(streamsTable).Filter(map[string]interface{}{
+		"provider": provider,
+	}).Field("channel_id")
 	res, err := query.Run(db.session)
-	defer res.Close()
 	if err != nil {
-		logrus.Warnf("Failed to enumerate twitch channels due to error %v", err)
+		logrus.Warnf("Failed to enumerate %v channels due to error %v", provider, err)
 		return nil, err
 	}
+	defer res.Close()
 	var data []string
 	if res.IsNil() {
 		return nil, nil
 	}
 	err = res.All(&data)
 	if err != nil {
-		logrus.Warnf("Failed to enumerate twitch channels due to error %v", err)
+		logrus.Warnf("Failed to enumerate %v channels due to error %v", provider, err)
 		return nil, err
 	}
 	return data, nil
 }
 
-//GetMemberByConnection looks up members by connection. The MemberConnections struct should have exactly one non-nil connection.
-func (db *Connection) GetMemberByConnection(connection guildmodels.MemberConnections, guildID, userID *string) ([]guildmodels.MemberData, error) {
-	rethink.SetVerbose(true)
-	//Check that exactly 1 connection is set
-	if connection.NumConnections() != 1 {
-		return nil, fmt.Errorf("member lookup requires exactly 1 connection, %d were provided", connection.NumConnections())
-	}
-	//Filter by connections struct
+//GetMembersByStream looks up the members who have linked the given channel, optionally limited to a single guild.
+func (db *Connection) GetMembersByStream(provider, channelID string, guildID *string) ([]guildmodels.MemberData, error) {
 	query := rethink.Table(membersTable).Filter(map[string]interface{}{
-		"connections": connection,
+		"connections": map[string]interface{}{
+			"links": map[string]interface{}{
+				provider: channelID,
+			},
+		},
 	})
-	//Filter by userID
-	if userID != nil {
-		query = query.Filter(func(member rethink.Term) rethink.Term {
-			return member.Field("id").Nth(0).Eq(*userID)
-		})
-	}
-	//Filter by guildID
 	if guildID != nil {
 		query = query.Filter(func(member rethink.Term) rethink.Term {
-			return member.Field("id").Nth(1).Eq(*guildID)
+			return member.Field("id").Nth(0).Eq(*guildID)
 		})
 	}
-	//Join member data with twich data
-	query = query.Merge(func(p rethink.Term) interface{} {
-		return map[string]interface{}{
-			"connections": map[string]interface{}{
-				"twitch_link": rethink.Table(twitchTable).Get(p.Field("connections").Field("twitch_link")),
-			},
-		}
-	})
 	res, err := query.Run(db.session)
-	defer res.Close()
 	if err != nil {
-		logrus.Warnf("Failed to lookup members for connection %v due to error %v", connection, err)
+		logrus.Warnf("Failed to lookup members linked to %v channel %v due to error %v", provider, channelID, err)
 		return nil, err
 	}
+	defer res.Close()
 	var data []guildmodels.MemberData
 	if res.IsNil() {
 		return nil, nil
 	}
 	err = res.All(&data)
 	if err != nil {
-		logrus.Warnf("Failed to retrieve member documents for connection %v due to error %v", connection, err)
-		return nil, nil
+		logrus.Warnf("Failed to retrieve member documents linked to %v channel %v due to error %v", provider, channelID, err)
+		return nil, err
 	}
 	return data, nil
 }
 
-//SetTwitchConnectionData updates the stored twitch connection for a given member, returning the new TwitchStream value as well as
-//the previous value if it was set.
-func (db *Connection) SetTwitchConnectionData(guildID, userID, twitchUID string) (*guildmodels.TwitchStream, *guildmodels.TwitchStream, error) {
-	//Get Twitch stream struct
-	twitch, err := db.GetTwitchStream(twitchUID)
+//SetStreamLink updates the channel a given member has linked from the named provider, returning the new StreamChannel
+//as well as the previously linked one if it was set.
+func (db *Connection) SetStreamLink(guildID, userID, provider, channelID string) (*guildmodels.StreamChannel, *guildmodels.StreamChannel, error) {
+	stream, err := db.GetStreamChannel(provider, channelID)
 	if err != nil {
 		return nil, nil, err
 	}
-	//Document to be inserted (or updated)
-	doc := guildmodels.MemberData{
-		GuildID: guildID,
-		UserID:  userID,
-		Connections: guildmodels.MemberConnections{
-			TwitchConnection: twitch,
+	//Document to be inserted (or merged into the existing one)
+	doc := map[string]interface{}{
+		"id": []string{guildID, userID},
+		"connections": map[string]interface{}{
+			"links": map[string]interface{}{
+				provider: channelID,
+			},
 		},
 	}
-	logrus.Trace("Inserting memberdata struct %#v", doc)
+	logrus.Tracef("Inserting memberdata document %#v", doc)
 	query := rethink.Table(membersTable).Insert(doc, rethink.InsertOpts{
 		ReturnChanges: "always",
 		Conflict:      "update",
 	})
 	res, err := query.RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to get twitch connection data for member %v:%v due to error %v", guildID, userID, err)
+		logrus.Warnf("Failed to set %v link for member %v:%v due to error %v", provider, guildID, userID, err)
 		return nil, nil, err
 	}
-	logrus.Tracef("Got result %#v from twitch connection data update", res)
+	logrus.Tracef("Got result %#v from stream link update", res)
 	changes := res.Changes
-	//If we got changes back from the DB query
-	if len(changes) >= 1 {
-		oldVal := changes[0].OldValue
-		//If there was an old value (ie. if there was an existing link for that user)
-		if oldVal != nil {
-			var oldValStruct struct {
-				GuildID     string            `gorethink:"id[0]"`
-				UserID      string            `gorethink:"id[1]"`
-				Connections map[string]string `gorethink:"connections"`
-			}
-			encoding.Decode(&oldValStruct, oldVal)
-			oldStreamUID := oldValStruct.Connections["twitch_link"]
-			logrus.Debugf("Retrieved Twitch UID %v as old (replaced) stream.", oldStreamUID)
-			if oldStreamUID != "" {
-				oldStreamStruct, err := db.GetTwitchStream(oldStreamUID)
-				if err != nil {
-					logrus.Warnf("Failed to fetch TwitchStream struct for user %v's old stream with uid %v due to error %v", userID, oldStreamUID, err)
-					return nil, twitch, nil
-				}
-				return oldStreamStruct, twitch, nil
+	if len(changes) < 1 {
+		return nil, nil, nil
+	}
+	oldVal := changes[0].OldValue
+	//If there was an old value (ie. if the user already had some links)
+	if oldVal != nil {
+		var oldMember guildmodels.MemberData
+		encoding.Decode(&oldMember, oldVal)
+		oldChannelID := oldMember.Connections.StreamLinks[provider]
+		logrus.Debugf("Retrieved %v channel %v as old (replaced) stream.", provider, oldChannelID)
+		if oldChannelID != "" {
+			oldStream, err := db.GetStreamChannel(provider, oldChannelID)
+			if err != nil {
+				logrus.Warnf("Failed to fetch StreamChannel struct for user %v's old %v channel %v due to error %v", userID, provider, oldChannelID, err)
+				return nil, stream, nil
 			}
+			return oldStream, stream, nil
 		}
-		//If there was no old value, just return the new one
-		return nil, twitch, err
 	}
-	return nil, nil, nil
+	return nil, stream, nil
+}
+
+//SetMemberHideSchedule sets whether a member's stream schedule should be left out of their guild's scheduled events
+func (db *Connection) SetMemberHideSchedule(guildID, userID string, hide bool) error {
+	doc := map[string]interface{}{
+		"id":            []string{guildID, userID},
+		"hide_schedule": hide,
+	}
+	_, err := rethink.Table(membersTable).Insert(doc, rethink.InsertOpts{
+		Conflict: "update",
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to update schedule setting for member %v:%v due to error %v", guildID, userID, err)
+		return err
+	}
+	return nil
 }
 
-//GetTwitchStream returns a TwitchStream struct for the stream with the provided uid. If it does not exist, a new one will be created and returned.
-func (db *Connection) GetTwitchStream(uid string) (*guildmodels.TwitchStream, error) {
+//RemoveStreamLink removes the link to the named provider for a given member, returning the StreamChannel they were
+//previously linked to. If the member had no link to that provider, nil will be returned.
+func (db *Connection) RemoveStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error) {
+	oldStream, err := db.GetStreamLink(guildID, userID, provider)
+	if err != nil {
+		return nil, err
+	} else if oldStream == nil {
+		return nil, nil
+	}
+	id := []string{guildID, userID}
+	_, err = rethink.Table(membersTable).Get(id).Replace(func(member rethink.Term) interface{} {
+		return member.Without(map[string]interface{}{
+			"connections": map[string]interface{}{
+				"links": map[string]interface{}{
+					provider: true,
+				},
+			},
+		})
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to remove %v link for member %v:%v due to error %v", provider, guildID, userID, err)
+		return nil, err
+	}
+	return oldStream, nil
+}
+
+//GetGuildStreamLinks returns the member data for every member of a guild who has linked at least one stream
+func (db *Connection) GetGuildStreamLinks(guildID string) ([]guildmodels.MemberData, error) {
+	query := rethink.Table(membersTable).Filter(func(member rethink.Term) rethink.Term {
+		return member.Field("id").Nth(0).Eq(guildID).And(
+			member.Field("connections").Field("links").Default(map[string]interface{}{}).Keys().IsEmpty().Not(),
+		)
+	})
+	res, err := query.Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up stream links for guild %v due to error %v", guildID, err)
+		return nil, err
+	}
+	defer res.Close()
+	var data []guildmodels.MemberData
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&data)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve member documents for stream links in guild %v due to error %v", guildID, err)
+		return nil, err
+	}
+	return data, nil
+}
+
+//SetStreamChannelLogin records the login name of a channel so that it can be displayed without querying its provider
+func (db *Connection) SetStreamChannelLogin(provider, channelID, login string) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(streamsTable).Get(key).Update(map[string]interface{}{
+		"login": login,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to set login name for stream %v due to error %v", key, err)
+		return err
+	}
+	return nil
+}
+
+//GetStreamChannel returns a StreamChannel struct for the channel with the provided ID on the named provider. If it
+//does not exist, a new one will be created and returned.
+func (db *Connection) GetStreamChannel(provider, channelID string) (*guildmodels.StreamChannel, error) {
 	//Document to be inserted (or updated)
-	doc := guildmodels.TwitchStream{
-		TwitchUID: uid,
+	doc := guildmodels.StreamChannel{
+		Key:       guildmodels.StreamKey(provider, channelID),
+		Provider:  provider,
+		ChannelID: channelID,
 	}
-	logrus.Trace("Inserting twitch struct %#v", doc)
-	query := rethink.Table(twitchTable).Insert(doc, rethink.InsertOpts{
+	logrus.Tracef("Inserting stream struct %#v", doc)
+	query := rethink.Table(streamsTable).Insert(doc, rethink.InsertOpts{
 		ReturnChanges: "always",
 		Conflict: func(id, oldDoc, newDoc rethink.Term) interface{} {
 			return oldDoc
@@ -181,103 +251,161 @@ func (db *Connection) GetTwitchStream(uid string) (*guildmodels.TwitchStream, er
 	})
 	res, err := query.RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to get stream struct for twitch uid %v due to error %v", uid, err)
+		logrus.Warnf("Failed to get stream struct for %v due to error %v", doc.Key, err)
 		return nil, err
 	}
-	logrus.Tracef("Got result %#v from twitch connection data update", res)
+	logrus.Tracef("Got result %#v from stream lookup", res)
 	changes := res.Changes
 	if len(changes) >= 1 {
 		stream := changes[0].NewValue
 		if stream != nil {
-			var streamData guildmodels.TwitchStream
+			var streamData guildmodels.StreamChannel
 			encoding.Decode(&streamData, stream)
 			return &streamData, nil
 		}
-		return nil, fmt.Errorf("got nil value when looking up twitch stream in database")
+		return nil, fmt.Errorf("got nil value when looking up stream in database")
+	}
+	return nil, fmt.Errorf("stream insertion did not return any changes")
+}
+
+//GetStreamChannels returns the stored StreamChannel for each of the provided stream keys which exists, keyed by
+//stream key
+func (db *Connection) GetStreamChannels(keys []string) (map[string]*guildmodels.StreamChannel, error) {
+	res := make(map[string]*guildmodels.StreamChannel, len(keys))
+	if len(keys) == 0 {
+		return res, nil
+	}
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	cursor, err := rethink.Table(streamsTable).GetAll(args...).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up streams %v due to error %v", keys, err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var streams []guildmodels.StreamChannel
+	err = cursor.All(&streams)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve stream documents for %v due to error %v", keys, err)
+		return nil, err
+	}
+	for i := range streams {
+		res[streams[i].Key] = &streams[i]
 	}
-	return nil, fmt.Errorf("twitch stream insertion did not return any changes")
+	return res, nil
 }
 
-//DeleteTwitchStream removes a twitch stream from the database
-func (db *Connection) DeleteTwitchStream(uid string) error {
+//DeleteStreamChannel removes a channel from the database, along with any member links to it
+func (db *Connection) DeleteStreamChannel(provider, channelID string) error {
+	key := guildmodels.StreamKey(provider, channelID)
 	//Remove any links to this stream
 	_, err := rethink.Table(membersTable).Filter(map[string]interface{}{
 		"connections": map[string]interface{}{
-			"twitch_link": uid,
-		},
-	}).Update(map[string]interface{}{
-		"connections": map[string]interface{}{
-			"twitch_link": rethink.Literal(nil),
+			"links": map[string]interface{}{
+				provider: channelID,
+			},
 		},
+	}).Replace(func(member rethink.Term) interface{} {
+		return member.Without(map[string]interface{}{
+			"connections": map[string]interface{}{
+				"links": map[string]interface{}{
+					provider: true,
+				},
+			},
+		})
 	}).RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to delete any remaining member links before removing twitch stream with UID %v due to error %v", uid, err)
+		logrus.Warnf("Failed to delete any remaining member links before removing stream %v due to error %v", key, err)
 		return err
 	}
 	//Delete stream
-	_, err = rethink.Table(twitchTable).Get(uid).Delete().RunWrite(db.session)
+	_, err = rethink.Table(streamsTable).Get(key).Delete().RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to delete twitch stream with UID %v due to error %v", uid, err)
+		logrus.Warnf("Failed to delete stream %v due to error %v", key, err)
 		return err
 	}
 	return nil
 }
 
-//AddDiscordStatusPost inserts a message reference into the status posts array for the provided twitch UID in the database
-func (db *Connection) AddDiscordStatusPost(tid string, post *guildmodels.MessageRef) error {
-	_, err := rethink.Table(twitchTable).Get(tid).Update(func(t rethink.Term) interface{} {
+//AddDiscordStatusPost inserts a message reference into the status posts array for the provided channel in the database
+func (db *Connection) AddDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(streamsTable).Get(key).Update(func(t rethink.Term) interface{} {
 		return t.Merge(map[string]interface{}{
 			"posts": t.Field("posts").Default([]interface{}{}).SetInsert(post),
 		})
 	}).RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to insert discord status post %v into DB for twitch uid %v due to error %v", post, tid, err)
+		logrus.Warnf("Failed to insert discord status post %v into DB for stream %v due to error %v", post, key, err)
 		return err
 	}
 	return nil
 }
 
-//RemoveDiscordStatusPost removes the given message reference from the status posts array for the provided twitch UID in the database
-func (db *Connection) RemoveDiscordStatusPost(uid string, post *guildmodels.MessageRef) error {
-	_, err := rethink.Table(twitchTable).Get(uid).Update(func(t rethink.Term) interface{} {
+//RemoveDiscordStatusPost removes the given message reference from the status posts array for the provided channel in
+//the database
+func (db *Connection) RemoveDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(streamsTable).Get(key).Update(func(t rethink.Term) interface{} {
 		return t.Merge(map[string]interface{}{
-			"posts": t.Field("posts").SetDifference(post),
+			"posts": t.Field("posts").Default([]interface{}{}).SetDifference([]interface{}{post}),
 		})
 	}).RunWrite(db.session)
 	if err != nil {
-		logrus.Warn("Failed to remove discord status post %v from DB for twitch uid %v due to error %v", post, uid, err)
+		logrus.Warnf("Failed to remove discord status post %v from DB for stream %v due to error %v", post, key, err)
 		return err
 	}
 	return nil
 }
 
-//ClearDiscordStatusPosts removes all message references from the status posts array for the provided twitch UID in the database
-func (db *Connection) ClearDiscordStatusPosts(uid string) error {
-	_, err := rethink.Table(twitchTable).Get(uid).Update(map[string]interface{}{
+//ClearDiscordStatusPosts removes all message references from the status posts array for the provided channel in the
+//database
+func (db *Connection) ClearDiscordStatusPosts(provider, channelID string) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(streamsTable).Get(key).Update(map[string]interface{}{
 		"posts": []guildmodels.MessageRef{},
 	}).RunWrite(db.session)
 	if err != nil {
-		logrus.Warn("Failed to remove discord status posts from DB for twitch uid %v due to error %v", uid, err)
+		logrus.Warnf("Failed to remove discord status posts from DB for stream %v due to error %v", key, err)
 		return err
 	}
 	return nil
 }
 
-//SetTwitchStreamLive updates the database to reflect whether the provided twitch stream is live or not.
-func (db *Connection) SetTwitchStreamLive(uid string, isLive bool) error {
-	stream := guildmodels.TwitchStream{
-		TwitchUID: uid,
-		IsLive:    isLive,
+//SetStreamChannelLive updates the database to reflect whether the provided channel is live or not.
+func (db *Connection) SetStreamChannelLive(provider, channelID string, isLive bool) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(streamsTable).Get(key).Update(map[string]interface{}{
+		"is_live": isLive,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to update live state of stream %v in database due to error %v", key, err)
+		return err
 	}
-
-	return db.updateTwitchStream(&stream)
+	return nil
 }
 
-func (db *Connection) updateTwitchStream(stream *guildmodels.TwitchStream) error {
-	_, err := rethink.Table(twitchTable).Get(stream.TwitchUID).Update(stream).RunWrite(db.session)
+//SetStreamChannelFollowers records the latest follower count of a stream, returning the previously recorded count
+func (db *Connection) SetStreamChannelFollowers(provider, channelID string, followers int) (int, error) {
+	key := guildmodels.StreamKey(provider, channelID)
+	res, err := rethink.Table(streamsTable).Get(key).Update(map[string]interface{}{
+		"followers": followers,
+	}, rethink.UpdateOpts{
+		ReturnChanges: "always",
+	}).RunWrite(db.session)
 	if err != nil {
-		logrus.Warnf("Failed to update twitch stream %v in database due to error %v", stream, err)
-		return err
+		logrus.Warnf("Failed to update follower count of stream %v in database due to error %v", key, err)
+		return 0, err
 	}
-	return nil
+	if len(res.Changes) < 1 || res.Changes[0].OldValue == nil {
+		return 0, nil
+	}
+	var old guildmodels.StreamChannel
+	err = encoding.Decode(&old, res.Changes[0].OldValue)
+	if err != nil {
+		return 0, err
+	}
+	return old.Followers, nil
 }
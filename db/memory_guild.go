@@ -0,0 +1,366 @@
+package db
+
+import (
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+)
+
+//updateGuild applies an update to a stored guild, creating the guild first if create is set. The update returns
+//whether it changed anything, and the number of guilds changed is returned in the same way as a RethinkDB update.
+func (m *MemoryStore) updateGuild(gid string, create bool, update func(g *guildmodels.DiscordGuild) bool) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	g := m.guilds[gid]
+	if g == nil {
+		if !create {
+			return 0
+		}
+		newGuild := guildmodels.DefaultGuild(gid)
+		g = &newGuild
+		m.guilds[gid] = g
+	}
+	if update(g) {
+		return 1
+	}
+	return 0
+}
+
+//findGuilds returns a copy of every stored guild matching a filter
+func (m *MemoryStore) findGuilds(filter func(g *guildmodels.DiscordGuild) bool) []guildmodels.DiscordGuild {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []guildmodels.DiscordGuild
+	for _, g := range m.guilds {
+		if filter(g) {
+			var guildCopy guildmodels.DiscordGuild
+			copyDoc(&guildCopy, g)
+			res = append(res, guildCopy)
+		}
+	}
+	return res
+}
+
+//GetOrCreateGuild fetches a guild with a given ID from the store, creating a new one if it does not exist.
+func (m *MemoryStore) GetOrCreateGuild(id string) (*guildmodels.DiscordGuild, error) {
+	var res guildmodels.DiscordGuild
+	m.updateGuild(id, true, func(g *guildmodels.DiscordGuild) bool {
+		copyDoc(&res, g)
+		return false
+	})
+	return &res, nil
+}
+
+//CreateGuild creates a guild if it doesn't exist yet, returning true if one was created
+func (m *MemoryStore) CreateGuild(gid string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.guilds[gid] != nil {
+		return false, nil
+	}
+	g := guildmodels.DefaultGuild(gid)
+	m.guilds[gid] = &g
+	return true, nil
+}
+
+//AddAdminRole adds a roleID to the list of AdminRoles for the given guild. It returns the number of updated
+//entries as well as any errors
+func (m *MemoryStore) AddAdminRole(gid string, roleID string) (int, error) {
+	return m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		return setInsert(&g.AdminRoles, roleID)
+	}), nil
+}
+
+//RemoveAdminRole removes a roleID from the list of AdminRoles for the given guild. It returns the number of updated
+//entries as well as any errors
+func (m *MemoryStore) RemoveAdminRole(gid string, roleID string) (int, error) {
+	return m.updateGuild(gid, false, func(g *guildmodels.DiscordGuild) bool {
+		return setRemove(&g.AdminRoles, roleID)
+	}), nil
+}
+
+//SetGuildAlertRoutes replaces the stream alert routes for a given guild. This also removes the legacy single stream
+//notification channel, so routes should include it if it is still wanted.
+func (m *MemoryStore) SetGuildAlertRoutes(gid string, routes []guildmodels.StreamAlertRoute) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.NotificationChannels = &guildmodels.NotificationChannels{}
+		copyDoc(&g.NotificationChannels.StreamAlertRoutes, routes)
+		return true
+	})
+	return nil
+}
+
+//UpdateGuildAlertTemplate replaces the stream alert template for a given guild. Passing a nil template removes it, so
+//the default alert text will be used.
+func (m *MemoryStore) UpdateGuildAlertTemplate(gid string, tmpl *guildmodels.AlertTemplate) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.StreamAlertTemplate = nil
+		if tmpl != nil {
+			copyDoc(&g.StreamAlertTemplate, tmpl)
+		}
+		return true
+	})
+	return nil
+}
+
+//AddGuildFollowedStream adds a stream key (see guildmodels.StreamKey) to the list of streams followed by the given
+//guild. It returns the number of updated entries as well as any errors
+func (m *MemoryStore) AddGuildFollowedStream(gid, streamKey string) (int, error) {
+	return m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		return setInsert(&g.FollowedStreams, streamKey)
+	}), nil
+}
+
+//RemoveGuildFollowedStream removes a stream key from the list of streams followed by the given guild. It returns the
+//number of updated entries as well as any errors
+func (m *MemoryStore) RemoveGuildFollowedStream(gid, streamKey string) (int, error) {
+	return m.updateGuild(gid, false, func(g *guildmodels.DiscordGuild) bool {
+		return setRemove(&g.FollowedStreams, streamKey)
+	}), nil
+}
+
+//GetGuildsFollowingStream returns every guild which follows the stream with the provided key, either directly or as
+//part of a team
+func (m *MemoryStore) GetGuildsFollowingStream(streamKey string) ([]guildmodels.DiscordGuild, error) {
+	return m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return g.FollowsStream(streamKey)
+	}), nil
+}
+
+//SetGuildFollowedTeam adds a twitch team to the list of teams followed by the given guild, replacing the existing
+//entry for the team if there is one
+func (m *MemoryStore) SetGuildFollowedTeam(gid string, team *guildmodels.FollowedTeam) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		removeTeam(g, team.Name)
+		var teamCopy guildmodels.FollowedTeam
+		copyDoc(&teamCopy, team)
+		g.FollowedTeams = append(g.FollowedTeams, teamCopy)
+		return true
+	})
+	return nil
+}
+
+//RemoveGuildFollowedTeam removes a twitch team from the list of teams followed by the given guild. It returns the
+//number of updated entries as well as any errors
+func (m *MemoryStore) RemoveGuildFollowedTeam(gid, teamName string) (int, error) {
+	return m.updateGuild(gid, false, func(g *guildmodels.DiscordGuild) bool {
+		return removeTeam(g, teamName)
+	}), nil
+}
+
+func removeTeam(g *guildmodels.DiscordGuild, teamName string) bool {
+	kept := g.FollowedTeams[:0:0]
+	for _, team := range g.FollowedTeams {
+		if team.Name != teamName {
+			kept = append(kept, team)
+		}
+	}
+	removed := len(kept) != len(g.FollowedTeams)
+	g.FollowedTeams = kept
+	return removed
+}
+
+//GetGuildsFollowingTeams returns every active guild which follows at least one twitch team
+func (m *MemoryStore) GetGuildsFollowingTeams() ([]guildmodels.DiscordGuild, error) {
+	return m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return len(g.FollowedTeams) > 0 && g.Active()
+	}), nil
+}
+
+//AddGuildFollowedCategory adds a twitch category to the list of categories followed by the given guild. It returns
+//the number of updated entries as well as any errors
+func (m *MemoryStore) AddGuildFollowedCategory(gid string, category *guildmodels.FollowedCategory) (int, error) {
+	return m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		for _, followed := range g.FollowedCategories {
+			if followed == *category {
+				return false
+			}
+		}
+		g.FollowedCategories = append(g.FollowedCategories, *category)
+		return true
+	}), nil
+}
+
+//RemoveGuildFollowedCategory removes the twitch category with the provided ID from the list of categories followed
+//by the given guild. It returns the number of updated entries as well as any errors
+func (m *MemoryStore) RemoveGuildFollowedCategory(gid, categoryID string) (int, error) {
+	return m.updateGuild(gid, false, func(g *guildmodels.DiscordGuild) bool {
+		kept := g.FollowedCategories[:0:0]
+		for _, category := range g.FollowedCategories {
+			if category.ID != categoryID {
+				kept = append(kept, category)
+			}
+		}
+		removed := len(kept) != len(g.FollowedCategories)
+		g.FollowedCategories = kept
+		return removed
+	}), nil
+}
+
+//SetGuildVerifiedLinks sets whether members of a guild must prove they own a channel before linking it
+func (m *MemoryStore) SetGuildVerifiedLinks(gid string, enabled bool) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.VerifiedLinks = enabled
+		return true
+	})
+	return nil
+}
+
+//SetGuildPresenceStreaming sets whether discord streaming activity should trigger stream alerts in a guild
+func (m *MemoryStore) SetGuildPresenceStreaming(gid string, enabled bool) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.PresenceStreaming = enabled
+		return true
+	})
+	return nil
+}
+
+//SetGuildScheduledEvents sets whether linked members' stream schedules should be synced to a guild's scheduled events
+func (m *MemoryStore) SetGuildScheduledEvents(gid string, enabled bool) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.ScheduledEvents = enabled
+		return true
+	})
+	return nil
+}
+
+//GetGuildsWithScheduledEvents returns every active guild which has stream schedule syncing turned on
+func (m *MemoryStore) GetGuildsWithScheduledEvents() ([]guildmodels.DiscordGuild, error) {
+	return m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return g.ScheduledEvents && g.Active()
+	}), nil
+}
+
+//SetGuildAnnouncement sets how a type of twitch community event should be announced in a guild. Passing a nil
+//announcement stops the event type from being announced.
+func (m *MemoryStore) SetGuildAnnouncement(gid, eventType string, announcement *guildmodels.Announcement) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		if announcement == nil {
+			delete(g.Announcements, eventType)
+			return true
+		}
+		if g.Announcements == nil {
+			g.Announcements = make(map[string]*guildmodels.Announcement)
+		}
+		var stored guildmodels.Announcement
+		copyDoc(&stored, announcement)
+		g.Announcements[eventType] = &stored
+		return true
+	})
+	return nil
+}
+
+//SetGuildClipsFeed sets where and when clips are posted in a guild. Passing a nil feed turns the clips feed off.
+func (m *MemoryStore) SetGuildClipsFeed(gid string, feed *guildmodels.ClipsFeed) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.ClipsFeed = nil
+		if feed != nil {
+			copyDoc(&g.ClipsFeed, feed)
+		}
+		return true
+	})
+	return nil
+}
+
+//GetGuildsWithClipsFeed returns every active guild which has a clips feed set up
+func (m *MemoryStore) GetGuildsWithClipsFeed() ([]guildmodels.DiscordGuild, error) {
+	return m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return g.ClipsFeed != nil && g.Active()
+	}), nil
+}
+
+//SetGuildLeftAt marks a guild as having been left by the bot at the given time. Passing nil marks the guild as active
+//again.
+func (m *MemoryStore) SetGuildLeftAt(gid string, leftAt *time.Time) error {
+	m.updateGuild(gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.LeftAt = nil
+		if leftAt != nil {
+			t := *leftAt
+			g.LeftAt = &t
+		}
+		return true
+	})
+	return nil
+}
+
+//GetActiveGuildIDs returns the IDs of every guild which the bot hasn't been removed from
+func (m *MemoryStore) GetActiveGuildIDs() ([]string, error) {
+	guilds := m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return g.Active()
+	})
+	ids := make([]string, 0, len(guilds))
+	for _, g := range guilds {
+		ids = append(ids, g.DiscordGID)
+	}
+	return ids, nil
+}
+
+//GetInactiveGuildIDs returns the set of guilds which the bot has been removed from but which haven't been purged yet
+func (m *MemoryStore) GetInactiveGuildIDs() (map[string]bool, error) {
+	guilds := m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return !g.Active()
+	})
+	ids := make(map[string]bool, len(guilds))
+	for _, g := range guilds {
+		ids[g.DiscordGID] = true
+	}
+	return ids, nil
+}
+
+//GetGuildsLeftBefore returns every guild which the bot was removed from before the given time
+func (m *MemoryStore) GetGuildsLeftBefore(before time.Time) ([]guildmodels.DiscordGuild, error) {
+	return m.findGuilds(func(g *guildmodels.DiscordGuild) bool {
+		return !g.Active() && g.LeftAt.Before(before)
+	}), nil
+}
+
+//PurgeGuild deletes all data stored for a guild, including its members, managed roles, scheduled events and posted
+//clips. Streams are left alone, as they may still be used elsewhere.
+func (m *MemoryStore) PurgeGuild(gid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key := range m.members {
+		if key[0] == gid {
+			delete(m.members, key)
+		}
+	}
+	m.roleRules = filterRoleRules(m.roleRules, func(rule *guildmodels.ManagedRoleRule) bool {
+		return rule.GuildID != gid
+	})
+	for key := range m.scheduledEvents {
+		if key[0] == gid {
+			delete(m.scheduledEvents, key)
+		}
+	}
+	for key := range m.postedClips {
+		if key[0] == gid {
+			delete(m.postedClips, key)
+		}
+	}
+	delete(m.guilds, gid)
+	return nil
+}
+
+//setInsert adds a value to a slice if it isn't already in it, returning true if it was added
+func setInsert(set *[]string, val string) bool {
+	for _, existing := range *set {
+		if existing == val {
+			return false
+		}
+	}
+	*set = append(*set, val)
+	return true
+}
+
+//setRemove removes every occurrence of a value from a slice, returning true if any were removed
+func setRemove(set *[]string, val string) bool {
+	kept := (*set)[:0:0]
+	for _, existing := range *set {
+		if existing != val {
+			kept = append(kept, existing)
+		}
+	}
+	removed := len(kept) != len(*set)
+	*set = kept
+	return removed
+}
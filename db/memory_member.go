@@ -0,0 +1,269 @@
+package db
+
+import (
+	"github.com/callummance/nia/guildmodels"
+)
+
+//copyMember returns a copy of a stored member, or nil if it doesn't exist
+func copyMember(member *guildmodels.MemberData) *guildmodels.MemberData {
+	if member == nil {
+		return nil
+	}
+	var res guildmodels.MemberData
+	copyDoc(&res, member)
+	return &res
+}
+
+//copyStream returns a copy of a stored stream, or nil if it doesn't exist
+func copyStream(stream *guildmodels.StreamChannel) *guildmodels.StreamChannel {
+	if stream == nil {
+		return nil
+	}
+	var res guildmodels.StreamChannel
+	copyDoc(&res, stream)
+	return &res
+}
+
+//findMembers returns a copy of every stored member matching a filter
+func (m *MemoryStore) findMembers(filter func(member *guildmodels.MemberData) bool) []guildmodels.MemberData {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []guildmodels.MemberData
+	for _, member := range m.members {
+		if filter(member) {
+			res = append(res, *copyMember(member))
+		}
+	}
+	return res
+}
+
+//member returns the stored data for a member, creating it if create is set. The lock must be held.
+func (m *MemoryStore) member(guildID, userID string, create bool) *guildmodels.MemberData {
+	key := compoundKey{guildID, userID}
+	member := m.members[key]
+	if member == nil && create {
+		member = &guildmodels.MemberData{
+			GuildID: guildID,
+			UserID:  userID,
+		}
+		m.members[key] = member
+	}
+	return member
+}
+
+//streamChannel returns the stored stream for a channel, creating it if it doesn't exist. The lock must be held.
+func (m *MemoryStore) streamChannel(provider, channelID string) *guildmodels.StreamChannel {
+	key := guildmodels.StreamKey(provider, channelID)
+	stream := m.streams[key]
+	if stream == nil {
+		stream = &guildmodels.StreamChannel{
+			Key:       key,
+			Provider:  provider,
+			ChannelID: channelID,
+		}
+		m.streams[key] = stream
+	}
+	return stream
+}
+
+//updateStream applies an update to a stored stream, doing nothing if it doesn't exist
+func (m *MemoryStore) updateStream(provider, channelID string, update func(stream *guildmodels.StreamChannel)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stream := m.streams[guildmodels.StreamKey(provider, channelID)]
+	if stream != nil {
+		update(stream)
+	}
+}
+
+//GetMemberData returns the stored data for a given member, or nil if nothing has been stored for them
+func (m *MemoryStore) GetMemberData(guildID, userID string) (*guildmodels.MemberData, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return copyMember(m.member(guildID, userID, false)), nil
+}
+
+//GetStreamLink returns the channel a given member has linked from the named provider, or nil if they have not
+//linked one.
+func (m *MemoryStore) GetStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return copyStream(m.streamLink(guildID, userID, provider)), nil
+}
+
+//streamLink returns the stream a member has linked from the named provider, creating the stream if it doesn't exist
+//yet. The lock must be held.
+func (m *MemoryStore) streamLink(guildID, userID, provider string) *guildmodels.StreamChannel {
+	member := m.member(guildID, userID, false)
+	if member == nil {
+		return nil
+	}
+	channelID := member.Connections.StreamLinks[provider]
+	if channelID == "" {
+		return nil
+	}
+	return m.streamChannel(provider, channelID)
+}
+
+//GetMembersByStream looks up the members who have linked the given channel, optionally limited to a single guild.
+func (m *MemoryStore) GetMembersByStream(provider, channelID string, guildID *string) ([]guildmodels.MemberData, error) {
+	return m.findMembers(func(member *guildmodels.MemberData) bool {
+		if guildID != nil && member.GuildID != *guildID {
+			return false
+		}
+		return member.Connections.StreamLinks[provider] == channelID
+	}), nil
+}
+
+//SetStreamLink updates the channel a given member has linked from the named provider, returning the new StreamChannel
+//as well as the previously linked one if it was set.
+func (m *MemoryStore) SetStreamLink(guildID, userID, provider, channelID string) (*guildmodels.StreamChannel, *guildmodels.StreamChannel, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stream := copyStream(m.streamChannel(provider, channelID))
+	oldStream := copyStream(m.streamLink(guildID, userID, provider))
+	member := m.member(guildID, userID, true)
+	if member.Connections.StreamLinks == nil {
+		member.Connections.StreamLinks = make(map[string]string)
+	}
+	member.Connections.StreamLinks[provider] = channelID
+	return oldStream, stream, nil
+}
+
+//SetMemberHideSchedule sets whether a member's stream schedule should be left out of their guild's scheduled events
+func (m *MemoryStore) SetMemberHideSchedule(guildID, userID string, hide bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.member(guildID, userID, true).HideSchedule = hide
+	return nil
+}
+
+//RemoveStreamLink removes the link to the named provider for a given member, returning the StreamChannel they were
+//previously linked to. If the member had no link to that provider, nil will be returned.
+func (m *MemoryStore) RemoveStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	oldStream := copyStream(m.streamLink(guildID, userID, provider))
+	if oldStream == nil {
+		return nil, nil
+	}
+	delete(m.member(guildID, userID, false).Connections.StreamLinks, provider)
+	return oldStream, nil
+}
+
+//GetGuildStreamLinks returns the member data for every member of a guild who has linked at least one stream
+func (m *MemoryStore) GetGuildStreamLinks(guildID string) ([]guildmodels.MemberData, error) {
+	return m.findMembers(func(member *guildmodels.MemberData) bool {
+		return member.GuildID == guildID && len(member.Connections.StreamLinks) > 0
+	}), nil
+}
+
+//GetAllStreamChannelIDs returns a list of the IDs of every channel from the named provider that has been registered by
+//members or followed by guilds
+func (m *MemoryStore) GetAllStreamChannelIDs(provider string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []string
+	for _, stream := range m.streams {
+		if stream.Provider == provider {
+			res = append(res, stream.ChannelID)
+		}
+	}
+	return res, nil
+}
+
+//GetStreamChannel returns a StreamChannel struct for the channel with the provided ID on the named provider. If it
+//does not exist, a new one will be created and returned.
+func (m *MemoryStore) GetStreamChannel(provider, channelID string) (*guildmodels.StreamChannel, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return copyStream(m.streamChannel(provider, channelID)), nil
+}
+
+//GetStreamChannels returns the stored StreamChannel for each of the provided stream keys which exists, keyed by
+//stream key
+func (m *MemoryStore) GetStreamChannels(keys []string) (map[string]*guildmodels.StreamChannel, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := make(map[string]*guildmodels.StreamChannel, len(keys))
+	for _, key := range keys {
+		if stream := m.streams[key]; stream != nil {
+			res[key] = copyStream(stream)
+		}
+	}
+	return res, nil
+}
+
+//SetStreamChannelLogin records the login name of a channel so that it can be displayed without querying its provider
+func (m *MemoryStore) SetStreamChannelLogin(provider, channelID, login string) error {
+	m.updateStream(provider, channelID, func(stream *guildmodels.StreamChannel) {
+		stream.Login = login
+	})
+	return nil
+}
+
+//DeleteStreamChannel removes a channel from the store, along with any member links to it
+func (m *MemoryStore) DeleteStreamChannel(provider, channelID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, member := range m.members {
+		if member.Connections.StreamLinks[provider] == channelID {
+			delete(member.Connections.StreamLinks, provider)
+		}
+	}
+	delete(m.streams, guildmodels.StreamKey(provider, channelID))
+	return nil
+}
+
+//AddDiscordStatusPost inserts a message reference into the status posts array for the provided channel
+func (m *MemoryStore) AddDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error {
+	m.updateStream(provider, channelID, func(stream *guildmodels.StreamChannel) {
+		for _, existing := range stream.DiscordStatusPosts {
+			if existing == *post {
+				return
+			}
+		}
+		stream.DiscordStatusPosts = append(stream.DiscordStatusPosts, *post)
+	})
+	return nil
+}
+
+//RemoveDiscordStatusPost removes the given message reference from the status posts array for the provided channel
+func (m *MemoryStore) RemoveDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error {
+	m.updateStream(provider, channelID, func(stream *guildmodels.StreamChannel) {
+		kept := stream.DiscordStatusPosts[:0:0]
+		for _, existing := range stream.DiscordStatusPosts {
+			if existing != *post {
+				kept = append(kept, existing)
+			}
+		}
+		stream.DiscordStatusPosts = kept
+	})
+	return nil
+}
+
+//ClearDiscordStatusPosts removes all message references from the status posts array for the provided channel
+func (m *MemoryStore) ClearDiscordStatusPosts(provider, channelID string) error {
+	m.updateStream(provider, channelID, func(stream *guildmodels.StreamChannel) {
+		stream.DiscordStatusPosts = nil
+	})
+	return nil
+}
+
+//SetStreamChannelLive updates the store to reflect whether the provided channel is live or not.
+func (m *MemoryStore) SetStreamChannelLive(provider, channelID string, isLive bool) error {
+	m.updateStream(provider, channelID, func(stream *guildmodels.StreamChannel) {
+		stream.IsLive = isLive
+	})
+	return nil
+}
+
+//SetStreamChannelFollowers records the latest follower count of a stream, returning the previously recorded count
+func (m *MemoryStore) SetStreamChannelFollowers(provider, channelID string, followers int) (int, error) {
+	var old int
+	m.updateStream(provider, channelID, func(stream *guildmodels.StreamChannel) {
+		old = stream.Followers
+		stream.Followers = followers
+	})
+	return old, nil
+}
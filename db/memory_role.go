@@ -0,0 +1,93 @@
+package db
+
+import (
+	"github.com/callummance/nia/guildmodels"
+)
+
+//filterRoleRules returns the rules for which keep returns true
+func filterRoleRules(rules []*guildmodels.ManagedRoleRule, keep func(rule *guildmodels.ManagedRoleRule) bool) []*guildmodels.ManagedRoleRule {
+	var res []*guildmodels.ManagedRoleRule
+	for _, rule := range rules {
+		if keep(rule) {
+			res = append(res, rule)
+		}
+	}
+	return res
+}
+
+//findRoleRules returns a copy of every stored rule in a guild which matches a filter
+func (m *MemoryStore) findRoleRules(guildID string, filter func(rule *guildmodels.ManagedRoleRule) bool) []guildmodels.ManagedRoleRule {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []guildmodels.ManagedRoleRule
+	for _, rule := range m.roleRules {
+		if rule.GuildID == guildID && filter(rule) {
+			var ruleCopy guildmodels.ManagedRoleRule
+			copyDoc(&ruleCopy, rule)
+			res = append(res, ruleCopy)
+		}
+	}
+	return res
+}
+
+//AddManagedRoleRule stores a new managed role rule
+func (m *MemoryStore) AddManagedRoleRule(rule guildmodels.ManagedRoleRule) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var stored guildmodels.ManagedRoleRule
+	copyDoc(&stored, &rule)
+	m.roleRules = append(m.roleRules, &stored)
+	return nil
+}
+
+//LookupNowLiveRoles returns a list of all roles in the given server which should be assigned when a member is online on a streaming
+//platform.
+func (m *MemoryStore) LookupNowLiveRoles(guildID string) ([]guildmodels.ManagedRoleRule, error) {
+	return m.findRoleRules(guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		return rule.RoleAssignment.AssignmentType == "nowlive"
+	}), nil
+}
+
+//LookupRolesByEmote takes a message ID as well as its channel and guild, along with an emoji ID.
+//It then returns any managed role rules that include that reaction.
+func (m *MemoryStore) LookupRolesByEmote(msgID string, chanID string, guildID string, emojiID string) ([]guildmodels.ManagedRoleRule, error) {
+	return m.findRoleRules(guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		opts := rule.RoleAssignment.ReactionRoleData
+		return rule.RoleAssignment.AssignmentType == "reaction" && opts != nil &&
+			opts.MsgID == msgID && opts.ChanID == chanID && opts.EmojiID == emojiID
+	}), nil
+}
+
+//GetGuildRolesWithInitialReact takes a guild ID and returns a slice of all role assignment rules for that server
+//that both use reactions for role assignment and for which the bost should make an initial reaction.
+func (m *MemoryStore) GetGuildRolesWithInitialReact(guildID string) ([]guildmodels.ManagedRoleRule, error) {
+	return m.findRoleRules(guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		opts := rule.RoleAssignment.ReactionRoleData
+		return rule.RoleAssignment.AssignmentType == "reaction" && opts != nil && opts.BotShouldReact
+	}), nil
+}
+
+//GetRoleRules returns all role assignment rules for a given role in a given server
+func (m *MemoryStore) GetRoleRules(guildID string, roleID string) ([]guildmodels.ManagedRoleRule, error) {
+	return m.findRoleRules(guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		return rule.RoleID == roleID
+	}), nil
+}
+
+//DeleteRoleRules removes every role assignment rule for a given role in a given server, returning the number of rules
+//deleted
+func (m *MemoryStore) DeleteRoleRules(guildID string, roleID string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	before := len(m.roleRules)
+	m.roleRules = filterRoleRules(m.roleRules, func(rule *guildmodels.ManagedRoleRule) bool {
+		return rule.GuildID != guildID || rule.RoleID != roleID
+	})
+	return before - len(m.roleRules), nil
+}
+
+//IsManagedRole returns true iff we have any rules stored for the given roleID in the given guildID
+func (m *MemoryStore) IsManagedRole(guildID string, roleID string) (bool, error) {
+	rules, err := m.GetRoleRules(guildID, roleID)
+	return len(rules) > 0, err
+}
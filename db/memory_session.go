@@ -0,0 +1,151 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+)
+
+//findSessions returns a copy of every stored session matching a filter
+func (m *MemoryStore) findSessions(filter func(session *guildmodels.StreamSession) bool) []guildmodels.StreamSession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []guildmodels.StreamSession
+	for _, session := range m.sessions {
+		if filter(session) {
+			var sessionCopy guildmodels.StreamSession
+			copyDoc(&sessionCopy, session)
+			res = append(res, sessionCopy)
+		}
+	}
+	return res
+}
+
+//addSessionSample adds a sample to every open session of a stream, returning false if it has no open sessions. The
+//lock must be held.
+func (m *MemoryStore) addSessionSample(streamKey, title string, sample *guildmodels.ViewerSample) bool {
+	added := false
+	for _, session := range m.sessions {
+		if session.StreamKey != streamKey || session.EndedAt != nil {
+			continue
+		}
+		session.Samples = append(session.Samples, *sample)
+		if title != "" {
+			setInsert(&session.Titles, title)
+		}
+		if sample.Game != "" {
+			setInsert(&session.Games, sample.Game)
+		}
+		added = true
+	}
+	return added
+}
+
+//StartStreamSession records that a stream has gone live, along with its first viewer sample. If the stream already
+//has a session which hasn't ended (eg. because of a repeated online event), the sample is added to it instead.
+func (m *MemoryStore) StartStreamSession(provider, channelID string, startedAt time.Time, title string, sample *guildmodels.ViewerSample) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := guildmodels.StreamKey(provider, channelID)
+	if m.addSessionSample(key, title, sample) {
+		return nil
+	}
+	session := &guildmodels.StreamSession{
+		ID:        m.newSessionID(),
+		StreamKey: key,
+		Provider:  provider,
+		ChannelID: channelID,
+		StartedAt: startedAt,
+		Samples:   []guildmodels.ViewerSample{*sample},
+	}
+	if title != "" {
+		session.Titles = []string{title}
+	}
+	if sample.Game != "" {
+		session.Games = []string{sample.Game}
+	}
+	m.sessions[session.ID] = session
+	return nil
+}
+
+//AddStreamSessionSample adds a viewer sample to the session for a stream which hasn't ended yet, noting the stream's
+//current title and game if they haven't been seen before
+func (m *MemoryStore) AddStreamSessionSample(provider, channelID, title string, sample *guildmodels.ViewerSample) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.addSessionSample(guildmodels.StreamKey(provider, channelID), title, sample)
+	return nil
+}
+
+//EndStreamSession records that a stream has gone offline, ending any of its sessions which haven't already ended
+func (m *MemoryStore) EndStreamSession(provider, channelID string, endedAt time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := guildmodels.StreamKey(provider, channelID)
+	for _, session := range m.sessions {
+		if session.StreamKey == key && session.EndedAt == nil {
+			t := endedAt
+			session.EndedAt = &t
+		}
+	}
+	return nil
+}
+
+//GetOpenStreamSessions returns every session which hasn't ended yet, optionally limited to a single stream key
+func (m *MemoryStore) GetOpenStreamSessions(streamKey *string) ([]guildmodels.StreamSession, error) {
+	return m.findSessions(func(session *guildmodels.StreamSession) bool {
+		return session.EndedAt == nil && (streamKey == nil || session.StreamKey == *streamKey)
+	}), nil
+}
+
+//GetStreamSessions returns the sessions of each of the provided streams which were live at any point after since,
+//ordered by start time
+func (m *MemoryStore) GetStreamSessions(streamKeys []string, since time.Time) ([]guildmodels.StreamSession, error) {
+	wanted := make(map[string]bool, len(streamKeys))
+	for _, key := range streamKeys {
+		wanted[key] = true
+	}
+	sessions := m.findSessions(func(session *guildmodels.StreamSession) bool {
+		return wanted[session.StreamKey] && (session.EndedAt == nil || !session.EndedAt.Before(since))
+	})
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions, nil
+}
+
+//GetPostedClips returns the set of the provided clip IDs which have already been posted in a guild
+func (m *MemoryStore) GetPostedClips(gid string, clipIDs []string) (map[string]bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	posted := make(map[string]bool)
+	for _, clipID := range clipIDs {
+		if m.postedClips[compoundKey{gid, clipID}] != nil {
+			posted[clipID] = true
+		}
+	}
+	return posted, nil
+}
+
+//AddPostedClip records that a clip has been posted in a guild
+func (m *MemoryStore) AddPostedClip(clip *guildmodels.PostedClip) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var stored guildmodels.PostedClip
+	copyDoc(&stored, clip)
+	m.postedClips[compoundKey{clip.GuildID, clip.ClipID}] = &stored
+	return nil
+}
+
+//PrunePostedClips forgets about posted clips which were created before the provided time
+func (m *MemoryStore) PrunePostedClips(before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, clip := range m.postedClips {
+		if clip.CreatedAt.Before(before) {
+			delete(m.postedClips, key)
+		}
+	}
+	return nil
+}
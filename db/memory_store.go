@@ -0,0 +1,131 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+//compoundKey identifies documents which RethinkDB stores under a two-part ID, such as members
+type compoundKey [2]string
+
+//MemoryStore keeps all of the bot's data in memory. It is intended for tests and quick local runs, as everything is
+//lost when it is closed. Documents are copied on the way in and out, so callers can't change stored data by holding
+//on to them.
+type MemoryStore struct {
+	lock            sync.Mutex
+	guilds          map[string]*guildmodels.DiscordGuild
+	roleRules       []*guildmodels.ManagedRoleRule
+	members         map[compoundKey]*guildmodels.MemberData
+	streams         map[string]*guildmodels.StreamChannel
+	sessions        map[string]*guildmodels.StreamSession
+	nextSessionID   int
+	scheduledEvents map[compoundKey]*guildmodels.ScheduledEventLink
+	postedClips     map[compoundKey]*guildmodels.PostedClip
+	secrets         map[string]*guildmodels.WebhookSecret
+}
+
+//NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{}
+	m.reset()
+	return m
+}
+
+//Close discards everything in the store
+func (m *MemoryStore) Close() {
+	logrus.Info("Discarding in-memory data...")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.reset()
+}
+
+//reset empties the store. The lock must be held if the store is in use.
+func (m *MemoryStore) reset() {
+	m.guilds = make(map[string]*guildmodels.DiscordGuild)
+	m.roleRules = nil
+	m.members = make(map[compoundKey]*guildmodels.MemberData)
+	m.streams = make(map[string]*guildmodels.StreamChannel)
+	m.sessions = make(map[string]*guildmodels.StreamSession)
+	m.scheduledEvents = make(map[compoundKey]*guildmodels.ScheduledEventLink)
+	m.postedClips = make(map[compoundKey]*guildmodels.PostedClip)
+	m.secrets = make(map[string]*guildmodels.WebhookSecret)
+}
+
+//copyDoc deep copies src into dst, which must be a pointer to the same type
+func copyDoc(dst, src interface{}) {
+	encoded, err := json.Marshal(src)
+	if err == nil {
+		err = json.Unmarshal(encoded, dst)
+	}
+	if err != nil {
+		//Every model can be encoded, so this can only happen if one is changed to contain something that can't
+		logrus.Panicf("Failed to copy document %#v due to error %v", src, err)
+	}
+}
+
+//GetWebhookSecret retrieves the webhook secret with the provided ID, returning nil if it hasn't been saved yet
+func (m *MemoryStore) GetWebhookSecret(id string) (*guildmodels.WebhookSecret, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	secret := m.secrets[id]
+	if secret == nil {
+		return nil, nil
+	}
+	var res guildmodels.WebhookSecret
+	copyDoc(&res, secret)
+	return &res, nil
+}
+
+//SetWebhookSecret saves a webhook secret, replacing any existing secret with the same ID
+func (m *MemoryStore) SetWebhookSecret(secret *guildmodels.WebhookSecret) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var stored guildmodels.WebhookSecret
+	copyDoc(&stored, secret)
+	m.secrets[secret.ID] = &stored
+	return nil
+}
+
+//GetGuildScheduledEvents returns every scheduled event which has been created for a stream schedule in a guild
+func (m *MemoryStore) GetGuildScheduledEvents(gid string) ([]guildmodels.ScheduledEventLink, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []guildmodels.ScheduledEventLink
+	for key, link := range m.scheduledEvents {
+		if key[0] != gid {
+			continue
+		}
+		var linkCopy guildmodels.ScheduledEventLink
+		copyDoc(&linkCopy, link)
+		res = append(res, linkCopy)
+	}
+	return res, nil
+}
+
+//SetScheduledEventLink stores the details of a scheduled event, replacing any previously stored for the same stream
+func (m *MemoryStore) SetScheduledEventLink(link *guildmodels.ScheduledEventLink) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var stored guildmodels.ScheduledEventLink
+	copyDoc(&stored, link)
+	m.scheduledEvents[compoundKey{link.GuildID, link.ScheduleKey}] = &stored
+	return nil
+}
+
+//DeleteScheduledEventLink forgets about the scheduled event for a stream in a guild
+func (m *MemoryStore) DeleteScheduledEventLink(gid, scheduleKey string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.scheduledEvents, compoundKey{gid, scheduleKey})
+	return nil
+}
+
+//newSessionID generates an ID for a stream session. The lock must be held.
+func (m *MemoryStore) newSessionID() string {
+	m.nextSessionID++
+	return strconv.Itoa(m.nextSessionID)
+}
@@ -0,0 +1,14 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/callummance/nia/db"
+	"github.com/callummance/nia/db/dbtest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	dbtest.RunStoreTests(t, func(t *testing.T) db.Store {
+		return db.NewMemoryStore()
+	})
+}
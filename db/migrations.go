@@ -0,0 +1,295 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+const schemaMigrationsTable string = "schema_migrations"
+
+//appliedMigration records a migration which has been applied to the database
+type appliedMigration struct {
+	Name      string    `gorethink:"id"`
+	AppliedAt time.Time `gorethink:"applied_at"`
+}
+
+//migration is a named change to the shape of the documents stored in the database, or to the tables holding them
+type migration struct {
+	//name identifies the migration in the schema_migrations table, so must never change once released
+	name string
+	//fields lists the fields which the migration writes or indexes in each table. Each must be present in the
+	//table's guildmodels struct, so that a migration can't be released which writes documents the bot can't read.
+	fields map[string][]string
+	//pending returns the number of documents which the migration would change
+	pending func(db *Connection) (int, error)
+	//apply makes the change, and must be safe to repeat if a previous run was interrupted
+	apply func(db *Connection) error
+}
+
+//migrations lists every migration in the order they are applied. New migrations go at the end.
+var migrations = []migration{
+	{
+		name: "0001_provider_neutral_streams",
+		fields: map[string][]string{
+			streamsTable: {"id", "provider", "channel_id"},
+			membersTable: {"connections.links"},
+			guildsTable:  {"followed_streams"},
+		},
+		pending: (*Connection).pendingTwitchStreams,
+		apply:   (*Connection).migrateTwitchStreams,
+	},
+	{
+		name: "0002_stream_sessions_stream_index",
+		fields: map[string][]string{
+			sessionsTable: {sessionsStreamIndex},
+		},
+		pending: (*Connection).pendingSessionsStreamIndex,
+		apply:   (*Connection).createSessionsStreamIndex,
+	},
+}
+
+//tableModels maps each table to the guildmodels struct its documents are stored as
+var tableModels = map[string]interface{}{
+	guildsTable:          guildmodels.DiscordGuild{},
+	guildRolesTable:      guildmodels.ManagedRoleRule{},
+	membersTable:         guildmodels.MemberData{},
+	streamsTable:         guildmodels.StreamChannel{},
+	sessionsTable:        guildmodels.StreamSession{},
+	scheduledEventsTable: guildmodels.ScheduledEventLink{},
+	postedClipsTable:     guildmodels.PostedClip{},
+	secretsTable:         guildmodels.WebhookSecret{},
+}
+
+//MigrationResult describes a migration which hadn't yet been applied
+type MigrationResult struct {
+	Name string
+	//Documents is the number of documents the migration changed, or would change in a dry run
+	Documents int
+	//UnknownFields lists fields found in the migrated tables which aren't part of their guildmodels structs, as
+	//`table.field.path`. It is not filled in for dry runs.
+	UnknownFields []string
+}
+
+//Migrate applies every migration which hasn't yet been recorded in the schema_migrations table, in order, returning
+//the details of each. If dryRun is set nothing is changed, and the migrations which would be applied are returned.
+func (db *Connection) Migrate(dryRun bool) ([]MigrationResult, error) {
+	for _, m := range migrations {
+		err := m.checkFields()
+		if err != nil {
+			logrus.Errorf("Migration %v does not match the stored models: %v", m.name, err)
+			return nil, err
+		}
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		logrus.Errorf("Failed to look up applied migrations due to error %v", err)
+		return nil, err
+	}
+
+	var results []MigrationResult
+	for _, m := range migrations {
+		if applied[m.name] {
+			continue
+		}
+		res := MigrationResult{Name: m.name}
+		res.Documents, err = m.pending(db)
+		if err != nil {
+			logrus.Errorf("Failed to check migration %v due to error %v", m.name, err)
+			return results, err
+		}
+		if dryRun {
+			logrus.Infof("Migration %v would change %v documents", m.name, res.Documents)
+			results = append(results, res)
+			continue
+		}
+
+		logrus.Infof("Applying migration %v to %v documents", m.name, res.Documents)
+		err = m.apply(db)
+		if err != nil {
+			logrus.Errorf("Failed to apply migration %v due to error %v", m.name, err)
+			return results, err
+		}
+		for table := range m.fields {
+			unknown, err := db.unknownFields(table)
+			if err != nil {
+				logrus.Errorf("Failed to check %v table after migration %v due to error %v", table, m.name, err)
+				return results, err
+			}
+			res.UnknownFields = append(res.UnknownFields, unknown...)
+		}
+		sort.Strings(res.UnknownFields)
+		if len(res.UnknownFields) > 0 {
+			logrus.Warnf("Tables changed by migration %v contain fields not in their models: %v", m.name, res.UnknownFields)
+		}
+		_, err = rethink.Table(schemaMigrationsTable).Insert(appliedMigration{
+			Name:      m.name,
+			AppliedAt: time.Now(),
+		}, rethink.InsertOpts{Conflict: "replace"}).RunWrite(db.session)
+		if err != nil {
+			logrus.Errorf("Failed to record migration %v due to error %v", m.name, err)
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+//appliedMigrations returns the set of migrations recorded in the schema_migrations table, which is treated as empty
+//if it hasn't been created yet
+func (db *Connection) appliedMigrations() (map[string]bool, error) {
+	existing, err := db.tableList()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]bool)
+	if !existing[schemaMigrationsTable] {
+		return applied, nil
+	}
+	res, err := rethink.Table(schemaMigrationsTable).Run(db.session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var records []appliedMigration
+	err = res.All(&records)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		applied[record.Name] = true
+	}
+	return applied, nil
+}
+
+//count returns the number of documents selected by a query
+func (db *Connection) count(query rethink.Term) (int, error) {
+	res, err := query.Count().Run(db.session)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	var n int
+	err = res.One(&n)
+	return n, err
+}
+
+//checkFields ensures every field written by a migration is present in the model for its table
+func (m *migration) checkFields() error {
+	for table, fields := range m.fields {
+		model, ok := tableModels[table]
+		if !ok {
+			return fmt.Errorf("table %v has no model", table)
+		}
+		for _, field := range fields {
+			if !modelHasField(reflect.TypeOf(model), strings.Split(field, ".")) {
+				return fmt.Errorf("field %v is not part of the model for table %v", field, table)
+			}
+		}
+	}
+	return nil
+}
+
+//modelField finds the struct field stored under the provided name, handling compound primary keys which are stored
+//as eg. `id[0]`
+func modelField(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("gorethink"), ",")[0]
+		if idx := strings.Index(tag, "["); idx >= 0 {
+			tag = tag[:idx]
+		}
+		if tag == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+//modelElem strips pointers and slices from a model type, leaving the type of the values they hold
+func modelElem(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+//modelHasField returns true iff a dot separated field path is stored by a model, where `*` matches any key of a map
+func modelHasField(t reflect.Type, path []string) bool {
+	for _, name := range path {
+		t = modelElem(t)
+		if t.Kind() == reflect.Map && name == "*" {
+			t = t.Elem()
+			continue
+		} else if t.Kind() != reflect.Struct {
+			return false
+		}
+		var ok bool
+		t, ok = modelField(t, name)
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+//unknownFields returns the paths of fields found in any document of a table which aren't part of its model
+func (db *Connection) unknownFields(table string) ([]string, error) {
+	model, ok := tableModels[table]
+	if !ok {
+		return nil, nil
+	}
+	res, err := rethink.Table(table).Run(db.session)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	found := make(map[string]bool)
+	var doc map[string]interface{}
+	for res.Next(&doc) {
+		collectUnknownFields(doc, reflect.TypeOf(model), table, found)
+		doc = nil
+	}
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+	unknown := make([]string, 0, len(found))
+	for path := range found {
+		unknown = append(unknown, path)
+	}
+	return unknown, nil
+}
+
+//collectUnknownFields adds the path of every field in a document which isn't part of a model to found
+func collectUnknownFields(doc interface{}, t reflect.Type, prefix string, found map[string]bool) {
+	t = modelElem(t)
+	switch v := doc.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			collectUnknownFields(elem, t, prefix, found)
+		}
+	case map[string]interface{}:
+		if t.Kind() == reflect.Map {
+			for _, elem := range v {
+				collectUnknownFields(elem, t.Elem(), prefix+".*", found)
+			}
+			return
+		} else if t.Kind() != reflect.Struct {
+			return
+		}
+		for name, elem := range v {
+			field, ok := modelField(t, name)
+			if !ok {
+				found[prefix+"."+name] = true
+				continue
+			}
+			collectUnknownFields(elem, field, prefix+"."+name, found)
+		}
+	}
+}
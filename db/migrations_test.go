@@ -0,0 +1,274 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+func TestMigrationsMatchModels(t *testing.T) {
+	names := make(map[string]bool, len(migrations))
+	for i, m := range migrations {
+		err := m.checkFields()
+		if err != nil {
+			t.Errorf("migration %v does not match the stored models: %v", m.name, err)
+		}
+		if names[m.name] {
+			t.Errorf("migration %v is listed more than once", m.name)
+		}
+		names[m.name] = true
+		if i > 0 && m.name <= migrations[i-1].name {
+			t.Errorf("migration %v is listed after %v, so would be applied out of order", m.name, migrations[i-1].name)
+		}
+		if m.pending == nil || m.apply == nil {
+			t.Errorf("migration %v is missing its pending or apply function", m.name)
+		}
+	}
+}
+
+func TestMigrationCheckFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string][]string
+		wantErr string
+	}{
+		{"top level fields", map[string][]string{streamsTable: {"id", "provider", "channel_id"}}, ""},
+		{"nested field", map[string][]string{membersTable: {"connections.links"}}, ""},
+		{"compound primary key", map[string][]string{membersTable: {"id"}}, ""},
+		{"field of a slice of structs", map[string][]string{guildsTable: {"followed_teams.streams"}}, ""},
+		{"field of map values", map[string][]string{guildsTable: {"announcements.*.channel_id"}}, ""},
+		{"missing field", map[string][]string{streamsTable: {"provider", "twitch_id"}}, "field twitch_id"},
+		{"missing nested field", map[string][]string{membersTable: {"connections.twitch_link"}}, "field connections.twitch_link"},
+		{"field of a plain value", map[string][]string{streamsTable: {"provider.name"}}, "field provider.name"},
+		{"table without a model", map[string][]string{"twitch": {"id"}}, "table twitch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := migration{name: "test", fields: test.fields}
+			err := m.checkFields()
+			if test.wantErr == "" && err != nil {
+				t.Errorf("expected fields to match the models, got %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("expected error about %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestModelHasField(t *testing.T) {
+	tests := []struct {
+		model interface{}
+		path  string
+		want  bool
+	}{
+		{guildmodels.StreamChannel{}, "posts.gid", true},
+		{guildmodels.StreamChannel{}, "posts.*", false},
+		{guildmodels.MemberData{}, "connections.links.*", true},
+		{guildmodels.MemberData{}, "connections.links.twitch", false},
+		{guildmodels.DiscordGuild{}, "left_at", true},
+		{guildmodels.DiscordGuild{}, "notification_channels", true},
+		{guildmodels.DiscordGuild{}, "announcements.*.template", true},
+		{guildmodels.DiscordGuild{}, "announcements.raid", false},
+		{guildmodels.PostedClip{}, "id", true},
+	}
+	for _, test := range tests {
+		got := modelHasField(reflect.TypeOf(test.model), strings.Split(test.path, "."))
+		if got != test.want {
+			t.Errorf("expected modelHasField(%T, %v) to be %v", test.model, test.path, test.want)
+		}
+	}
+}
+
+func TestCollectUnknownFields(t *testing.T) {
+	doc := map[string]interface{}{
+		"id":          "g1",
+		"admin_roles": []interface{}{"1", "2"},
+		"followed_teams": []interface{}{
+			map[string]interface{}{"name": "team", "streams": []interface{}{"twitch:1"}, "logo": "x"},
+		},
+		"announcements": map[string]interface{}{
+			"raid":   map[string]interface{}{"channel_id": "3"},
+			"follow": map[string]interface{}{"channel_id": "4", "colour": 5},
+		},
+		"followed_twitch_streams": []interface{}{"1"},
+	}
+	found := make(map[string]bool)
+	collectUnknownFields(doc, reflect.TypeOf(guildmodels.DiscordGuild{}), guildsTable, found)
+	var got []string
+	for path := range found {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	want := []string{"guilds.announcements.*.colour", "guilds.followed_teams.logo", "guilds.followed_twitch_streams"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected unknown fields %v, got %v", want, got)
+	}
+}
+
+//newTestConnection connects to a new database on the RethinkDB instance at NIA_DB_ADDR with every table created, but
+//no migrations applied. The database is dropped once the test finishes. The test is skipped if NIA_DB_ADDR isn't set.
+func newTestConnection(t *testing.T) *Connection {
+	t.Helper()
+	addr, exists := os.LookupEnv(dbAddrEnvVar)
+	if !exists {
+		t.Skipf("%v is not set", dbAddrEnvVar)
+	}
+	oldName, hadName := os.LookupEnv(dbNameEnvVar)
+	name := fmt.Sprintf("nia_test_migrations_%d", time.Now().UnixNano())
+	os.Setenv(dbNameEnvVar, name)
+	conn, err := Connect()
+	if hadName {
+		os.Setenv(dbNameEnvVar, oldName)
+	} else {
+		os.Unsetenv(dbNameEnvVar)
+	}
+	if err != nil {
+		t.Fatalf("failed to connect to rethinkdb at %v: %v", addr, err)
+	}
+	t.Cleanup(func() {
+		_, err := rethink.DBDrop(name).RunWrite(conn.session)
+		if err != nil {
+			t.Errorf("failed to drop test database %v: %v", name, err)
+		}
+		conn.Close()
+	})
+	err = conn.CreateDatabase(name)
+	if err == nil {
+		err = conn.CreateTables()
+	}
+	if err != nil {
+		t.Fatalf("failed to set up test database %v: %v", name, err)
+	}
+	return conn
+}
+
+//fakeMigration returns a migration which reports the provided number of pending documents, and records its name in
+//applied when it is applied
+func fakeMigration(name string, pending int, applied *[]string) migration {
+	return migration{
+		name:   name,
+		fields: map[string][]string{streamsTable: {"provider"}},
+		pending: func(db *Connection) (int, error) {
+			return pending, nil
+		},
+		apply: func(db *Connection) error {
+			*applied = append(*applied, name)
+			return nil
+		},
+	}
+}
+
+func migrationNames(results []MigrationResult) []string {
+	names := make([]string, 0, len(results))
+	for _, res := range results {
+		names = append(names, fmt.Sprintf("%v:%d", res.Name, res.Documents))
+	}
+	return names
+}
+
+func TestMigrate(t *testing.T) {
+	conn := newTestConnection(t)
+	realMigrations := migrations
+	defer func() {
+		migrations = realMigrations
+	}()
+	var applied []string
+	migrations = []migration{
+		fakeMigration("0001_first", 2, &applied),
+		fakeMigration("0002_second", 0, &applied),
+	}
+
+	//A dry run lists what would be applied without changing anything
+	results, err := conn.Migrate(true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if got := migrationNames(results); !reflect.DeepEqual(got, []string{"0001_first:2", "0002_second:0"}) {
+		t.Errorf("unexpected dry run results %v", got)
+	}
+	if len(applied) != 0 {
+		t.Errorf("dry run applied %v", applied)
+	}
+
+	results, err = conn.Migrate(false)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if !reflect.DeepEqual(applied, []string{"0001_first", "0002_second"}) || len(results) != 2 {
+		t.Errorf("expected both migrations to be applied in order, applied %v", applied)
+	}
+	recorded, err := conn.appliedMigrations()
+	if err != nil || len(recorded) != 2 || !recorded["0001_first"] || !recorded["0002_second"] {
+		t.Errorf("expected both migrations to be recorded, got %v (error %v)", recorded, err)
+	}
+
+	//Only new migrations are applied on later runs, and one which fails isn't recorded so is tried again
+	failing := fakeMigration("0003_third", 1, &applied)
+	failing.apply = func(db *Connection) error {
+		return errors.New("failed to migrate")
+	}
+	migrations = append(migrations, failing)
+	applied = nil
+	_, err = conn.Migrate(false)
+	if err == nil {
+		t.Errorf("expected failing migration to return an error")
+	}
+	migrations[2] = fakeMigration("0003_third", 1, &applied)
+	results, err = conn.Migrate(false)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if !reflect.DeepEqual(applied, []string{"0003_third"}) || len(results) != 1 {
+		t.Errorf("expected only the previously failed migration to be applied, applied %v", applied)
+	}
+
+	//Migrations which write fields missing from the models are refused before anything is applied
+	bad := fakeMigration("0004_bad", 1, &applied)
+	bad.fields = map[string][]string{streamsTable: {"twitch_id"}}
+	migrations = append(migrations, bad)
+	applied = nil
+	_, err = conn.Migrate(false)
+	if err == nil || len(applied) != 0 {
+		t.Errorf("expected migration with unknown fields to be refused, got error %v and applied %v", err, applied)
+	}
+}
+
+func TestSessionsStreamIndexMigration(t *testing.T) {
+	conn := newTestConnection(t)
+	for i := 0; i < 3; i++ {
+		_, err := rethink.Table(sessionsTable).Insert(guildmodels.StreamSession{
+			StreamKey: guildmodels.StreamKey("twitch", fmt.Sprint(i)),
+			Provider:  "twitch",
+			ChannelID: fmt.Sprint(i),
+			StartedAt: time.Now(),
+		}).RunWrite(conn.session)
+		if err != nil {
+			t.Fatalf("failed to insert session: %v", err)
+		}
+	}
+	pending, err := conn.pendingSessionsStreamIndex()
+	if err != nil || pending != 3 {
+		t.Errorf("expected all 3 sessions to need indexing, got %v (error %v)", pending, err)
+	}
+	err = conn.createSessionsStreamIndex()
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	pending, err = conn.pendingSessionsStreamIndex()
+	if err != nil || pending != 0 {
+		t.Errorf("expected nothing to be pending once the index exists, got %v (error %v)", pending, err)
+	}
+	//Creating the index again does nothing
+	err = conn.createSessionsStreamIndex()
+	if err != nil {
+		t.Errorf("failed to repeat index creation: %v", err)
+	}
+}
@@ -13,6 +13,7 @@ func (db *Connection) AddManagedRoleRule(rule guildmodels.ManagedRoleRule) error
 	resp, err := rethink.Table(guildRolesTable).Insert(rule).RunWrite(db.session)
 	if err != nil {
 		logrus.Warnf("Encountered error inserting managed role  rule %v into database: %v.", rule, err)
+		return err
 	} else if resp.Errors > 0 {
 		err := fmt.Errorf("%v", resp.FirstError)
 		logrus.Warnf("Encountered error appending admin role to DB: %v", err)
@@ -142,6 +143,25 @@ func (db *Connection) GetRoleRules(guildID string, roleID string) ([]guildmodels
 	return matchingRoleRules, nil
 }
 
+//DeleteRoleRules removes every role assignment rule for a given role in a given server, returning the number of rules
+//deleted
+func (db *Connection) DeleteRoleRules(guildID string, roleID string) (int, error) {
+	filter := map[string]interface{}{
+		"guild_id": guildID,
+		"role_id":  roleID,
+	}
+	resp, err := rethink.Table(guildRolesTable).Filter(filter).Delete().RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error deleting rules for role %v in guild %v: %v.", roleID, guildID, err)
+		return 0, err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error deleting rules for role %v in guild %v: %v.", roleID, guildID, err)
+		return 0, err
+	}
+	return resp.Deleted, nil
+}
+
 //IsManagedRole returns true iff we have any rules stored for the given roleID in the given guildID
 func (db *Connection) IsManagedRole(guildID string, roleID string) (bool, error) {
 	filter := map[string]interface{}{
@@ -156,8 +176,5 @@ func (db *Connection) IsManagedRole(guildID string, roleID string) (bool, error)
 		logrus.Warnf("Encountered error looking up rules for role %v in guild %v: %v.", roleID, guildID, err)
 		return false, err
 	}
-	if res.IsNil() {
-		return true, nil
-	}
-	return false, nil
+	return !res.IsNil(), nil
 }
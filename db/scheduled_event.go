@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+const scheduledEventsTable string = "scheduled_events"
+
+//GetGuildScheduledEvents returns every scheduled event which has been created for a stream schedule in a guild
+func (db *Connection) GetGuildScheduledEvents(gid string) ([]guildmodels.ScheduledEventLink, error) {
+	res, err := rethink.Table(scheduledEventsTable).Filter(func(link rethink.Term) rethink.Term {
+		return link.Field("id").Nth(0).Eq(gid)
+	}).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up scheduled events for guild %v due to error %v", gid, err)
+		return nil, err
+	}
+	defer res.Close()
+	var links []guildmodels.ScheduledEventLink
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&links)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve scheduled events for guild %v due to error %v", gid, err)
+		return nil, err
+	}
+	return links, nil
+}
+
+//SetScheduledEventLink stores the details of a scheduled event, replacing any previously stored for the same stream
+func (db *Connection) SetScheduledEventLink(link *guildmodels.ScheduledEventLink) error {
+	resp, err := rethink.Table(scheduledEventsTable).Insert(link, rethink.InsertOpts{
+		Conflict: "replace",
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to store scheduled event %v due to error %v", link.ScheduleKey, err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Failed to store scheduled event %v due to error %v", link.ScheduleKey, err)
+		return err
+	}
+	return nil
+}
+
+//DeleteScheduledEventLink forgets about the scheduled event for a stream in a guild
+func (db *Connection) DeleteScheduledEventLink(gid, scheduleKey string) error {
+	_, err := rethink.Table(scheduledEventsTable).Get([]string{gid, scheduleKey}).Delete().RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to delete scheduled event %v in guild %v due to error %v", scheduleKey, gid, err)
+		return err
+	}
+	return nil
+}
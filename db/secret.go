@@ -0,0 +1,47 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+const secretsTable string = "secrets"
+
+//GetWebhookSecret retrieves the webhook secret with the provided ID, returning nil if it hasn't been saved yet
+func (db *Connection) GetWebhookSecret(id string) (*guildmodels.WebhookSecret, error) {
+	res, err := rethink.Table(secretsTable).Get(id).Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up webhook secret %v due to error %v", id, err)
+		return nil, err
+	}
+	defer res.Close()
+	if res.IsNil() {
+		return nil, nil
+	}
+	var secret guildmodels.WebhookSecret
+	err = res.One(&secret)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve webhook secret %v due to error %v", id, err)
+		return nil, err
+	}
+	return &secret, nil
+}
+
+//SetWebhookSecret saves a webhook secret, replacing any existing secret with the same ID
+func (db *Connection) SetWebhookSecret(secret *guildmodels.WebhookSecret) error {
+	resp, err := rethink.Table(secretsTable).Insert(secret, rethink.InsertOpts{
+		Conflict: "replace",
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Encountered error saving webhook secret %v: %v", secret.ID, err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Encountered error saving webhook secret %v: %v", secret.ID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+const sessionsTable string = "stream_sessions"
+
+//sessionsStreamIndex is a secondary index on the stream key of each session
+const sessionsStreamIndex string = "stream"
+
+//openSessions selects the sessions which have not yet ended
+func openSessions(session rethink.Term) rethink.Term {
+	return session.HasFields("ended_at").Not()
+}
+
+//StartStreamSession records that a stream has gone live, along with its first viewer sample. If the stream already
+//has a session which hasn't ended (eg. because of a repeated online event), the sample is added to it instead.
+func (db *Connection) StartStreamSession(provider, channelID string, startedAt time.Time, title string, sample *guildmodels.ViewerSample) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	open, err := db.GetOpenStreamSessions(&key)
+	if err != nil {
+		return err
+	} else if len(open) > 0 {
+		return db.AddStreamSessionSample(provider, channelID, title, sample)
+	}
+	session := guildmodels.StreamSession{
+		StreamKey: key,
+		Provider:  provider,
+		ChannelID: channelID,
+		StartedAt: startedAt,
+		Samples:   []guildmodels.ViewerSample{*sample},
+	}
+	if title != "" {
+		session.Titles = []string{title}
+	}
+	if sample.Game != "" {
+		session.Games = []string{sample.Game}
+	}
+	resp, err := rethink.Table(sessionsTable).Insert(session).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to record start of stream session for %v due to error %v", key, err)
+		return err
+	} else if resp.Errors > 0 {
+		err := fmt.Errorf("%v", resp.FirstError)
+		logrus.Warnf("Failed to record start of stream session for %v due to error %v", key, err)
+		return err
+	}
+	return nil
+}
+
+//AddStreamSessionSample adds a viewer sample to the session for a stream which hasn't ended yet, noting the stream's
+//current title and game if they haven't been seen before
+func (db *Connection) AddStreamSessionSample(provider, channelID, title string, sample *guildmodels.ViewerSample) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(sessionsTable).Filter(map[string]interface{}{
+		"stream": key,
+	}).Filter(openSessions).Update(func(t rethink.Term) interface{} {
+		update := map[string]interface{}{
+			"samples": t.Field("samples").Default([]interface{}{}).Append(sample),
+		}
+		if title != "" {
+			update["titles"] = t.Field("titles").Default([]interface{}{}).SetInsert(title)
+		}
+		if sample.Game != "" {
+			update["games"] = t.Field("games").Default([]interface{}{}).SetInsert(sample.Game)
+		}
+		return update
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to add viewer sample to stream session for %v due to error %v", key, err)
+		return err
+	}
+	return nil
+}
+
+//EndStreamSession records that a stream has gone offline, ending any of its sessions which haven't already ended
+func (db *Connection) EndStreamSession(provider, channelID string, endedAt time.Time) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	_, err := rethink.Table(sessionsTable).Filter(map[string]interface{}{
+		"stream": key,
+	}).Filter(openSessions).Update(map[string]interface{}{
+		"ended_at": endedAt,
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to record end of stream session for %v due to error %v", key, err)
+		return err
+	}
+	return nil
+}
+
+//GetOpenStreamSessions returns every session which hasn't ended yet, optionally limited to a single stream key
+func (db *Connection) GetOpenStreamSessions(streamKey *string) ([]guildmodels.StreamSession, error) {
+	query := rethink.Table(sessionsTable).Filter(openSessions)
+	if streamKey != nil {
+		query = query.Filter(map[string]interface{}{
+			"stream": *streamKey,
+		})
+	}
+	res, err := query.Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up open stream sessions due to error %v", err)
+		return nil, err
+	}
+	defer res.Close()
+	var sessions []guildmodels.StreamSession
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&sessions)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve open stream sessions due to error %v", err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+//GetStreamSessions returns the sessions of each of the provided streams which were live at any point after since,
+//ordered by start time
+func (db *Connection) GetStreamSessions(streamKeys []string, since time.Time) ([]guildmodels.StreamSession, error) {
+	if len(streamKeys) == 0 {
+		return nil, nil
+	}
+	keys := make([]interface{}, 0, len(streamKeys))
+	for _, key := range streamKeys {
+		keys = append(keys, key)
+	}
+	res, err := rethink.Table(sessionsTable).GetAllByIndex(sessionsStreamIndex, keys...).Filter(func(session rethink.Term) rethink.Term {
+		return session.Field("ended_at").Default(rethink.Now()).Ge(since)
+	}).OrderBy("started_at").Run(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to look up stream sessions for %v due to error %v", streamKeys, err)
+		return nil, err
+	}
+	defer res.Close()
+	var sessions []guildmodels.StreamSession
+	if res.IsNil() {
+		return nil, nil
+	}
+	err = res.All(&sessions)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve stream sessions for %v due to error %v", streamKeys, err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+//sessionsStreamIndexExists returns true if the index on the stream key of each session has been created
+func (db *Connection) sessionsStreamIndexExists() (bool, error) {
+	res, err := rethink.Table(sessionsTable).IndexList().Run(db.session)
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+	var indexes []string
+	err = res.All(&indexes)
+	if err != nil {
+		return false, err
+	}
+	for _, index := range indexes {
+		if index == sessionsStreamIndex {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//pendingSessionsStreamIndex returns the number of sessions which will be indexed when the index on their stream key is
+//created, or 0 if it already exists. The sessions table may not have been created yet during a dry run, in which case
+//there is nothing to index.
+func (db *Connection) pendingSessionsStreamIndex() (int, error) {
+	existing, err := db.tableList()
+	if err != nil || !existing[sessionsTable] {
+		return 0, err
+	}
+	exists, err := db.sessionsStreamIndexExists()
+	if err != nil || exists {
+		return 0, err
+	}
+	return db.count(rethink.Table(sessionsTable))
+}
+
+//createSessionsStreamIndex creates the index on the stream key of each session if it doesn't already exist, then waits
+//for it to be ready
+func (db *Connection) createSessionsStreamIndex() error {
+	exists, err := db.sessionsStreamIndexExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = rethink.Table(sessionsTable).IndexCreate(sessionsStreamIndex).RunWrite(db.session)
+		if err != nil {
+			logrus.Warnf("Failed to create %v index on stream sessions due to error %v", sessionsStreamIndex, err)
+			return err
+		}
+	}
+	return rethink.Table(sessionsTable).IndexWait(sessionsStreamIndex).Exec(db.session)
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+//readTable reads every document in a RethinkDB table into dst, which must be a pointer to a slice
+func (db *Connection) readTable(table string, dst interface{}) error {
+	res, err := rethink.Table(table).Run(db.session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	return res.All(dst)
+}
+
+//CopyToSQLite copies every document stored in RethinkDB into a SQLite database, replacing any documents there with the
+//same IDs. Tables which don't exist in RethinkDB are skipped. Everything is copied in a single transaction, so nothing
+//is changed if the copy fails. It returns the number of documents copied from each table.
+func CopyToSQLite(src *Connection, dst *SQLiteStore) (map[string]int, error) {
+	var (
+		guilds          []guildmodels.DiscordGuild
+		roleRules       []guildmodels.ManagedRoleRule
+		members         []guildmodels.MemberData
+		streams         []guildmodels.StreamChannel
+		sessions        []guildmodels.StreamSession
+		scheduledEvents []guildmodels.ScheduledEventLink
+		postedClips     []guildmodels.PostedClip
+		secrets         []guildmodels.WebhookSecret
+	)
+	reads := []struct {
+		table string
+		dst   interface{}
+	}{
+		{guildsTable, &guilds},
+		{guildRolesTable, &roleRules},
+		{membersTable, &members},
+		{streamsTable, &streams},
+		{sessionsTable, &sessions},
+		{scheduledEventsTable, &scheduledEvents},
+		{postedClipsTable, &postedClips},
+		{secretsTable, &secrets},
+	}
+	existing, err := src.tableList()
+	if err != nil {
+		logrus.Errorf("Failed to list RethinkDB tables due to error %v", err)
+		return nil, err
+	}
+	for _, r := range reads {
+		if !existing[r.table] {
+			logrus.Warnf("Skipping %v table as it doesn't exist in RethinkDB", r.table)
+			continue
+		}
+		err := src.readTable(r.table, r.dst)
+		if err != nil {
+			logrus.Errorf("Failed to read %v table from RethinkDB due to error %v", r.table, err)
+			return nil, err
+		}
+	}
+
+	counts := map[string]int{
+		guildsTable:          len(guilds),
+		guildRolesTable:      len(roleRules),
+		membersTable:         len(members),
+		streamsTable:         len(streams),
+		sessionsTable:        len(sessions),
+		scheduledEventsTable: len(scheduledEvents),
+		postedClipsTable:     len(postedClips),
+		secretsTable:         len(secrets),
+	}
+	err = dst.tx("copy RethinkDB data into sqlite", func(tx *sql.Tx) error {
+		for i := range guilds {
+			err := sqliteGuilds.put(tx, &guilds[i], guilds[i].DiscordGID)
+			if err != nil {
+				return err
+			}
+		}
+		//Role rules aren't read with their RethinkDB IDs, so they are replaced rather than given new copies
+		for i := range roleRules {
+			_, err := sqliteRoleRules.delete(tx, roleRules[i].GuildID)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range roleRules {
+			err := sqliteRoleRules.put(tx, &roleRules[i], roleRules[i].GuildID, newDocID())
+			if err != nil {
+				return err
+			}
+		}
+		for i := range members {
+			err := sqliteMembers.put(tx, &members[i], members[i].GuildID, members[i].UserID)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range streams {
+			err := sqliteStreams.put(tx, &streams[i], streams[i].Key)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range sessions {
+			err := sqliteSessions.put(tx, &sessions[i], sessions[i].ID)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range scheduledEvents {
+			err := sqliteScheduledEvents.put(tx, &scheduledEvents[i], scheduledEvents[i].GuildID, scheduledEvents[i].ScheduleKey)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range postedClips {
+			err := sqlitePostedClips.put(tx, &postedClips[i], postedClips[i].GuildID, postedClips[i].ClipID)
+			if err != nil {
+				return err
+			}
+		}
+		for i := range secrets {
+			err := sqliteSecrets.put(tx, &secrets[i], secrets[i].ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
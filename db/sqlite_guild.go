@@ -0,0 +1,330 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+)
+
+//updateGuild applies an update to a stored guild, creating the guild first if create is set. The update returns
+//whether it changed anything, and the number of guilds changed is returned in the same way as a RethinkDB update.
+func (s *SQLiteStore) updateGuild(what, gid string, create bool, update func(g *guildmodels.DiscordGuild) bool) (int, error) {
+	changed := 0
+	err := s.tx(what, func(tx *sql.Tx) error {
+		var g guildmodels.DiscordGuild
+		exists, err := sqliteGuilds.get(tx, &g, gid)
+		if err != nil {
+			return err
+		} else if !exists {
+			if !create {
+				return nil
+			}
+			g = guildmodels.DefaultGuild(gid)
+		}
+		if update(&g) {
+			changed = 1
+		} else if exists {
+			return nil
+		}
+		return sqliteGuilds.put(tx, &g, gid)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+//findGuilds returns every stored guild matching a filter
+func (s *SQLiteStore) findGuilds(what string, filter func(g *guildmodels.DiscordGuild) bool) ([]guildmodels.DiscordGuild, error) {
+	var res []guildmodels.DiscordGuild
+	err := s.tx(what, func(tx *sql.Tx) error {
+		return sqliteGuilds.each(tx, func(key []string, doc []byte) error {
+			var g guildmodels.DiscordGuild
+			err := json.Unmarshal(doc, &g)
+			if err == nil && filter(&g) {
+				res = append(res, g)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//GetOrCreateGuild fetches a guild with a given ID from the database, creating a new one if it does not exist.
+func (s *SQLiteStore) GetOrCreateGuild(id string) (*guildmodels.DiscordGuild, error) {
+	var res guildmodels.DiscordGuild
+	_, err := s.updateGuild("get guild "+id, id, true, func(g *guildmodels.DiscordGuild) bool {
+		res = *g
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+//CreateGuild creates a guild if it doesn't exist yet, returning true if one was created
+func (s *SQLiteStore) CreateGuild(gid string) (bool, error) {
+	created := false
+	err := s.tx("create guild "+gid, func(tx *sql.Tx) error {
+		var g guildmodels.DiscordGuild
+		exists, err := sqliteGuilds.get(tx, &g, gid)
+		if err != nil || exists {
+			return err
+		}
+		created = true
+		return sqliteGuilds.put(tx, guildmodels.DefaultGuild(gid), gid)
+	})
+	return created && err == nil, err
+}
+
+//AddAdminRole adds a roleID to the list of AdminRoles for the given guild. It returns the number of updated
+//entries as well as any errors
+func (s *SQLiteStore) AddAdminRole(gid string, roleID string) (int, error) {
+	return s.updateGuild("add admin role", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		return setInsert(&g.AdminRoles, roleID)
+	})
+}
+
+//RemoveAdminRole removes a roleID from the list of AdminRoles for the given guild. It returns the number of updated
+//entries as well as any errors
+func (s *SQLiteStore) RemoveAdminRole(gid string, roleID string) (int, error) {
+	return s.updateGuild("remove admin role", gid, false, func(g *guildmodels.DiscordGuild) bool {
+		return setRemove(&g.AdminRoles, roleID)
+	})
+}
+
+//SetGuildAlertRoutes replaces the stream alert routes for a given guild. This also removes the legacy single stream
+//notification channel, so routes should include it if it is still wanted.
+func (s *SQLiteStore) SetGuildAlertRoutes(gid string, routes []guildmodels.StreamAlertRoute) error {
+	_, err := s.updateGuild("update guild alert routes", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.NotificationChannels = &guildmodels.NotificationChannels{
+			StreamAlertRoutes: routes,
+		}
+		return true
+	})
+	return err
+}
+
+//UpdateGuildAlertTemplate replaces the stream alert template for a given guild. Passing a nil template removes it, so
+//the default alert text will be used.
+func (s *SQLiteStore) UpdateGuildAlertTemplate(gid string, tmpl *guildmodels.AlertTemplate) error {
+	_, err := s.updateGuild("update guild alert template", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.StreamAlertTemplate = tmpl
+		return true
+	})
+	return err
+}
+
+//AddGuildFollowedStream adds a stream key (see guildmodels.StreamKey) to the list of streams followed by the given
+//guild. It returns the number of updated entries as well as any errors
+func (s *SQLiteStore) AddGuildFollowedStream(gid, streamKey string) (int, error) {
+	return s.updateGuild("add followed stream", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		return setInsert(&g.FollowedStreams, streamKey)
+	})
+}
+
+//RemoveGuildFollowedStream removes a stream key from the list of streams followed by the given guild. It returns the
+//number of updated entries as well as any errors
+func (s *SQLiteStore) RemoveGuildFollowedStream(gid, streamKey string) (int, error) {
+	return s.updateGuild("remove followed stream", gid, false, func(g *guildmodels.DiscordGuild) bool {
+		return setRemove(&g.FollowedStreams, streamKey)
+	})
+}
+
+//GetGuildsFollowingStream returns every guild which follows the stream with the provided key, either directly or as
+//part of a team
+func (s *SQLiteStore) GetGuildsFollowingStream(streamKey string) ([]guildmodels.DiscordGuild, error) {
+	return s.findGuilds("look up guilds following stream "+streamKey, func(g *guildmodels.DiscordGuild) bool {
+		return g.FollowsStream(streamKey)
+	})
+}
+
+//SetGuildFollowedTeam adds a twitch team to the list of teams followed by the given guild, replacing the existing
+//entry for the team if there is one
+func (s *SQLiteStore) SetGuildFollowedTeam(gid string, team *guildmodels.FollowedTeam) error {
+	_, err := s.updateGuild("update followed team", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		removeTeam(g, team.Name)
+		g.FollowedTeams = append(g.FollowedTeams, *team)
+		return true
+	})
+	return err
+}
+
+//RemoveGuildFollowedTeam removes a twitch team from the list of teams followed by the given guild. It returns the
+//number of updated entries as well as any errors
+func (s *SQLiteStore) RemoveGuildFollowedTeam(gid, teamName string) (int, error) {
+	return s.updateGuild("remove followed team", gid, false, func(g *guildmodels.DiscordGuild) bool {
+		return removeTeam(g, teamName)
+	})
+}
+
+//GetGuildsFollowingTeams returns every active guild which follows at least one twitch team
+func (s *SQLiteStore) GetGuildsFollowingTeams() ([]guildmodels.DiscordGuild, error) {
+	return s.findGuilds("look up guilds following twitch teams", func(g *guildmodels.DiscordGuild) bool {
+		return len(g.FollowedTeams) > 0 && g.Active()
+	})
+}
+
+//AddGuildFollowedCategory adds a twitch category to the list of categories followed by the given guild. It returns
+//the number of updated entries as well as any errors
+func (s *SQLiteStore) AddGuildFollowedCategory(gid string, category *guildmodels.FollowedCategory) (int, error) {
+	return s.updateGuild("add followed category", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		for _, followed := range g.FollowedCategories {
+			if followed == *category {
+				return false
+			}
+		}
+		g.FollowedCategories = append(g.FollowedCategories, *category)
+		return true
+	})
+}
+
+//RemoveGuildFollowedCategory removes the twitch category with the provided ID from the list of categories followed
+//by the given guild. It returns the number of updated entries as well as any errors
+func (s *SQLiteStore) RemoveGuildFollowedCategory(gid, categoryID string) (int, error) {
+	return s.updateGuild("remove followed category", gid, false, func(g *guildmodels.DiscordGuild) bool {
+		kept := g.FollowedCategories[:0:0]
+		for _, category := range g.FollowedCategories {
+			if category.ID != categoryID {
+				kept = append(kept, category)
+			}
+		}
+		removed := len(kept) != len(g.FollowedCategories)
+		g.FollowedCategories = kept
+		return removed
+	})
+}
+
+//SetGuildVerifiedLinks sets whether members of a guild must prove they own a channel before linking it
+func (s *SQLiteStore) SetGuildVerifiedLinks(gid string, enabled bool) error {
+	_, err := s.updateGuild("update guild verified links setting", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.VerifiedLinks = enabled
+		return true
+	})
+	return err
+}
+
+//SetGuildPresenceStreaming sets whether discord streaming activity should trigger stream alerts in a guild
+func (s *SQLiteStore) SetGuildPresenceStreaming(gid string, enabled bool) error {
+	_, err := s.updateGuild("update guild presence streaming setting", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.PresenceStreaming = enabled
+		return true
+	})
+	return err
+}
+
+//SetGuildScheduledEvents sets whether linked members' stream schedules should be synced to a guild's scheduled events
+func (s *SQLiteStore) SetGuildScheduledEvents(gid string, enabled bool) error {
+	_, err := s.updateGuild("update guild scheduled events setting", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.ScheduledEvents = enabled
+		return true
+	})
+	return err
+}
+
+//GetGuildsWithScheduledEvents returns every active guild which has stream schedule syncing turned on
+func (s *SQLiteStore) GetGuildsWithScheduledEvents() ([]guildmodels.DiscordGuild, error) {
+	return s.findGuilds("look up guilds with scheduled events turned on", func(g *guildmodels.DiscordGuild) bool {
+		return g.ScheduledEvents && g.Active()
+	})
+}
+
+//SetGuildAnnouncement sets how a type of twitch community event should be announced in a guild. Passing a nil
+//announcement stops the event type from being announced.
+func (s *SQLiteStore) SetGuildAnnouncement(gid, eventType string, announcement *guildmodels.Announcement) error {
+	_, err := s.updateGuild("update guild announcements", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		if announcement == nil {
+			delete(g.Announcements, eventType)
+			return true
+		}
+		if g.Announcements == nil {
+			g.Announcements = make(map[string]*guildmodels.Announcement)
+		}
+		g.Announcements[eventType] = announcement
+		return true
+	})
+	return err
+}
+
+//SetGuildClipsFeed sets where and when clips are posted in a guild. Passing a nil feed turns the clips feed off.
+func (s *SQLiteStore) SetGuildClipsFeed(gid string, feed *guildmodels.ClipsFeed) error {
+	_, err := s.updateGuild("update guild clips feed", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.ClipsFeed = feed
+		return true
+	})
+	return err
+}
+
+//GetGuildsWithClipsFeed returns every active guild which has a clips feed set up
+func (s *SQLiteStore) GetGuildsWithClipsFeed() ([]guildmodels.DiscordGuild, error) {
+	return s.findGuilds("look up guilds with a clips feed", func(g *guildmodels.DiscordGuild) bool {
+		return g.ClipsFeed != nil && g.Active()
+	})
+}
+
+//SetGuildLeftAt marks a guild as having been left by the bot at the given time. Passing nil marks the guild as active
+//again.
+func (s *SQLiteStore) SetGuildLeftAt(gid string, leftAt *time.Time) error {
+	_, err := s.updateGuild("update guild left time", gid, true, func(g *guildmodels.DiscordGuild) bool {
+		g.LeftAt = leftAt
+		return true
+	})
+	return err
+}
+
+//GetActiveGuildIDs returns the IDs of every guild which the bot hasn't been removed from
+func (s *SQLiteStore) GetActiveGuildIDs() ([]string, error) {
+	guilds, err := s.findGuilds("look up active guilds", func(g *guildmodels.DiscordGuild) bool {
+		return g.Active()
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(guilds))
+	for _, g := range guilds {
+		ids = append(ids, g.DiscordGID)
+	}
+	return ids, nil
+}
+
+//GetInactiveGuildIDs returns the set of guilds which the bot has been removed from but which haven't been purged yet
+func (s *SQLiteStore) GetInactiveGuildIDs() (map[string]bool, error) {
+	guilds, err := s.findGuilds("look up inactive guilds", func(g *guildmodels.DiscordGuild) bool {
+		return !g.Active()
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(guilds))
+	for _, g := range guilds {
+		ids[g.DiscordGID] = true
+	}
+	return ids, nil
+}
+
+//GetGuildsLeftBefore returns every guild which the bot was removed from before the given time
+func (s *SQLiteStore) GetGuildsLeftBefore(before time.Time) ([]guildmodels.DiscordGuild, error) {
+	return s.findGuilds("look up inactive guilds", func(g *guildmodels.DiscordGuild) bool {
+		return !g.Active() && g.LeftAt.Before(before)
+	})
+}
+
+//PurgeGuild deletes all data stored for a guild, including its members, managed roles, scheduled events and posted
+//clips. Streams are left alone, as they may still be used elsewhere.
+func (s *SQLiteStore) PurgeGuild(gid string) error {
+	return s.tx("purge guild "+gid, func(tx *sql.Tx) error {
+		for _, t := range []sqliteTable{sqliteMembers, sqliteRoleRules, sqliteScheduledEvents, sqlitePostedClips, sqliteGuilds} {
+			_, err := t.delete(tx, gid)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,328 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+//findMembers returns every stored member matching a filter, optionally limited to a single guild
+func (s *SQLiteStore) findMembers(what string, filter func(member *guildmodels.MemberData) bool, guildID ...string) ([]guildmodels.MemberData, error) {
+	var res []guildmodels.MemberData
+	err := s.tx(what, func(tx *sql.Tx) error {
+		return sqliteMembers.each(tx, func(key []string, doc []byte) error {
+			var member guildmodels.MemberData
+			err := json.Unmarshal(doc, &member)
+			if err == nil && filter(&member) {
+				res = append(res, member)
+			}
+			return err
+		}, guildID...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//streamChannel returns the stored stream for a channel, creating it if it doesn't exist
+func (s *SQLiteStore) streamChannel(tx *sql.Tx, provider, channelID string) (*guildmodels.StreamChannel, error) {
+	key := guildmodels.StreamKey(provider, channelID)
+	var stream guildmodels.StreamChannel
+	exists, err := sqliteStreams.get(tx, &stream, key)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		stream = guildmodels.StreamChannel{
+			Key:       key,
+			Provider:  provider,
+			ChannelID: channelID,
+		}
+		err = sqliteStreams.put(tx, &stream, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &stream, nil
+}
+
+//streamLink returns the stream a member has linked from the named provider, creating the stream if it doesn't exist
+//yet
+func (s *SQLiteStore) streamLink(tx *sql.Tx, member *guildmodels.MemberData, provider string) (*guildmodels.StreamChannel, error) {
+	channelID := member.Connections.StreamLinks[provider]
+	if channelID == "" {
+		return nil, nil
+	}
+	return s.streamChannel(tx, provider, channelID)
+}
+
+//updateStream applies an update to a stored stream, doing nothing if it doesn't exist
+func (s *SQLiteStore) updateStream(what, provider, channelID string, update func(stream *guildmodels.StreamChannel)) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	return s.tx(what+" for stream "+key, func(tx *sql.Tx) error {
+		var stream guildmodels.StreamChannel
+		exists, err := sqliteStreams.get(tx, &stream, key)
+		if err != nil || !exists {
+			return err
+		}
+		update(&stream)
+		return sqliteStreams.put(tx, &stream, key)
+	})
+}
+
+//GetMemberData returns the stored data for a given member, or nil if nothing has been stored for them
+func (s *SQLiteStore) GetMemberData(guildID, userID string) (*guildmodels.MemberData, error) {
+	var member guildmodels.MemberData
+	exists, err := sqliteMembers.get(s.db, &member, guildID, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get data for member %v:%v due to error %v", guildID, userID, err)
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	return &member, nil
+}
+
+//GetStreamLink returns the channel a given member has linked from the named provider, or nil if they have not
+//linked one.
+func (s *SQLiteStore) GetStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error) {
+	member, err := s.GetMemberData(guildID, userID)
+	if err != nil || member == nil {
+		return nil, err
+	}
+	var stream *guildmodels.StreamChannel
+	err = s.tx("get "+provider+" link", func(tx *sql.Tx) error {
+		stream, err = s.streamLink(tx, member, provider)
+		return err
+	})
+	return stream, err
+}
+
+//GetMembersByStream looks up the members who have linked the given channel, optionally limited to a single guild.
+func (s *SQLiteStore) GetMembersByStream(provider, channelID string, guildID *string) ([]guildmodels.MemberData, error) {
+	var inGuild []string
+	if guildID != nil {
+		inGuild = []string{*guildID}
+	}
+	return s.findMembers("look up members linked to "+provider+" channel "+channelID, func(member *guildmodels.MemberData) bool {
+		return member.Connections.StreamLinks[provider] == channelID
+	}, inGuild...)
+}
+
+//SetStreamLink updates the channel a given member has linked from the named provider, returning the new StreamChannel
+//as well as the previously linked one if it was set.
+func (s *SQLiteStore) SetStreamLink(guildID, userID, provider, channelID string) (*guildmodels.StreamChannel, *guildmodels.StreamChannel, error) {
+	var oldStream, stream *guildmodels.StreamChannel
+	err := s.tx("set "+provider+" link for member "+guildID+":"+userID, func(tx *sql.Tx) error {
+		var err error
+		stream, err = s.streamChannel(tx, provider, channelID)
+		if err != nil {
+			return err
+		}
+		member := guildmodels.MemberData{
+			GuildID: guildID,
+			UserID:  userID,
+		}
+		_, err = sqliteMembers.get(tx, &member, guildID, userID)
+		if err != nil {
+			return err
+		}
+		oldStream, err = s.streamLink(tx, &member, provider)
+		if err != nil {
+			return err
+		}
+		if member.Connections.StreamLinks == nil {
+			member.Connections.StreamLinks = make(map[string]string)
+		}
+		member.Connections.StreamLinks[provider] = channelID
+		return sqliteMembers.put(tx, &member, guildID, userID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return oldStream, stream, nil
+}
+
+//updateMember applies an update to a stored member, creating the member first if create is set
+func (s *SQLiteStore) updateMember(what, guildID, userID string, create bool, update func(member *guildmodels.MemberData)) error {
+	return s.tx(what+" for member "+guildID+":"+userID, func(tx *sql.Tx) error {
+		member := guildmodels.MemberData{
+			GuildID: guildID,
+			UserID:  userID,
+		}
+		exists, err := sqliteMembers.get(tx, &member, guildID, userID)
+		if err != nil || !(exists || create) {
+			return err
+		}
+		update(&member)
+		return sqliteMembers.put(tx, &member, guildID, userID)
+	})
+}
+
+//SetMemberHideSchedule sets whether a member's stream schedule should be left out of their guild's scheduled events
+func (s *SQLiteStore) SetMemberHideSchedule(guildID, userID string, hide bool) error {
+	return s.updateMember("update schedule setting", guildID, userID, true, func(member *guildmodels.MemberData) {
+		member.HideSchedule = hide
+	})
+}
+
+//RemoveStreamLink removes the link to the named provider for a given member, returning the StreamChannel they were
+//previously linked to. If the member had no link to that provider, nil will be returned.
+func (s *SQLiteStore) RemoveStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error) {
+	oldStream, err := s.GetStreamLink(guildID, userID, provider)
+	if err != nil || oldStream == nil {
+		return nil, err
+	}
+	err = s.updateMember("remove "+provider+" link", guildID, userID, false, func(member *guildmodels.MemberData) {
+		delete(member.Connections.StreamLinks, provider)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oldStream, nil
+}
+
+//GetGuildStreamLinks returns the member data for every member of a guild who has linked at least one stream
+func (s *SQLiteStore) GetGuildStreamLinks(guildID string) ([]guildmodels.MemberData, error) {
+	return s.findMembers("look up stream links for guild "+guildID, func(member *guildmodels.MemberData) bool {
+		return len(member.Connections.StreamLinks) > 0
+	}, guildID)
+}
+
+//GetAllStreamChannelIDs returns a list of the IDs of every channel from the named provider that has been registered by
+//members or followed by guilds
+func (s *SQLiteStore) GetAllStreamChannelIDs(provider string) ([]string, error) {
+	var res []string
+	err := s.tx("enumerate "+provider+" channels", func(tx *sql.Tx) error {
+		return sqliteStreams.each(tx, func(key []string, doc []byte) error {
+			var stream guildmodels.StreamChannel
+			err := json.Unmarshal(doc, &stream)
+			if err == nil && stream.Provider == provider {
+				res = append(res, stream.ChannelID)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//GetStreamChannel returns a StreamChannel struct for the channel with the provided ID on the named provider. If it
+//does not exist, a new one will be created and returned.
+func (s *SQLiteStore) GetStreamChannel(provider, channelID string) (*guildmodels.StreamChannel, error) {
+	var stream *guildmodels.StreamChannel
+	err := s.tx("get stream struct for "+guildmodels.StreamKey(provider, channelID), func(tx *sql.Tx) error {
+		var err error
+		stream, err = s.streamChannel(tx, provider, channelID)
+		return err
+	})
+	return stream, err
+}
+
+//GetStreamChannels returns the stored StreamChannel for each of the provided stream keys which exists, keyed by
+//stream key
+func (s *SQLiteStore) GetStreamChannels(keys []string) (map[string]*guildmodels.StreamChannel, error) {
+	res := make(map[string]*guildmodels.StreamChannel, len(keys))
+	err := s.tx("look up streams", func(tx *sql.Tx) error {
+		for _, key := range keys {
+			var stream guildmodels.StreamChannel
+			exists, err := sqliteStreams.get(tx, &stream, key)
+			if err != nil {
+				return err
+			} else if exists {
+				res[key] = &stream
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//SetStreamChannelLogin records the login name of a channel so that it can be displayed without querying its provider
+func (s *SQLiteStore) SetStreamChannelLogin(provider, channelID, login string) error {
+	return s.updateStream("set login name", provider, channelID, func(stream *guildmodels.StreamChannel) {
+		stream.Login = login
+	})
+}
+
+//DeleteStreamChannel removes a channel from the database, along with any member links to it
+func (s *SQLiteStore) DeleteStreamChannel(provider, channelID string) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	return s.tx("delete stream "+key, func(tx *sql.Tx) error {
+		err := sqliteMembers.each(tx, func(memberKey []string, doc []byte) error {
+			var member guildmodels.MemberData
+			err := json.Unmarshal(doc, &member)
+			if err != nil || member.Connections.StreamLinks[provider] != channelID {
+				return err
+			}
+			delete(member.Connections.StreamLinks, provider)
+			return sqliteMembers.put(tx, &member, memberKey...)
+		})
+		if err != nil {
+			return err
+		}
+		_, err = sqliteStreams.delete(tx, key)
+		return err
+	})
+}
+
+//AddDiscordStatusPost inserts a message reference into the status posts array for the provided channel in the database
+func (s *SQLiteStore) AddDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error {
+	return s.updateStream("insert discord status post", provider, channelID, func(stream *guildmodels.StreamChannel) {
+		for _, existing := range stream.DiscordStatusPosts {
+			if existing == *post {
+				return
+			}
+		}
+		stream.DiscordStatusPosts = append(stream.DiscordStatusPosts, *post)
+	})
+}
+
+//RemoveDiscordStatusPost removes the given message reference from the status posts array for the provided channel in
+//the database
+func (s *SQLiteStore) RemoveDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error {
+	return s.updateStream("remove discord status post", provider, channelID, func(stream *guildmodels.StreamChannel) {
+		kept := stream.DiscordStatusPosts[:0:0]
+		for _, existing := range stream.DiscordStatusPosts {
+			if existing != *post {
+				kept = append(kept, existing)
+			}
+		}
+		stream.DiscordStatusPosts = kept
+	})
+}
+
+//ClearDiscordStatusPosts removes all message references from the status posts array for the provided channel in the
+//database
+func (s *SQLiteStore) ClearDiscordStatusPosts(provider, channelID string) error {
+	return s.updateStream("remove discord status posts", provider, channelID, func(stream *guildmodels.StreamChannel) {
+		stream.DiscordStatusPosts = nil
+	})
+}
+
+//SetStreamChannelLive updates the database to reflect whether the provided channel is live or not.
+func (s *SQLiteStore) SetStreamChannelLive(provider, channelID string, isLive bool) error {
+	return s.updateStream("update live state", provider, channelID, func(stream *guildmodels.StreamChannel) {
+		stream.IsLive = isLive
+	})
+}
+
+//SetStreamChannelFollowers records the latest follower count of a stream, returning the previously recorded count
+func (s *SQLiteStore) SetStreamChannelFollowers(provider, channelID string, followers int) (int, error) {
+	var old int
+	err := s.updateStream("update follower count", provider, channelID, func(stream *guildmodels.StreamChannel) {
+		old = stream.Followers
+		stream.Followers = followers
+	})
+	if err != nil {
+		return 0, err
+	}
+	return old, nil
+}
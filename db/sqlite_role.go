@@ -0,0 +1,96 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/callummance/nia/guildmodels"
+)
+
+//findRoleRules returns every stored rule in a guild which matches a filter
+func (s *SQLiteStore) findRoleRules(what, guildID string, filter func(rule *guildmodels.ManagedRoleRule) bool) ([]guildmodels.ManagedRoleRule, error) {
+	var res []guildmodels.ManagedRoleRule
+	err := s.tx(what, func(tx *sql.Tx) error {
+		return sqliteRoleRules.each(tx, func(key []string, doc []byte) error {
+			var rule guildmodels.ManagedRoleRule
+			err := json.Unmarshal(doc, &rule)
+			if err == nil && filter(&rule) {
+				res = append(res, rule)
+			}
+			return err
+		}, guildID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//AddManagedRoleRule inserts a new managed role rule struct into the database
+func (s *SQLiteStore) AddManagedRoleRule(rule guildmodels.ManagedRoleRule) error {
+	return s.tx("insert managed role rule", func(tx *sql.Tx) error {
+		return sqliteRoleRules.put(tx, &rule, rule.GuildID, newDocID())
+	})
+}
+
+//LookupNowLiveRoles returns a list of all roles in the given server which should be assigned when a member is online on a streaming
+//platform.
+func (s *SQLiteStore) LookupNowLiveRoles(guildID string) ([]guildmodels.ManagedRoleRule, error) {
+	return s.findRoleRules("look up streaming assigned roles for guild "+guildID, guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		return rule.RoleAssignment.AssignmentType == "nowlive"
+	})
+}
+
+//LookupRolesByEmote takes a message ID as well as its channel and guild, along with an emoji ID.
+//It then returns any managed role rules that include that reaction.
+func (s *SQLiteStore) LookupRolesByEmote(msgID string, chanID string, guildID string, emojiID string) ([]guildmodels.ManagedRoleRule, error) {
+	return s.findRoleRules("look up role rules for emote "+emojiID, guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		opts := rule.RoleAssignment.ReactionRoleData
+		return rule.RoleAssignment.AssignmentType == "reaction" && opts != nil &&
+			opts.MsgID == msgID && opts.ChanID == chanID && opts.EmojiID == emojiID
+	})
+}
+
+//GetGuildRolesWithInitialReact takes a guild ID and returns a slice of all role assignment rules for that server
+//that both use reactions for role assignment and for which the bost should make an initial reaction.
+func (s *SQLiteStore) GetGuildRolesWithInitialReact(guildID string) ([]guildmodels.ManagedRoleRule, error) {
+	return s.findRoleRules("look up roles with initial reaction for guild "+guildID, guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		opts := rule.RoleAssignment.ReactionRoleData
+		return rule.RoleAssignment.AssignmentType == "reaction" && opts != nil && opts.BotShouldReact
+	})
+}
+
+//GetRoleRules returns all role assignment rules for a given role in a given server
+func (s *SQLiteStore) GetRoleRules(guildID string, roleID string) ([]guildmodels.ManagedRoleRule, error) {
+	return s.findRoleRules("look up rules for role "+roleID, guildID, func(rule *guildmodels.ManagedRoleRule) bool {
+		return rule.RoleID == roleID
+	})
+}
+
+//DeleteRoleRules removes every role assignment rule for a given role in a given server, returning the number of rules
+//deleted
+func (s *SQLiteStore) DeleteRoleRules(guildID string, roleID string) (int, error) {
+	deleted := 0
+	err := s.tx("delete rules for role "+roleID, func(tx *sql.Tx) error {
+		return sqliteRoleRules.each(tx, func(key []string, doc []byte) error {
+			var rule guildmodels.ManagedRoleRule
+			err := json.Unmarshal(doc, &rule)
+			if err != nil || rule.RoleID != roleID {
+				return err
+			}
+			n, err := sqliteRoleRules.delete(tx, key...)
+			deleted += n
+			return err
+		}, guildID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+//IsManagedRole returns true iff we have any rules stored for the given roleID in the given guildID
+func (s *SQLiteStore) IsManagedRole(guildID string, roleID string) (bool, error) {
+	rules, err := s.GetRoleRules(guildID, roleID)
+	return len(rules) > 0, err
+}
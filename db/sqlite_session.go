@@ -0,0 +1,236 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+//updateSessions applies an update to every stored session matching a filter, returning the number updated
+func (s *SQLiteStore) updateSessions(tx *sql.Tx, filter func(session *guildmodels.StreamSession) bool, update func(session *guildmodels.StreamSession)) (int, error) {
+	updated := 0
+	err := sqliteSessions.each(tx, func(key []string, doc []byte) error {
+		var session guildmodels.StreamSession
+		err := json.Unmarshal(doc, &session)
+		if err != nil || !filter(&session) {
+			return err
+		}
+		update(&session)
+		updated++
+		return sqliteSessions.put(tx, &session, key...)
+	})
+	return updated, err
+}
+
+//findSessions returns every stored session matching a filter
+func (s *SQLiteStore) findSessions(what string, filter func(session *guildmodels.StreamSession) bool) ([]guildmodels.StreamSession, error) {
+	var res []guildmodels.StreamSession
+	err := s.tx(what, func(tx *sql.Tx) error {
+		return sqliteSessions.each(tx, func(key []string, doc []byte) error {
+			var session guildmodels.StreamSession
+			err := json.Unmarshal(doc, &session)
+			if err == nil && filter(&session) {
+				res = append(res, session)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//openSessionsOf selects the sessions of a stream which have not yet ended
+func openSessionsOf(streamKey string) func(session *guildmodels.StreamSession) bool {
+	return func(session *guildmodels.StreamSession) bool {
+		return session.StreamKey == streamKey && session.EndedAt == nil
+	}
+}
+
+//addSample returns an update which adds a viewer sample to a session, noting the stream's title and game if they
+//haven't been seen before
+func addSample(title string, sample *guildmodels.ViewerSample) func(session *guildmodels.StreamSession) {
+	return func(session *guildmodels.StreamSession) {
+		session.Samples = append(session.Samples, *sample)
+		if title != "" {
+			setInsert(&session.Titles, title)
+		}
+		if sample.Game != "" {
+			setInsert(&session.Games, sample.Game)
+		}
+	}
+}
+
+//StartStreamSession records that a stream has gone live, along with its first viewer sample. If the stream already
+//has a session which hasn't ended (eg. because of a repeated online event), the sample is added to it instead.
+func (s *SQLiteStore) StartStreamSession(provider, channelID string, startedAt time.Time, title string, sample *guildmodels.ViewerSample) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	return s.tx("record start of stream session for "+key, func(tx *sql.Tx) error {
+		updated, err := s.updateSessions(tx, openSessionsOf(key), addSample(title, sample))
+		if err != nil || updated > 0 {
+			return err
+		}
+		session := guildmodels.StreamSession{
+			ID:        newDocID(),
+			StreamKey: key,
+			Provider:  provider,
+			ChannelID: channelID,
+			StartedAt: startedAt,
+			Samples:   []guildmodels.ViewerSample{*sample},
+		}
+		if title != "" {
+			session.Titles = []string{title}
+		}
+		if sample.Game != "" {
+			session.Games = []string{sample.Game}
+		}
+		return sqliteSessions.put(tx, &session, session.ID)
+	})
+}
+
+//AddStreamSessionSample adds a viewer sample to the session for a stream which hasn't ended yet, noting the stream's
+//current title and game if they haven't been seen before
+func (s *SQLiteStore) AddStreamSessionSample(provider, channelID, title string, sample *guildmodels.ViewerSample) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	return s.tx("add viewer sample to stream session for "+key, func(tx *sql.Tx) error {
+		_, err := s.updateSessions(tx, openSessionsOf(key), addSample(title, sample))
+		return err
+	})
+}
+
+//EndStreamSession records that a stream has gone offline, ending any of its sessions which haven't already ended
+func (s *SQLiteStore) EndStreamSession(provider, channelID string, endedAt time.Time) error {
+	key := guildmodels.StreamKey(provider, channelID)
+	return s.tx("record end of stream session for "+key, func(tx *sql.Tx) error {
+		_, err := s.updateSessions(tx, openSessionsOf(key), func(session *guildmodels.StreamSession) {
+			session.EndedAt = &endedAt
+		})
+		return err
+	})
+}
+
+//GetOpenStreamSessions returns every session which hasn't ended yet, optionally limited to a single stream key
+func (s *SQLiteStore) GetOpenStreamSessions(streamKey *string) ([]guildmodels.StreamSession, error) {
+	return s.findSessions("look up open stream sessions", func(session *guildmodels.StreamSession) bool {
+		return session.EndedAt == nil && (streamKey == nil || session.StreamKey == *streamKey)
+	})
+}
+
+//GetStreamSessions returns the sessions of each of the provided streams which were live at any point after since,
+//ordered by start time
+func (s *SQLiteStore) GetStreamSessions(streamKeys []string, since time.Time) ([]guildmodels.StreamSession, error) {
+	if len(streamKeys) == 0 {
+		return nil, nil
+	}
+	wanted := make(map[string]bool, len(streamKeys))
+	for _, key := range streamKeys {
+		wanted[key] = true
+	}
+	sessions, err := s.findSessions("look up stream sessions", func(session *guildmodels.StreamSession) bool {
+		return wanted[session.StreamKey] && (session.EndedAt == nil || !session.EndedAt.Before(since))
+	})
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions, err
+}
+
+//GetGuildScheduledEvents returns every scheduled event which has been created for a stream schedule in a guild
+func (s *SQLiteStore) GetGuildScheduledEvents(gid string) ([]guildmodels.ScheduledEventLink, error) {
+	var res []guildmodels.ScheduledEventLink
+	err := s.tx("look up scheduled events for guild "+gid, func(tx *sql.Tx) error {
+		return sqliteScheduledEvents.each(tx, func(key []string, doc []byte) error {
+			var link guildmodels.ScheduledEventLink
+			err := json.Unmarshal(doc, &link)
+			res = append(res, link)
+			return err
+		}, gid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//SetScheduledEventLink stores the details of a scheduled event, replacing any previously stored for the same stream
+func (s *SQLiteStore) SetScheduledEventLink(link *guildmodels.ScheduledEventLink) error {
+	return s.tx("store scheduled event "+link.ScheduleKey, func(tx *sql.Tx) error {
+		return sqliteScheduledEvents.put(tx, link, link.GuildID, link.ScheduleKey)
+	})
+}
+
+//DeleteScheduledEventLink forgets about the scheduled event for a stream in a guild
+func (s *SQLiteStore) DeleteScheduledEventLink(gid, scheduleKey string) error {
+	return s.tx("delete scheduled event "+scheduleKey, func(tx *sql.Tx) error {
+		_, err := sqliteScheduledEvents.delete(tx, gid, scheduleKey)
+		return err
+	})
+}
+
+//GetPostedClips returns the set of the provided clip IDs which have already been posted in a guild
+func (s *SQLiteStore) GetPostedClips(gid string, clipIDs []string) (map[string]bool, error) {
+	posted := make(map[string]bool)
+	err := s.tx("look up posted clips in guild "+gid, func(tx *sql.Tx) error {
+		for _, clipID := range clipIDs {
+			var clip guildmodels.PostedClip
+			exists, err := sqlitePostedClips.get(tx, &clip, gid, clipID)
+			if err != nil {
+				return err
+			} else if exists {
+				posted[clipID] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posted, nil
+}
+
+//AddPostedClip records that a clip has been posted in a guild
+func (s *SQLiteStore) AddPostedClip(clip *guildmodels.PostedClip) error {
+	return s.tx("record posted clip "+clip.ClipID, func(tx *sql.Tx) error {
+		return sqlitePostedClips.put(tx, clip, clip.GuildID, clip.ClipID)
+	})
+}
+
+//PrunePostedClips forgets about posted clips which were created before the provided time
+func (s *SQLiteStore) PrunePostedClips(before time.Time) error {
+	return s.tx("prune old posted clips", func(tx *sql.Tx) error {
+		return sqlitePostedClips.each(tx, func(key []string, doc []byte) error {
+			var clip guildmodels.PostedClip
+			err := json.Unmarshal(doc, &clip)
+			if err != nil || !clip.CreatedAt.Before(before) {
+				return err
+			}
+			_, err = sqlitePostedClips.delete(tx, key...)
+			return err
+		})
+	})
+}
+
+//GetWebhookSecret retrieves the webhook secret with the provided ID, returning nil if it hasn't been saved yet
+func (s *SQLiteStore) GetWebhookSecret(id string) (*guildmodels.WebhookSecret, error) {
+	var secret guildmodels.WebhookSecret
+	exists, err := sqliteSecrets.get(s.db, &secret, id)
+	if err != nil {
+		logrus.Warnf("Failed to look up webhook secret %v due to error %v", id, err)
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	return &secret, nil
+}
+
+//SetWebhookSecret saves a webhook secret, replacing any existing secret with the same ID
+func (s *SQLiteStore) SetWebhookSecret(secret *guildmodels.WebhookSecret) error {
+	return s.tx("save webhook secret "+secret.ID, func(tx *sql.Tx) error {
+		return sqliteSecrets.put(tx, secret, secret.ID)
+	})
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	//Registers the pure-go "sqlite" driver, so that the bot can still be built without cgo
+	_ "modernc.org/sqlite"
+)
+
+//dbPathEnvVar sets the file the sqlite backend stores its data in
+const dbPathEnvVar string = "NIA_DB_PATH"
+const dbPathDefault string = "nia.db"
+
+//SQLiteStore stores the bot's data in a single SQLite file. Each table holds the same documents as the matching
+//RethinkDB table, encoded as JSON in a doc column alongside the columns making up their ID, so that they can be
+//inspected with the sqlite3 shell and its JSON functions.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+//sqliteTable describes a table of JSON documents, keyed by one or more text columns
+type sqliteTable struct {
+	name string
+	keys []string
+}
+
+var (
+	sqliteGuilds          = sqliteTable{guildsTable, []string{"id"}}
+	sqliteRoleRules       = sqliteTable{guildRolesTable, []string{"guild_id", "id"}}
+	sqliteMembers         = sqliteTable{membersTable, []string{"guild_id", "user_id"}}
+	sqliteStreams         = sqliteTable{streamsTable, []string{"id"}}
+	sqliteSessions        = sqliteTable{sessionsTable, []string{"id"}}
+	sqliteScheduledEvents = sqliteTable{scheduledEventsTable, []string{"guild_id", "schedule_key"}}
+	sqlitePostedClips     = sqliteTable{postedClipsTable, []string{"guild_id", "clip_id"}}
+	sqliteSecrets         = sqliteTable{secretsTable, []string{"id"}}
+)
+
+var sqliteTables = []sqliteTable{
+	sqliteGuilds,
+	sqliteRoleRules,
+	sqliteMembers,
+	sqliteStreams,
+	sqliteSessions,
+	sqliteScheduledEvents,
+	sqlitePostedClips,
+	sqliteSecrets,
+}
+
+//querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//InitSQLite opens the SQLite database at the path provided by the relevant environment variable
+func InitSQLite() (*SQLiteStore, error) {
+	path, exists := os.LookupEnv(dbPathEnvVar)
+	if !exists {
+		logrus.Warnf("DB path was not provided, falling back to default `%v`", dbPathDefault)
+		path = dbPathDefault
+	}
+	return OpenSQLite(path)
+}
+
+//OpenSQLite opens the SQLite database in the file at path, creating it and any missing tables if needed
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		logrus.Errorf("Failed to open sqlite database %v because %v.", path, err)
+		return nil, fmt.Errorf("failed to open sqlite database %v because %v", path, err)
+	}
+	//Writes are serialized by sqlite anyway, and a single connection means they never have to wait for a lock
+	db.SetMaxOpenConns(1)
+	res := &SQLiteStore{
+		db: db,
+	}
+	err = res.createTables()
+	if err != nil {
+		_ = db.Close()
+		logrus.Errorf("Failed to set up sqlite database %v because %v.", path, err)
+		return nil, fmt.Errorf("failed to set up sqlite database %v because %v", path, err)
+	}
+	return res, nil
+}
+
+//Close cleanly closes the database file
+func (s *SQLiteStore) Close() {
+	logrus.Info("Closing sqlite DB...")
+	_ = s.db.Close()
+}
+
+func (s *SQLiteStore) createTables() error {
+	_, err := s.db.Exec("PRAGMA journal_mode = WAL")
+	if err != nil {
+		return err
+	}
+	for _, t := range sqliteTables {
+		_, err := s.db.Exec(t.createQuery())
+		if err != nil {
+			return fmt.Errorf("failed to create %v table: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+//tx runs f in a transaction, which is committed if it returns no error and rolled back otherwise. what describes
+//the transaction for logging.
+func (s *SQLiteStore) tx(what string, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err == nil {
+		err = f(tx)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			_ = tx.Rollback()
+		}
+	}
+	if err != nil {
+		logrus.Warnf("Failed to %v due to error %v", what, err)
+	}
+	return err
+}
+
+//newDocID generates a random ID for documents which don't have a natural one, in the same form as RethinkDB's
+func newDocID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		logrus.Panicf("Failed to generate document ID due to error %v", err)
+	}
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (t sqliteTable) createQuery() string {
+	cols := make([]string, 0, len(t.keys)+1)
+	for _, key := range t.keys {
+		cols = append(cols, key+" TEXT NOT NULL")
+	}
+	cols = append(cols, "doc TEXT NOT NULL")
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v, PRIMARY KEY (%v))", t.name, strings.Join(cols, ", "), strings.Join(t.keys, ", "))
+}
+
+//where returns a condition matching the first len(key) key columns, along with its arguments
+func (t sqliteTable) where(key []string) (string, []interface{}) {
+	if len(key) > len(t.keys) {
+		logrus.Panicf("Too many key columns %v for table %v", key, t.name)
+	}
+	if len(key) == 0 {
+		return "1", nil
+	}
+	conds := make([]string, 0, len(key))
+	args := make([]interface{}, 0, len(key))
+	for i, val := range key {
+		conds = append(conds, t.keys[i]+" = ?")
+		args = append(args, val)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+//get decodes the document with the given key into dst, returning false if it doesn't exist
+func (t sqliteTable) get(q querier, dst interface{}, key ...string) (bool, error) {
+	cond, args := t.where(key)
+	var doc []byte
+	err := q.QueryRow(fmt.Sprintf("SELECT doc FROM %v WHERE %v", t.name, cond), args...).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(doc, dst)
+}
+
+//put stores a document under the given key, replacing any existing document
+func (t sqliteTable) put(q querier, doc interface{}, key ...string) error {
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, 0, len(key)+1)
+	for _, val := range key {
+		args = append(args, val)
+	}
+	args = append(args, string(encoded))
+	placeholders := strings.Repeat("?, ", len(key)) + "?"
+	_, err = q.Exec(fmt.Sprintf("INSERT OR REPLACE INTO %v (%v, doc) VALUES (%v)", t.name, strings.Join(t.keys, ", "), placeholders), args...)
+	return err
+}
+
+//delete removes every document whose key starts with the given columns, returning the number removed
+func (t sqliteTable) delete(q querier, key ...string) (int, error) {
+	cond, args := t.where(key)
+	res, err := q.Exec(fmt.Sprintf("DELETE FROM %v WHERE %v", t.name, cond), args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+//each calls visit with the key and encoded document of every document whose key starts with the given columns
+func (t sqliteTable) each(q querier, visit func(key []string, doc []byte) error, key ...string) error {
+	cond, args := t.where(key)
+	rows, err := q.Query(fmt.Sprintf("SELECT %v, doc FROM %v WHERE %v", strings.Join(t.keys, ", "), t.name, cond), args...)
+	if err != nil {
+		return err
+	}
+	//Documents are collected before visiting them, so that visit can run other queries on the same connection
+	type row struct {
+		key []string
+		doc []byte
+	}
+	var found []row
+	for rows.Next() {
+		r := row{key: make([]string, len(t.keys))}
+		dests := make([]interface{}, 0, len(t.keys)+1)
+		for i := range r.key {
+			dests = append(dests, &r.key[i])
+		}
+		dests = append(dests, &r.doc)
+		err = rows.Scan(dests...)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		found = append(found, r)
+	}
+	err = rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range found {
+		err = visit(r.key, r.doc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
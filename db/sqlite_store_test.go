@@ -0,0 +1,19 @@
+package db_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/callummance/nia/db"
+	"github.com/callummance/nia/db/dbtest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	dbtest.RunStoreTests(t, func(t *testing.T) db.Store {
+		s, err := db.OpenSQLite(filepath.Join(t.TempDir(), "nia.db"))
+		if err != nil {
+			t.Fatalf("failed to open sqlite database: %v", err)
+		}
+		return s
+	})
+}
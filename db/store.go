@@ -0,0 +1,132 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+)
+
+//dbBackendEnvVar selects where the bot's data is stored. It can be "rethinkdb" (the default), "sqlite", which stores
+//everything in a single file, or "memory", which keeps everything in memory and loses it when the bot stops.
+const dbBackendEnvVar string = "NIA_DB_BACKEND"
+
+//Names of the available storage backends
+const (
+	BackendRethinkDB = "rethinkdb"
+	BackendSQLite    = "sqlite"
+	BackendMemory    = "memory"
+)
+
+//Store contains every operation the bot uses to store and retrieve its data. Connection stores data in RethinkDB,
+//SQLiteStore stores it in a single file and MemoryStore keeps it in memory; the dbtest package checks that they all
+//behave the same way.
+type Store interface {
+	Close()
+
+	//Guilds
+	GetOrCreateGuild(id string) (*guildmodels.DiscordGuild, error)
+	CreateGuild(gid string) (bool, error)
+	AddAdminRole(gid string, roleID string) (int, error)
+	RemoveAdminRole(gid string, roleID string) (int, error)
+	SetGuildAlertRoutes(gid string, routes []guildmodels.StreamAlertRoute) error
+	UpdateGuildAlertTemplate(gid string, tmpl *guildmodels.AlertTemplate) error
+	AddGuildFollowedStream(gid, streamKey string) (int, error)
+	RemoveGuildFollowedStream(gid, streamKey string) (int, error)
+	GetGuildsFollowingStream(streamKey string) ([]guildmodels.DiscordGuild, error)
+	SetGuildFollowedTeam(gid string, team *guildmodels.FollowedTeam) error
+	RemoveGuildFollowedTeam(gid, teamName string) (int, error)
+	GetGuildsFollowingTeams() ([]guildmodels.DiscordGuild, error)
+	AddGuildFollowedCategory(gid string, category *guildmodels.FollowedCategory) (int, error)
+	RemoveGuildFollowedCategory(gid, categoryID string) (int, error)
+	SetGuildVerifiedLinks(gid string, enabled bool) error
+	SetGuildPresenceStreaming(gid string, enabled bool) error
+	SetGuildScheduledEvents(gid string, enabled bool) error
+	GetGuildsWithScheduledEvents() ([]guildmodels.DiscordGuild, error)
+	SetGuildAnnouncement(gid, eventType string, announcement *guildmodels.Announcement) error
+	SetGuildClipsFeed(gid string, feed *guildmodels.ClipsFeed) error
+	GetGuildsWithClipsFeed() ([]guildmodels.DiscordGuild, error)
+	SetGuildLeftAt(gid string, leftAt *time.Time) error
+	GetActiveGuildIDs() ([]string, error)
+	GetInactiveGuildIDs() (map[string]bool, error)
+	GetGuildsLeftBefore(before time.Time) ([]guildmodels.DiscordGuild, error)
+	PurgeGuild(gid string) error
+
+	//Managed role rules
+	AddManagedRoleRule(rule guildmodels.ManagedRoleRule) error
+	LookupNowLiveRoles(guildID string) ([]guildmodels.ManagedRoleRule, error)
+	LookupRolesByEmote(msgID string, chanID string, guildID string, emojiID string) ([]guildmodels.ManagedRoleRule, error)
+	GetGuildRolesWithInitialReact(guildID string) ([]guildmodels.ManagedRoleRule, error)
+	GetRoleRules(guildID string, roleID string) ([]guildmodels.ManagedRoleRule, error)
+	DeleteRoleRules(guildID string, roleID string) (int, error)
+	IsManagedRole(guildID string, roleID string) (bool, error)
+
+	//Members
+	GetMemberData(guildID, userID string) (*guildmodels.MemberData, error)
+	GetStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error)
+	GetMembersByStream(provider, channelID string, guildID *string) ([]guildmodels.MemberData, error)
+	SetStreamLink(guildID, userID, provider, channelID string) (*guildmodels.StreamChannel, *guildmodels.StreamChannel, error)
+	SetMemberHideSchedule(guildID, userID string, hide bool) error
+	RemoveStreamLink(guildID, userID, provider string) (*guildmodels.StreamChannel, error)
+	GetGuildStreamLinks(guildID string) ([]guildmodels.MemberData, error)
+
+	//Streams
+	GetAllStreamChannelIDs(provider string) ([]string, error)
+	GetStreamChannel(provider, channelID string) (*guildmodels.StreamChannel, error)
+	GetStreamChannels(keys []string) (map[string]*guildmodels.StreamChannel, error)
+	SetStreamChannelLogin(provider, channelID, login string) error
+	DeleteStreamChannel(provider, channelID string) error
+	AddDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error
+	RemoveDiscordStatusPost(provider, channelID string, post *guildmodels.MessageRef) error
+	ClearDiscordStatusPosts(provider, channelID string) error
+	SetStreamChannelLive(provider, channelID string, isLive bool) error
+	SetStreamChannelFollowers(provider, channelID string, followers int) (int, error)
+
+	//Stream sessions
+	StartStreamSession(provider, channelID string, startedAt time.Time, title string, sample *guildmodels.ViewerSample) error
+	AddStreamSessionSample(provider, channelID, title string, sample *guildmodels.ViewerSample) error
+	EndStreamSession(provider, channelID string, endedAt time.Time) error
+	GetOpenStreamSessions(streamKey *string) ([]guildmodels.StreamSession, error)
+	GetStreamSessions(streamKeys []string, since time.Time) ([]guildmodels.StreamSession, error)
+
+	//Scheduled events
+	GetGuildScheduledEvents(gid string) ([]guildmodels.ScheduledEventLink, error)
+	SetScheduledEventLink(link *guildmodels.ScheduledEventLink) error
+	DeleteScheduledEventLink(gid, scheduleKey string) error
+
+	//Clips
+	GetPostedClips(gid string, clipIDs []string) (map[string]bool, error)
+	AddPostedClip(clip *guildmodels.PostedClip) error
+	PrunePostedClips(before time.Time) error
+
+	//Webhook secrets
+	GetWebhookSecret(id string) (*guildmodels.WebhookSecret, error)
+	SetWebhookSecret(secret *guildmodels.WebhookSecret) error
+}
+
+var (
+	_ Store = (*Connection)(nil)
+	_ Store = (*SQLiteStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+//Open connects to the storage backend chosen by the relevant environment variable
+func Open() (Store, error) {
+	backend, exists := os.LookupEnv(dbBackendEnvVar)
+	if !exists {
+		backend = BackendRethinkDB
+	}
+	switch backend {
+	case BackendRethinkDB:
+		return Init()
+	case BackendSQLite:
+		return InitSQLite()
+	case BackendMemory:
+		logrus.Warn("Storing data in memory; it will be lost when the bot stops")
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("`%v` must be one of %v, %v or %v, not `%v`", dbBackendEnvVar, BackendRethinkDB, BackendSQLite, BackendMemory, backend)
+	}
+}
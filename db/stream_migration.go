@@ -0,0 +1,120 @@
+package db
+
+import (
+	"github.com/callummance/nia/guildmodels"
+	"github.com/sirupsen/logrus"
+	rethink "gopkg.in/gorethink/gorethink.v3"
+)
+
+//legacyTwitchTable held twitch streams keyed by broadcaster UID before streams from all providers were moved into
+//streamsTable
+const legacyTwitchTable string = "twitch"
+const legacyTwitchProvider string = "twitch"
+
+//pendingTwitchStreams returns the number of documents which migrateTwitchStreams would copy or change
+func (db *Connection) pendingTwitchStreams() (int, error) {
+	existing, err := db.tableList()
+	if err != nil || !existing[legacyTwitchTable] {
+		return 0, err
+	}
+	streams, err := db.count(rethink.Table(legacyTwitchTable))
+	if err != nil {
+		return 0, err
+	}
+	members, err := db.count(rethink.Table(membersTable).Filter(func(member rethink.Term) rethink.Term {
+		return member.Field("connections").HasFields("twitch_link")
+	}))
+	if err != nil {
+		return 0, err
+	}
+	guilds, err := db.count(rethink.Table(guildsTable).Filter(func(guild rethink.Term) rethink.Term {
+		return guild.HasFields("followed_twitch_streams")
+	}))
+	if err != nil {
+		return 0, err
+	}
+	return streams + members + guilds, nil
+}
+
+//migrateTwitchStreams moves data stored in the twitch-only format into the provider-neutral one:
+//
+//  - rows of the twitch table are copied into the streams table, keyed by stream key
+//  - member `connections.twitch_link` fields become `connections.links.twitch`
+//  - guild `followed_twitch_streams` become stream keys in `followed_streams`
+//
+//The twitch table is dropped once everything has been copied, so this does nothing on later runs. Each step is safe
+//to repeat if a previous run was interrupted.
+func (db *Connection) migrateTwitchStreams() error {
+	existing, err := db.tableList()
+	if err != nil {
+		return err
+	} else if !existing[legacyTwitchTable] {
+		return nil
+	}
+	logrus.Infof("Migrating data from legacy `%v` table into `%v` table", legacyTwitchTable, streamsTable)
+	err = rethink.Table(legacyTwitchTable).Wait().Exec(db.session)
+	if err != nil {
+		logrus.Warnf("Failed waiting for legacy twitch table to be ready due to error %v", err)
+		return err
+	}
+	keyPrefix := guildmodels.StreamKey(legacyTwitchProvider, "")
+
+	//Streams
+	_, err = rethink.Table(streamsTable).Insert(
+		rethink.Table(legacyTwitchTable).Map(func(stream rethink.Term) interface{} {
+			return stream.Without("tid").Merge(map[string]interface{}{
+				"id":         rethink.Expr(keyPrefix).Add(stream.Field("tid")),
+				"provider":   legacyTwitchProvider,
+				"channel_id": stream.Field("tid"),
+			})
+		}),
+		rethink.InsertOpts{Conflict: "replace"},
+	).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to copy legacy twitch streams due to error %v", err)
+		return err
+	}
+
+	//Member links
+	_, err = rethink.Table(membersTable).Filter(func(member rethink.Term) rethink.Term {
+		return member.Field("connections").HasFields("twitch_link")
+	}).Replace(func(member rethink.Term) interface{} {
+		return member.Without(map[string]interface{}{
+			"connections": map[string]interface{}{"twitch_link": true},
+		}).Merge(map[string]interface{}{
+			"connections": map[string]interface{}{
+				"links": map[string]interface{}{
+					legacyTwitchProvider: member.Field("connections").Field("twitch_link"),
+				},
+			},
+		})
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to migrate legacy twitch links due to error %v", err)
+		return err
+	}
+
+	//Guild followed streams
+	_, err = rethink.Table(guildsTable).Filter(func(guild rethink.Term) rethink.Term {
+		return guild.HasFields("followed_twitch_streams")
+	}).Replace(func(guild rethink.Term) interface{} {
+		keys := guild.Field("followed_twitch_streams").Map(func(uid rethink.Term) interface{} {
+			return rethink.Expr(keyPrefix).Add(uid)
+		})
+		return guild.Without("followed_twitch_streams").Merge(map[string]interface{}{
+			"followed_streams": guild.Field("followed_streams").Default([]interface{}{}).SetUnion(keys),
+		})
+	}).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to migrate legacy followed twitch streams due to error %v", err)
+		return err
+	}
+
+	_, err = rethink.TableDrop(legacyTwitchTable).RunWrite(db.session)
+	if err != nil {
+		logrus.Warnf("Failed to drop legacy twitch table due to error %v", err)
+		return err
+	}
+	logrus.Info("Finished migrating legacy twitch data")
+	return nil
+}
@@ -0,0 +1,104 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+//API contains the discord operations Nia uses to act on guilds. It is implemented by EventSource, which makes requests
+//to discord, and by fakediscord.API, which keeps everything in memory.
+type API interface {
+	//Messages
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	UserChannelCreate(recipientID string) (*discordgo.Channel, error)
+
+	//Reactions
+	MessageReactionAdd(channelID, messageID, emojiID string) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string) error
+	MessageReactionsRemoveEmoji(channelID, messageID, emojiID string) error
+
+	//Guilds and channels
+	Guild(guildID string) (*discordgo.Guild, error)
+	GuildChannels(guildID string) ([]*discordgo.Channel, error)
+	Channel(channelID string) (*discordgo.Channel, error)
+	MissingPermissions(guildID, channelID string) ([]string, error)
+	PresencesEnabled() bool
+
+	//Roles and members
+	GuildRoles(guildID string) ([]*discordgo.Role, error)
+	GuildMember(guildID, userID string) (*discordgo.Member, error)
+	GuildMembersWithRole(guildID, roleID string) ([]*discordgo.Member, error)
+	GuildMemberRoleAdd(guildID, userID, roleID string) error
+	GuildMemberRoleRemove(guildID, userID, roleID string) error
+
+	//Scheduled events
+	GuildScheduledEventCreate(gid string, ev *ScheduledEvent) (*ScheduledEvent, error)
+	GuildScheduledEventEdit(gid, eventID string, ev *ScheduledEvent) (*ScheduledEvent, error)
+	GuildScheduledEventSetStatus(gid, eventID string, status ScheduledEventStatus) error
+}
+
+var _ API = (*EventSource)(nil)
+
+//ChannelMessageSendComplex sends a message to a channel
+func (d *EventSource) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	return d.Session().ChannelMessageSendComplex(channelID, data)
+}
+
+//ChannelMessageSendEmbed sends a message containing only an embed to a channel
+func (d *EventSource) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	return d.Session().ChannelMessageSendEmbed(channelID, embed)
+}
+
+//ChannelMessageDelete deletes a message
+func (d *EventSource) ChannelMessageDelete(channelID, messageID string) error {
+	return d.Session().ChannelMessageDelete(channelID, messageID)
+}
+
+//UserChannelCreate opens a direct message channel with a user
+func (d *EventSource) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	return d.Session().UserChannelCreate(recipientID)
+}
+
+//MessageReactionAdd reacts to a message as the bot
+func (d *EventSource) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	return d.Session().MessageReactionAdd(channelID, messageID, emojiID)
+}
+
+//MessageReactionRemove removes a user's reaction from a message
+func (d *EventSource) MessageReactionRemove(channelID, messageID, emojiID, userID string) error {
+	return d.Session().MessageReactionRemove(channelID, messageID, emojiID, userID)
+}
+
+//MessageReactionsRemoveEmoji removes every reaction with the given emoji from a message
+func (d *EventSource) MessageReactionsRemoveEmoji(channelID, messageID, emojiID string) error {
+	return d.Session().MessageReactionsRemoveEmoji(channelID, messageID, emojiID)
+}
+
+//Guild looks up a guild, using the state of the shard which receives its events where possible
+func (d *EventSource) Guild(guildID string) (*discordgo.Guild, error) {
+	if g, err := d.GuildSession(guildID).State.Guild(guildID); err == nil {
+		return g, nil
+	}
+	return d.Session().Guild(guildID)
+}
+
+//GuildChannels returns the channels of a guild
+func (d *EventSource) GuildChannels(guildID string) ([]*discordgo.Channel, error) {
+	return d.Session().GuildChannels(guildID)
+}
+
+//Channel looks up a channel
+func (d *EventSource) Channel(channelID string) (*discordgo.Channel, error) {
+	return d.Session().Channel(channelID)
+}
+
+//GuildMemberRoleAdd gives a member of a guild a role
+func (d *EventSource) GuildMemberRoleAdd(guildID, userID, roleID string) error {
+	return d.Session().GuildMemberRoleAdd(guildID, userID, roleID)
+}
+
+//GuildMemberRoleRemove takes a role away from a member of a guild
+func (d *EventSource) GuildMemberRoleRemove(guildID, userID, roleID string) error {
+	return d.Session().GuildMemberRoleRemove(guildID, userID, roleID)
+}
@@ -1,33 +1,57 @@
 package discord
 
 import (
+	"expvar"
 	"fmt"
 	"net/url"
 	"os"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/dispatch"
 	"github.com/sirupsen/logrus"
 )
 
 const discordTokenEnvVar = "NIA_DISCORD_BOT_TOKEN"
+
+//presenceIntentEnvVar enables the privileged presence intent, which must also be turned on for the bot in the discord
+//developer portal
+const presenceIntentEnvVar = "NIA_DISCORD_PRESENCE_INTENT"
+
+//membersIntentEnvVar enables the privileged server members intent, which must also be turned on for the bot in the
+//discord developer portal. Member join, leave and update events are only received if it is set, and guild members are
+//only cached if they can be kept up to date by those events.
+const membersIntentEnvVar = "NIA_DISCORD_MEMBERS_INTENT"
 const botScope = "bot"
-const permissions = discordgo.PermissionAllText | discordgo.PermissionAllChannel
+const permissions = discordgo.PermissionAllText | discordgo.PermissionAllChannel | permissionManageEvents
 
 //EventHandler is a struct which can handle all the events the discord listener generates.
 type EventHandler interface {
 	HandleMessage(*discordgo.MessageCreate)
 	HandleReactionAdd(*discordgo.MessageReaction)
 	HandleReactionRemove(*discordgo.MessageReaction)
+	HandlePresenceUpdate(*discordgo.PresenceUpdate)
+	HandleShardReady(*ShardReady)
+	HandleGuildCreate(*discordgo.GuildCreate)
+	HandleGuildDelete(*discordgo.GuildDelete)
+	HandleGuildMemberAdd(*discordgo.GuildMemberAdd)
+	HandleGuildMemberRemove(*discordgo.GuildMemberRemove)
+	HandleGuildRoleDelete(*discordgo.GuildRoleDelete)
+	HandleChannelDelete(*discordgo.ChannelDelete)
 }
 
-//EventSource represents a connection to the Discord gateway
+//EventSource represents a connection to the Discord gateway, made up of one or more shards
 type EventSource struct {
-	discordClient *discordgo.Session
-	handler       EventHandler
+	shards           []*shard
+	handler          EventHandler
+	events           *dispatch.Dispatcher
+	members          *memberCache
+	presencesEnabled bool
+	membersEnabled   bool
 }
 
-//StartDiscordListener initializes an EventSource and starts listening for events from the discord gateway
-func StartDiscordListener(handler EventHandler) (*EventSource, error) {
+//StartDiscordListener initializes an EventSource and starts listening for events from the discord gateway. Events are
+//passed on to the handler through the provided dispatcher.
+func StartDiscordListener(handler EventHandler, events *dispatch.Dispatcher) (*EventSource, error) {
 	//Get token from environment variable
 	apiTok, exists := os.LookupEnv(discordTokenEnvVar)
 	if !exists {
@@ -35,37 +59,89 @@ func StartDiscordListener(handler EventHandler) (*EventSource, error) {
 		return nil, fmt.Errorf("`%v` env variable was not set", discordTokenEnvVar)
 	}
 
-	//Create new client
-	dc, err := discordgo.New("Bot " + apiTok)
+	token := "Bot " + apiTok
+	res := EventSource{
+		handler:          handler,
+		events:           events,
+		members:          newMemberCache(),
+		presencesEnabled: os.Getenv(presenceIntentEnvVar) == "true",
+		membersEnabled:   os.Getenv(membersIntentEnvVar) == "true",
+	}
+
+	//Create a client for each shard
+	primary, err := newShard(token, 0, 1)
 	if err != nil {
 		logrus.Warnf("Failed to create Discord gateway client due to %v", err)
 		return nil, err
 	}
-	dispatch := EventSource{
-		discordClient: dc,
-		handler:       handler,
+	shardCount, err := getShardCount(primary.session)
+	if err != nil {
+		logrus.Errorf("Failed to work out how many discord shards to start due to error %v", err)
+		return nil, err
 	}
+	primary.session.ShardCount = shardCount
+	res.shards = append(res.shards, primary)
+	for i := 1; i < shardCount; i++ {
+		sh, err := newShard(token, i, shardCount)
+		if err != nil {
+			logrus.Warnf("Failed to create Discord gateway client due to %v", err)
+			return nil, err
+		}
+		//Rate limits apply to the bot as a whole, so every shard needs to share the same limiter
+		sh.session.Ratelimiter = primary.session.Ratelimiter
+		res.shards = append(res.shards, sh)
+	}
+	for _, sh := range res.shards {
+		res.registerHandlers(sh.session)
+	}
+	shardStats.Set("shards", expvar.Func(func() interface{} {
+		return res.ShardStatuses()
+	}))
 
-	//Register event handlers
-	dc.AddHandler(dispatch.dispatchMessageCreateEvent)
-	dc.AddHandler(dispatch.dispatchMessageReactionAddEvent)
-	dc.AddHandler(dispatch.dispatchMessageReactionRemoveEvent)
-
-	//Register intents
-	dc.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsGuildMessageReactions
-
-	//Open a websocket connection
-	err = dc.Open()
+	//Open websocket connections
+	logrus.Infof("Starting %d discord shard(s)", shardCount)
+	err = res.openShards()
 	if err != nil {
 		logrus.Errorf("Failed to connect to discord websockets gateway; encountered error %v", err)
 		return nil, err
 	}
-	return &dispatch, nil
+	return &res, nil
+}
+
+//registerHandlers sets up a shard's session to pass events on to the handler, and requests the intents needed for them
+func (d *EventSource) registerHandlers(dc *discordgo.Session) {
+	//Register event handlers
+	dc.AddHandler(d.dispatchMessageCreateEvent)
+	dc.AddHandler(d.dispatchMessageReactionAddEvent)
+	dc.AddHandler(d.dispatchMessageReactionRemoveEvent)
+	dc.AddHandler(d.dispatchReadyEvent)
+	dc.AddHandler(d.dispatchGuildCreateEvent)
+	dc.AddHandler(d.dispatchGuildDeleteEvent)
+	dc.AddHandler(d.handleGuildRoleCreateEvent)
+	dc.AddHandler(d.handleGuildRoleUpdateEvent)
+	dc.AddHandler(d.dispatchGuildRoleDeleteEvent)
+	dc.AddHandler(d.dispatchChannelDeleteEvent)
+
+	//Register intents
+	dc.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsGuildMessageReactions
+	if d.presencesEnabled {
+		dc.Identify.Intents |= discordgo.IntentsGuildPresences
+		dc.AddHandler(d.dispatchPresenceUpdateEvent)
+	}
+	if d.membersEnabled {
+		dc.Identify.Intents |= discordgo.IntentsGuildMembers
+		dc.AddHandler(d.dispatchGuildMemberAddEvent)
+		dc.AddHandler(d.dispatchGuildMemberRemoveEvent)
+		dc.AddHandler(d.handleGuildMemberUpdateEvent)
+		dc.AddHandler(d.handleGuildMembersChunkEvent)
+	}
+	//Presences are passed straight on to the handler, so there's no need to keep every member's presence in memory
+	dc.State.TrackPresences = false
 }
 
 //BotAddURL generates a URL that can be used to add the bot to a server
 func (d *EventSource) BotAddURL() (*url.URL, error) {
-	user, err := d.discordClient.User("@me")
+	user, err := d.Session().User("@me")
 	if err != nil {
 		return nil, err
 	}
@@ -87,12 +163,25 @@ func (d *EventSource) BotAddURL() (*url.URL, error) {
 //Close cleanly terminates the Discord connection
 func (d *EventSource) Close() {
 	logrus.Info("Terminating discord event listener...")
-	_ = d.discordClient.Close()
+	for _, sh := range d.shards {
+		_ = sh.session.Close()
+	}
 }
 
-//Session returns a handle to the underlying discordgo session
+//PresencesEnabled returns true if the bot receives presence updates from discord
+func (d *EventSource) PresencesEnabled() bool {
+	return d.presencesEnabled
+}
+
+//MembersEnabled returns true if the bot receives member join, leave and update events from discord
+func (d *EventSource) MembersEnabled() bool {
+	return d.membersEnabled
+}
+
+//Session returns a handle to a discordgo session which can be used for REST requests. Its state only contains the
+//guilds on the first shard, so GuildSession should be used for state lookups instead.
 func (d *EventSource) Session() *discordgo.Session {
-	return d.discordClient
+	return d.shards[0].session
 }
 
 func (d *EventSource) dispatchMessageCreateEvent(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -102,18 +191,13 @@ func (d *EventSource) dispatchMessageCreateEvent(s *discordgo.Session, m *discor
 		return
 	}
 
-	//Prevent panic from crashing the whole bot
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("Bot handler thread panicked: %v", r)
-		}
-	}()
-
-	//Dispatch to bot handlers
-	d.handler.HandleMessage(m)
-
 	//For debugging
 	logrus.Debugf("Got message `%v`\n", m.Content)
+
+	//Dispatch to bot handlers
+	d.submit(EventKey(m.GuildID, m.Author.ID), func() {
+		d.handler.HandleMessage(m)
+	})
 }
 
 func (d *EventSource) dispatchMessageReactionAddEvent(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
@@ -123,18 +207,13 @@ func (d *EventSource) dispatchMessageReactionAddEvent(s *discordgo.Session, r *d
 		return
 	}
 
-	//Prevent panic from crashing the whole bot
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("Bot handler thread panicked: %v", r)
-		}
-	}()
-
-	//Dispatch to bot handlers
-	d.handler.HandleReactionAdd(r.MessageReaction)
-
 	//debugging
 	logrus.Debugf("Got reaction `%#v`\n", r.MessageReaction)
+
+	//Dispatch to bot handlers
+	d.submit(EventKey(r.GuildID, r.UserID), func() {
+		d.handler.HandleReactionAdd(r.MessageReaction)
+	})
 }
 
 func (d *EventSource) dispatchMessageReactionRemoveEvent(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
@@ -144,16 +223,39 @@ func (d *EventSource) dispatchMessageReactionRemoveEvent(s *discordgo.Session, r
 		return
 	}
 
-	//Prevent panic from crashing the whole bot
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("Bot handler thread panicked: %v", r)
-		}
-	}()
+	//debugging
+	logrus.Debugf("Removed reaction `%#v`\n", *r.MessageReaction)
 
 	//Dispatch to bot handlers
-	d.handler.HandleReactionRemove(r.MessageReaction)
+	d.submit(EventKey(r.GuildID, r.UserID), func() {
+		d.handler.HandleReactionRemove(r.MessageReaction)
+	})
+}
 
-	//debugging
-	logrus.Debugf("Removed reaction `%#v`\n", *r.MessageReaction)
+func (d *EventSource) dispatchPresenceUpdateEvent(s *discordgo.Session, p *discordgo.PresenceUpdate) {
+	if p.User == nil || p.User.ID == s.State.User.ID {
+		return
+	}
+
+	//Dispatch to bot handlers
+	d.submit(EventKey(p.GuildID, p.User.ID), func() {
+		d.handler.HandlePresenceUpdate(p)
+	})
+}
+
+//submit queues an event to be passed on to the handler once any earlier events with the same key have been handled
+func (d *EventSource) submit(key string, event func()) {
+	err := d.events.Submit(key, event)
+	if err != nil {
+		logrus.Warnf("Dropping discord event with key %v due to error %v", key, err)
+	}
+}
+
+//EventKey returns the key used to order discord events. Events caused by the same user within a guild are handled in
+//the order they were received, as are events about the guild itself, which have an empty userID.
+func EventKey(guildID, userID string) string {
+	if userID == "" {
+		return "discord/" + guildID
+	}
+	return "discord/" + guildID + "/" + userID
 }
@@ -0,0 +1,342 @@
+package fakediscord
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/discord"
+)
+
+//ChannelMessageSendComplex sends a message to a channel as the bot
+func (a *API) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if data.Content == "" && data.Embed == nil && len(data.Files) == 0 {
+		return nil, badRequest(errCodeCannotSendEmpty, "Cannot send an empty message")
+	}
+	if data.Reference != nil {
+		if data.Reference.ChannelID != "" && data.Reference.ChannelID != channelID {
+			return nil, badRequest(errCodeInvalidFormBody, "Invalid Form Body")
+		}
+		if _, err := a.getMessage(channelID, data.Reference.MessageID); err != nil {
+			return nil, badRequest(errCodeInvalidFormBody, "Invalid Form Body")
+		}
+	}
+	msg := a.addMessage(ch, a.bot, data.Content)
+	if data.Embed != nil {
+		embed := *data.Embed
+		msg.message.Embeds = []*discordgo.MessageEmbed{&embed}
+	}
+	if data.Reference != nil {
+		ref := *data.Reference
+		msg.message.MessageReference = &ref
+	}
+	return copyMessage(msg), nil
+}
+
+//ChannelMessageSendEmbed sends a message containing only an embed to a channel as the bot
+func (a *API) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	return a.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embed: embed,
+	})
+}
+
+//ChannelMessageDelete deletes a message
+func (a *API) ChannelMessageDelete(channelID, messageID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	msg.deleted = true
+	return nil
+}
+
+//UserChannelCreate opens a direct message channel with a user, or returns the existing one
+func (a *API) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if channelID, exists := a.dmChannels[recipientID]; exists {
+		return copyChannel(a.channels[channelID]), nil
+	}
+	if a.users[recipientID] == nil {
+		return nil, notFound(errCodeUnknownUser, "User")
+	}
+	ch := &channel{
+		channel: discordgo.Channel{
+			ID:   a.newID(),
+			Type: discordgo.ChannelTypeDM,
+		},
+		messages: make(map[string]*message),
+	}
+	a.channels[ch.channel.ID] = ch
+	a.dmChannels[recipientID] = ch.channel.ID
+	return copyChannel(ch), nil
+}
+
+//MessageReactionAdd reacts to a message as the bot
+func (a *API) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	addReaction(msg, emojiID, a.bot.ID)
+	return nil
+}
+
+//MessageReactionRemove removes a user's reaction from a message
+func (a *API) MessageReactionRemove(channelID, messageID, emojiID, userID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	removeReaction(msg, emojiID, userID)
+	return nil
+}
+
+//MessageReactionsRemoveEmoji removes every reaction with the given emoji from a message
+func (a *API) MessageReactionsRemoveEmoji(channelID, messageID, emojiID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	delete(msg.reactions, emojiID)
+	return nil
+}
+
+//Guild returns a guild along with its roles, channels and members
+func (a *API) Guild(guildID string) (*discordgo.Guild, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return a.guildSnapshot(g), nil
+}
+
+//GuildChannels returns the channels of a guild in the order they were created
+func (a *API) GuildChannels(guildID string) ([]*discordgo.Channel, error) {
+	g, err := a.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return g.Channels, nil
+}
+
+//Channel looks up a channel
+func (a *API) Channel(channelID string) (*discordgo.Channel, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return copyChannel(ch), nil
+}
+
+//MissingPermissions returns the permissions set for a channel with SetMissingPermissions
+func (a *API) MissingPermissions(guildID, channelID string) ([]string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), ch.missingPermissions...), nil
+}
+
+//PresencesEnabled returns the value set with SetPresencesEnabled
+func (a *API) PresencesEnabled() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.presencesEnabled
+}
+
+//GuildRoles returns the roles of a guild
+func (a *API) GuildRoles(guildID string) ([]*discordgo.Role, error) {
+	g, err := a.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return g.Roles, nil
+}
+
+//GuildMember looks up a member of a guild
+func (a *API) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, m, err := a.getMember(guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return copyMember(m), nil
+}
+
+//GuildMembersWithRole returns every member of a guild who has the given role
+func (a *API) GuildMembersWithRole(guildID, roleID string) ([]*discordgo.Member, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	var res []*discordgo.Member
+	for _, m := range g.members {
+		for _, r := range m.Roles {
+			if r == roleID {
+				res = append(res, copyMember(m))
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+//GuildMemberRoleAdd gives a member of a guild a role
+func (a *API) GuildMemberRoleAdd(guildID, userID, roleID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, m, err := a.getMember(guildID, userID)
+	if err != nil {
+		return err
+	}
+	if g.roles[roleID] == nil {
+		return notFound(errCodeUnknownRole, "Role")
+	}
+	for _, r := range m.Roles {
+		if r == roleID {
+			return nil
+		}
+	}
+	//Members handed out earlier may still be in use, so replace the roles rather than appending to them in place
+	m.Roles = append(append([]string(nil), m.Roles...), roleID)
+	return nil
+}
+
+//GuildMemberRoleRemove takes a role away from a member of a guild
+func (a *API) GuildMemberRoleRemove(guildID, userID, roleID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, m, err := a.getMember(guildID, userID)
+	if err != nil {
+		return err
+	}
+	if g.roles[roleID] == nil {
+		return notFound(errCodeUnknownRole, "Role")
+	}
+	roles := make([]string, 0, len(m.Roles))
+	for _, r := range m.Roles {
+		if r != roleID {
+			roles = append(roles, r)
+		}
+	}
+	m.Roles = roles
+	return nil
+}
+
+//GuildScheduledEventCreate creates a scheduled event in a guild, returning it with its ID filled in
+func (a *API) GuildScheduledEventCreate(gid string, ev *discord.ScheduledEvent) (*discord.ScheduledEvent, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(gid)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Name == "" || ev.StartTime.IsZero() || ev.EndTime.IsZero() || ev.Location == "" {
+		return nil, badRequest(errCodeInvalidFormBody, "Invalid Form Body")
+	}
+	created := *ev
+	created.ID = a.newID()
+	created.Status = discord.ScheduledEventStatusScheduled
+	g.events[created.ID] = &created
+	res := created
+	return &res, nil
+}
+
+//GuildScheduledEventEdit updates the fields of a scheduled event which are set in ev
+func (a *API) GuildScheduledEventEdit(gid, eventID string, ev *discord.ScheduledEvent) (*discord.ScheduledEvent, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	existing, err := a.getScheduledEvent(gid, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Status != 0 {
+		if err := checkStatusChange(existing.Status, ev.Status); err != nil {
+			return nil, err
+		}
+		existing.Status = ev.Status
+	}
+	if ev.Name != "" {
+		existing.Name = ev.Name
+	}
+	if ev.Description != "" {
+		existing.Description = ev.Description
+	}
+	if ev.Location != "" {
+		existing.Location = ev.Location
+	}
+	if !ev.StartTime.IsZero() {
+		existing.StartTime = ev.StartTime
+	}
+	if !ev.EndTime.IsZero() {
+		existing.EndTime = ev.EndTime
+	}
+	res := *existing
+	return &res, nil
+}
+
+//GuildScheduledEventSetStatus changes the status of a scheduled event
+func (a *API) GuildScheduledEventSetStatus(gid, eventID string, status discord.ScheduledEventStatus) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	existing, err := a.getScheduledEvent(gid, eventID)
+	if err != nil {
+		return err
+	}
+	if err := checkStatusChange(existing.Status, status); err != nil {
+		return err
+	}
+	existing.Status = status
+	return nil
+}
+
+//getScheduledEvent returns a scheduled event in a guild, or an unknown event error. The lock must be held.
+func (a *API) getScheduledEvent(gid, eventID string) (*discord.ScheduledEvent, error) {
+	g, err := a.getGuild(gid)
+	if err != nil {
+		return nil, err
+	}
+	ev := g.events[eventID]
+	if ev == nil {
+		return nil, notFound(errCodeUnknownEvent, "Guild Scheduled Event")
+	}
+	return ev, nil
+}
+
+//checkStatusChange returns an error if discord wouldn't allow a scheduled event to move between the given statuses.
+//Scheduled events can be started or cancelled, and active events can only be completed.
+func checkStatusChange(from, to discord.ScheduledEventStatus) error {
+	if from == to {
+		return nil
+	}
+	switch {
+	case from == discord.ScheduledEventStatusScheduled &&
+		(to == discord.ScheduledEventStatusActive || to == discord.ScheduledEventStatusCancelled):
+		return nil
+	case from == discord.ScheduledEventStatusActive && to == discord.ScheduledEventStatusCompleted:
+		return nil
+	default:
+		return badRequest(errCodeInvalidFormBody, "Invalid Form Body")
+	}
+}
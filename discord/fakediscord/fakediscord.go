@@ -0,0 +1,183 @@
+// Package fakediscord provides an in-memory implementation of discord.API, so that the bot can be exercised end-to-end
+// without a connection to discord. Guilds, channels, roles and members are set up with the Add functions, and incoming
+// events are created with SendMessage and AddReaction to be passed to the bot's handlers. Requests which discord would
+// reject fail with the same REST errors discord would return.
+package fakediscord
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/discord"
+)
+
+// Error codes returned by discord which the fake can produce
+const (
+	errCodeUnknownChannel  = 10003
+	errCodeUnknownGuild    = 10004
+	errCodeUnknownMember   = 10007
+	errCodeUnknownMessage  = 10008
+	errCodeUnknownRole     = 10011
+	errCodeUnknownUser     = 10013
+	errCodeUnknownEvent    = 10070
+	errCodeCannotSendEmpty = 50006
+	errCodeInvalidFormBody = 50035
+)
+
+// firstSnowflake is the ID given to the first object created by the fake. IDs are handed out in increasing order, and
+// are the same length as real snowflakes so that they can be parsed in the same way.
+const firstSnowflake = 800000000000000000
+
+// API is an in-memory discord, which is safe to use from multiple goroutines
+type API struct {
+	lock   sync.Mutex
+	nextID uint64
+	bot    *discordgo.User
+	users  map[string]*discordgo.User
+	guilds map[string]*guild
+	//channels contains every guild and direct message channel, keyed by ID
+	channels map[string]*channel
+	//dmChannels contains the direct message channel opened with each user, keyed by user ID
+	dmChannels map[string]string
+	//presencesEnabled is returned by PresencesEnabled
+	presencesEnabled bool
+}
+
+type guild struct {
+	guild   discordgo.Guild
+	roles   map[string]*discordgo.Role
+	members map[string]*discordgo.Member
+	events  map[string]*discord.ScheduledEvent
+	//channelOrder contains the IDs of the guild's channels in the order they were created
+	channelOrder []string
+}
+
+type channel struct {
+	channel discordgo.Channel
+	//missingPermissions are the names of the permissions the bot doesn't have in the channel
+	missingPermissions []string
+	messages           map[string]*message
+	//messageOrder contains the IDs of the channel's messages in the order they were sent
+	messageOrder []string
+}
+
+type message struct {
+	message discordgo.Message
+	//reactions contains the IDs of the users who have reacted with each emoji, in the order they reacted
+	reactions map[string][]string
+	deleted   bool
+}
+
+var _ discord.API = (*API)(nil)
+
+// New creates an empty fake discord, in which the bot has the given user ID
+func New(botUserID string) *API {
+	bot := &discordgo.User{
+		ID:       botUserID,
+		Username: "Nia",
+		Bot:      true,
+	}
+	return &API{
+		nextID:     firstSnowflake,
+		bot:        bot,
+		users:      map[string]*discordgo.User{botUserID: bot},
+		guilds:     make(map[string]*guild),
+		channels:   make(map[string]*channel),
+		dmChannels: make(map[string]string),
+	}
+}
+
+// newID returns a new unique snowflake. The lock must be held.
+func (a *API) newID() string {
+	id := a.nextID
+	a.nextID++
+	return strconv.FormatUint(id, 10)
+}
+
+// restError builds an error in the same form discordgo returns for a failed request
+func restError(status int, code int, msg string) error {
+	body := fmt.Sprintf(`{"code": %d, "message": %q}`, code, msg)
+	return &discordgo.RESTError{
+		Response: &http.Response{
+			Status:     fmt.Sprintf("%d %v", status, http.StatusText(status)),
+			StatusCode: status,
+		},
+		ResponseBody: []byte(body),
+		Message: &discordgo.APIErrorMessage{
+			Code:    code,
+			Message: msg,
+		},
+	}
+}
+
+func notFound(code int, what string) error {
+	return restError(http.StatusNotFound, code, "Unknown "+what)
+}
+
+func badRequest(code int, msg string) error {
+	return restError(http.StatusBadRequest, code, msg)
+}
+
+// getGuild returns the guild with the given ID, or an unknown guild error. The lock must be held.
+func (a *API) getGuild(guildID string) (*guild, error) {
+	g := a.guilds[guildID]
+	if g == nil {
+		return nil, notFound(errCodeUnknownGuild, "Guild")
+	}
+	return g, nil
+}
+
+// getChannel returns the channel with the given ID, or an unknown channel error. The lock must be held.
+func (a *API) getChannel(channelID string) (*channel, error) {
+	ch := a.channels[channelID]
+	if ch == nil {
+		return nil, notFound(errCodeUnknownChannel, "Channel")
+	}
+	return ch, nil
+}
+
+// getMessage returns the message with the given ID, or an unknown message error. The lock must be held.
+func (a *API) getMessage(channelID, messageID string) (*message, error) {
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	msg := ch.messages[messageID]
+	if msg == nil || msg.deleted {
+		return nil, notFound(errCodeUnknownMessage, "Message")
+	}
+	return msg, nil
+}
+
+// getMember returns the member of a guild with the given ID, or an unknown member error. The lock must be held.
+func (a *API) getMember(guildID, userID string) (*guild, *discordgo.Member, error) {
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := g.members[userID]
+	if m == nil {
+		return nil, nil, notFound(errCodeUnknownMember, "Member")
+	}
+	return g, m, nil
+}
+
+func copyMember(m *discordgo.Member) *discordgo.Member {
+	res := *m
+	res.Roles = append([]string(nil), m.Roles...)
+	return &res
+}
+
+func copyChannel(ch *channel) *discordgo.Channel {
+	res := ch.channel
+	return &res
+}
+
+func copyMessage(msg *message) *discordgo.Message {
+	res := msg.message
+	res.Embeds = append([]*discordgo.MessageEmbed(nil), msg.message.Embeds...)
+	return &res
+}
@@ -0,0 +1,341 @@
+package fakediscord
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/callummance/nia/discord"
+)
+
+var (
+	userMentionRegex = regexp.MustCompile(`<@!?(\d+)>`)
+	roleMentionRegex = regexp.MustCompile(`<@&(\d+)>`)
+)
+
+//SetPresencesEnabled sets whether the bot should behave as though it receives presence updates
+func (a *API) SetPresencesEnabled(enabled bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.presencesEnabled = enabled
+}
+
+//AddGuild creates a guild owned by the given user, along with its @everyone role. The bot is added as a member.
+func (a *API) AddGuild(name, ownerID string) *discordgo.Guild {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	id := a.newID()
+	g := &guild{
+		guild: discordgo.Guild{
+			ID:      id,
+			Name:    name,
+			OwnerID: ownerID,
+		},
+		roles:   make(map[string]*discordgo.Role),
+		members: make(map[string]*discordgo.Member),
+		events:  make(map[string]*discord.ScheduledEvent),
+	}
+	//The @everyone role has the same ID as the guild
+	g.roles[id] = &discordgo.Role{
+		ID:   id,
+		Name: "@everyone",
+	}
+	g.members[a.bot.ID] = &discordgo.Member{
+		GuildID: id,
+		User:    a.bot,
+	}
+	a.guilds[id] = g
+	return a.guildSnapshot(g)
+}
+
+//AddRole creates a role in a guild
+func (a *API) AddRole(guildID, name string) (*discordgo.Role, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	r := &discordgo.Role{
+		ID:          a.newID(),
+		Name:        name,
+		Mentionable: true,
+		Position:    len(g.roles),
+	}
+	g.roles[r.ID] = r
+	res := *r
+	return &res, nil
+}
+
+//AddChannel creates a text channel in a guild
+func (a *API) AddChannel(guildID, name string) (*discordgo.Channel, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	ch := &channel{
+		channel: discordgo.Channel{
+			ID:      a.newID(),
+			GuildID: guildID,
+			Name:    name,
+			Type:    discordgo.ChannelTypeGuildText,
+		},
+		messages: make(map[string]*message),
+	}
+	a.channels[ch.channel.ID] = ch
+	g.channelOrder = append(g.channelOrder, ch.channel.ID)
+	return copyChannel(ch), nil
+}
+
+//AddMember adds a user to a guild with the given roles, creating the user if they don't exist yet
+func (a *API) AddMember(guildID, userID, username string, roleIDs ...string) (*discordgo.Member, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleID := range roleIDs {
+		if g.roles[roleID] == nil {
+			return nil, notFound(errCodeUnknownRole, "Role")
+		}
+	}
+	user := a.users[userID]
+	if user == nil {
+		user = &discordgo.User{
+			ID:       userID,
+			Username: username,
+		}
+		a.users[userID] = user
+	}
+	m := &discordgo.Member{
+		GuildID: guildID,
+		User:    user,
+		Roles:   append([]string(nil), roleIDs...),
+	}
+	g.members[userID] = m
+	return copyMember(m), nil
+}
+
+//RemoveMember removes a user from a guild
+func (a *API) RemoveMember(guildID, userID string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, _, err := a.getMember(guildID, userID)
+	if err != nil {
+		return err
+	}
+	delete(g.members, userID)
+	return nil
+}
+
+//SetMissingPermissions sets the names of the permissions the bot doesn't have in a channel
+func (a *API) SetMissingPermissions(channelID string, permissions ...string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return err
+	}
+	ch.missingPermissions = append([]string(nil), permissions...)
+	return nil
+}
+
+//SendMessage posts a message from a user, returning the event discord would send to the bot for it
+func (a *API) SendMessage(channelID, userID, content string) (*discordgo.MessageCreate, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	user := a.users[userID]
+	if user == nil {
+		return nil, notFound(errCodeUnknownUser, "User")
+	}
+	var member *discordgo.Member
+	if ch.channel.GuildID != "" {
+		_, member, err = a.getMember(ch.channel.GuildID, userID)
+		if err != nil {
+			return nil, err
+		}
+		member = copyMember(member)
+		//Members included with messages don't have their user filled in
+		member.User = nil
+	}
+	msg := a.addMessage(ch, user, content)
+	msg.message.Member = member
+	for _, match := range userMentionRegex.FindAllStringSubmatch(content, -1) {
+		if mentioned := a.users[match[1]]; mentioned != nil {
+			msg.message.Mentions = append(msg.message.Mentions, mentioned)
+		}
+	}
+	for _, match := range roleMentionRegex.FindAllStringSubmatch(content, -1) {
+		msg.message.MentionRoles = append(msg.message.MentionRoles, match[1])
+	}
+	return &discordgo.MessageCreate{Message: copyMessage(msg)}, nil
+}
+
+//AddReaction reacts to a message as a user, returning the event discord would send to the bot for it. Custom emoji are
+//given in the same name:id form used by the API.
+func (a *API) AddReaction(channelID, messageID, userID, emojiID string) (*discordgo.MessageReaction, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if a.users[userID] == nil {
+		return nil, notFound(errCodeUnknownUser, "User")
+	}
+	addReaction(msg, emojiID, userID)
+	return reactionEvent(msg, userID, emojiID), nil
+}
+
+//RemoveReaction removes a user's reaction from a message, returning the event discord would send to the bot for it
+func (a *API) RemoveReaction(channelID, messageID, userID, emojiID string) (*discordgo.MessageReaction, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	removeReaction(msg, emojiID, userID)
+	return reactionEvent(msg, userID, emojiID), nil
+}
+
+//Messages returns every message in a channel which hasn't been deleted, oldest first
+func (a *API) Messages(channelID string) ([]*discordgo.Message, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ch, err := a.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	var res []*discordgo.Message
+	for _, id := range ch.messageOrder {
+		if msg := ch.messages[id]; !msg.deleted {
+			res = append(res, copyMessage(msg))
+		}
+	}
+	return res, nil
+}
+
+//DirectMessages returns every message sent to a user in direct messages, oldest first
+func (a *API) DirectMessages(userID string) []*discordgo.Message {
+	a.lock.Lock()
+	channelID, exists := a.dmChannels[userID]
+	a.lock.Unlock()
+	if !exists {
+		return nil
+	}
+	res, _ := a.Messages(channelID)
+	return res
+}
+
+//Reactions returns the IDs of the users who have reacted to a message with the given emoji, in the order they reacted
+func (a *API) Reactions(channelID, messageID, emojiID string) ([]string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	msg, err := a.getMessage(channelID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), msg.reactions[emojiID]...), nil
+}
+
+//ScheduledEvents returns every scheduled event in a guild
+func (a *API) ScheduledEvents(guildID string) ([]*discord.ScheduledEvent, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	g, err := a.getGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*discord.ScheduledEvent, 0, len(g.events))
+	for _, ev := range g.events {
+		evCopy := *ev
+		res = append(res, &evCopy)
+	}
+	return res, nil
+}
+
+//addMessage stores a new message in a channel. The lock must be held.
+func (a *API) addMessage(ch *channel, author *discordgo.User, content string) *message {
+	msg := &message{
+		message: discordgo.Message{
+			ID:        a.newID(),
+			ChannelID: ch.channel.ID,
+			GuildID:   ch.channel.GuildID,
+			Content:   content,
+			Author:    author,
+		},
+		reactions: make(map[string][]string),
+	}
+	ch.messages[msg.message.ID] = msg
+	ch.messageOrder = append(ch.messageOrder, msg.message.ID)
+	return msg
+}
+
+func addReaction(msg *message, emojiID, userID string) {
+	for _, uid := range msg.reactions[emojiID] {
+		if uid == userID {
+			return
+		}
+	}
+	msg.reactions[emojiID] = append(msg.reactions[emojiID], userID)
+}
+
+func removeReaction(msg *message, emojiID, userID string) {
+	users := msg.reactions[emojiID]
+	for i, uid := range users {
+		if uid == userID {
+			users = append(users[:i:i], users[i+1:]...)
+			break
+		}
+	}
+	if len(users) == 0 {
+		delete(msg.reactions, emojiID)
+	} else {
+		msg.reactions[emojiID] = users
+	}
+}
+
+func reactionEvent(msg *message, userID, emojiID string) *discordgo.MessageReaction {
+	emoji := discordgo.Emoji{Name: emojiID}
+	if i := strings.LastIndex(emojiID, ":"); i >= 0 {
+		emoji.Name, emoji.ID = emojiID[:i], emojiID[i+1:]
+	}
+	return &discordgo.MessageReaction{
+		UserID:    userID,
+		MessageID: msg.message.ID,
+		Emoji:     emoji,
+		ChannelID: msg.message.ChannelID,
+		GuildID:   msg.message.GuildID,
+	}
+}
+
+//guildSnapshot builds a discordgo guild containing the current roles, channels and members of a guild. The lock must
+//be held.
+func (a *API) guildSnapshot(g *guild) *discordgo.Guild {
+	res := g.guild
+	res.Roles = make([]*discordgo.Role, 0, len(g.roles))
+	for _, r := range g.roles {
+		rCopy := *r
+		res.Roles = append(res.Roles, &rCopy)
+	}
+	res.Channels = make([]*discordgo.Channel, 0, len(g.channelOrder))
+	for _, id := range g.channelOrder {
+		if ch := a.channels[id]; ch != nil {
+			res.Channels = append(res.Channels, copyChannel(ch))
+		}
+	}
+	res.Members = make([]*discordgo.Member, 0, len(g.members))
+	for _, m := range g.members {
+		res.Members = append(res.Members, copyMember(m))
+	}
+	res.MemberCount = len(g.members)
+	return &res
+}
@@ -0,0 +1,117 @@
+package discord
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+//ShardReady is passed to the handler when a shard has connected to the gateway. It lists every guild the bot is in
+//which the shard receives events for.
+type ShardReady struct {
+	ShardID    int
+	ShardCount int
+	GuildIDs   []string
+}
+
+//HandlesGuild returns true if the guild with the given ID belongs to the shard, and so would be in GuildIDs if the bot
+//was still in it
+func (r *ShardReady) HandlesGuild(guildID string) bool {
+	return shardForGuild(guildID, r.ShardCount) == r.ShardID
+}
+
+func (d *EventSource) dispatchReadyEvent(s *discordgo.Session, r *discordgo.Ready) {
+	ready := &ShardReady{
+		ShardID:    s.ShardID,
+		ShardCount: s.ShardCount,
+		GuildIDs:   make([]string, 0, len(r.Guilds)),
+	}
+	for _, g := range r.Guilds {
+		ready.GuildIDs = append(ready.GuildIDs, g.ID)
+	}
+	logrus.Debugf("Got ready event for shard %d with %d guilds", ready.ShardID, len(ready.GuildIDs))
+	d.submit(fmt.Sprintf("discord/shard/%d", ready.ShardID), func() {
+		d.handler.HandleShardReady(ready)
+	})
+}
+
+func (d *EventSource) dispatchGuildCreateEvent(s *discordgo.Session, g *discordgo.GuildCreate) {
+	logrus.Debugf("Got guild create event for guild %v", g.ID)
+	if !g.Unavailable {
+		d.members.setGuild(g.Guild)
+		if d.membersEnabled {
+			go d.requestGuildMembers(s, g.ID)
+		}
+	}
+	d.submit(EventKey(g.ID, ""), func() {
+		d.handler.HandleGuildCreate(g)
+	})
+}
+
+func (d *EventSource) dispatchGuildDeleteEvent(s *discordgo.Session, g *discordgo.GuildDelete) {
+	logrus.Debugf("Got guild delete event for guild %v", g.ID)
+	d.members.removeGuild(g.ID)
+	d.submit(EventKey(g.ID, ""), func() {
+		d.handler.HandleGuildDelete(g)
+	})
+}
+
+func (d *EventSource) dispatchGuildMemberAddEvent(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.User == nil || m.User.ID == s.State.User.ID {
+		return
+	}
+	d.members.setMember(m.Member)
+	d.submit(EventKey(m.GuildID, m.User.ID), func() {
+		d.handler.HandleGuildMemberAdd(m)
+	})
+}
+
+func (d *EventSource) dispatchGuildMemberRemoveEvent(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	//The bot leaving a guild is handled by the guild delete event instead
+	if m.User == nil || m.User.ID == s.State.User.ID {
+		return
+	}
+	d.members.removeMember(m.GuildID, m.User.ID)
+	d.submit(EventKey(m.GuildID, m.User.ID), func() {
+		d.handler.HandleGuildMemberRemove(m)
+	})
+}
+
+//handleGuildMemberUpdateEvent keeps the member cache up to date. The bot doesn't need to act on member updates, so
+//they aren't passed on to the handler.
+func (d *EventSource) handleGuildMemberUpdateEvent(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	if m.User == nil || m.User.ID == s.State.User.ID {
+		return
+	}
+	d.members.setMember(m.Member)
+}
+
+//handleGuildRoleCreateEvent adds new roles to the member cache. Like role updates, they aren't passed on to the
+//handler.
+func (d *EventSource) handleGuildRoleCreateEvent(s *discordgo.Session, r *discordgo.GuildRoleCreate) {
+	d.members.setRole(r.GuildID, r.Role)
+}
+
+func (d *EventSource) handleGuildRoleUpdateEvent(s *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+	d.members.setRole(r.GuildID, r.Role)
+}
+
+func (d *EventSource) dispatchGuildRoleDeleteEvent(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
+	logrus.Debugf("Got role delete event for role %v in guild %v", r.RoleID, r.GuildID)
+	d.members.removeRole(r.GuildID, r.RoleID)
+	d.submit(EventKey(r.GuildID, ""), func() {
+		d.handler.HandleGuildRoleDelete(r)
+	})
+}
+
+func (d *EventSource) dispatchChannelDeleteEvent(s *discordgo.Session, c *discordgo.ChannelDelete) {
+	//Only channels within guilds are of any interest
+	if c.Channel == nil || c.GuildID == "" {
+		return
+	}
+	logrus.Debugf("Got channel delete event for channel %v in guild %v", c.ID, c.GuildID)
+	d.submit(EventKey(c.GuildID, ""), func() {
+		d.handler.HandleChannelDelete(c)
+	})
+}
@@ -2,73 +2,85 @@ package discord
 
 import (
 	"github.com/bwmarrin/discordgo"
-	"github.com/sirupsen/logrus"
 )
 
 const memberPageSize int = 512
 
-//GuildMemberResult represents an item fetched using the a GuildMembersIter.
-type GuildMemberResult struct {
-	Member *discordgo.Member
-	Error  error
+//GuildMember looks up a member of a guild, using the member cache where possible
+func (d *EventSource) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	if d.membersEnabled {
+		if m, _ := d.members.member(guildID, userID); m != nil {
+			return m, nil
+		}
+	}
+	m, err := d.Session().GuildMember(guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if d.membersEnabled {
+		d.members.setMember(withGuildID(m, guildID))
+	}
+	return m, nil
 }
 
-//GuildMembersIter returns a new iterator through the members in a given discord guild
-func (e *EventSource) GuildMembersIter(guildID string) chan GuildMemberResult {
-	s := e.Session()
-	ch := make(chan GuildMemberResult)
-	go func(guildID string, s *discordgo.Session) {
-		isDone := false
-		lastLoc := 0
-		var currentPage []*discordgo.Member
-		for {
-			if isDone {
-				ch <- GuildMemberResult{
-					Member: nil,
-					Error:  nil,
-				}
-			} else if lastLoc+1 < len(currentPage) {
-				//We still have members in the current page that have not been returned
-				lastLoc++
-				ch <- GuildMemberResult{
-					Member: currentPage[lastLoc],
-					Error:  nil,
-				}
-			} else {
-				//Need to fetch more members from the API
-				afterUID := maxUID(currentPage)
-				newMembers, err := s.GuildMembers(guildID, afterUID, memberPageSize)
-				if err != nil {
-					logrus.Warnf("Failed to fetch page of guild members from discord api: %v", err)
-					ch <- GuildMemberResult{
-						Member: nil,
-						Error:  err,
-					}
-				}
-				//If new page of members is empty, close the iterator.
-				if len(newMembers) == 0 {
-					isDone = true
-					close(ch)
-					return
-				}
-				currentPage = newMembers
-				lastLoc = 0
-				ch <- GuildMemberResult{
-					Member: currentPage[0],
-					Error:  nil,
-				}
-			}
+//GuildMembers returns every member of a guild. They are taken from the member cache once it has received the guild's
+//full member list, or otherwise fetched from the API.
+func (d *EventSource) GuildMembers(guildID string) ([]*discordgo.Member, error) {
+	if d.membersEnabled {
+		if members := d.members.members(guildID); members != nil {
+			return members, nil
 		}
-	}(guildID, s)
+	}
+	return d.fetchGuildMembers(guildID)
+}
 
-	return ch
+//GuildMembersWithRole returns every member of a guild who has the given role
+func (d *EventSource) GuildMembersWithRole(guildID, roleID string) ([]*discordgo.Member, error) {
+	members, err := d.GuildMembers(guildID)
+	if err != nil {
+		return nil, err
+	}
+	var res []*discordgo.Member
+	for _, m := range members {
+		if hasRole(m, roleID) {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+//GuildRoles returns the roles of a guild, using the member cache where possible
+func (d *EventSource) GuildRoles(guildID string) ([]*discordgo.Role, error) {
+	if roles := d.members.roles(guildID); roles != nil {
+		return roles, nil
+	}
+	return d.Session().GuildRoles(guildID)
+}
+
+//fetchGuildMembers pages through every member of a guild using the REST API
+func (d *EventSource) fetchGuildMembers(guildID string) ([]*discordgo.Member, error) {
+	var members []*discordgo.Member
+	afterUID := "0"
+	for {
+		page, err := d.Session().GuildMembers(guildID, afterUID, memberPageSize)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if len(page) < memberPageSize {
+			return members, nil
+		}
+		afterUID = maxUID(page)
+	}
 }
 
 func maxUID(members []*discordgo.Member) string {
 	maxuid := "0"
 	for _, member := range members {
-		if member.User.ID > maxuid {
-			maxuid = member.User.ID
+		//Snowflakes are numeric, so a longer ID is always the larger one
+		uid := member.User.ID
+		if len(uid) > len(maxuid) || (len(uid) == len(maxuid) && uid > maxuid) {
+			maxuid = uid
 		}
 	}
 	return maxuid
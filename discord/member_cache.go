@@ -0,0 +1,239 @@
+package discord
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+//memberRequestInterval spaces out requests for guild member lists on each shard, so that starting up in lots of guilds
+//at once doesn't go over the gateway's limit of 120 commands a minute
+const memberRequestInterval = 600 * time.Millisecond
+
+var memberCacheStats = expvar.NewMap("discord_member_cache")
+
+//memberCache holds the members and roles of each guild the bot is in, so that they can be looked up without going to
+//the REST API. Members are only cached if the server members intent is enabled, as they can't be kept up to date
+//otherwise.
+type memberCache struct {
+	lock   sync.RWMutex
+	guilds map[string]*cachedGuild
+}
+
+type cachedGuild struct {
+	members map[string]*discordgo.Member
+	roles   map[string]*discordgo.Role
+	//chunksReceived is the number of member chunks received since the full member list was last requested
+	chunksReceived int
+	//complete is set once every member chunk has been received, after which members missing from the cache can be
+	//assumed not to be in the guild
+	complete bool
+}
+
+func newMemberCache() *memberCache {
+	c := &memberCache{
+		guilds: make(map[string]*cachedGuild),
+	}
+	memberCacheStats.Set("guilds", expvar.Func(func() interface{} {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		return len(c.guilds)
+	}))
+	memberCacheStats.Set("members", expvar.Func(func() interface{} {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		total := 0
+		for _, g := range c.guilds {
+			total += len(g.members)
+		}
+		return total
+	}))
+	return c
+}
+
+//setGuild replaces anything cached for a guild with the roles and members it was sent with
+func (c *memberCache) setGuild(g *discordgo.Guild) {
+	cached := &cachedGuild{
+		members: make(map[string]*discordgo.Member, len(g.Members)),
+		roles:   make(map[string]*discordgo.Role, len(g.Roles)),
+	}
+	for _, m := range g.Members {
+		if m.User != nil {
+			cached.members[m.User.ID] = withGuildID(m, g.ID)
+		}
+	}
+	for _, r := range g.Roles {
+		cached.roles[r.ID] = r
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.guilds[g.ID] = cached
+}
+
+func (c *memberCache) removeGuild(guildID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.guilds, guildID)
+}
+
+//addChunk adds a chunk of members received in response to a request for a guild's full member list. Gateway events
+//are handled concurrently, so members which are already cached are left alone in case they were updated after the
+//chunk was sent.
+func (c *memberCache) addChunk(chunk *discordgo.GuildMembersChunk) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached := c.guilds[chunk.GuildID]
+	if cached == nil {
+		return
+	}
+	for _, m := range chunk.Members {
+		if m.User == nil {
+			continue
+		}
+		if _, exists := cached.members[m.User.ID]; !exists {
+			cached.members[m.User.ID] = withGuildID(m, chunk.GuildID)
+		}
+	}
+	cached.chunksReceived++
+	if cached.chunksReceived >= chunk.ChunkCount {
+		cached.complete = true
+		logrus.Debugf("Finished caching %d members of guild %v", len(cached.members), chunk.GuildID)
+	}
+}
+
+func (c *memberCache) setMember(m *discordgo.Member) {
+	if m.User == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached := c.guilds[m.GuildID]; cached != nil {
+		cached.members[m.User.ID] = m
+	}
+}
+
+func (c *memberCache) removeMember(guildID, userID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached := c.guilds[guildID]; cached != nil {
+		delete(cached.members, userID)
+	}
+}
+
+func (c *memberCache) setRole(guildID string, r *discordgo.Role) {
+	if r == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached := c.guilds[guildID]; cached != nil {
+		cached.roles[r.ID] = r
+	}
+}
+
+//removeRole removes a deleted role from the cache, along with the members who had it
+func (c *memberCache) removeRole(guildID, roleID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached := c.guilds[guildID]
+	if cached == nil {
+		return
+	}
+	delete(cached.roles, roleID)
+	for uid, m := range cached.members {
+		if !hasRole(m, roleID) {
+			continue
+		}
+		//Members may still be in use elsewhere, so update a copy rather than changing them in place
+		updated := *m
+		updated.Roles = make([]string, 0, len(m.Roles)-1)
+		for _, r := range m.Roles {
+			if r != roleID {
+				updated.Roles = append(updated.Roles, r)
+			}
+		}
+		cached.members[uid] = &updated
+	}
+}
+
+//member returns a cached member of a guild, along with whether the guild's member list is complete
+func (c *memberCache) member(guildID, userID string) (*discordgo.Member, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cached := c.guilds[guildID]
+	if cached == nil {
+		return nil, false
+	}
+	return cached.members[userID], cached.complete
+}
+
+//members returns every cached member of a guild, or nil if the guild's member list isn't complete
+func (c *memberCache) members(guildID string) []*discordgo.Member {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cached := c.guilds[guildID]
+	if cached == nil || !cached.complete {
+		return nil
+	}
+	res := make([]*discordgo.Member, 0, len(cached.members))
+	for _, m := range cached.members {
+		res = append(res, m)
+	}
+	return res
+}
+
+//roles returns the cached roles of a guild, or nil if the guild isn't cached
+func (c *memberCache) roles(guildID string) []*discordgo.Role {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cached := c.guilds[guildID]
+	if cached == nil {
+		return nil
+	}
+	res := make([]*discordgo.Role, 0, len(cached.roles))
+	for _, r := range cached.roles {
+		res = append(res, r)
+	}
+	return res
+}
+
+func withGuildID(m *discordgo.Member, guildID string) *discordgo.Member {
+	if m.GuildID == guildID {
+		return m
+	}
+	res := *m
+	res.GuildID = guildID
+	return &res
+}
+
+func hasRole(m *discordgo.Member, roleID string) bool {
+	for _, r := range m.Roles {
+		if r == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+//requestGuildMembers asks discord to send the full member list of a guild over the gateway, waiting first if another
+//request was made on the same shard too recently
+func (d *EventSource) requestGuildMembers(s *discordgo.Session, guildID string) {
+	sh := d.shards[s.ShardID]
+	sh.memberRequestLock.Lock()
+	if wait := time.Until(sh.lastMemberRequest.Add(memberRequestInterval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	sh.lastMemberRequest = time.Now()
+	sh.memberRequestLock.Unlock()
+	err := s.RequestGuildMembers(guildID, "", 0, false)
+	if err != nil {
+		logrus.Warnf("Failed to request member list for guild %v due to error %v", guildID, err)
+	}
+}
+
+func (d *EventSource) handleGuildMembersChunkEvent(s *discordgo.Session, c *discordgo.GuildMembersChunk) {
+	d.members.addChunk(c)
+}
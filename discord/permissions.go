@@ -0,0 +1,40 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+//requiredPermissions are the permissions the bot needs within a channel to be fully functional, along with the name
+//shown for each in discord
+var requiredPermissions = []struct {
+	permission int64
+	name       string
+}{
+	{discordgo.PermissionReadMessages, "View Channel"},
+	{discordgo.PermissionSendMessages, "Send Messages"},
+	{discordgo.PermissionEmbedLinks, "Embed Links"},
+	{discordgo.PermissionAddReactions, "Add Reactions"},
+	{discordgo.PermissionReadMessageHistory, "Read Message History"},
+	{discordgo.PermissionManageRoles, "Manage Roles"},
+	{permissionManageEvents, "Manage Events"},
+}
+
+//MissingPermissions returns the names of any permissions the bot needs but doesn't have in the given channel of a
+//guild
+func (d *EventSource) MissingPermissions(guildID, channelID string) ([]string, error) {
+	s := d.GuildSession(guildID)
+	perms, err := s.State.UserChannelPermissions(s.State.User.ID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return nil, nil
+	}
+	var missing []string
+	for _, required := range requiredPermissions {
+		if perms&required.permission == 0 {
+			missing = append(missing, required.name)
+		}
+	}
+	return missing, nil
+}
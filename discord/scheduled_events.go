@@ -0,0 +1,137 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//discordgo doesn't support guild scheduled events yet, so requests for them are made directly
+
+//permissionManageEvents allows the bot to create and edit guild scheduled events
+const permissionManageEvents = 1 << 33
+
+const (
+	scheduledEventPrivacyGuildOnly = 2
+	scheduledEventEntityExternal   = 3
+)
+
+//ScheduledEventStatus is the status of a guild scheduled event
+type ScheduledEventStatus int
+
+//Statuses which a guild scheduled event can be in
+const (
+	ScheduledEventStatusScheduled ScheduledEventStatus = 1
+	ScheduledEventStatusActive    ScheduledEventStatus = 2
+	ScheduledEventStatusCompleted ScheduledEventStatus = 3
+	ScheduledEventStatusCancelled ScheduledEventStatus = 4
+)
+
+//ScheduledEvent is a guild scheduled event which takes place somewhere outside of discord
+type ScheduledEvent struct {
+	ID          string
+	Name        string
+	Description string
+	//Location is shown as where the event takes place, eg. a link to a stream
+	Location  string
+	StartTime time.Time
+	EndTime   time.Time
+	Status    ScheduledEventStatus
+}
+
+type scheduledEventMetadata struct {
+	Location string `json:"location"`
+}
+
+type scheduledEventParams struct {
+	Name               string                  `json:"name,omitempty"`
+	Description        string                  `json:"description,omitempty"`
+	PrivacyLevel       int                     `json:"privacy_level,omitempty"`
+	EntityType         int                     `json:"entity_type,omitempty"`
+	EntityMetadata     *scheduledEventMetadata `json:"entity_metadata,omitempty"`
+	ScheduledStartTime *time.Time              `json:"scheduled_start_time,omitempty"`
+	ScheduledEndTime   *time.Time              `json:"scheduled_end_time,omitempty"`
+	Status             ScheduledEventStatus    `json:"status,omitempty"`
+}
+
+type scheduledEventResponse struct {
+	ID                 string                 `json:"id"`
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description"`
+	EntityMetadata     scheduledEventMetadata `json:"entity_metadata"`
+	ScheduledStartTime time.Time              `json:"scheduled_start_time"`
+	ScheduledEndTime   time.Time              `json:"scheduled_end_time"`
+	Status             ScheduledEventStatus   `json:"status"`
+}
+
+func endpointGuildScheduledEvents(gid string) string {
+	return discordgo.EndpointGuild(gid) + "/scheduled-events"
+}
+
+func endpointGuildScheduledEvent(gid, eventID string) string {
+	return endpointGuildScheduledEvents(gid) + "/" + eventID
+}
+
+//GuildScheduledEventCreate creates an external scheduled event in a guild, returning it with its ID filled in
+func (d *EventSource) GuildScheduledEventCreate(gid string, ev *ScheduledEvent) (*ScheduledEvent, error) {
+	start, end := ev.StartTime.UTC(), ev.EndTime.UTC()
+	params := scheduledEventParams{
+		Name:               ev.Name,
+		Description:        ev.Description,
+		PrivacyLevel:       scheduledEventPrivacyGuildOnly,
+		EntityType:         scheduledEventEntityExternal,
+		EntityMetadata:     &scheduledEventMetadata{Location: ev.Location},
+		ScheduledStartTime: &start,
+		ScheduledEndTime:   &end,
+	}
+	endpoint := endpointGuildScheduledEvents(gid)
+	body, err := d.Session().RequestWithBucketID(http.MethodPost, endpoint, params, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return decodeScheduledEvent(body)
+}
+
+//GuildScheduledEventEdit updates the name, description, location and times of an external scheduled event
+func (d *EventSource) GuildScheduledEventEdit(gid, eventID string, ev *ScheduledEvent) (*ScheduledEvent, error) {
+	start, end := ev.StartTime.UTC(), ev.EndTime.UTC()
+	params := scheduledEventParams{
+		Name:               ev.Name,
+		Description:        ev.Description,
+		EntityMetadata:     &scheduledEventMetadata{Location: ev.Location},
+		ScheduledStartTime: &start,
+		ScheduledEndTime:   &end,
+	}
+	endpoint := endpointGuildScheduledEvent(gid, eventID)
+	body, err := d.Session().RequestWithBucketID(http.MethodPatch, endpoint, params, endpointGuildScheduledEvent(gid, ""))
+	if err != nil {
+		return nil, err
+	}
+	return decodeScheduledEvent(body)
+}
+
+//GuildScheduledEventSetStatus starts, completes or cancels a scheduled event
+func (d *EventSource) GuildScheduledEventSetStatus(gid, eventID string, status ScheduledEventStatus) error {
+	endpoint := endpointGuildScheduledEvent(gid, eventID)
+	_, err := d.Session().RequestWithBucketID(http.MethodPatch, endpoint, scheduledEventParams{Status: status}, endpointGuildScheduledEvent(gid, ""))
+	return err
+}
+
+func decodeScheduledEvent(body []byte) (*ScheduledEvent, error) {
+	var resp scheduledEventResponse
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledEvent{
+		ID:          resp.ID,
+		Name:        resp.Name,
+		Description: resp.Description,
+		Location:    resp.EntityMetadata.Location,
+		StartTime:   resp.ScheduledStartTime,
+		EndTime:     resp.ScheduledEndTime,
+		Status:      resp.Status,
+	}, nil
+}
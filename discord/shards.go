@@ -0,0 +1,152 @@
+package discord
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+//shardCountEnvVar sets how many gateway connections are opened. It can either be a number, or "auto" (the default)
+//to use the number recommended by discord.
+const shardCountEnvVar = "NIA_DISCORD_SHARD_COUNT"
+
+//shardIdentifyInterval is how long to wait between starting each shard, as discord only allows one identify every
+//five seconds
+const shardIdentifyInterval = 5 * time.Second
+
+var shardStats = expvar.NewMap("discord_shards")
+
+//ShardStatus describes the state of a single gateway connection
+type ShardStatus struct {
+	ID        int
+	Connected bool
+	//Since is when the shard last connected or disconnected
+	Since time.Time
+	//Guilds is the number of guilds the shard was handling when it last became ready
+	Guilds int
+	//Reconnects is the number of times the shard has connected again after being disconnected
+	Reconnects int
+}
+
+//shard is one of the gateway connections making up an EventSource
+type shard struct {
+	session *discordgo.Session
+	lock    sync.Mutex
+	status  ShardStatus
+	//memberRequestLock guards lastMemberRequest, which is when the shard last asked for a guild's member list
+	memberRequestLock sync.Mutex
+	lastMemberRequest time.Time
+}
+
+func newShard(token string, id, count int) (*shard, error) {
+	s, err := discordgo.New(token)
+	if err != nil {
+		return nil, err
+	}
+	s.ShardID = id
+	s.ShardCount = count
+	sh := &shard{
+		session: s,
+		status:  ShardStatus{ID: id},
+	}
+	s.AddHandler(sh.onConnect)
+	s.AddHandler(sh.onDisconnect)
+	s.AddHandler(sh.onReady)
+	return sh, nil
+}
+
+func (sh *shard) onConnect(s *discordgo.Session, c *discordgo.Connect) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	if !sh.status.Since.IsZero() {
+		sh.status.Reconnects++
+	}
+	sh.status.Connected = true
+	sh.status.Since = time.Now()
+	logrus.Infof("Discord shard %d/%d connected", sh.status.ID, s.ShardCount)
+}
+
+func (sh *shard) onDisconnect(s *discordgo.Session, d *discordgo.Disconnect) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	sh.status.Connected = false
+	sh.status.Since = time.Now()
+	logrus.Warnf("Discord shard %d/%d disconnected", sh.status.ID, s.ShardCount)
+}
+
+func (sh *shard) onReady(s *discordgo.Session, r *discordgo.Ready) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	sh.status.Guilds = len(r.Guilds)
+}
+
+func (sh *shard) getStatus() ShardStatus {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	return sh.status
+}
+
+//getShardCount works out how many shards should be started
+func getShardCount(s *discordgo.Session) (int, error) {
+	countStr := os.Getenv(shardCountEnvVar)
+	if countStr == "" || countStr == "auto" {
+		gateway, err := s.GatewayBot()
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch recommended shard count: %v", err)
+		}
+		if gateway.Shards < 1 {
+			return 1, nil
+		}
+		return gateway.Shards, nil
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("`%v` must be a positive number or \"auto\", not `%v`", shardCountEnvVar, countStr)
+	}
+	return count, nil
+}
+
+//shardForGuild returns the ID of the shard which receives events for a guild
+func shardForGuild(guildID string, count int) int {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return int((id >> 22) % uint64(count))
+}
+
+//openShards connects each shard to the gateway in turn, waiting between each so as not to exceed the identify limit
+func (d *EventSource) openShards() error {
+	for i, sh := range d.shards {
+		if i > 0 {
+			time.Sleep(shardIdentifyInterval)
+		}
+		err := sh.session.Open()
+		if err != nil {
+			d.Close()
+			return fmt.Errorf("failed to open shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+//ShardStatuses returns the current state of each gateway connection
+func (d *EventSource) ShardStatuses() []ShardStatus {
+	res := make([]ShardStatus, len(d.shards))
+	for i, sh := range d.shards {
+		res[i] = sh.getStatus()
+	}
+	return res
+}
+
+//GuildSession returns the session for the shard which receives events for the given guild. Its state is the only one
+//which contains the guild, so it should be used for any state lookups.
+func (d *EventSource) GuildSession(guildID string) *discordgo.Session {
+	return d.shards[shardForGuild(guildID, len(d.shards))].session
+}
@@ -0,0 +1,179 @@
+//Package dispatch runs event handlers on a bounded pool of workers, whilst making sure that events sharing a key are
+//handled one at a time in the order they were received.
+package dispatch
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+//ErrQueueFull is returned by TrySubmit if there is no room for another event
+var ErrQueueFull = errors.New("event queue is full")
+
+//ErrClosed is returned when an event is submitted after the dispatcher has started shutting down
+var ErrClosed = errors.New("event dispatcher is closed")
+
+var (
+	stats           = expvar.NewMap("event_dispatcher")
+	queuedEvents    = new(expvar.Int)
+	processedEvents = new(expvar.Int)
+	blockedSubmits  = new(expvar.Int)
+	rejectedEvents  = new(expvar.Int)
+	panickedEvents  = new(expvar.Int)
+)
+
+func init() {
+	stats.Set("queued", queuedEvents)
+	stats.Set("processed", processedEvents)
+	stats.Set("blocked_submits", blockedSubmits)
+	stats.Set("rejected", rejectedEvents)
+	stats.Set("panicked", panickedEvents)
+}
+
+//Dispatcher queues events by key and runs them on a fixed number of workers. Events with the same key are run in the
+//order they were submitted, and never at the same time as each other.
+type Dispatcher struct {
+	lock sync.Mutex
+	//queues contains the events waiting for each key. The event at the front of a queue stays there while it is being
+	//run, so a key is only ever picked up by one worker at a time.
+	queues map[string][]func()
+	closed bool
+	//runnable contains keys which have events waiting and aren't currently being run. Each key with a queue is either
+	//in runnable or being run, and there can't be more keys than slots, so sending to it never blocks.
+	runnable chan string
+	//slots limits how many events can be queued at once
+	slots   chan struct{}
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+}
+
+//New starts a dispatcher with the given number of workers, which will hold at most maxQueued events at once
+func New(workers, maxQueued int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if maxQueued < 1 {
+		maxQueued = 1
+	}
+	d := &Dispatcher{
+		queues:   make(map[string][]func()),
+		runnable: make(chan string, maxQueued),
+		slots:    make(chan struct{}, maxQueued),
+	}
+	stats.Set("queued_keys", expvar.Func(func() interface{} {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return len(d.queues)
+	}))
+	stats.Set("capacity", expvar.Func(func() interface{} {
+		return cap(d.slots)
+	}))
+	d.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+//Submit queues an event to be run after any other events with the same key. If the queue is full, it blocks until
+//there is room, which slows down whatever is producing events. It must not be called from within an event, as the
+//event would be waiting on itself; use TrySubmit instead.
+func (d *Dispatcher) Submit(key string, event func()) error {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		blockedSubmits.Add(1)
+		d.slots <- struct{}{}
+	}
+	return d.enqueue(key, event)
+}
+
+//TrySubmit queues an event to be run after any other events with the same key, or returns ErrQueueFull straight away
+//if there is no room for it
+func (d *Dispatcher) TrySubmit(key string, event func()) error {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		rejectedEvents.Add(1)
+		return ErrQueueFull
+	}
+	return d.enqueue(key, event)
+}
+
+//enqueue adds an event to the queue for its key, once a slot has been taken for it
+func (d *Dispatcher) enqueue(key string, event func()) error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		<-d.slots
+		rejectedEvents.Add(1)
+		return ErrClosed
+	}
+	d.pending.Add(1)
+	queue, scheduled := d.queues[key]
+	d.queues[key] = append(queue, event)
+	d.lock.Unlock()
+	queuedEvents.Add(1)
+	if !scheduled {
+		d.runnable <- key
+	}
+	return nil
+}
+
+//work runs events until the dispatcher is closed. After each event, its key goes to the back of the line so that a
+//busy key can't starve the others.
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for key := range d.runnable {
+		d.lock.Lock()
+		event := d.queues[key][0]
+		d.lock.Unlock()
+
+		run(key, event)
+
+		d.lock.Lock()
+		remaining := d.queues[key][1:]
+		if len(remaining) == 0 {
+			delete(d.queues, key)
+		} else {
+			d.queues[key] = remaining
+		}
+		d.lock.Unlock()
+		<-d.slots
+		queuedEvents.Add(-1)
+		processedEvents.Add(1)
+		if len(remaining) > 0 {
+			d.runnable <- key
+		}
+		d.pending.Done()
+	}
+}
+
+func run(key string, event func()) {
+	//Prevent panic from crashing the whole bot
+	defer func() {
+		if r := recover(); r != nil {
+			panickedEvents.Add(1)
+			logrus.Errorf("Handler for event with key %v panicked: %v", key, r)
+		}
+	}()
+	event()
+}
+
+//Close stops accepting new events, then waits for every queued event to finish before stopping the workers
+func (d *Dispatcher) Close() {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+	d.closed = true
+	d.lock.Unlock()
+	logrus.Info("Waiting for queued events to finish...")
+	d.pending.Wait()
+	close(d.runnable)
+	d.workers.Wait()
+}
@@ -0,0 +1,264 @@
+package dispatch
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+//closeWithin closes the dispatcher, failing the test if it doesn't finish in time
+func closeWithin(t *testing.T, d *Dispatcher, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		d.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("dispatcher did not close within %v", timeout)
+	}
+}
+
+//blockWorker submits an event which runs until the returned channel is closed, and waits for it to start
+func blockWorker(t *testing.T, d *Dispatcher, key string) chan struct{} {
+	t.Helper()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	err := d.Submit(key, func() {
+		close(started)
+		<-release
+	})
+	if err != nil {
+		t.Fatalf("failed to submit blocking event: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(testTimeout):
+		t.Fatalf("blocking event never started")
+	}
+	return release
+}
+
+func TestOrderingPerKey(t *testing.T) {
+	const keys, eventsPerKey = 16, 200
+	d := New(8, 64)
+	var lock sync.Mutex
+	got := make(map[string][]int, keys)
+	running := make([]int32, keys)
+	var overlaps int32
+
+	var producers sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		producers.Add(1)
+		go func(k int) {
+			defer producers.Done()
+			key := fmt.Sprintf("key-%d", k)
+			for i := 0; i < eventsPerKey; i++ {
+				i := i
+				err := d.Submit(key, func() {
+					if atomic.AddInt32(&running[k], 1) > 1 {
+						atomic.AddInt32(&overlaps, 1)
+					}
+					lock.Lock()
+					got[key] = append(got[key], i)
+					lock.Unlock()
+					atomic.AddInt32(&running[k], -1)
+				})
+				if err != nil {
+					t.Errorf("failed to submit event %d for %v: %v", i, key, err)
+				}
+			}
+		}(k)
+	}
+	producers.Wait()
+	closeWithin(t, d, testTimeout)
+
+	if overlaps > 0 {
+		t.Errorf("events with the same key ran at the same time %d times", overlaps)
+	}
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		if len(got[key]) != eventsPerKey {
+			t.Errorf("expected %d events to run for %v, got %d", eventsPerKey, key, len(got[key]))
+			continue
+		}
+		for i, v := range got[key] {
+			if v != i {
+				t.Errorf("events for %v ran out of order: %v", key, got[key])
+				break
+			}
+		}
+	}
+}
+
+func TestOtherKeysRunWhileOneIsBusy(t *testing.T) {
+	d := New(2, 10)
+	release := blockWorker(t, d, "busy")
+	defer closeWithin(t, d, testTimeout)
+	defer close(release)
+
+	done := make(chan struct{})
+	d.Submit("other", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("event for another key was held up by a busy key")
+	}
+}
+
+func TestTrySubmitDropsWhenFull(t *testing.T) {
+	d := New(1, 2)
+	release := blockWorker(t, d, "a")
+	//The running event still holds its slot, so there is only room for one more
+	err := d.TrySubmit("b", func() {})
+	if err != nil {
+		t.Fatalf("expected event to be queued, got %v", err)
+	}
+	ran := false
+	err = d.TrySubmit("c", func() { ran = true })
+	if err != ErrQueueFull {
+		t.Errorf("expected %v, got %v", ErrQueueFull, err)
+	}
+	close(release)
+	closeWithin(t, d, testTimeout)
+	if ran {
+		t.Errorf("dropped event was run")
+	}
+}
+
+func TestSubmitBlocksWhenFull(t *testing.T) {
+	d := New(1, 2)
+	release := blockWorker(t, d, "a")
+	d.Submit("b", func() {})
+
+	ran := make(chan struct{})
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- d.Submit("c", func() { close(ran) })
+	}()
+	select {
+	case err := <-submitted:
+		t.Fatalf("submit returned %v whilst the queue was full", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-submitted:
+		if err != nil {
+			t.Fatalf("expected blocked event to be queued once there was room, got %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("submit was still blocked after the queue emptied")
+	}
+	select {
+	case <-ran:
+	case <-time.After(testTimeout):
+		t.Fatalf("blocked event never ran")
+	}
+	closeWithin(t, d, testTimeout)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	d := New(1, 10)
+	after := make(chan struct{})
+	d.Submit("a", func() { panic("oh no") })
+	d.Submit("a", func() { close(after) })
+	select {
+	case <-after:
+	case <-time.After(testTimeout):
+		t.Fatalf("event after a panicking event with the same key never ran")
+	}
+	//The worker which ran the panicking event must still be alive
+	other := make(chan struct{})
+	d.Submit("b", func() { close(other) })
+	select {
+	case <-other:
+	case <-time.After(testTimeout):
+		t.Fatalf("dispatcher stopped running events after a panic")
+	}
+	closeWithin(t, d, testTimeout)
+}
+
+func TestCloseDrainsQueuedEvents(t *testing.T) {
+	const events = 100
+	d := New(3, events)
+	var count int32
+	for i := 0; i < events; i++ {
+		err := d.Submit(fmt.Sprintf("key-%d", i%7), func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&count, 1)
+		})
+		if err != nil {
+			t.Fatalf("failed to submit event %d: %v", i, err)
+		}
+	}
+	closeWithin(t, d, testTimeout)
+	if count != events {
+		t.Errorf("expected all %d queued events to run before close returned, but %d did", events, count)
+	}
+	if err := d.Submit("late", func() {}); err != ErrClosed {
+		t.Errorf("expected %v when submitting after close, got %v", ErrClosed, err)
+	}
+	if err := d.TrySubmit("late", func() {}); err != ErrClosed {
+		t.Errorf("expected %v when trying to submit after close, got %v", ErrClosed, err)
+	}
+	//Closing again is a no-op
+	closeWithin(t, d, testTimeout)
+}
+
+func TestCloseWithBlockedSubmit(t *testing.T) {
+	d := New(1, 1)
+	release := blockWorker(t, d, "a")
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- d.Submit("b", func() {})
+	}()
+	//Give the submit time to block on the full queue
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		d.Close()
+		close(closed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Fatalf("close deadlocked with a submit waiting for room")
+	}
+	select {
+	case err := <-submitted:
+		if err != nil && err != ErrClosed {
+			t.Errorf("expected blocked submit to succeed or return %v, got %v", ErrClosed, err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("blocked submit never returned")
+	}
+}
+
+func TestEventsCanTrySubmitDuringClose(t *testing.T) {
+	d := New(1, 10)
+	results := make(chan error, 1)
+	release := make(chan struct{})
+	d.Submit("a", func() {
+		<-release
+		results <- d.TrySubmit("a", func() {})
+	})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	closeWithin(t, d, testTimeout)
+	if err := <-results; err != ErrClosed {
+		t.Errorf("expected event submitted during close to be rejected with %v, got %v", ErrClosed, err)
+	}
+}
@@ -10,7 +10,7 @@ require (
 	github.com/callummance/nazuna v0.0.0-20210528200130-aae064d70da0
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
 	github.com/joho/godotenv v1.3.0
 	github.com/prometheus/common v0.19.0
@@ -22,4 +22,5 @@ require (
 	google.golang.org/appengine v1.6.7 // indirect
 	gopkg.in/fatih/pool.v2 v2.0.0 // indirect
 	gopkg.in/gorethink/gorethink.v3 v3.0.5
+	modernc.org/sqlite v1.11.1
 )
@@ -0,0 +1,37 @@
+package guildmodels
+
+//Possible values for the mature content filter on a StreamAlertRoute
+const (
+	MatureFilterAllow = "allow"
+	MatureFilterDeny  = "deny"
+	MatureFilterOnly  = "only"
+)
+
+//StreamAlertRoute represents a channel which stream alerts should be posted to, along with filters deciding which
+//streams should be posted there. Empty filters match every stream.
+type StreamAlertRoute struct {
+	RouteID   string   `gorethink:"id"`
+	ChannelID string   `gorethink:"channel_id"`
+	Games     []string `gorethink:"games,omitempty"`
+	MemberIDs []string `gorethink:"member_ids,omitempty"`
+	RoleIDs   []string `gorethink:"role_ids,omitempty"`
+	Mature    string   `gorethink:"mature,omitempty"`
+	//Fallback routes are only used if none of the non-fallback routes in a guild match a stream
+	Fallback bool `gorethink:"fallback,omitempty"`
+}
+
+//AlertRoutes returns the stream alert routes for a guild. Guilds which were set up with a single stream notification
+//channel will have it returned as a fallback route.
+func (nc *NotificationChannels) AlertRoutes() []StreamAlertRoute {
+	if nc == nil {
+		return nil
+	}
+	if len(nc.StreamAlertRoutes) == 0 && nc.StreamNotificationsChannel != nil {
+		return []StreamAlertRoute{{
+			RouteID:   "default",
+			ChannelID: *nc.StreamNotificationsChannel,
+			Fallback:  true,
+		}}
+	}
+	return nc.StreamAlertRoutes
+}
@@ -0,0 +1,29 @@
+package guildmodels
+
+//AlertTemplate contains the text/template strings used to build stream alert posts within a guild. Any
+//empty string fields will fall back to the bot's default alert text.
+type AlertTemplate struct {
+	Content     string               `gorethink:"content,omitempty"`
+	Title       string               `gorethink:"title,omitempty"`
+	Description string               `gorethink:"description,omitempty"`
+	Colour      *int                 `gorethink:"colour,omitempty"`
+	Fields      []AlertTemplateField `gorethink:"fields,omitempty"`
+	PingRoleID  string               `gorethink:"ping_role_id,omitempty"`
+	PingHere    bool                 `gorethink:"ping_here,omitempty"`
+}
+
+//AlertTemplateField represents a single embed field in a stream alert post. Both the name and value are
+//templates.
+type AlertTemplateField struct {
+	Name   string `gorethink:"name"`
+	Value  string `gorethink:"value"`
+	Inline bool   `gorethink:"inline"`
+}
+
+//DefaultAlertTemplate returns the template used for guilds which have not set their own
+func DefaultAlertTemplate() AlertTemplate {
+	return AlertTemplate{
+		Title:       "{{.Title}}",
+		Description: "{{.Streamer}} is streaming {{.Game}} for {{.Viewers}} users",
+	}
+}
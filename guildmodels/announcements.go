@@ -0,0 +1,29 @@
+package guildmodels
+
+//Types of twitch community events which can be announced
+const (
+	AnnouncementRaid      = "raid"
+	AnnouncementFollow    = "follow"
+	AnnouncementSubscribe = "subscribe"
+)
+
+//DefaultFollowMilestoneStep is used for follow announcements which don't set a MilestoneStep
+const DefaultFollowMilestoneStep = 100
+
+//Announcement contains where and how one type of twitch community event should be announced in a guild
+type Announcement struct {
+	ChannelID string `gorethink:"channel_id"`
+	//Template is the text of the announcement, using Go template syntax. The default for the event type is used if
+	//it is empty.
+	Template string `gorethink:"template,omitempty"`
+	//MilestoneStep is only used for follows, which are announced each time a follower count reaches a multiple of it
+	MilestoneStep int `gorethink:"milestone_step,omitempty"`
+	//MembersOnly is only used for raids, and limits announcements to raids where both broadcasters are linked to
+	//members of the guild
+	MembersOnly bool `gorethink:"members_only,omitempty"`
+}
+
+//AnnouncementTypes returns every type of event which can be announced
+func AnnouncementTypes() []string {
+	return []string{AnnouncementRaid, AnnouncementFollow, AnnouncementSubscribe}
+}
@@ -0,0 +1,20 @@
+package guildmodels
+
+import "time"
+
+//ClipsFeed configures where clips of linked members' twitch streams are posted in a guild
+type ClipsFeed struct {
+	ChannelID string `gorethink:"channel_id"`
+	//MinViews is the number of views a clip needs before it is posted
+	MinViews int `gorethink:"min_views"`
+	//Since is when the feed was set up. Clips created before then are never posted.
+	Since time.Time `gorethink:"since"`
+}
+
+//PostedClip records a clip which has been posted to a guild's clips feed, so that it isn't posted again
+type PostedClip struct {
+	GuildID   string     `gorethink:"id[0]"`
+	ClipID    string     `gorethink:"id[1]"`
+	CreatedAt time.Time  `gorethink:"created_at"`
+	Post      MessageRef `gorethink:"post"`
+}
@@ -0,0 +1,60 @@
+package guildmodels
+
+import "strings"
+
+//FollowedTeam is a twitch team whose members a guild wants alerts for
+type FollowedTeam struct {
+	//Name is the team's unique name on twitch, as used in its URL
+	Name        string `gorethink:"name"`
+	DisplayName string `gorethink:"display_name"`
+	//Streams holds the keys (see StreamKey) of each channel on the team's roster when it was last synced
+	Streams []string `gorethink:"streams"`
+}
+
+//FollowedCategory is a twitch category which a guild wants followed streams to be announced in
+type FollowedCategory struct {
+	ID   string `gorethink:"id"`
+	Name string `gorethink:"name"`
+}
+
+//FollowsStream returns true if the guild follows the stream with the provided key, either directly or as part of a
+//team
+func (g *DiscordGuild) FollowsStream(streamKey string) bool {
+	for _, key := range g.FollowedStreams {
+		if key == streamKey {
+			return true
+		}
+	}
+	for _, team := range g.FollowedTeams {
+		for _, key := range team.Streams {
+			if key == streamKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//FollowedTeam returns the team with the provided name which the guild follows, or nil if it doesn't follow it
+func (g *DiscordGuild) FollowedTeam(name string) *FollowedTeam {
+	for i, team := range g.FollowedTeams {
+		if strings.EqualFold(team.Name, name) {
+			return &g.FollowedTeams[i]
+		}
+	}
+	return nil
+}
+
+//WantsCategory returns true if alerts for followed streams should be posted for streams in the provided category. If
+//the guild doesn't follow any categories, streams in every category are wanted.
+func (g *DiscordGuild) WantsCategory(category string) bool {
+	if len(g.FollowedCategories) == 0 {
+		return true
+	}
+	for _, followed := range g.FollowedCategories {
+		if strings.EqualFold(followed.Name, category) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,16 +1,45 @@
 package guildmodels
 
+import "time"
+
 //DiscordGuild contains configuration for a discord guild managed by this bot
 type DiscordGuild struct {
 	DiscordGID           string                `gorethink:"id"`
 	AdminRoles           []string              `gorethink:"admin_roles,omitempty"`
 	NotificationChannels *NotificationChannels `gorethink:"notification_channels,omitempty"`
+	StreamAlertTemplate  *AlertTemplate        `gorethink:"stream_alert_template,omitempty"`
+	//Keys (see StreamKey) of streams the guild wants alerts for regardless of whether they are linked to a member
+	FollowedStreams []string `gorethink:"followed_streams,omitempty"`
+	//If VerifiedLinks is set, members must log in to a provider to prove they own a channel before linking it
+	VerifiedLinks bool `gorethink:"verified_links,omitempty"`
+	//Announcements configures announcements of twitch community events, keyed by event type (eg. AnnouncementRaid)
+	Announcements map[string]*Announcement `gorethink:"announcements,omitempty"`
+	//If PresenceStreaming is set, members who show as streaming in discord get alerts even without a linked channel
+	PresenceStreaming bool `gorethink:"presence_streaming,omitempty"`
+	//If ScheduledEvents is set, linked members' stream schedules are kept in sync with the guild's scheduled events
+	ScheduledEvents bool `gorethink:"scheduled_events,omitempty"`
+	//ClipsFeed configures posting of clips from linked members' twitch streams, or is nil if it is turned off
+	ClipsFeed *ClipsFeed `gorethink:"clips_feed,omitempty"`
+	//FollowedTeams are twitch teams whose members the guild wants alerts for, along with their current rosters
+	FollowedTeams []FollowedTeam `gorethink:"followed_teams,omitempty"`
+	//If any FollowedCategories are set, followed streams are only announced when they go live in one of them
+	FollowedCategories []FollowedCategory `gorethink:"followed_categories,omitempty"`
+	//LeftAt is set when the bot is removed from the guild. The guild is inactive until the bot rejoins, and its data is
+	//purged once it has been inactive for long enough.
+	LeftAt *time.Time `gorethink:"left_at,omitempty"`
+}
+
+//Active returns true unless the bot has been removed from the guild
+func (g *DiscordGuild) Active() bool {
+	return g.LeftAt == nil
 }
 
 //NotificationChannels contains details on which channel each type of alert should be
 //posted onto within a discord guild
 type NotificationChannels struct {
-	StreamNotificationsChannel *string `gorethink:"stream_notification_channel,omitempty"`
+	//Deprecated: replaced by StreamAlertRoutes, but still read for guilds which have not set up any routes
+	StreamNotificationsChannel *string            `gorethink:"stream_notification_channel,omitempty"`
+	StreamAlertRoutes          []StreamAlertRoute `gorethink:"stream_alert_routes,omitempty"`
 }
 
 //DefaultGuild returns an otherwise-empty guild struct with a given ID
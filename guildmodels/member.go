@@ -5,27 +5,33 @@ type MemberData struct {
 	GuildID     string            `gorethink:"id[0]"`
 	UserID      string            `gorethink:"id[1]"`
 	Connections MemberConnections `gorethink:"connections"`
+	//If HideSchedule is set, the member's stream schedule won't be added to the guild's scheduled events
+	HideSchedule bool `gorethink:"hide_schedule,omitempty"`
 }
 
 //MemberConnections contains a bit of data on a member
 type MemberConnections struct {
-	TwitchConnection *TwitchStream `gorethink:"twitch_link,omitempty,reference" gorethink_ref:"tid"`
+	//StreamLinks maps the name of each streaming provider to the ID of the member's channel on that provider
+	StreamLinks map[string]string `gorethink:"links,omitempty"`
 }
 
-//NumConnections returns the number of non-nil connections in a MemberConnections struct
-func (cs *MemberConnections) NumConnections() int {
-	res := 0
-	if cs.TwitchConnection != nil {
-		res++
-	}
-	return res
-}
-
-//TwitchStream contains details on a link to a single twitch stream as well as data on its current state
-type TwitchStream struct {
-	TwitchUID          string       `gorethink:"tid"`
+//StreamChannel contains details on a single channel from a streaming provider which has been linked to a member or
+//followed by a guild, as well as data on its current state
+type StreamChannel struct {
+	//Key uniquely identifies the channel across all providers, and is generated by StreamKey
+	Key                string       `gorethink:"id"`
+	Provider           string       `gorethink:"provider"`
+	ChannelID          string       `gorethink:"channel_id"`
+	Login              string       `gorethink:"login,omitempty"`
 	DiscordStatusPosts []MessageRef `gorethink:"posts,omitempty"`
 	IsLive             bool         `gorethink:"is_live"`
+	//Followers is the follower count seen in the most recent follow event, used to detect follower milestones
+	Followers int `gorethink:"followers,omitempty"`
+}
+
+//StreamKey returns the key used to identify a channel across all providers
+func StreamKey(provider, channelID string) string {
+	return provider + ":" + channelID
 }
 
 //MessageRef contains the details needed to specify a single discord message
@@ -0,0 +1,25 @@
+package guildmodels
+
+import "time"
+
+//ScheduledEventLink ties a stream from a channel's schedule to the discord scheduled event created for it in a guild.
+//The details of the event are stored so that it is only edited when the schedule changes.
+type ScheduledEventLink struct {
+	GuildID string `gorethink:"id[0]"`
+	//ScheduleKey identifies the scheduled stream, and is generated by ScheduleKey
+	ScheduleKey string `gorethink:"id[1]"`
+	//StreamKey identifies the channel the stream is scheduled on
+	StreamKey   string    `gorethink:"stream"`
+	EventID     string    `gorethink:"event_id"`
+	Name        string    `gorethink:"name"`
+	Description string    `gorethink:"description"`
+	Location    string    `gorethink:"location"`
+	StartTime   time.Time `gorethink:"start_time"`
+	EndTime     time.Time `gorethink:"end_time"`
+	Cancelled   bool      `gorethink:"cancelled,omitempty"`
+}
+
+//ScheduleKey returns the key used to identify a stream in a channel's schedule across all providers
+func ScheduleKey(provider, channelID, scheduledStreamID string) string {
+	return StreamKey(provider, channelID) + ":" + scheduledStreamID
+}
@@ -0,0 +1,17 @@
+package guildmodels
+
+import "time"
+
+//TwitchWebhookSecretID is the ID under which the twitch eventsub webhook secret is stored
+const TwitchWebhookSecretID = "twitch_webhook"
+
+//WebhookSecret is a secret used to sign incoming webhook notifications, along with the secret it replaced
+type WebhookSecret struct {
+	ID       string `gorethink:"id"`
+	Current  string `gorethink:"current"`
+	Previous string `gorethink:"previous,omitempty"`
+	//CreatedAt is when Current was generated
+	CreatedAt time.Time `gorethink:"created_at"`
+	//Applied is set once everything using the secret has been moved over to Current
+	Applied bool `gorethink:"applied"`
+}
@@ -0,0 +1,26 @@
+package guildmodels
+
+import "time"
+
+//StreamSession records a single broadcast by a stream channel, from when it went live to when it went offline
+type StreamSession struct {
+	ID string `gorethink:"id,omitempty"`
+	//StreamKey identifies the channel which was live, and is generated by StreamKey
+	StreamKey string    `gorethink:"stream"`
+	Provider  string    `gorethink:"provider"`
+	ChannelID string    `gorethink:"channel_id"`
+	StartedAt time.Time `gorethink:"started_at"`
+	//EndedAt is nil for sessions which are still live
+	EndedAt *time.Time `gorethink:"ended_at,omitempty"`
+	//Titles and Games contain each distinct title and game seen during the session
+	Titles  []string       `gorethink:"titles,omitempty"`
+	Games   []string       `gorethink:"games,omitempty"`
+	Samples []ViewerSample `gorethink:"samples,omitempty"`
+}
+
+//ViewerSample is a snapshot of a live stream's viewer count and game
+type ViewerSample struct {
+	Time    time.Time `gorethink:"time"`
+	Viewers int       `gorethink:"viewers"`
+	Game    string    `gorethink:"game,omitempty"`
+}
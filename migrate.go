@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/callummance/nia/db"
+	"github.com/sirupsen/logrus"
+)
+
+//runMigrate applies any pending database migrations, or with --dry-run lists the migrations which would be applied,
+//without starting the bot
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list pending migrations without applying them")
+	_ = flags.Parse(args)
+
+	if *dryRun {
+		conn, err := db.Connect()
+		if err != nil {
+			logrus.Fatalf("Failed to connect to database due to error %v", err)
+		}
+		defer conn.Close()
+		results, err := conn.Migrate(true)
+		if err != nil {
+			logrus.Fatalf("Failed to check migrations due to error %v", err)
+		} else if len(results) == 0 {
+			logrus.Info("Database is up to date")
+		}
+		return
+	}
+
+	//Init creates any missing tables and applies migrations
+	conn, err := db.Init()
+	if err != nil {
+		logrus.Fatalf("Failed to migrate database due to error %v", err)
+	}
+	conn.Close()
+	logrus.Info("Database is up to date")
+}
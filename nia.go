@@ -1,7 +1,9 @@
 package main
 
 import (
+	_ "expvar"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,6 +14,9 @@ import (
 )
 
 const logLevelEnvVar string = "NIA_LOG_LEVEL"
+
+//debugListenEnvVar sets the address to serve runtime metrics on at /debug/vars. Metrics are not served if it is unset.
+const debugListenEnvVar string = "NIA_DEBUG_LISTEN_ADDR"
 const defaultLogLevel = logrus.InfoLevel
 
 func main() {
@@ -38,6 +43,22 @@ func main() {
 		logrus.SetLevel(logrus.ErrorLevel)
 	}
 
+	//Run migrations without starting the bot if requested
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	//Serve metrics if requested
+	if debugAddr, exists := os.LookupEnv(debugListenEnvVar); exists {
+		go func() {
+			err := http.ListenAndServe(debugAddr, nil)
+			if err != nil {
+				logrus.Errorf("Metrics server stopped due to error %v", err)
+			}
+		}()
+	}
+
 	//Init bot
 	bot, err := bot.Init()
 	if err != nil {
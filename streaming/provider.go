@@ -0,0 +1,123 @@
+package streaming
+
+import "time"
+
+//Provider is a streaming service which members can link their channels from and which guilds can follow channels on.
+//Providers emit online and offline events for every channel they are subscribed to through an EventHandler.
+type Provider interface {
+	//Info returns static details about the provider
+	Info() ProviderInfo
+	//ResolveChannel looks up a channel from a name, handle or URL as typed by a user
+	ResolveChannel(nameOrURL string) (*Channel, error)
+	//Subscribe starts generating online and offline events for the channel with the provided ID
+	Subscribe(channelID string) error
+	//Unsubscribe stops generating events for the channel with the provided ID
+	Unsubscribe(channelID string) error
+	//GetLiveStreams retrieves details on each of the provided channels which are currently live, keyed by channel ID.
+	//Channels which are not live will not have an entry in the returned map.
+	GetLiveStreams(channelIDs []string) (map[string]*LiveStream, error)
+	//ForceUpdate checks the current state of a channel and emits an online or offline event to match
+	ForceUpdate(channelID string) error
+	Close() error
+}
+
+//ProviderInfo contains static details about a provider
+type ProviderInfo struct {
+	//Name identifies the provider in the database and in commands, eg. "twitch"
+	Name string
+	//DisplayName is used when the provider is mentioned in discord messages, eg. "Twitch"
+	DisplayName string
+	//Colour is the default colour of alert embeds for streams on this provider
+	Colour int
+}
+
+//Channel identifies a single channel on a provider
+type Channel struct {
+	Provider        string
+	ID              string
+	Login           string
+	DisplayName     string
+	URL             string
+	ProfileImageURL string
+}
+
+//LiveStream contains details on a stream which is currently live
+type LiveStream struct {
+	Provider    string
+	ChannelID   string
+	ChannelName string
+	Title       string
+	Game        string
+	Viewers     int
+	IsMature    bool
+	StartedAt   time.Time
+	//URL links to the stream itself
+	URL string
+	//ChannelURL links to the channel the stream is on, and can be passed to ResolveChannel
+	ChannelURL string
+	//ThumbnailURL links to a full size preview image of the stream
+	ThumbnailURL string
+	//Platform is the name of the site the stream is on, if it is different to the provider's DisplayName
+	Platform string
+}
+
+//OnlineEvent is emitted by a provider when a channel starts streaming
+type OnlineEvent struct {
+	Provider    string
+	ChannelID   string
+	ChannelName string
+}
+
+//OfflineEvent is emitted by a provider when a channel stops streaming
+type OfflineEvent struct {
+	Provider  string
+	ChannelID string
+}
+
+//EventHandler handles the events generated by providers
+type EventHandler interface {
+	HandleStreamOnline(*OnlineEvent)
+	HandleStreamOffline(*OfflineEvent)
+}
+
+//OwnershipVerifier is implemented by providers which can confirm that a user owns a channel by having them log in to
+//the provider
+type OwnershipVerifier interface {
+	//VerificationURL returns a page the user should visit to log in. Once they have, the provider passes the
+	//channel they logged in as, along with the same state, to its VerificationHandler.
+	VerificationURL(state string) (string, error)
+}
+
+//Verification is emitted by a provider when a user has logged in through a VerificationURL
+type Verification struct {
+	Provider string
+	State    string
+	Channel  Channel
+}
+
+//VerificationHandler handles the results of ownership verification. The returned message is shown to the user at
+//the end of the login flow.
+type VerificationHandler interface {
+	HandleVerification(*Verification) (string, error)
+}
+
+//ScheduleReader is implemented by providers which let channels publish a schedule of upcoming streams
+type ScheduleReader interface {
+	//GetSchedule returns the streams a channel has scheduled to start before the provided time, including any which
+	//have been cancelled
+	GetSchedule(channelID string, until time.Time) ([]ScheduledStream, error)
+}
+
+//ScheduledStream is a single upcoming stream from a channel's schedule
+type ScheduledStream struct {
+	//ID identifies the stream within the channel's schedule, and stays the same if the stream is rescheduled
+	ID          string
+	ChannelName string
+	Title       string
+	Game        string
+	StartTime   time.Time
+	EndTime     time.Time
+	Cancelled   bool
+	//ChannelURL links to the channel the stream will be on
+	ChannelURL string
+}
@@ -0,0 +1,48 @@
+package twitch
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//Clip is a clip created from one of a broadcaster's streams
+type Clip struct {
+	ID              string    `json:"id"`
+	URL             string    `json:"url"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name"`
+	CreatorName     string    `json:"creator_name"`
+	Title           string    `json:"title"`
+	ViewCount       int       `json:"view_count"`
+	CreatedAt       time.Time `json:"created_at"`
+	ThumbnailURL    string    `json:"thumbnail_url"`
+	//Duration is the length of the clip in seconds
+	Duration float64 `json:"duration"`
+}
+
+//GetClips returns every clip of a broadcaster's streams which was created after since
+func (t *EventSource) GetClips(twitchUID string, since time.Time) ([]Clip, error) {
+	query := url.Values{
+		"broadcaster_id": {twitchUID},
+		"started_at":     {since.UTC().Format(time.RFC3339)},
+		"ended_at":       {time.Now().UTC().Format(time.RFC3339)},
+		"first":          {"100"},
+	}
+	var res []Clip
+	for {
+		var resp struct {
+			Data       []Clip          `json:"data"`
+			Pagination helixPagination `json:"pagination"`
+		}
+		err := t.helix.do(http.MethodGet, "/clips", query, nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resp.Data...)
+		if resp.Pagination.Cursor == "" || len(resp.Data) == 0 {
+			return res, nil
+		}
+		query.Set("after", resp.Pagination.Cursor)
+	}
+}
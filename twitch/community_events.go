@@ -0,0 +1,205 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+//communityEvent describes an optional eventsub subscription type which is created alongside the stream online and
+//offline subscriptions for every broadcaster
+type communityEvent struct {
+	subType string
+	version string
+	//condition builds the subscription condition for a broadcaster
+	condition func(twitchUID string) map[string]string
+}
+
+//communityEvents contains each of the event types which can be enabled using NIA_TWITCH_COMMUNITY_EVENTS
+var communityEvents = map[string]communityEvent{
+	"raid": {
+		subType: "channel.raid",
+		version: "1",
+		//Only raids by the broadcaster are wanted; raids between two subscribed broadcasters will still be caught
+		condition: func(twitchUID string) map[string]string {
+			return map[string]string{"from_broadcaster_user_id": twitchUID}
+		},
+	},
+	"follow": {
+		subType: "channel.follow",
+		version: "2",
+		condition: func(twitchUID string) map[string]string {
+			return map[string]string{"broadcaster_user_id": twitchUID, "moderator_user_id": twitchUID}
+		},
+	},
+	"subscribe": {
+		subType: "channel.subscribe",
+		version: "1",
+		condition: func(twitchUID string) map[string]string {
+			return map[string]string{"broadcaster_user_id": twitchUID}
+		},
+	},
+}
+
+//RaidEvent is emitted when a broadcaster raids another channel
+type RaidEvent struct {
+	FromUID   string `json:"from_broadcaster_user_id"`
+	FromLogin string `json:"from_broadcaster_user_login"`
+	FromName  string `json:"from_broadcaster_user_name"`
+	ToUID     string `json:"to_broadcaster_user_id"`
+	ToLogin   string `json:"to_broadcaster_user_login"`
+	ToName    string `json:"to_broadcaster_user_name"`
+	Viewers   int    `json:"viewers"`
+}
+
+//FollowEvent is emitted when a user follows a broadcaster
+type FollowEvent struct {
+	UserID           string `json:"user_id"`
+	UserName         string `json:"user_name"`
+	BroadcasterUID   string `json:"broadcaster_user_id"`
+	BroadcasterLogin string `json:"broadcaster_user_login"`
+	BroadcasterName  string `json:"broadcaster_user_name"`
+	//Followers is the broadcaster's follower count after the follow, or 0 if it could not be retrieved
+	Followers int `json:"-"`
+}
+
+//SubscribeEvent is emitted when a user subscribes to a broadcaster
+type SubscribeEvent struct {
+	UserID           string `json:"user_id"`
+	UserName         string `json:"user_name"`
+	BroadcasterUID   string `json:"broadcaster_user_id"`
+	BroadcasterLogin string `json:"broadcaster_user_login"`
+	BroadcasterName  string `json:"broadcaster_user_name"`
+	//Tier is 1000, 2000 or 3000 for tier 1, 2 and 3 subscriptions respectively
+	Tier   string `json:"tier"`
+	IsGift bool   `json:"is_gift"`
+}
+
+//parseCommunityEvents reads a comma separated list of community event names, returning the matching eventsub types
+func parseCommunityEvents(names string) ([]string, error) {
+	var res []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		ev, exists := communityEvents[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown twitch community event `%v`; expected some of raid, follow and subscribe", name)
+		}
+		res = append(res, ev.subType)
+	}
+	return res, nil
+}
+
+func communityEventByType(subType string) (communityEvent, bool) {
+	for _, ev := range communityEvents {
+		if ev.subType == subType {
+			return ev, true
+		}
+	}
+	return communityEvent{}, false
+}
+
+//subscriptionVersion returns the eventsub version used for a subscription type
+func subscriptionVersion(subType string) string {
+	if ev, exists := communityEventByType(subType); exists {
+		return ev.version
+	}
+	return "1"
+}
+
+//subscriptionBroadcaster returns the UID of the broadcaster a subscription was created for
+func subscriptionBroadcaster(sub subscriptionInfo) string {
+	if uid, exists := sub.Condition["from_broadcaster_user_id"]; exists && sub.Type == "channel.raid" {
+		return uid
+	}
+	return sub.Condition["broadcaster_user_id"]
+}
+
+//subscribeCommunityEvents creates any enabled community event subscriptions which a broadcaster doesn't already
+//have. These are optional, so failures are only logged; follows and subscriptions in particular need the
+//broadcaster to have authorized us. subscriptionsLock must be held by the caller.
+func (t *EventSource) subscribeCommunityEvents(twitchUID string) {
+	if len(t.communityEvents) == 0 {
+		return
+	}
+	subs := t.communitySubscriptions[twitchUID]
+	if subs == nil {
+		subs = make(map[string]string, len(t.communityEvents))
+		t.communitySubscriptions[twitchUID] = subs
+	}
+	for _, subType := range t.communityEvents {
+		if _, exists := subs[subType]; exists {
+			continue
+		}
+		ev, _ := communityEventByType(subType)
+		id, err := t.transport.createSubscription(subType, ev.condition(twitchUID))
+		if err != nil {
+			logrus.Warnf("Failed to subscribe to twitch %v events for %v due to error %v", subType, twitchUID, err)
+			continue
+		}
+		subs[subType] = id
+	}
+}
+
+//unsubscribeCommunityEvents deletes every community event subscription for a broadcaster. subscriptionsLock must be
+//held by the caller.
+func (t *EventSource) unsubscribeCommunityEvents(twitchUID string) {
+	for subType, id := range t.communitySubscriptions[twitchUID] {
+		err := t.transport.deleteSubscription(id)
+		if err != nil {
+			logrus.Warnf("Failed to delete twitch %v subscription for %v due to error %v", subType, twitchUID, err)
+		}
+	}
+	delete(t.communitySubscriptions, twitchUID)
+}
+
+//dispatchCommunityEvent decodes a community event notification and passes it on to the handler
+func (t *EventSource) dispatchCommunityEvent(s *eventsubSubscription, event json.RawMessage) {
+	var err error
+	switch s.Type {
+	case "channel.raid":
+		var ev RaidEvent
+		if err = json.Unmarshal(event, &ev); err == nil {
+			logrus.Debugf("Got raid from %v to %v", ev.FromName, ev.ToName)
+			t.handler.HandleTwitchRaid(&ev)
+		}
+	case "channel.follow":
+		var ev FollowEvent
+		if err = json.Unmarshal(event, &ev); err == nil {
+			ev.Followers, err = t.helix.followerCount(ev.BroadcasterUID)
+			if err != nil {
+				logrus.Warnf("Failed to look up follower count for %v due to error %v", ev.BroadcasterName, err)
+			}
+			logrus.Debugf("Got follow from %v to %v", ev.UserName, ev.BroadcasterName)
+			t.handler.HandleTwitchFollow(&ev)
+			err = nil
+		}
+	case "channel.subscribe":
+		var ev SubscribeEvent
+		if err = json.Unmarshal(event, &ev); err == nil {
+			logrus.Debugf("Got subscription from %v to %v", ev.UserName, ev.BroadcasterName)
+			t.handler.HandleTwitchSubscribe(&ev)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Failed to decode %v event %v due to error %v", s.Type, string(event), err)
+	}
+}
+
+//followerCount returns the total number of followers a broadcaster has
+func (h *helixClient) followerCount(twitchUID string) (int, error) {
+	var resp struct {
+		Total int `json:"total"`
+	}
+	err := h.do(http.MethodGet, "/channels/followers", url.Values{"broadcaster_id": {twitchUID}, "first": {"1"}}, nil, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Total, nil
+}
@@ -0,0 +1,186 @@
+package twitch
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/callummance/nazuna"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	twitchClientIDEnvVar     = "NIA_TWITCH_CLIENT_ID"
+	twitchClientSecretEnvVar = "NIA_TWITCH_CLIENT_SECRET"
+	serverHostnameEnvVar     = "NIA_TWITCH_SERVER_HOSTNAME"
+	serverPortEnvVar         = "NIA_TWITCH_SERVER_WH_LISTEN_PORT"
+	eventsubTransportEnvVar  = "NIA_TWITCH_EVENTSUB_TRANSPORT"
+	websocketURLEnvVar       = "NIA_TWITCH_EVENTSUB_WS_URL"
+	apiURLEnvVar             = "NIA_TWITCH_API_URL"
+	authURLEnvVar            = "NIA_TWITCH_AUTH_URL"
+	userAccessTokenEnvVar    = "NIA_TWITCH_USER_ACCESS_TOKEN"
+	userRefreshTokenEnvVar   = "NIA_TWITCH_USER_REFRESH_TOKEN"
+	monitorIntervalEnvVar    = "NIA_TWITCH_SUBSCRIPTION_CHECK_INTERVAL"
+	oauthRedirectURLEnvVar   = "NIA_TWITCH_OAUTH_REDIRECT_URL"
+	communityEventsEnvVar    = "NIA_TWITCH_COMMUNITY_EVENTS"
+	webhookPermissiveEnvVar  = "NIA_TWITCH_WEBHOOK_PERMISSIVE"
+	secretRotationEnvVar     = "NIA_TWITCH_WEBHOOK_SECRET_ROTATION"
+)
+
+const (
+	defaultWebsocketURL = "wss://eventsub.wss.twitch.tv/ws"
+	defaultAPIURL       = "https://api.twitch.tv/helix"
+	defaultAuthURL      = "https://id.twitch.tv/oauth2"
+	defaultServerPort   = ":8080"
+	//defaultSecretRotation is how long a webhook secret is used for before it is replaced
+	defaultSecretRotation = 30 * 24 * time.Hour
+	//webhookPath is where eventsub webhook notifications are delivered
+	webhookPath = "/twitchhook"
+)
+
+const (
+	//transportWebhook receives eventsub notifications via a webhook served by nazuna. This requires the bot to be
+	//reachable over HTTPS at NIA_TWITCH_SERVER_HOSTNAME.
+	transportWebhook = "webhook"
+	//transportWebsocket receives eventsub notifications over an outgoing websocket connection, so no public
+	//hostname is needed. Twitch requires websocket subscriptions to be created with a user access token.
+	transportWebsocket = "websocket"
+)
+
+//twitchConfig holds the twitch settings read from the environment
+type twitchConfig struct {
+	clientID         string
+	clientSecret     string
+	transport        string
+	serverHostname   string
+	serverPort       string
+	permissive       bool
+	websocketURL     string
+	apiURL           string
+	authURL          string
+	userAccessToken  string
+	userRefreshToken string
+	monitorInterval  time.Duration
+	//oauthRedirectURL is the public URL of the OAuth callback used to verify channel ownership. If it is empty,
+	//ownership verification is unavailable.
+	oauthRedirectURL string
+	//webhookBackend is the loopback address nazuna's webhook server listens on. It is picked when the listener starts,
+	//and requests are proxied to it from the public server on NIA_TWITCH_SERVER_WH_LISTEN_PORT.
+	webhookBackend string
+	//communityEvents contains the eventsub types of any optional events which should be subscribed to for every
+	//broadcaster, such as channel.raid
+	communityEvents []string
+	//secretRotation is how often the webhook secret is replaced, or zero if it never is
+	secretRotation time.Duration
+}
+
+func getConfigFromEnv() (*twitchConfig, error) {
+	clientID, exists := os.LookupEnv(twitchClientIDEnvVar)
+	if !exists {
+		logrus.Warnf("`%v` env variable was not set.", twitchClientIDEnvVar)
+		return nil, fmt.Errorf("`%v` env variable was not set", twitchClientIDEnvVar)
+	}
+	clientSecret, exists := os.LookupEnv(twitchClientSecretEnvVar)
+	if !exists {
+		logrus.Warnf("`%v` env variable was not set.", twitchClientSecretEnvVar)
+		return nil, fmt.Errorf("`%v` env variable was not set", twitchClientSecretEnvVar)
+	}
+	conf := twitchConfig{
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		transport:        strings.ToLower(envOrDefault(eventsubTransportEnvVar, transportWebhook)),
+		websocketURL:     envOrDefault(websocketURLEnvVar, defaultWebsocketURL),
+		apiURL:           envOrDefault(apiURLEnvVar, defaultAPIURL),
+		authURL:          envOrDefault(authURLEnvVar, defaultAuthURL),
+		userAccessToken:  os.Getenv(userAccessTokenEnvVar),
+		userRefreshToken: os.Getenv(userRefreshTokenEnvVar),
+		monitorInterval:  defaultMonitorInterval,
+		serverHostname:   os.Getenv(serverHostnameEnvVar),
+		serverPort:       envOrDefault(serverPortEnvVar, defaultServerPort),
+		oauthRedirectURL: os.Getenv(oauthRedirectURLEnvVar),
+	}
+	if interval, exists := os.LookupEnv(monitorIntervalEnvVar); exists {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed <= 0 {
+			logrus.Warnf("`%v` should be a positive duration such as 5m, but was %v. Using default of %v.", monitorIntervalEnvVar, interval, defaultMonitorInterval)
+		} else {
+			conf.monitorInterval = parsed
+		}
+	}
+
+	switch conf.transport {
+	case transportWebhook:
+		if conf.serverHostname == "" {
+			logrus.Warnf("`%v` env variable was not set.", serverHostnameEnvVar)
+			return nil, fmt.Errorf("`%v` env variable was not set", serverHostnameEnvVar)
+		}
+		if _, exists := os.LookupEnv(serverPortEnvVar); !exists {
+			logrus.Warnf("`%v` env variable was not set.", serverPortEnvVar)
+		}
+		if conf.oauthRedirectURL == "" {
+			conf.oauthRedirectURL = "https://" + conf.serverHostname + oauthCallbackPath
+		}
+	case transportWebsocket:
+		if conf.userAccessToken == "" {
+			logrus.Warnf("`%v` env variable was not set.", userAccessTokenEnvVar)
+			return nil, fmt.Errorf("`%v` env variable must be set to use the websocket eventsub transport", userAccessTokenEnvVar)
+		}
+	default:
+		return nil, fmt.Errorf("unknown eventsub transport `%v`; `%v` should be either %v or %v", conf.transport, eventsubTransportEnvVar, transportWebhook, transportWebsocket)
+	}
+
+	communityEvents, err := parseCommunityEvents(os.Getenv(communityEventsEnvVar))
+	if err != nil {
+		return nil, fmt.Errorf("`%v` is invalid: %v", communityEventsEnvVar, err)
+	}
+	conf.communityEvents = communityEvents
+
+	if permissive, exists := os.LookupEnv(webhookPermissiveEnvVar); exists {
+		parsed, err := strconv.ParseBool(permissive)
+		if err != nil {
+			return nil, fmt.Errorf("`%v` should be either true or false, but was %v", webhookPermissiveEnvVar, permissive)
+		}
+		conf.permissive = parsed
+	}
+	conf.secretRotation = defaultSecretRotation
+	if rotation, exists := os.LookupEnv(secretRotationEnvVar); exists {
+		parsed, err := time.ParseDuration(rotation)
+		if err != nil || parsed < 0 {
+			logrus.Warnf("`%v` should be a duration such as 720h, or 0 to disable rotation, but was %v. Using default of %v.", secretRotationEnvVar, rotation, defaultSecretRotation)
+		} else {
+			conf.secretRotation = parsed
+		}
+	}
+	return &conf, nil
+}
+
+//nazunaOpts generates the options needed to start a nazuna webhook client. Signatures are checked by the
+//webhookVerifier before requests are proxied through to nazuna, which only ever knows the secret it was started with,
+//so nazuna itself is run in permissive mode.
+func (c *twitchConfig) nazunaOpts(secret string) nazuna.NazunaOpts {
+	return nazuna.NazunaOpts{
+		WebhookPath:    webhookPath,
+		ListenOn:       c.webhookBackend,
+		ClientID:       c.clientID,
+		ClientSecret:   c.clientSecret,
+		Scopes:         nil,
+		Secret:         secret,
+		ServerHostname: c.serverHostname,
+		Permissive:     true,
+	}
+}
+
+//webhookCallbackURL returns the public URL eventsub webhook notifications should be delivered to
+func (c *twitchConfig) webhookCallbackURL() string {
+	return "https://" + c.serverHostname + webhookPath
+}
+
+func envOrDefault(envVar, def string) string {
+	val, exists := os.LookupEnv(envVar)
+	if !exists || val == "" {
+		return def
+	}
+	return val
+}
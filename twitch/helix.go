@@ -0,0 +1,303 @@
+package twitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const helixRequestTimeout = 15 * time.Second
+
+//helixClient is a minimal client for the parts of the Twitch Helix API used by the bot. If a user access
+//token is provided it will be used for every request (and refreshed if a refresh token is also provided); otherwise
+//an app access token will be fetched using the client credentials.
+type helixClient struct {
+	apiURL       string
+	authURL      string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+
+	tokenLock    sync.Mutex
+	accessToken  string
+	refreshToken string
+	isUserToken  bool
+}
+
+//helixError is returned when the Helix API responds with a non-success status code
+type helixError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *helixError) Error() string {
+	return fmt.Sprintf("twitch API returned status %d: %v", e.StatusCode, e.Message)
+}
+
+//helixUser contains the details of a twitch user returned by the helix API
+type helixUser struct {
+	ID              string `json:"id"`
+	Login           string `json:"login"`
+	DisplayName     string `json:"display_name"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+//helixStream contains the details of a live stream returned by the helix API
+type helixStream struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	UserLogin    string    `json:"user_login"`
+	UserName     string    `json:"user_name"`
+	GameID       string    `json:"game_id"`
+	GameName     string    `json:"game_name"`
+	Type         string    `json:"type"`
+	Title        string    `json:"title"`
+	ViewerCount  int       `json:"viewer_count"`
+	StartedAt    time.Time `json:"started_at"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	IsMature     bool      `json:"is_mature"`
+}
+
+//eventsubSubscription contains the details of an eventsub subscription returned by the helix API or included in an
+//eventsub message
+type eventsubSubscription struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Status    string            `json:"status"`
+	Condition map[string]string `json:"condition"`
+}
+
+type helixPagination struct {
+	Cursor string `json:"cursor"`
+}
+
+type eventsubTransportRequest struct {
+	Method    string `json:"method"`
+	SessionID string `json:"session_id,omitempty"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+type createEventsubSubscriptionRequest struct {
+	Type      string                   `json:"type"`
+	Version   string                   `json:"version"`
+	Condition map[string]string        `json:"condition"`
+	Transport eventsubTransportRequest `json:"transport"`
+}
+
+func newHelixClient(conf *twitchConfig) *helixClient {
+	return &helixClient{
+		apiURL:       strings.TrimSuffix(conf.apiURL, "/"),
+		authURL:      strings.TrimSuffix(conf.authURL, "/"),
+		clientID:     conf.clientID,
+		clientSecret: conf.clientSecret,
+		httpClient:   &http.Client{Timeout: helixRequestTimeout},
+		accessToken:  conf.userAccessToken,
+		refreshToken: conf.userRefreshToken,
+		isUserToken:  conf.userAccessToken != "",
+	}
+}
+
+//GetBroadcaster looks up a twitch user by login name
+func (h *helixClient) GetBroadcaster(name string) (*helixUser, error) {
+	var resp struct {
+		Data []helixUser `json:"data"`
+	}
+	err := h.do(http.MethodGet, "/users", url.Values{"login": []string{strings.ToLower(name)}}, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) < 1 {
+		return nil, fmt.Errorf("no twitch user with login %v exists", name)
+	}
+	return &resp.Data[0], nil
+}
+
+//GetStreams fetches details on any live streams belonging to the requested users
+func (h *helixClient) GetStreams(userIDs []string) ([]helixStream, error) {
+	query := url.Values{"user_id": userIDs, "first": []string{"100"}}
+	var res []helixStream
+	for {
+		var resp struct {
+			Data       []helixStream   `json:"data"`
+			Pagination helixPagination `json:"pagination"`
+		}
+		err := h.do(http.MethodGet, "/streams", query, nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resp.Data...)
+		if resp.Pagination.Cursor == "" || len(resp.Data) == 0 {
+			return res, nil
+		}
+		query.Set("after", resp.Pagination.Cursor)
+	}
+}
+
+//createEventsubSubscription creates a new eventsub subscription, returning the subscription details
+func (h *helixClient) createEventsubSubscription(req createEventsubSubscriptionRequest) (*eventsubSubscription, error) {
+	var resp struct {
+		Data []eventsubSubscription `json:"data"`
+	}
+	err := h.do(http.MethodPost, "/eventsub/subscriptions", nil, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) < 1 {
+		return nil, fmt.Errorf("twitch API did not return the created subscription")
+	}
+	return &resp.Data[0], nil
+}
+
+//deleteEventsubSubscription deletes the eventsub subscription with the provided ID
+func (h *helixClient) deleteEventsubSubscription(id string) error {
+	return h.do(http.MethodDelete, "/eventsub/subscriptions", url.Values{"id": []string{id}}, nil, nil)
+}
+
+//listEventsubSubscriptions retrieves every eventsub subscription visible to the current access token
+func (h *helixClient) listEventsubSubscriptions() ([]eventsubSubscription, error) {
+	query := url.Values{}
+	var res []eventsubSubscription
+	for {
+		var resp struct {
+			Data       []eventsubSubscription `json:"data"`
+			Pagination helixPagination        `json:"pagination"`
+		}
+		err := h.do(http.MethodGet, "/eventsub/subscriptions", query, nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resp.Data...)
+		if resp.Pagination.Cursor == "" || len(resp.Data) == 0 {
+			return res, nil
+		}
+		query.Set("after", resp.Pagination.Cursor)
+	}
+}
+
+//do makes a request to the helix API, decoding the response into out if it is non-nil. If the access token has
+//expired, it will be renewed and the request retried once.
+func (h *helixClient) do(method, path string, query url.Values, body interface{}, out interface{}) error {
+	err := h.doOnce(method, path, query, body, out)
+	if herr, ok := err.(*helixError); ok && herr.StatusCode == http.StatusUnauthorized {
+		logrus.Infof("Twitch access token was rejected; renewing and retrying request to %v", path)
+		h.tokenLock.Lock()
+		err = h.renewToken()
+		h.tokenLock.Unlock()
+		if err != nil {
+			return err
+		}
+		return h.doOnce(method, path, query, body, out)
+	}
+	return err
+}
+
+func (h *helixClient) doOnce(method, path string, query url.Values, body interface{}, out interface{}) error {
+	token, err := h.token()
+	if err != nil {
+		return err
+	}
+	reqURL := h.apiURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	var reqBody *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Client-Id", h.clientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(respBody, &errResp)
+		return &helixError{StatusCode: resp.StatusCode, Message: errResp.Message}
+	}
+	if out != nil && len(respBody) > 0 {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
+
+//token returns the current access token, fetching a new app access token if there isn't one
+func (h *helixClient) token() (string, error) {
+	h.tokenLock.Lock()
+	defer h.tokenLock.Unlock()
+	if h.accessToken == "" {
+		err := h.renewToken()
+		if err != nil {
+			return "", err
+		}
+	}
+	return h.accessToken, nil
+}
+
+//renewToken fetches a new access token. tokenLock must be held by the caller.
+func (h *helixClient) renewToken() error {
+	form := url.Values{
+		"client_id":     []string{h.clientID},
+		"client_secret": []string{h.clientSecret},
+	}
+	switch {
+	case h.isUserToken && h.refreshToken != "":
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", h.refreshToken)
+	case h.isUserToken:
+		return fmt.Errorf("twitch user access token has expired and no refresh token was provided")
+	default:
+		form.Set("grant_type", "client_credentials")
+	}
+	resp, err := h.httpClient.PostForm(h.authURL+"/token", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to renew twitch access token; got status %v", resp.Status)
+	}
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return err
+	}
+	h.accessToken = tokenResp.AccessToken
+	if tokenResp.RefreshToken != "" {
+		h.refreshToken = tokenResp.RefreshToken
+	}
+	return nil
+}
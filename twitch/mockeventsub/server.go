@@ -0,0 +1,1190 @@
+//Package mockeventsub provides a small local stand-in for the Twitch EventSub websocket service, along with the helix
+//endpoints nia uses alongside it, so that the websocket transport can be run without access to Twitch.
+//
+//Users are created automatically the first time they are looked up by login, and streams are started and stopped
+//either through the methods on Server or through the control endpoints under /mock:
+//
+//	POST /mock/online?login=<login>[&title=<title>&game=<game>&mature=true]
+//	POST /mock/offline?login=<login>
+//	POST /mock/reconnect   (sends a session_reconnect message to every session)
+//	POST /mock/disconnect  (drops every websocket connection without warning)
+//	POST /mock/stall       (stops sending keepalives to every current session, so that clients time out)
+//	POST /mock/revoke?id=<subscription id>[&status=authorization_revoked]
+//	POST /mock/raid?from=<login>&to=<login>[&viewers=<n>]
+//	POST /mock/follow?login=<login>&user=<login>   (also adds one to the broadcaster's follower count)
+//	POST /mock/subscribe?login=<login>&user=<login>[&tier=1000&gift=true]
+//	POST /mock/schedule?login=<login>&start=<RFC3339 time>[&duration=2h&title=<title>&game=<game>&cancelled=true]
+//	DELETE /mock/schedule?login=<login>[&id=<segment id>]   (removes one segment, or the whole schedule)
+//	POST /mock/clip?login=<login>[&title=<title>&views=<n>&creator=<login>]
+//	POST /mock/clipviews?id=<clip id>&views=<n>
+//	POST /mock/team?name=<team>[&members=<login>,<login>...]   (replaces the team's roster)
+//
+//Categories looked up through /helix/games are created automatically too, so any category name is valid.
+//
+//The OAuth authorization code flow is mocked too. /oauth2/authorize approves every request straight away, logging in
+//as the user named by its login parameter (or mockuser if there isn't one), so a test can act as any twitch user by
+//adding &login=<login> to an authorization URL.
+package mockeventsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+//User is a twitch user known to the mock server
+type User struct {
+	ID              string `json:"id"`
+	Login           string `json:"login"`
+	DisplayName     string `json:"display_name"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+//Stream contains the details of a live stream
+type Stream struct {
+	Title       string
+	GameName    string
+	ViewerCount int
+	IsMature    bool
+	StartedAt   time.Time
+}
+
+//ScheduleSegment is a single stream in a broadcaster's schedule
+type ScheduleSegment struct {
+	ID        string
+	Title     string
+	GameName  string
+	StartTime time.Time
+	EndTime   time.Time
+	Cancelled bool
+}
+
+//Clip is a clip of one of a broadcaster's streams
+type Clip struct {
+	ID              string    `json:"id"`
+	URL             string    `json:"url"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name"`
+	CreatorID       string    `json:"creator_id"`
+	CreatorName     string    `json:"creator_name"`
+	Title           string    `json:"title"`
+	ViewCount       int       `json:"view_count"`
+	CreatedAt       time.Time `json:"created_at"`
+	ThumbnailURL    string    `json:"thumbnail_url"`
+	Duration        float64   `json:"duration"`
+}
+
+//Team is a twitch team, whose members are stored as user IDs
+type Team struct {
+	ID          string
+	Name        string
+	DisplayName string
+	MemberIDs   []string
+}
+
+//Game is a category which streams can be in
+type Game struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+//Transport describes where notifications for a subscription are delivered
+type Transport struct {
+	Method    string `json:"method"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+//Subscription is an eventsub subscription created through the mock helix API
+type Subscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport Transport         `json:"transport"`
+	CreatedAt time.Time         `json:"created_at"`
+	Cost      int               `json:"cost"`
+}
+
+type session struct {
+	id           string
+	conn         *websocket.Conn
+	reconnectURL string
+	writeLock    sync.Mutex
+	done         chan struct{}
+	//stalled is closed to stop sending keepalives
+	stalled   chan struct{}
+	stallOnce sync.Once
+}
+
+//Server is a mock eventsub websocket server and helix API. It implements http.Handler.
+type Server struct {
+	keepalive time.Duration
+	upgrader  websocket.Upgrader
+	mux       *http.ServeMux
+
+	lock          sync.Mutex
+	nextID        int
+	sessions      map[string]*session
+	subscriptions map[string]*Subscription
+	users         map[string]*User
+	live          map[string]*Stream
+	followers     map[string]int
+	schedules     map[string][]ScheduleSegment
+	clips         []*Clip
+	teams         map[string]*Team
+	games         map[string]*Game
+	//authCodes and userTokens map authorization codes and user access tokens to the ID of the user they belong to
+	authCodes  map[string]string
+	userTokens map[string]string
+}
+
+//New creates a mock server which sends keepalive messages at the provided interval
+func New(keepalive time.Duration) *Server {
+	s := &Server{
+		keepalive:     keepalive,
+		sessions:      make(map[string]*session),
+		subscriptions: make(map[string]*Subscription),
+		users:         make(map[string]*User),
+		live:          make(map[string]*Stream),
+		followers:     make(map[string]int),
+		schedules:     make(map[string][]ScheduleSegment),
+		teams:         make(map[string]*Team),
+		games:         make(map[string]*Game),
+		authCodes:     make(map[string]string),
+		userTokens:    make(map[string]string),
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/ws", s.handleWebsocket)
+	s.mux.HandleFunc("/oauth2/authorize", s.handleAuthorize)
+	s.mux.HandleFunc("/oauth2/token", s.handleToken)
+	s.mux.HandleFunc("/oauth2/validate", s.handleValidate)
+	s.mux.HandleFunc("/oauth2/revoke", s.handleRevoke)
+	s.mux.HandleFunc("/helix/users", s.requireAuth(s.handleUsers))
+	s.mux.HandleFunc("/helix/streams", s.requireAuth(s.handleStreams))
+	s.mux.HandleFunc("/helix/channels/followers", s.requireAuth(s.handleFollowers))
+	s.mux.HandleFunc("/helix/eventsub/subscriptions", s.requireAuth(s.handleSubscriptions))
+	s.mux.HandleFunc("/helix/schedule", s.requireAuth(s.handleSchedule))
+	s.mux.HandleFunc("/helix/clips", s.requireAuth(s.handleClips))
+	s.mux.HandleFunc("/helix/teams", s.requireAuth(s.handleTeams))
+	s.mux.HandleFunc("/helix/games", s.requireAuth(s.handleGames))
+	s.mux.HandleFunc("/mock/online", s.handleControlOnline)
+	s.mux.HandleFunc("/mock/offline", s.handleControlOffline)
+	s.mux.HandleFunc("/mock/reconnect", s.handleControlReconnect)
+	s.mux.HandleFunc("/mock/disconnect", s.handleControlDisconnect)
+	s.mux.HandleFunc("/mock/stall", s.handleControlStall)
+	s.mux.HandleFunc("/mock/revoke", s.handleControlRevoke)
+	s.mux.HandleFunc("/mock/raid", s.handleControlRaid)
+	s.mux.HandleFunc("/mock/follow", s.handleControlFollow)
+	s.mux.HandleFunc("/mock/subscribe", s.handleControlSubscribe)
+	s.mux.HandleFunc("/mock/schedule", s.handleControlSchedule)
+	s.mux.HandleFunc("/mock/clip", s.handleControlClip)
+	s.mux.HandleFunc("/mock/clipviews", s.handleControlClipViews)
+	s.mux.HandleFunc("/mock/team", s.handleControlTeam)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//AddUser returns the user with the provided login, creating them if they don't already exist
+func (s *Server) AddUser(login string) User {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return *s.userByLogin(login)
+}
+
+//GoLive marks a user's stream as live and sends stream.online notifications to any subscribed sessions
+func (s *Server) GoLive(login string, stream Stream) User {
+	s.lock.Lock()
+	user := s.userByLogin(login)
+	if stream.StartedAt.IsZero() {
+		stream.StartedAt = time.Now().UTC()
+	}
+	s.live[user.ID] = &stream
+	event := map[string]interface{}{
+		"id":                     s.newID(),
+		"broadcaster_user_id":    user.ID,
+		"broadcaster_user_login": user.Login,
+		"broadcaster_user_name":  user.DisplayName,
+		"type":                   "live",
+		"started_at":             stream.StartedAt,
+	}
+	s.lock.Unlock()
+	s.notify("stream.online", map[string]string{"broadcaster_user_id": user.ID}, event)
+	return *user
+}
+
+//GoOffline marks a user's stream as offline and sends stream.offline notifications to any subscribed sessions
+func (s *Server) GoOffline(login string) User {
+	s.lock.Lock()
+	user := s.userByLogin(login)
+	delete(s.live, user.ID)
+	event := map[string]interface{}{
+		"broadcaster_user_id":    user.ID,
+		"broadcaster_user_login": user.Login,
+		"broadcaster_user_name":  user.DisplayName,
+	}
+	s.lock.Unlock()
+	s.notify("stream.offline", map[string]string{"broadcaster_user_id": user.ID}, event)
+	return *user
+}
+
+//Raid sends channel.raid notifications for a raid from one user to another
+func (s *Server) Raid(fromLogin, toLogin string, viewers int) {
+	s.lock.Lock()
+	from := s.userByLogin(fromLogin)
+	to := s.userByLogin(toLogin)
+	event := map[string]interface{}{
+		"from_broadcaster_user_id":    from.ID,
+		"from_broadcaster_user_login": from.Login,
+		"from_broadcaster_user_name":  from.DisplayName,
+		"to_broadcaster_user_id":      to.ID,
+		"to_broadcaster_user_login":   to.Login,
+		"to_broadcaster_user_name":    to.DisplayName,
+		"viewers":                     viewers,
+	}
+	s.lock.Unlock()
+	s.notify("channel.raid", map[string]string{"from_broadcaster_user_id": from.ID}, event)
+	s.notify("channel.raid", map[string]string{"to_broadcaster_user_id": to.ID}, event)
+}
+
+//Follow adds a follower to a broadcaster and sends channel.follow notifications. It returns the broadcaster's new
+//follower count.
+func (s *Server) Follow(login, followerLogin string) int {
+	s.lock.Lock()
+	broadcaster := s.userByLogin(login)
+	follower := s.userByLogin(followerLogin)
+	s.followers[broadcaster.ID]++
+	total := s.followers[broadcaster.ID]
+	event := map[string]interface{}{
+		"user_id":                follower.ID,
+		"user_login":             follower.Login,
+		"user_name":              follower.DisplayName,
+		"broadcaster_user_id":    broadcaster.ID,
+		"broadcaster_user_login": broadcaster.Login,
+		"broadcaster_user_name":  broadcaster.DisplayName,
+		"followed_at":            time.Now().UTC(),
+	}
+	s.lock.Unlock()
+	s.notify("channel.follow", map[string]string{"broadcaster_user_id": broadcaster.ID}, event)
+	return total
+}
+
+//SetFollowers sets a broadcaster's follower count without sending any notifications
+func (s *Server) SetFollowers(login string, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.followers[s.userByLogin(login).ID] = count
+}
+
+//Subscribe sends channel.subscribe notifications for a new subscriber to a broadcaster
+func (s *Server) Subscribe(login, subscriberLogin, tier string, isGift bool) {
+	s.lock.Lock()
+	broadcaster := s.userByLogin(login)
+	subscriber := s.userByLogin(subscriberLogin)
+	if tier == "" {
+		tier = "1000"
+	}
+	event := map[string]interface{}{
+		"user_id":                subscriber.ID,
+		"user_login":             subscriber.Login,
+		"user_name":              subscriber.DisplayName,
+		"broadcaster_user_id":    broadcaster.ID,
+		"broadcaster_user_login": broadcaster.Login,
+		"broadcaster_user_name":  broadcaster.DisplayName,
+		"tier":                   tier,
+		"is_gift":                isGift,
+	}
+	s.lock.Unlock()
+	s.notify("channel.subscribe", map[string]string{"broadcaster_user_id": broadcaster.ID}, event)
+}
+
+//AddScheduleSegment adds a stream to a broadcaster's schedule, returning it with its ID filled in
+func (s *Server) AddScheduleSegment(login string, segment ScheduleSegment) ScheduleSegment {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user := s.userByLogin(login)
+	segment.ID = s.newID()
+	s.schedules[user.ID] = append(s.schedules[user.ID], segment)
+	return segment
+}
+
+//RemoveScheduleSegment removes a stream from a broadcaster's schedule. If id is empty, the whole schedule is removed.
+func (s *Server) RemoveScheduleSegment(login, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user := s.userByLogin(login)
+	if id == "" {
+		delete(s.schedules, user.ID)
+		return
+	}
+	segments := s.schedules[user.ID]
+	for i, segment := range segments {
+		if segment.ID == id {
+			s.schedules[user.ID] = append(segments[:i], segments[i+1:]...)
+			return
+		}
+	}
+}
+
+//AddClip creates a clip of a broadcaster's stream, returning it with its ID and URL filled in
+func (s *Server) AddClip(login, creatorLogin, title string, views int) Clip {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	broadcaster := s.userByLogin(login)
+	creator := s.userByLogin(creatorLogin)
+	id := s.newID()
+	clip := &Clip{
+		ID:              id,
+		URL:             fmt.Sprintf("https://clips.twitch.tv/%v", id),
+		BroadcasterID:   broadcaster.ID,
+		BroadcasterName: broadcaster.DisplayName,
+		CreatorID:       creator.ID,
+		CreatorName:     creator.DisplayName,
+		Title:           title,
+		ViewCount:       views,
+		CreatedAt:       time.Now().UTC(),
+		ThumbnailURL:    fmt.Sprintf("https://clips-media-assets2.twitch.tv/%v-preview-480x272.jpg", id),
+		Duration:        30,
+	}
+	s.clips = append(s.clips, clip)
+	return *clip
+}
+
+//SetClipViews sets the view count of a clip, returning false if there is no clip with the provided ID
+func (s *Server) SetClipViews(id string, views int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, clip := range s.clips {
+		if clip.ID == id {
+			clip.ViewCount = views
+			return true
+		}
+	}
+	return false
+}
+
+//SetTeamMembers replaces the roster of a team, creating the team if it doesn't yet exist
+func (s *Server) SetTeamMembers(name string, logins []string) Team {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name = strings.ToLower(name)
+	team, exists := s.teams[name]
+	if !exists {
+		team = &Team{
+			ID:          s.newID(),
+			Name:        name,
+			DisplayName: name,
+		}
+		s.teams[name] = team
+	}
+	team.MemberIDs = make([]string, 0, len(logins))
+	for _, login := range logins {
+		team.MemberIDs = append(team.MemberIDs, s.userByLogin(login).ID)
+	}
+	return *team
+}
+
+//RequestReconnect sends a session_reconnect message to every connected session. Subscriptions are moved to the new
+//session once the client connects to the reconnect URL.
+func (s *Server) RequestReconnect() {
+	for _, sess := range s.activeSessions() {
+		sess.send(message("session_reconnect", "", map[string]interface{}{
+			"session": map[string]interface{}{
+				"id":                        sess.id,
+				"status":                    "reconnecting",
+				"keepalive_timeout_seconds": nil,
+				"reconnect_url":             sess.reconnectURL,
+			},
+		}))
+	}
+}
+
+//DropConnections closes every websocket connection without sending a close message, as happens when the network
+//fails
+func (s *Server) DropConnections() {
+	for _, sess := range s.activeSessions() {
+		sess.conn.UnderlyingConn().Close()
+	}
+}
+
+//StallConnections stops sending keepalive messages to every connected session without closing the connections, as
+//happens when a connection silently dies. Sessions which connect afterwards still get keepalives.
+func (s *Server) StallConnections() {
+	for _, sess := range s.activeSessions() {
+		sess.stallOnce.Do(func() {
+			close(sess.stalled)
+		})
+	}
+}
+
+//Revoke disables a subscription with the provided status and sends a revocation message to the session it belongs
+//to. It returns false if there is no subscription with the provided ID.
+func (s *Server) Revoke(id, status string) bool {
+	s.lock.Lock()
+	sub, exists := s.subscriptions[id]
+	if !exists {
+		s.lock.Unlock()
+		return false
+	}
+	sub.Status = status
+	revoked := *sub
+	sess := s.sessions[sub.Transport.SessionID]
+	s.lock.Unlock()
+	if sess != nil {
+		sess.send(message("revocation", revoked.Type, map[string]interface{}{
+			"subscription": revoked,
+		}))
+	}
+	return true
+}
+
+//Subscriptions returns a copy of every subscription which currently exists
+func (s *Server) Subscriptions() []Subscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		res = append(res, *sub)
+	}
+	return res
+}
+
+//Close disconnects every websocket session
+func (s *Server) Close() {
+	for _, sess := range s.activeSessions() {
+		sess.conn.Close()
+	}
+}
+
+func (s *Server) activeSessions() []*session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		res = append(res, sess)
+	}
+	return res
+}
+
+//newID generates a new unique ID. The lock must be held by the caller.
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+//userByLogin finds or creates the user with the provided login. The lock must be held by the caller.
+func (s *Server) userByLogin(login string) *User {
+	login = strings.ToLower(login)
+	for _, user := range s.users {
+		if user.Login == login {
+			return user
+		}
+	}
+	user := &User{
+		ID:              s.newID(),
+		Login:           login,
+		DisplayName:     login,
+		ProfileImageURL: fmt.Sprintf("https://static-cdn.jtvnw.net/jtv_user_pictures/%v-profile_image-300x300.png", login),
+	}
+	s.users[user.ID] = user
+	return user
+}
+
+//notify sends a notification to every session with an enabled subscription of the given type whose condition
+//contains every entry in the provided condition
+func (s *Server) notify(subType string, condition map[string]string, event interface{}) {
+	s.lock.Lock()
+	type delivery struct {
+		sess *session
+		msg  interface{}
+	}
+	var deliveries []delivery
+	for _, sub := range s.subscriptions {
+		if sub.Type != subType || sub.Status != "enabled" || !conditionMatches(sub.Condition, condition) {
+			continue
+		}
+		sess, exists := s.sessions[sub.Transport.SessionID]
+		if !exists {
+			continue
+		}
+		deliveries = append(deliveries, delivery{
+			sess: sess,
+			msg: message("notification", sub.Type, map[string]interface{}{
+				"subscription": *sub,
+				"event":        event,
+			}),
+		})
+	}
+	s.lock.Unlock()
+	for _, d := range deliveries {
+		d.sess.send(d.msg)
+	}
+}
+
+//conditionMatches returns true if a subscription condition contains every entry in want
+func conditionMatches(condition, want map[string]string) bool {
+	for k, v := range want {
+		if condition[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	keepalive := s.keepalive
+	if requested, err := strconv.Atoi(r.URL.Query().Get("keepalive_timeout_seconds")); err == nil && requested >= 10 && requested <= 600 {
+		keepalive = time.Duration(requested) * time.Second
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.Warnf("Failed to upgrade mock eventsub websocket connection due to error %v", err)
+		return
+	}
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+
+	s.lock.Lock()
+	sess := &session{
+		id:      s.newID(),
+		conn:    conn,
+		done:    make(chan struct{}),
+		stalled: make(chan struct{}),
+	}
+	sess.reconnectURL = fmt.Sprintf("%v://%v%v?reconnect_from=%v", scheme, r.Host, r.URL.Path, sess.id)
+	s.sessions[sess.id] = sess
+	//Clients reconnecting at our request keep their subscriptions
+	var previous *session
+	if from := r.URL.Query().Get("reconnect_from"); from != "" {
+		previous = s.sessions[from]
+		for _, sub := range s.subscriptions {
+			if sub.Transport.SessionID == from {
+				sub.Transport.SessionID = sess.id
+			}
+		}
+	}
+	s.lock.Unlock()
+
+	logrus.Infof("Mock eventsub session %v connected", sess.id)
+	sess.send(message("session_welcome", "", map[string]interface{}{
+		"session": map[string]interface{}{
+			"id":                        sess.id,
+			"status":                    "connected",
+			"keepalive_timeout_seconds": int(keepalive / time.Second),
+			"reconnect_url":             nil,
+			"connected_at":              time.Now().UTC(),
+		},
+	}))
+	if previous != nil {
+		previous.conn.Close()
+	}
+	go sess.keepaliveLoop(keepalive)
+
+	//Clients aren't expected to send anything, so just wait for the connection to close
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	close(sess.done)
+	conn.Close()
+	s.lock.Lock()
+	delete(s.sessions, sess.id)
+	for _, sub := range s.subscriptions {
+		if sub.Transport.SessionID == sess.id {
+			sub.Status = "websocket_disconnected"
+		}
+	}
+	s.lock.Unlock()
+	logrus.Infof("Mock eventsub session %v disconnected", sess.id)
+}
+
+func (sess *session) keepaliveLoop(keepalive time.Duration) {
+	ticker := time.NewTicker(keepalive * 3 / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.done:
+			return
+		case <-sess.stalled:
+			return
+		case <-ticker.C:
+			sess.send(message("session_keepalive", "", map[string]interface{}{}))
+		}
+	}
+}
+
+func (sess *session) send(msg interface{}) {
+	sess.writeLock.Lock()
+	defer sess.writeLock.Unlock()
+	err := sess.conn.WriteJSON(msg)
+	if err != nil {
+		logrus.Debugf("Failed to write to mock eventsub session %v due to error %v", sess.id, err)
+	}
+}
+
+var messageCounter struct {
+	sync.Mutex
+	next int
+}
+
+func message(messageType, subscriptionType string, payload interface{}) map[string]interface{} {
+	messageCounter.Lock()
+	messageCounter.next++
+	id := fmt.Sprintf("mock-message-%v", messageCounter.next)
+	messageCounter.Unlock()
+	metadata := map[string]interface{}{
+		"message_id":        id,
+		"message_type":      messageType,
+		"message_timestamp": time.Now().UTC(),
+	}
+	if subscriptionType != "" {
+		metadata["subscription_type"] = subscriptionType
+		metadata["subscription_version"] = "1"
+	}
+	return map[string]interface{}{
+		"metadata": metadata,
+		"payload":  payload,
+	}
+}
+
+func (s *Server) requireAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Client-Id") == "" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			writeError(w, http.StatusUnauthorized, "missing client ID or access token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//handleAuthorize immediately approves an authorization request, redirecting back with a code for the requested user
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" || query.Get("client_id") == "" || query.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "expected client_id, redirect_uri and response_type=code")
+		return
+	}
+	login := query.Get("login")
+	if login == "" {
+		login = "mockuser"
+	}
+	s.lock.Lock()
+	user := s.userByLogin(login)
+	code := "mock-code-" + s.newID()
+	s.authCodes[code] = user.ID
+	s.lock.Unlock()
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("scope", query.Get("scope"))
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") == "authorization_code" {
+		s.lock.Lock()
+		uid, exists := s.authCodes[r.FormValue("code")]
+		delete(s.authCodes, r.FormValue("code"))
+		token := "mock-user-token-" + s.newID()
+		if exists {
+			s.userTokens[token] = uid
+		}
+		s.lock.Unlock()
+		if !exists {
+			writeError(w, http.StatusBadRequest, "Invalid authorization code")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token":  token,
+			"refresh_token": "mock-refresh-token",
+			"expires_in":    3600,
+			"scope":         []string{},
+			"token_type":    "bearer",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  "mock-access-token",
+		"refresh_token": "mock-refresh-token",
+		"expires_in":    3600,
+		"token_type":    "bearer",
+	})
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "OAuth ")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if uid, exists := s.userTokens[token]; exists {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"client_id":  "mock-client-id",
+			"login":      s.users[uid].Login,
+			"user_id":    uid,
+			"scopes":     []string{},
+			"expires_in": 3600,
+		})
+	} else if token == "mock-access-token" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"client_id":  "mock-client-id",
+			"scopes":     []string{},
+			"expires_in": 3600,
+		})
+	} else {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+	}
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	delete(s.userTokens, r.FormValue("token"))
+	s.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data := []User{}
+	for _, login := range r.URL.Query()["login"] {
+		data = append(data, *s.userByLogin(login))
+	}
+	for _, id := range r.URL.Query()["id"] {
+		if user, exists := s.users[id]; exists {
+			data = append(data, *user)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data := []map[string]interface{}{}
+	for _, id := range r.URL.Query()["user_id"] {
+		stream, live := s.live[id]
+		user, exists := s.users[id]
+		if !live || !exists {
+			continue
+		}
+		data = append(data, map[string]interface{}{
+			"id":            "stream-" + id,
+			"user_id":       user.ID,
+			"user_login":    user.Login,
+			"user_name":     user.DisplayName,
+			"game_id":       "",
+			"game_name":     stream.GameName,
+			"type":          "live",
+			"title":         stream.Title,
+			"viewer_count":  stream.ViewerCount,
+			"started_at":    stream.StartedAt,
+			"language":      "en",
+			"thumbnail_url": fmt.Sprintf("https://static-cdn.jtvnw.net/previews-ttv/live_user_%v-{width}x{height}.jpg", user.Login),
+			"is_mature":     stream.IsMature,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data, "pagination": map[string]interface{}{}})
+}
+
+func (s *Server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		subs := s.Subscriptions()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data":           subs,
+			"total":          len(subs),
+			"total_cost":     0,
+			"max_total_cost": 10000,
+			"pagination":     map[string]interface{}{},
+		})
+	case http.MethodPost:
+		var req struct {
+			Type      string            `json:"type"`
+			Version   string            `json:"version"`
+			Condition map[string]string `json:"condition"`
+			Transport Transport         `json:"transport"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Transport.Method != "websocket" {
+			writeError(w, http.StatusBadRequest, "the mock server only supports the websocket transport")
+			return
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if _, exists := s.sessions[req.Transport.SessionID]; !exists {
+			writeError(w, http.StatusBadRequest, "websocket session does not exist")
+			return
+		}
+		for _, sub := range s.subscriptions {
+			if sub.Type == req.Type && sub.Transport.SessionID == req.Transport.SessionID && len(sub.Condition) == len(req.Condition) && conditionMatches(sub.Condition, req.Condition) {
+				writeError(w, http.StatusConflict, "subscription already exists")
+				return
+			}
+		}
+		sub := &Subscription{
+			ID:        s.newID(),
+			Status:    "enabled",
+			Type:      req.Type,
+			Version:   req.Version,
+			Condition: req.Condition,
+			Transport: req.Transport,
+			CreatedAt: time.Now().UTC(),
+		}
+		s.subscriptions[sub.ID] = sub
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"data":           []Subscription{*sub},
+			"total":          len(s.subscriptions),
+			"total_cost":     0,
+			"max_total_cost": 10000,
+		})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if _, exists := s.subscriptions[id]; !exists {
+			writeError(w, http.StatusNotFound, "subscription not found")
+			return
+		}
+		delete(s.subscriptions, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleFollowers(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       []interface{}{},
+		"total":      s.followers[r.URL.Query().Get("broadcaster_id")],
+		"pagination": map[string]interface{}{},
+	})
+}
+
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, exists := s.users[r.URL.Query().Get("broadcaster_id")]
+	segments := s.schedules[r.URL.Query().Get("broadcaster_id")]
+	if !exists || len(segments) == 0 {
+		writeError(w, http.StatusNotFound, "segments were not found")
+		return
+	}
+	sorted := make([]ScheduleSegment, len(segments))
+	copy(sorted, segments)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].StartTime.Before(sorted[j].StartTime)
+	})
+	data := make([]map[string]interface{}, 0, len(sorted))
+	for _, segment := range sorted {
+		var category interface{}
+		if segment.GameName != "" {
+			category = map[string]string{"id": "0", "name": segment.GameName}
+		}
+		var canceledUntil interface{}
+		if segment.Cancelled {
+			canceledUntil = segment.EndTime.UTC()
+		}
+		data = append(data, map[string]interface{}{
+			"id":             segment.ID,
+			"start_time":     segment.StartTime.UTC(),
+			"end_time":       segment.EndTime.UTC(),
+			"title":          segment.Title,
+			"canceled_until": canceledUntil,
+			"category":       category,
+			"is_recurring":   false,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"segments":          data,
+			"broadcaster_id":    user.ID,
+			"broadcaster_name":  user.DisplayName,
+			"broadcaster_login": user.Login,
+			"vacation":          nil,
+		},
+		"pagination": map[string]interface{}{},
+	})
+}
+
+func (s *Server) handleClips(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	startedAt, _ := time.Parse(time.RFC3339, query.Get("started_at"))
+	endedAt, err := time.Parse(time.RFC3339, query.Get("ended_at"))
+	if err != nil {
+		endedAt = time.Now().Add(time.Hour)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data := []Clip{}
+	for _, clip := range s.clips {
+		if clip.BroadcasterID == query.Get("broadcaster_id") && !clip.CreatedAt.Before(startedAt) && clip.CreatedAt.Before(endedAt) {
+			data = append(data, *clip)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       data,
+		"pagination": map[string]interface{}{},
+	})
+}
+
+func (s *Server) handleTeams(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	team, exists := s.teams[strings.ToLower(r.URL.Query().Get("name"))]
+	if !exists {
+		writeError(w, http.StatusNotFound, "team was not found")
+		return
+	}
+	users := make([]map[string]string, 0, len(team.MemberIDs))
+	for _, id := range team.MemberIDs {
+		user := s.users[id]
+		users = append(users, map[string]string{
+			"user_id":    user.ID,
+			"user_login": user.Login,
+			"user_name":  user.DisplayName,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": []map[string]interface{}{{
+			"id":                team.ID,
+			"team_name":         team.Name,
+			"team_display_name": team.DisplayName,
+			"users":             users,
+		}},
+	})
+}
+
+func (s *Server) handleGames(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data := []Game{}
+	for _, name := range r.URL.Query()["name"] {
+		game, exists := s.games[strings.ToLower(name)]
+		if !exists {
+			game = &Game{ID: s.newID(), Name: name}
+			s.games[strings.ToLower(name)] = game
+		}
+		data = append(data, *game)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) handleControlOnline(w http.ResponseWriter, r *http.Request) {
+	login := r.URL.Query().Get("login")
+	if r.Method != http.MethodPost || login == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with a login parameter")
+		return
+	}
+	viewers, _ := strconv.Atoi(r.URL.Query().Get("viewers"))
+	user := s.GoLive(login, Stream{
+		Title:       r.URL.Query().Get("title"),
+		GameName:    r.URL.Query().Get("game"),
+		ViewerCount: viewers,
+		IsMature:    r.URL.Query().Get("mature") == "true",
+	})
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) handleControlOffline(w http.ResponseWriter, r *http.Request) {
+	login := r.URL.Query().Get("login")
+	if r.Method != http.MethodPost || login == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with a login parameter")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.GoOffline(login))
+}
+
+func (s *Server) handleControlReconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "expected POST")
+		return
+	}
+	s.RequestReconnect()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "expected POST")
+		return
+	}
+	s.DropConnections()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlStall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "expected POST")
+		return
+	}
+	s.StallConnections()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlRevoke(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if r.Method != http.MethodPost || id == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with an id parameter")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "authorization_revoked"
+	}
+	if !s.Revoke(id, status) {
+		writeError(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlRaid(w http.ResponseWriter, r *http.Request) {
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if r.Method != http.MethodPost || from == "" || to == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with from and to parameters")
+		return
+	}
+	viewers, _ := strconv.Atoi(r.URL.Query().Get("viewers"))
+	s.Raid(from, to, viewers)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlFollow(w http.ResponseWriter, r *http.Request) {
+	login, user := r.URL.Query().Get("login"), r.URL.Query().Get("user")
+	if r.Method != http.MethodPost || login == "" || user == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with login and user parameters")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"total": s.Follow(login, user)})
+}
+
+func (s *Server) handleControlSubscribe(w http.ResponseWriter, r *http.Request) {
+	login, user := r.URL.Query().Get("login"), r.URL.Query().Get("user")
+	if r.Method != http.MethodPost || login == "" || user == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with login and user parameters")
+		return
+	}
+	s.Subscribe(login, user, r.URL.Query().Get("tier"), r.URL.Query().Get("gift") == "true")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlSchedule(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	login := query.Get("login")
+	if login == "" {
+		writeError(w, http.StatusBadRequest, "expected a login parameter")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		start, err := time.Parse(time.RFC3339, query.Get("start"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "start should be an RFC3339 time")
+			return
+		}
+		duration := 2 * time.Hour
+		if query.Get("duration") != "" {
+			duration, err = time.ParseDuration(query.Get("duration"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "duration should be a duration such as 2h")
+				return
+			}
+		}
+		segment := s.AddScheduleSegment(login, ScheduleSegment{
+			Title:     query.Get("title"),
+			GameName:  query.Get("game"),
+			StartTime: start,
+			EndTime:   start.Add(duration),
+			Cancelled: query.Get("cancelled") == "true",
+		})
+		writeJSON(w, http.StatusOK, segment)
+	case http.MethodDelete:
+		s.RemoveScheduleSegment(login, query.Get("id"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "expected POST or DELETE")
+	}
+}
+
+func (s *Server) handleControlClip(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	login := query.Get("login")
+	if r.Method != http.MethodPost || login == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with a login parameter")
+		return
+	}
+	creator := query.Get("creator")
+	if creator == "" {
+		creator = login
+	}
+	views, _ := strconv.Atoi(query.Get("views"))
+	writeJSON(w, http.StatusOK, s.AddClip(login, creator, query.Get("title"), views))
+}
+
+func (s *Server) handleControlClipViews(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	views, err := strconv.Atoi(query.Get("views"))
+	if r.Method != http.MethodPost || query.Get("id") == "" || err != nil {
+		writeError(w, http.StatusBadRequest, "expected POST with id and views parameters")
+		return
+	}
+	if !s.SetClipViews(query.Get("id"), views) {
+		writeError(w, http.StatusNotFound, "clip not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleControlTeam(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if r.Method != http.MethodPost || name == "" {
+		writeError(w, http.StatusBadRequest, "expected POST with a name parameter")
+		return
+	}
+	var logins []string
+	if query.Get("members") != "" {
+		logins = strings.Split(query.Get("members"), ",")
+	}
+	writeJSON(w, http.StatusOK, s.SetTeamMembers(name, logins))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		logrus.Warnf("Failed to write mock API response due to error %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": msg,
+	})
+}
@@ -0,0 +1,206 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/callummance/nia/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+//oauthCallbackPath is where twitch redirects users after they log in, unless NIA_TWITCH_OAUTH_REDIRECT_URL says
+//otherwise
+const oauthCallbackPath = "/twitchoauth"
+
+var _ streaming.OwnershipVerifier = (*EventSource)(nil)
+
+//oauthClient runs the authorization code flow used to confirm which twitch account a user owns. No scopes are
+//requested, as we only need to know who logged in.
+type oauthClient struct {
+	authURL      string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	httpClient   *http.Client
+}
+
+//oauthUser is the account an access token belongs to, as reported by the validate endpoint
+type oauthUser struct {
+	UserID string `json:"user_id"`
+	Login  string `json:"login"`
+}
+
+var callbackPage = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Nia</title></head>
+<body><p>{{.}}</p></body>
+</html>
+`))
+
+func newOAuthClient(conf *twitchConfig) *oauthClient {
+	return &oauthClient{
+		authURL:      strings.TrimSuffix(conf.authURL, "/"),
+		clientID:     conf.clientID,
+		clientSecret: conf.clientSecret,
+		redirectURL:  conf.oauthRedirectURL,
+		httpClient:   &http.Client{Timeout: helixRequestTimeout},
+	}
+}
+
+//VerificationURL returns a link to the twitch login page. Once the user has logged in, the account they used is
+//passed to the handler's HandleVerification along with the provided state.
+func (t *EventSource) VerificationURL(state string) (string, error) {
+	if t.oauth == nil {
+		return "", fmt.Errorf("twitch channel verification is not available as no OAuth redirect URL is configured")
+	}
+	query := url.Values{
+		"client_id":     {t.oauth.clientID},
+		"redirect_uri":  {t.oauth.redirectURL},
+		"response_type": {"code"},
+		"scope":         {""},
+		"state":         {state},
+		//Always show the login prompt, so that a user who is logged in to the wrong account can switch
+		"force_verify": {"true"},
+	}
+	return t.oauth.authURL + "/authorize?" + query.Encode(), nil
+}
+
+//handleOAuthCallback handles users being redirected back to us after logging in to twitch
+func (t *EventSource) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	if errCode := query.Get("error"); errCode != "" {
+		logrus.Debugf("Twitch authorization with state %v was not completed: %v", state, errCode)
+		writeCallbackPage(w, http.StatusOK, "Authorization was cancelled, so your twitch channel has not been linked.")
+		return
+	}
+	code := query.Get("code")
+	if code == "" || state == "" {
+		writeCallbackPage(w, http.StatusBadRequest, "This link is missing some information; please try again using the link you were sent.")
+		return
+	}
+	token, err := t.oauth.exchangeCode(code)
+	if err != nil {
+		logrus.Warnf("Failed to exchange twitch authorization code due to error %v", err)
+		writeCallbackPage(w, http.StatusBadGateway, "Something went wrong whilst talking to twitch; please try again later.")
+		return
+	}
+	user, err := t.oauth.validate(token)
+	//We only needed the token to find out who logged in, so get rid of it straight away
+	if revokeErr := t.oauth.revoke(token); revokeErr != nil {
+		logrus.Warnf("Failed to revoke twitch access token used for channel verification due to error %v", revokeErr)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to validate twitch access token due to error %v", err)
+		writeCallbackPage(w, http.StatusBadGateway, "Something went wrong whilst talking to twitch; please try again later.")
+		return
+	}
+	channel := streaming.Channel{
+		Provider: ProviderName,
+		ID:       user.UserID,
+		Login:    user.Login,
+		URL:      channelURL(user.Login),
+	}
+	broadcaster, err := t.helix.GetBroadcaster(user.Login)
+	if err != nil {
+		logrus.Warnf("Failed to look up details of verified twitch user %v due to error %v", user.Login, err)
+	} else {
+		channel.DisplayName = broadcaster.DisplayName
+		channel.ProfileImageURL = broadcaster.ProfileImageURL
+	}
+	msg, err := t.handler.HandleVerification(&streaming.Verification{
+		Provider: ProviderName,
+		State:    state,
+		Channel:  channel,
+	})
+	if err != nil {
+		writeCallbackPage(w, http.StatusOK, err.Error())
+		return
+	}
+	writeCallbackPage(w, http.StatusOK, msg)
+}
+
+//exchangeCode swaps an authorization code for a user access token
+func (o *oauthClient) exchangeCode(code string) (string, error) {
+	form := url.Values{
+		"client_id":     {o.clientID},
+		"client_secret": {o.clientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {o.redirectURL},
+	}
+	resp, err := o.httpClient.PostForm(o.authURL+"/token", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", &helixError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return "", err
+	}
+	return tokenResp.AccessToken, nil
+}
+
+//validate looks up which user an access token belongs to
+func (o *oauthClient) validate(token string) (*oauthUser, error) {
+	req, err := http.NewRequest(http.MethodGet, o.authURL+"/validate", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "OAuth "+token)
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &helixError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	var user oauthUser
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	if user.UserID == "" {
+		return nil, fmt.Errorf("access token does not belong to a user")
+	}
+	return &user, nil
+}
+
+//revoke invalidates an access token
+func (o *oauthClient) revoke(token string) error {
+	resp, err := o.httpClient.PostForm(o.authURL+"/revoke", url.Values{
+		"client_id": {o.clientID},
+		"token":     {token},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status %v", resp.Status)
+	}
+	return nil
+}
+
+func writeCallbackPage(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := callbackPage.Execute(w, msg)
+	if err != nil {
+		logrus.Warnf("Failed to write twitch OAuth callback page due to error %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package twitch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/callummance/nia/streaming"
+)
+
+//ProviderName identifies twitch in the database and in commands
+const ProviderName = "twitch"
+
+const twitchColourHex = 0x6441a5
+
+var _ streaming.Provider = (*EventSource)(nil)
+
+var channelRegex = regexp.MustCompile(`^(?:(?:https?://)?(?:(?:www|go|m)\.)?twitch\.tv/)?([a-zA-Z0-9_]{4,25})/?$`)
+
+//Info returns static details about the twitch provider
+func (t *EventSource) Info() streaming.ProviderInfo {
+	return streaming.ProviderInfo{
+		Name:        ProviderName,
+		DisplayName: "Twitch",
+		Colour:      twitchColourHex,
+	}
+}
+
+//ResolveChannel looks up a twitch channel from a username or channel URL
+func (t *EventSource) ResolveChannel(nameOrURL string) (*streaming.Channel, error) {
+	matches := channelRegex.FindStringSubmatch(strings.TrimSpace(nameOrURL))
+	if matches == nil {
+		return nil, fmt.Errorf("%v is not a twitch username or channel URL", nameOrURL)
+	}
+	user, err := t.helix.GetBroadcaster(matches[1])
+	if err != nil {
+		return nil, err
+	}
+	return &streaming.Channel{
+		Provider:        ProviderName,
+		ID:              user.ID,
+		Login:           user.Login,
+		DisplayName:     user.DisplayName,
+		URL:             channelURL(user.Login),
+		ProfileImageURL: user.ProfileImageURL,
+	}, nil
+}
+
+//GetLiveStreams retrieves details on each of the provided streams which are currently live, keyed by broadcaster
+//UID. Streams which are not live will not have an entry in the returned map.
+func (t *EventSource) GetLiveStreams(twitchUIDs []string) (map[string]*streaming.LiveStream, error) {
+	streams, err := t.getStreams(twitchUIDs)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*streaming.LiveStream, len(streams))
+	for uid, stream := range streams {
+		res[uid] = liveStream(stream)
+	}
+	return res, nil
+}
+
+func liveStream(stream *helixStream) *streaming.LiveStream {
+	thumb := strings.Replace(stream.ThumbnailURL, "{width}", "1920", 1)
+	thumb = strings.Replace(thumb, "{height}", "1080", 1)
+	return &streaming.LiveStream{
+		Provider:     ProviderName,
+		ChannelID:    stream.UserID,
+		ChannelName:  stream.UserName,
+		Title:        stream.Title,
+		Game:         stream.GameName,
+		Viewers:      stream.ViewerCount,
+		IsMature:     stream.IsMature,
+		StartedAt:    stream.StartedAt,
+		URL:          channelURL(stream.UserLogin),
+		ChannelURL:   channelURL(stream.UserLogin),
+		ThumbnailURL: thumb,
+	}
+}
+
+func channelURL(login string) string {
+	return fmt.Sprintf("https://twitch.tv/%v", login)
+}
@@ -0,0 +1,89 @@
+package twitch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/callummance/nia/streaming"
+)
+
+//scheduleDefaultLength is used as the end time of schedule segments which don't have one
+const scheduleDefaultLength = 2 * time.Hour
+
+type helixScheduleSegment struct {
+	ID            string     `json:"id"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       *time.Time `json:"end_time"`
+	Title         string     `json:"title"`
+	CanceledUntil *time.Time `json:"canceled_until"`
+	Category      *struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"category"`
+	IsRecurring bool `json:"is_recurring"`
+}
+
+type helixSchedule struct {
+	Segments         []helixScheduleSegment `json:"segments"`
+	BroadcasterID    string                 `json:"broadcaster_id"`
+	BroadcasterName  string                 `json:"broadcaster_name"`
+	BroadcasterLogin string                 `json:"broadcaster_login"`
+	Vacation         *struct {
+		StartTime time.Time `json:"start_time"`
+		EndTime   time.Time `json:"end_time"`
+	} `json:"vacation"`
+}
+
+//GetSchedule returns the segments of a broadcaster's stream schedule which start before until. Segments which fall
+//within the broadcaster's vacation are returned as cancelled.
+func (t *EventSource) GetSchedule(twitchUID string, until time.Time) ([]streaming.ScheduledStream, error) {
+	query := url.Values{
+		"broadcaster_id": {twitchUID},
+		"first":          {"25"},
+	}
+	var res []streaming.ScheduledStream
+	for {
+		var resp struct {
+			Data       helixSchedule   `json:"data"`
+			Pagination helixPagination `json:"pagination"`
+		}
+		err := t.helix.do(http.MethodGet, "/schedule", query, nil, &resp)
+		if herr, ok := err.(*helixError); ok && herr.StatusCode == http.StatusNotFound {
+			//Broadcasters who have never set up a schedule don't have one at all
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		schedule := resp.Data
+		for _, segment := range schedule.Segments {
+			if segment.StartTime.After(until) {
+				return res, nil
+			}
+			stream := streaming.ScheduledStream{
+				ID:          segment.ID,
+				ChannelName: schedule.BroadcasterName,
+				Title:       segment.Title,
+				StartTime:   segment.StartTime,
+				EndTime:     segment.StartTime.Add(scheduleDefaultLength),
+				Cancelled:   segment.CanceledUntil != nil,
+				ChannelURL:  fmt.Sprintf("https://twitch.tv/%v", schedule.BroadcasterLogin),
+			}
+			if segment.EndTime != nil {
+				stream.EndTime = *segment.EndTime
+			}
+			if segment.Category != nil {
+				stream.Game = segment.Category.Name
+			}
+			if v := schedule.Vacation; v != nil && !segment.StartTime.Before(v.StartTime) && segment.StartTime.Before(v.EndTime) {
+				stream.Cancelled = true
+			}
+			res = append(res, stream)
+		}
+		if resp.Pagination.Cursor == "" || len(schedule.Segments) == 0 {
+			return res, nil
+		}
+		query.Set("after", resp.Pagination.Cursor)
+	}
+}
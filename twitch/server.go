@@ -0,0 +1,62 @@
+package twitch
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+//startServer starts the public HTTP server on NIA_TWITCH_SERVER_WH_LISTEN_PORT. When using the webhook transport,
+//eventsub requests are verified, then community events are handled directly and everything else is proxied through to
+//nazuna's own webhook server. If an OAuth redirect URL is configured, the callback used for channel verification is
+//served too.
+func (t *EventSource) startServer(conf *twitchConfig) error {
+	mux := http.NewServeMux()
+	routes := 0
+	if conf.transport == transportWebhook {
+		backend, err := url.Parse("http://" + conf.webhookBackend)
+		if err != nil {
+			return fmt.Errorf("webhook backend address %v is not valid: %v", conf.webhookBackend, err)
+		}
+		mux.Handle(webhookPath, t.verifier.wrap(t.handleCommunityEvents(httputil.NewSingleHostReverseProxy(backend))))
+		routes++
+	}
+	if t.oauth != nil {
+		redirect, err := url.Parse(conf.oauthRedirectURL)
+		if err != nil {
+			return fmt.Errorf("`%v` is not a valid URL: %v", oauthRedirectURLEnvVar, err)
+		}
+		path := redirect.Path
+		if path == "" {
+			path = "/"
+		}
+		mux.HandleFunc(path, t.handleOAuthCallback)
+		routes++
+	}
+	if routes == 0 {
+		return nil
+	}
+	t.server = &http.Server{Addr: conf.serverPort, Handler: mux}
+	go func() {
+		err := t.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Twitch HTTP server stopped due to error %v", err)
+		}
+	}()
+	return nil
+}
+
+//freeLoopbackAddr returns an address on the loopback interface whose port is currently unused, for nazuna's webhook
+//server to listen on
+func freeLoopbackAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
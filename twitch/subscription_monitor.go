@@ -0,0 +1,287 @@
+package twitch
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMonitorInterval = 5 * time.Minute
+	monitorInitialBackoff  = time.Minute
+	monitorMaxBackoff      = 6 * time.Hour
+	//monitorReportThreshold is the number of consecutive failed attempts to recreate a subscription after which it
+	//will be reported to the event handler
+	monitorReportThreshold = 3
+	//statusMissing is used in place of a subscription status when a subscription we created is no longer listed
+	statusMissing = "missing"
+)
+
+var (
+	subscriptionStatusCounts = expvar.NewMap("twitch_eventsub_subscriptions")
+	failingSubscriptionCount = expvar.NewInt("twitch_eventsub_failing_subscriptions")
+)
+
+//SubscriptionFailure describes a stream subscription which has been found inactive and has not yet been successfully
+//recreated
+type SubscriptionFailure struct {
+	BroadcasterUID string
+	Type           string
+	//The status of the subscription as reported by twitch, or "missing" if it no longer exists
+	Status   string
+	Attempts int
+	//The error returned by the most recent attempt to recreate the subscription. This will be nil if the subscription
+	//was recreated but has not yet been seen to be active.
+	LastError   error
+	NextAttempt time.Time
+}
+
+//SubscriptionHealth summarises the state of the eventsub subscriptions as of the most recent check
+type SubscriptionHealth struct {
+	LastCheck    time.Time
+	StatusCounts map[string]int
+	Failures     []SubscriptionFailure
+}
+
+type subscriptionKey struct {
+	broadcasterUID string
+	subType        string
+}
+
+//subscriptionMonitor keeps track of the results of periodic subscription checks
+type subscriptionMonitor struct {
+	interval time.Duration
+	stop     chan struct{}
+
+	lock      sync.Mutex
+	lastCheck time.Time
+	counts    map[string]int
+	failures  map[subscriptionKey]*SubscriptionFailure
+}
+
+func newSubscriptionMonitor(interval time.Duration) *subscriptionMonitor {
+	return &subscriptionMonitor{
+		interval: interval,
+		stop:     make(chan struct{}),
+		counts:   make(map[string]int),
+		failures: make(map[subscriptionKey]*SubscriptionFailure),
+	}
+}
+
+//SubscriptionHealth returns the results of the most recent subscription check
+func (t *EventSource) SubscriptionHealth() SubscriptionHealth {
+	m := t.monitor
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := SubscriptionHealth{
+		LastCheck:    m.lastCheck,
+		StatusCounts: make(map[string]int, len(m.counts)),
+		Failures:     make([]SubscriptionFailure, 0, len(m.failures)),
+	}
+	for status, count := range m.counts {
+		res.StatusCounts[status] = count
+	}
+	for _, failure := range m.failures {
+		res.Failures = append(res.Failures, *failure)
+	}
+	sort.Slice(res.Failures, func(i, j int) bool {
+		return res.Failures[i].BroadcasterUID < res.Failures[j].BroadcasterUID ||
+			(res.Failures[i].BroadcasterUID == res.Failures[j].BroadcasterUID && res.Failures[i].Type < res.Failures[j].Type)
+	})
+	return res
+}
+
+//monitorSubscriptions periodically checks the state of all subscriptions until the EventSource is closed
+func (t *EventSource) monitorSubscriptions() {
+	ticker := time.NewTicker(t.monitor.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.monitor.stop:
+			return
+		case <-ticker.C:
+			t.checkSubscriptions()
+		}
+	}
+}
+
+//checkSubscriptions lists all subscriptions from the API and tries to recreate any stream subscriptions which are no
+//longer active. Subscriptions which keep failing are retried with exponential backoff.
+func (t *EventSource) checkSubscriptions() {
+	//Prevent panic from crashing the whole bot
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Twitch subscription monitor panicked: %v", r)
+		}
+	}()
+
+	subscriptions, err := t.transport.subscriptions()
+	if err != nil {
+		logrus.Errorf("Failed to check twitch eventsub subscriptions due to error %v", err)
+		return
+	}
+	counts := make(map[string]int)
+	byID := make(map[string]subscriptionInfo, len(subscriptions))
+	for _, sub := range subscriptions {
+		counts[sub.Status]++
+		byID[sub.ID] = sub
+	}
+	t.monitor.recordCounts(counts)
+
+	t.subscriptionsLock.Lock()
+	wanted := make(map[string]subscription, len(t.liveSubscriptions))
+	for uid, sub := range t.liveSubscriptions {
+		wanted[uid] = sub
+	}
+	t.subscriptionsLock.Unlock()
+
+	for uid, sub := range wanted {
+		t.checkSubscription(subscriptionKey{broadcasterUID: uid, subType: "stream.online"}, sub.StreamOnlineSub, byID)
+		t.checkSubscription(subscriptionKey{broadcasterUID: uid, subType: "stream.offline"}, sub.StreamOfflineSub, byID)
+	}
+	t.monitor.pruneFailures(wanted)
+	failingSubscriptionCount.Set(int64(t.monitor.failureCount()))
+}
+
+func (t *EventSource) checkSubscription(key subscriptionKey, subID string, byID map[string]subscriptionInfo) {
+	existing, exists := byID[subID]
+	if exists && subscriptionIsActive(existing.Status) {
+		if t.monitor.clearFailure(key) {
+			logrus.Infof("Twitch %v subscription for %v has recovered", key.subType, key.broadcasterUID)
+		}
+		return
+	}
+	status := statusMissing
+	if exists {
+		status = existing.Status
+	}
+	if !t.monitor.due(key) {
+		return
+	}
+
+	//The lock is held whilst the subscription is recreated so that it can't also be replaced by
+	//recreateSubscriptions or resubscribeAll, or removed by Unsubscribe, at the same time
+	t.subscriptionsLock.Lock()
+	current, stillWanted := t.liveSubscriptions[key.broadcasterUID]
+	currentID := current.StreamOfflineSub
+	if key.subType == "stream.online" {
+		currentID = current.StreamOnlineSub
+	}
+	if !stillWanted || currentID != subID {
+		//The subscription has been replaced or removed since the list of subscriptions was retrieved
+		t.subscriptionsLock.Unlock()
+		return
+	}
+	logrus.Infof("Twitch %v subscription for %v has status %v. Recreating...", key.subType, key.broadcasterUID, status)
+	if exists {
+		err := t.transport.deleteSubscription(subID)
+		if err != nil {
+			logrus.Warnf("Failed to delete inactive subscription %v due to error %v", subID, err)
+		}
+	}
+	newID, err := t.transport.createSubscription(key.subType, map[string]string{"broadcaster_user_id": key.broadcasterUID})
+	if err == nil {
+		if key.subType == "stream.online" {
+			current.StreamOnlineSub = newID
+		} else {
+			current.StreamOfflineSub = newID
+		}
+		t.liveSubscriptions[key.broadcasterUID] = current
+	}
+	t.subscriptionsLock.Unlock()
+
+	//The attempt is only forgotten once a later check finds the new subscription active, so that subscriptions which
+	//are recreated but then fail again still back off and get reported
+	failure, report := t.monitor.recordAttempt(key, status, err)
+	if err != nil {
+		logrus.Errorf("Failed to recreate twitch %v subscription for %v due to error %v; retrying after %v", key.subType, key.broadcasterUID, err, failure.NextAttempt)
+	}
+	if report {
+		t.dispatchSubscriptionFailure(&failure)
+	}
+}
+
+func (m *subscriptionMonitor) recordCounts(counts map[string]int) {
+	m.lock.Lock()
+	m.counts = counts
+	m.lastCheck = time.Now()
+	m.lock.Unlock()
+
+	subscriptionStatusCounts.Init()
+	for status, count := range counts {
+		subscriptionStatusCounts.Add(status, int64(count))
+	}
+}
+
+//due returns true if enough time has passed since the last failure to try recreating the subscription again
+func (m *subscriptionMonitor) due(key subscriptionKey) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	failure, exists := m.failures[key]
+	return !exists || !time.Now().Before(failure.NextAttempt)
+}
+
+//recordAttempt notes an attempt to recreate an inactive subscription, returning a copy of the failure details and
+//whether the failure should now be reported
+func (m *subscriptionMonitor) recordAttempt(key subscriptionKey, status string, err error) (SubscriptionFailure, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	failure, exists := m.failures[key]
+	if !exists {
+		failure = &SubscriptionFailure{
+			BroadcasterUID: key.broadcasterUID,
+			Type:           key.subType,
+		}
+		m.failures[key] = failure
+	}
+	failure.Status = status
+	failure.Attempts++
+	failure.LastError = err
+	backoff := monitorInitialBackoff << uint(failure.Attempts-1)
+	if backoff > monitorMaxBackoff || backoff <= 0 {
+		backoff = monitorMaxBackoff
+	}
+	failure.NextAttempt = time.Now().Add(backoff)
+	return *failure, failure.Attempts == monitorReportThreshold
+}
+
+//clearFailure forgets any failures for a subscription, returning true if there were any
+func (m *subscriptionMonitor) clearFailure(key subscriptionKey) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, exists := m.failures[key]
+	delete(m.failures, key)
+	return exists
+}
+
+//pruneFailures forgets failures for any streams which are no longer subscribed to
+func (m *subscriptionMonitor) pruneFailures(wanted map[string]subscription) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key := range m.failures {
+		if _, exists := wanted[key.broadcasterUID]; !exists {
+			delete(m.failures, key)
+		}
+	}
+}
+
+func (m *subscriptionMonitor) failureCount() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.failures)
+}
+
+func (t *EventSource) dispatchSubscriptionFailure(failure *SubscriptionFailure) {
+	//Prevent panic from crashing the whole bot
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Bot handler thread panicked: %v", r)
+		}
+	}()
+
+	//Dispatch to bot handlers
+	t.handler.HandleTwitchSubscriptionFailure(failure)
+}
@@ -0,0 +1,262 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeTransport keeps subscriptions in memory, and can be made to fail to create them
+type fakeTransport struct {
+	lock    sync.Mutex
+	nextID  int
+	subs    map[string]subscriptionInfo
+	failing bool
+	created int
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{subs: make(map[string]subscriptionInfo)}
+}
+
+func (f *fakeTransport) createSubscription(subType string, condition map[string]string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failing {
+		return "", errors.New("twitch is having a bad day")
+	}
+	f.nextID++
+	f.created++
+	id := fmt.Sprintf("sub-%d", f.nextID)
+	f.subs[id] = subscriptionInfo{ID: id, Type: subType, Status: "enabled", Condition: condition}
+	return id, nil
+}
+
+func (f *fakeTransport) deleteSubscription(id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.subs, id)
+	return nil
+}
+
+func (f *fakeTransport) subscriptions() ([]subscriptionInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res := make([]subscriptionInfo, 0, len(f.subs))
+	for _, sub := range f.subs {
+		res = append(res, sub)
+	}
+	return res, nil
+}
+
+func (f *fakeTransport) clearSubscriptions() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subs = make(map[string]subscriptionInfo)
+	return nil
+}
+
+func (f *fakeTransport) close() error {
+	return nil
+}
+
+func (f *fakeTransport) setStatus(id, status string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sub := f.subs[id]
+	sub.Status = status
+	f.subs[id] = sub
+}
+
+func (f *fakeTransport) setFailing(failing bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failing = failing
+}
+
+func (f *fakeTransport) createdCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.created
+}
+
+//makeDue makes any failing subscriptions due to be retried straight away
+func (m *subscriptionMonitor) makeDue() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, failure := range m.failures {
+		failure.NextAttempt = time.Now().Add(-time.Second)
+	}
+}
+
+func TestMonitorBackoffSchedule(t *testing.T) {
+	m := newSubscriptionMonitor(defaultMonitorInterval)
+	key := subscriptionKey{broadcasterUID: "1001", subType: "stream.online"}
+	want := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute,
+		64 * time.Minute, 128 * time.Minute, 256 * time.Minute, 6 * time.Hour, 6 * time.Hour,
+	}
+	for i, backoff := range want {
+		if i > 0 && m.due(key) {
+			t.Errorf("subscription was due to be retried straight after attempt %d", i)
+		}
+		before := time.Now()
+		failure, report := m.recordAttempt(key, statusMissing, errors.New("failed"))
+		if failure.Attempts != i+1 {
+			t.Errorf("expected attempt %d, got %d", i+1, failure.Attempts)
+		}
+		if got := failure.NextAttempt.Sub(before); got < backoff || got > backoff+time.Second {
+			t.Errorf("expected backoff of %v after attempt %d, got %v", backoff, i+1, got)
+		}
+		if wantReport := i+1 == monitorReportThreshold; report != wantReport {
+			t.Errorf("expected report to be %v after attempt %d", wantReport, i+1)
+		}
+	}
+	//The backoff shouldn't overflow however many attempts there have been
+	for i := 0; i < 100; i++ {
+		failure, _ := m.recordAttempt(key, statusMissing, errors.New("failed"))
+		if backoff := time.Until(failure.NextAttempt); backoff <= 0 || backoff > monitorMaxBackoff {
+			t.Fatalf("got backoff of %v after %d attempts", backoff, failure.Attempts)
+		}
+	}
+	if !m.clearFailure(key) {
+		t.Errorf("expected failure to be cleared")
+	}
+	if !m.due(key) {
+		t.Errorf("expected subscription to be due once its failure was cleared")
+	}
+}
+
+func TestCheckSubscriptionsReportsFailures(t *testing.T) {
+	h := newFakeHandler()
+	transport := newFakeTransport()
+	source := &EventSource{
+		transport:         transport,
+		liveSubscriptions: make(map[string]subscription),
+		handler:           h,
+		monitor:           newSubscriptionMonitor(defaultMonitorInterval),
+	}
+	onlineID, _ := transport.createSubscription("stream.online", map[string]string{"broadcaster_user_id": "1001"})
+	offlineID, _ := transport.createSubscription("stream.offline", map[string]string{"broadcaster_user_id": "1001"})
+	source.liveSubscriptions["1001"] = subscription{StreamOnlineSub: onlineID, StreamOfflineSub: offlineID}
+
+	transport.setStatus(onlineID, "notification_failures_exceeded")
+	transport.setFailing(true)
+	for attempt := 1; attempt <= monitorReportThreshold+1; attempt++ {
+		source.monitor.makeDue()
+		source.checkSubscriptions()
+		health := source.SubscriptionHealth()
+		if len(health.Failures) != 1 || health.Failures[0].Attempts != attempt {
+			t.Fatalf("expected one failure with %d attempts, got %+v", attempt, health.Failures)
+		}
+		if attempt != monitorReportThreshold {
+			h.expectNone(t, 10*time.Millisecond)
+			continue
+		}
+		//The inactive subscription was deleted by the first attempt, so it is now missing
+		failure, ok := h.next(t).(*SubscriptionFailure)
+		if !ok || failure.BroadcasterUID != "1001" || failure.Type != "stream.online" || failure.Attempts != monitorReportThreshold || failure.Status != statusMissing || failure.LastError == nil {
+			t.Errorf("expected failure of stream.online subscription to be reported, got %+v", failure)
+		}
+	}
+
+	//Once twitch recovers the subscription should be recreated, and then forgotten once it is seen to be active
+	transport.setFailing(false)
+	source.monitor.makeDue()
+	source.checkSubscriptions()
+	source.subscriptionsLock.Lock()
+	recreated := source.liveSubscriptions["1001"]
+	source.subscriptionsLock.Unlock()
+	if recreated.StreamOnlineSub == onlineID || recreated.StreamOfflineSub != offlineID {
+		t.Errorf("expected only the stream.online subscription to be replaced, got %+v", recreated)
+	}
+	source.checkSubscriptions()
+	if health := source.SubscriptionHealth(); len(health.Failures) != 0 {
+		t.Errorf("expected failure to be forgotten once the subscription was active, got %+v", health.Failures)
+	}
+	h.expectNone(t, 10*time.Millisecond)
+}
+
+func TestCheckSubscriptionSkipsReplacedSubscriptions(t *testing.T) {
+	transport := newFakeTransport()
+	source := &EventSource{
+		transport:         transport,
+		liveSubscriptions: make(map[string]subscription),
+		handler:           newFakeHandler(),
+		monitor:           newSubscriptionMonitor(defaultMonitorInterval),
+	}
+	onlineID, _ := transport.createSubscription("stream.online", map[string]string{"broadcaster_user_id": "1001"})
+	offlineID, _ := transport.createSubscription("stream.offline", map[string]string{"broadcaster_user_id": "1001"})
+	source.liveSubscriptions["1001"] = subscription{StreamOnlineSub: onlineID, StreamOfflineSub: offlineID}
+	byID := map[string]subscriptionInfo{
+		"old-online": {ID: "old-online", Type: "stream.online", Status: "authorization_revoked"},
+	}
+	created := transport.createdCount()
+
+	//The subscription listed as inactive has already been replaced, e.g. by the webhook secret being rotated
+	source.checkSubscription(subscriptionKey{broadcasterUID: "1001", subType: "stream.online"}, "old-online", byID)
+	//The stream has been unsubscribed from since the subscriptions were listed
+	source.checkSubscription(subscriptionKey{broadcasterUID: "2002", subType: "stream.online"}, "old-online", byID)
+
+	if transport.createdCount() != created {
+		t.Errorf("expected no subscriptions to be created for replaced or removed subscriptions")
+	}
+	source.subscriptionsLock.Lock()
+	defer source.subscriptionsLock.Unlock()
+	if got := source.liveSubscriptions["1001"]; got.StreamOnlineSub != onlineID {
+		t.Errorf("expected current subscription %v to be kept, got %v", onlineID, got.StreamOnlineSub)
+	}
+	if _, exists := source.liveSubscriptions["2002"]; exists {
+		t.Errorf("removed stream was subscribed to again")
+	}
+}
+
+func TestCheckSubscriptionsDuringSecretRotation(t *testing.T) {
+	transport := newFakeTransport()
+	source := &EventSource{
+		transport:              transport,
+		liveSubscriptions:      make(map[string]subscription),
+		communitySubscriptions: make(map[string]map[string]string),
+		handler:                newFakeHandler(),
+		monitor:                newSubscriptionMonitor(defaultMonitorInterval),
+	}
+	for i := 0; i < 50; i++ {
+		uid := fmt.Sprint(1000 + i)
+		err := source.Subscribe(uid)
+		if err != nil {
+			t.Fatalf("failed to subscribe to %v: %v", uid, err)
+		}
+		source.subscriptionsLock.Lock()
+		transport.setStatus(source.liveSubscriptions[uid].StreamOnlineSub, "notification_failures_exceeded")
+		source.subscriptionsLock.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		source.checkSubscriptions()
+	}()
+	go func() {
+		defer wg.Done()
+		source.recreateSubscriptions()
+	}()
+	wg.Wait()
+
+	//Every subscription should be tracked exactly once, with none left behind on the transport
+	subs, _ := transport.subscriptions()
+	if len(subs) != 100 {
+		t.Errorf("expected 100 subscriptions after both paths recreated them, got %d", len(subs))
+	}
+	source.subscriptionsLock.Lock()
+	defer source.subscriptionsLock.Unlock()
+	for uid, sub := range source.liveSubscriptions {
+		for _, id := range []string{sub.StreamOnlineSub, sub.StreamOfflineSub} {
+			if _, exists := transport.subs[id]; !exists {
+				t.Errorf("subscription %v for %v is tracked but doesn't exist", id, uid)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package twitch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//Team is a twitch team along with the channels which are currently members of it
+type Team struct {
+	ID          string
+	Name        string
+	DisplayName string
+	Members     []TeamMember
+}
+
+//TeamMember is a channel which belongs to a twitch team
+type TeamMember struct {
+	ID          string `json:"user_id"`
+	Login       string `json:"user_login"`
+	DisplayName string `json:"user_name"`
+}
+
+//Category is a game or other category which twitch streams can be in
+type Category struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+//GetTeam looks up a twitch team and its current roster by name
+func (t *EventSource) GetTeam(name string) (*Team, error) {
+	var resp struct {
+		Data []struct {
+			ID              string       `json:"id"`
+			TeamName        string       `json:"team_name"`
+			TeamDisplayName string       `json:"team_display_name"`
+			Users           []TeamMember `json:"users"`
+		} `json:"data"`
+	}
+	err := t.helix.do(http.MethodGet, "/teams", url.Values{"name": {strings.ToLower(name)}}, nil, &resp)
+	if herr, ok := err.(*helixError); ok && herr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("no twitch team called %v exists", name)
+	} else if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) < 1 {
+		return nil, fmt.Errorf("no twitch team called %v exists", name)
+	}
+	team := resp.Data[0]
+	return &Team{
+		ID:          team.ID,
+		Name:        team.TeamName,
+		DisplayName: team.TeamDisplayName,
+		Members:     team.Users,
+	}, nil
+}
+
+//GetCategory looks up a twitch category by its exact name
+func (t *EventSource) GetCategory(name string) (*Category, error) {
+	var resp struct {
+		Data []Category `json:"data"`
+	}
+	err := t.helix.do(http.MethodGet, "/games", url.Values{"name": {name}}, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) < 1 {
+		return nil, fmt.Errorf("no twitch category called %v exists", name)
+	}
+	return &resp.Data[0], nil
+}
@@ -0,0 +1,116 @@
+package twitch
+
+import "fmt"
+
+//eventsubTransport manages eventsub subscriptions for one method of receiving notifications. Notifications
+//themselves are passed back to the EventSource dispatchers by each implementation.
+type eventsubTransport interface {
+	//createSubscription creates a new subscription, returning its ID
+	createSubscription(subType string, condition map[string]string) (string, error)
+	deleteSubscription(id string) error
+	//subscriptions lists every existing subscription which was created using this transport
+	subscriptions() ([]subscriptionInfo, error)
+	clearSubscriptions() error
+	close() error
+}
+
+//subscriptionInfo contains the details of an existing eventsub subscription
+type subscriptionInfo struct {
+	ID        string
+	Type      string
+	Status    string
+	Condition map[string]string
+}
+
+//subscriptionIsActive returns true if a subscription with the provided status is still expected to deliver
+//notifications
+func subscriptionIsActive(status string) bool {
+	switch status {
+	case "enabled", "webhook_callback_verification_pending":
+		return true
+	default:
+		return false
+	}
+}
+
+func newSubscriptionInfo(sub *eventsubSubscription) subscriptionInfo {
+	return subscriptionInfo{
+		ID:        sub.ID,
+		Type:      sub.Type,
+		Status:    sub.Status,
+		Condition: sub.Condition,
+	}
+}
+
+//listSubscriptions lists every eventsub subscription visible to a helix client
+func listSubscriptions(api *helixClient) ([]subscriptionInfo, error) {
+	subs, err := api.listEventsubSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]subscriptionInfo, 0, len(subs))
+	for i := range subs {
+		res = append(res, newSubscriptionInfo(&subs[i]))
+	}
+	return res, nil
+}
+
+//clearSubscriptions deletes every eventsub subscription visible to a helix client
+func clearSubscriptions(api *helixClient) error {
+	subs, err := api.listEventsubSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		err := api.deleteEventsubSubscription(sub.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//webhookTransport receives notifications through the webhook server run by nazuna. Subscriptions are managed
+//directly through the helix API, so that they can be signed with whichever secret is current at the time.
+type webhookTransport struct {
+	api      *helixClient
+	callback string
+	secret   func() string
+}
+
+func (w *webhookTransport) createSubscription(subType string, condition map[string]string) (string, error) {
+	if _, isCommunityEvent := communityEventByType(subType); !isCommunityEvent && subType != "stream.online" && subType != "stream.offline" {
+		return "", fmt.Errorf("subscription type %v is not supported by the webhook transport", subType)
+	}
+	sub, err := w.api.createEventsubSubscription(createEventsubSubscriptionRequest{
+		Type:      subType,
+		Version:   subscriptionVersion(subType),
+		Condition: condition,
+		Transport: eventsubTransportRequest{
+			Method:   "webhook",
+			Callback: w.callback,
+			Secret:   w.secret(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return sub.ID, nil
+}
+
+func (w *webhookTransport) deleteSubscription(id string) error {
+	return w.api.deleteEventsubSubscription(id)
+}
+
+func (w *webhookTransport) subscriptions() ([]subscriptionInfo, error) {
+	return listSubscriptions(w.api)
+}
+
+func (w *webhookTransport) clearSubscriptions() error {
+	return clearSubscriptions(w.api)
+}
+
+func (w *webhookTransport) close() error {
+	//nazuna's webhook server runs for the lifetime of the process
+	return nil
+}
@@ -1,26 +1,27 @@
 package twitch
 
 import (
+	"encoding/json"
 	"fmt"
-	"os"
+	"net/http"
+	"sync"
 
 	"github.com/callummance/nazuna"
 	"github.com/callummance/nazuna/messages"
-	"github.com/callummance/nazuna/restclient"
+	"github.com/callummance/nia/dispatch"
+	"github.com/callummance/nia/streaming"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	twitchClientIDEnvVar     = "NIA_TWITCH_CLIENT_ID"
-	twitchClientSecretEnvVar = "NIA_TWITCH_CLIENT_SECRET"
-	serverHostnameEnvVar     = "NIA_TWITCH_SERVER_HOSTNAME"
-	serverPortEnvVar         = "NIA_TWITCH_SERVER_WH_LISTEN_PORT"
-)
-
-//EventHandler is a struct which can handle all the events the discord listener generates.
+//EventHandler is a struct which can handle all the events the twitch listener generates.
 type EventHandler interface {
-	HandleTwitchStreamOnline(*messages.StreamOnlineEvent)
-	HandleTwitchStreamOffline(*messages.StreamOfflineEvent)
+	streaming.EventHandler
+	streaming.VerificationHandler
+	HandleTwitchSubscriptionFailure(*SubscriptionFailure)
+	HandleTwitchRaid(*RaidEvent)
+	HandleTwitchFollow(*FollowEvent)
+	HandleTwitchSubscribe(*SubscribeEvent)
+	WebhookSecretStore
 }
 
 type subscription struct {
@@ -30,29 +31,97 @@ type subscription struct {
 
 //EventSource contains a handle to the twitch event listener as well as REST client
 type EventSource struct {
-	twitchClient      *nazuna.EventsubClient
+	transport         eventsubTransport
+	subscriptionsLock sync.Mutex
 	liveSubscriptions map[string]subscription
 	handler           EventHandler
+	monitor           *subscriptionMonitor
+	oauth             *oauthClient
+	server            *http.Server
+	//communitySubscriptions contains the IDs of community event subscriptions, keyed by broadcaster UID then
+	//subscription type
+	communitySubscriptions map[string]map[string]string
+	communityEvents        []string
+	//helix is used for every request to the helix API other than managing webhook subscriptions, which need an app
+	//access token
+	helix *helixClient
+	//verifier checks the signatures of webhook notifications, and is nil when using the websocket transport
+	verifier          *webhookVerifier
+	webhookSecretLock sync.Mutex
+	webhookSecret     WebhookSecret
+	//events queues events so that those for the same stream are handled in order
+	events *dispatch.Dispatcher
 }
 
-//StartTwitchListener starts listening for events from the Twitch API
-func StartTwitchListener(handler EventHandler, initChannelListeners []string) (*EventSource, error) {
+//StartTwitchListener starts listening for events from the Twitch API, using either a webhook or a websocket
+//depending on the value of NIA_TWITCH_EVENTSUB_TRANSPORT. Events are passed on to the handler through the provided
+//dispatcher.
+func StartTwitchListener(handler EventHandler, initChannelListeners []string, events *dispatch.Dispatcher) (*EventSource, error) {
 	logrus.Tracef("Starting twitch listener with requested Twitch UIDs %v", initChannelListeners)
-	opts, err := getOptsFromEnv()
+	conf, err := getConfigFromEnv()
 	if err != nil {
 		logrus.Errorf("Failed to start twitch listener as %v", err)
 		return nil, err
 	}
-	client, err := nazuna.NewClient(*opts)
-	res := EventSource{
-		twitchClient:      client,
-		liveSubscriptions: make(map[string]subscription, len(initChannelListeners)),
-		handler:           handler,
+	res := &EventSource{
+		liveSubscriptions:      make(map[string]subscription, len(initChannelListeners)),
+		communitySubscriptions: make(map[string]map[string]string),
+		communityEvents:        conf.communityEvents,
+		handler:                handler,
+		events:                 events,
+		monitor:                newSubscriptionMonitor(conf.monitorInterval),
+	}
+	if conf.oauthRedirectURL != "" {
+		res.oauth = newOAuthClient(conf)
+	}
+
+	switch conf.transport {
+	case transportWebsocket:
+		api := newHelixClient(conf)
+		transport, err := startWebsocketTransport(conf.websocketURL, api, res.dispatchNotification, res.resubscribeAll)
+		if err != nil {
+			logrus.Errorf("Failed to connect to twitch eventsub websocket due to error %v", err)
+			return nil, err
+		}
+		res.helix = api
+		res.transport = transport
+	default:
+		res.verifier = newWebhookVerifier(conf.permissive)
+		err := res.loadWebhookSecret()
+		if err != nil {
+			logrus.Errorf("Failed to load twitch webhook secret due to error %v", err)
+			return nil, err
+		}
+		conf.webhookBackend, err = freeLoopbackAddr()
+		if err != nil {
+			logrus.Errorf("Failed to find a free port for the twitch webhook listener due to error %v", err)
+			return nil, err
+		}
+		client, err := nazuna.NewClient(conf.nazunaOpts(res.currentWebhookSecret()))
+		if err != nil {
+			logrus.Errorf("Failed to start twitch webhook listener due to error %v", err)
+			return nil, err
+		}
+		//Register handlers
+		client.RegisterHandler(res.dispatchStreamOnlineEvent)
+		client.RegisterHandler(res.dispatchStreamOfflineEvent)
+		res.helix = newHelixClient(conf)
+		//Webhook subscriptions must be created with an app access token
+		appConf := *conf
+		appConf.userAccessToken, appConf.userRefreshToken = "", ""
+		res.transport = &webhookTransport{
+			api:      newHelixClient(&appConf),
+			callback: conf.webhookCallbackURL(),
+			secret:   res.currentWebhookSecret,
+		}
 	}
 
-	//Register handlers
-	client.RegisterHandler(res.dispatchStreamOnlineEvent)
-	client.RegisterHandler(res.dispatchStreamOfflineEvent)
+	err = res.startServer(conf)
+	if err != nil {
+		logrus.Errorf("Failed to start twitch HTTP server due to error %v", err)
+		res.transport.close()
+		return nil, err
+	}
 
 	//	//TODO: check their current state and generate online/offline event to adjust for changes whilst the bot is offline
 
@@ -68,93 +137,91 @@ func StartTwitchListener(handler EventHandler, initChannelListeners []string) (*
 		return nil, err
 	}
 
-	return &res, nil
+	//Keep checking for subscriptions which fail later on
+	res.checkSubscriptions()
+	go res.monitorSubscriptions()
+	if res.verifier != nil {
+		go res.manageWebhookSecret(conf.secretRotation)
+	}
+
+	return res, nil
 }
 
-//SubscribeToURL takes a twitch name or URL and attempts to subscribe to stream.online and stream.offline events for that broadcaster.
-//Returns a twitchStream object if successful.
-func (t *EventSource) SubscribeToURL(nameOrURL string) error {
-	userData, err := t.twitchClient.GetBroadcaster(nameOrURL)
-	if err != nil {
-		return err
-	}
-	broadcasterID := userData.ID
-	err = t.SubscribeToStream(broadcasterID)
-	if err != nil {
-		return err
+//Close stops listening for twitch events
+func (t *EventSource) Close() error {
+	close(t.monitor.stop)
+	if t.server != nil {
+		t.server.Close()
 	}
-	return nil
+	return t.transport.close()
 }
 
-//SubscribeToStream attempts to create StreamOnline and StreamOffline subscriptions for the provided broadcaster
+//Subscribe attempts to create StreamOnline and StreamOffline subscriptions for the provided broadcaster
 //UID. If subscription data already exists in the provided twitchstream struct, this function will do nothing.
-func (t *EventSource) SubscribeToStream(twitchUID string) error {
+func (t *EventSource) Subscribe(twitchUID string) error {
+	t.subscriptionsLock.Lock()
+	defer t.subscriptionsLock.Unlock()
 	//Check if we have a subscription registered already
 	_, exists := t.liveSubscriptions[twitchUID]
 	//Only create a new subscription if one is not already there
 	//TODO: check that the subscription is actually still running
 	if !exists {
-		onlineSub, err := t.twitchClient.CreateSubscription(messages.ConditionStreamOnline{
-			BroadcasterUID: twitchUID,
-		})
+		condition := map[string]string{"broadcaster_user_id": twitchUID}
+		onlineSub, err := t.transport.createSubscription("stream.online", condition)
 		if err != nil {
 			return err
 		}
-		offlineSub, err := t.twitchClient.CreateSubscription(messages.ConditionStreamOffline{
-			BroadcasterUID: twitchUID,
-		})
+		offlineSub, err := t.transport.createSubscription("stream.offline", condition)
 		if err != nil {
-			if len(onlineSub.Data) > 0 {
-				t.twitchClient.DeleteSubscription(onlineSub.Data[0].ID)
-			}
+			t.transport.deleteSubscription(onlineSub)
 			return err
 		}
 		//Save subscription details to map
 		t.liveSubscriptions[twitchUID] = subscription{
-			StreamOnlineSub:  onlineSub.Data[0].ID,
-			StreamOfflineSub: offlineSub.Data[0].ID,
+			StreamOnlineSub:  onlineSub,
+			StreamOfflineSub: offlineSub,
 		}
 	}
+	t.subscribeCommunityEvents(twitchUID)
 	return nil
 }
 
-//UnsubscribeFromStream attempts to unsubscribe from stream online and offline events for the provided stream. It will also
+//Unsubscribe attempts to unsubscribe from stream online and offline events for the provided stream. It will also
 //reset the event subscription IDs
-func (t *EventSource) UnsubscribeFromStream(twitchUID string) error {
+func (t *EventSource) Unsubscribe(twitchUID string) error {
+	t.subscriptionsLock.Lock()
+	defer t.subscriptionsLock.Unlock()
 	s, exists := t.liveSubscriptions[twitchUID]
 	if !exists {
 		return fmt.Errorf("eventsub subscription for twitch stream with ID %v does not exist", twitchUID)
 	}
-	err := t.twitchClient.DeleteSubscription(s.StreamOfflineSub)
+	err := t.transport.deleteSubscription(s.StreamOfflineSub)
 	if err != nil {
 		return err
 	}
-	err = t.twitchClient.DeleteSubscription(s.StreamOnlineSub)
+	err = t.transport.deleteSubscription(s.StreamOnlineSub)
 	if err != nil {
 		return err
 	}
 	delete(t.liveSubscriptions, twitchUID)
+	t.unsubscribeCommunityEvents(twitchUID)
 	return nil
 }
 
-//GetBroadcasterDeets looks up a broadcaster by name and attempts to fetch their details
-func (t *EventSource) GetBroadcasterDeets(name string) (*restclient.TwitchUser, error) {
-	return t.twitchClient.GetBroadcaster(name)
-}
-
 //ClearSubscriptions attempts to unsubscribe from all current subscriptions
 func (t *EventSource) ClearSubscriptions() error {
+	t.subscriptionsLock.Lock()
+	defer t.subscriptionsLock.Unlock()
 	t.liveSubscriptions = make(map[string]subscription)
-	err := t.twitchClient.ClearSubscriptions()
+	t.communitySubscriptions = make(map[string]map[string]string)
+	err := t.transport.clearSubscriptions()
 	return err
 }
 
-//GetStream attempts to retrieve details on an airing stream. Returns nil if an error occurred or if
+//getStream attempts to retrieve details on an airing stream. Returns nil if an error occurred or if
 //nothing was returned by the API (this usually means the stream is not currently live)
-func (t *EventSource) GetStream(twitchUID string) (*restclient.TwitchStream, error) {
-	res, err := t.twitchClient.GetStreams(restclient.GetStreamsOpts{
-		UserID: []string{twitchUID},
-	})
+func (t *EventSource) getStream(twitchUID string) (*helixStream, error) {
+	res, err := t.helix.GetStreams([]string{twitchUID})
 	if err != nil {
 		return nil, err
 	}
@@ -164,127 +231,143 @@ func (t *EventSource) GetStream(twitchUID string) (*restclient.TwitchStream, err
 	return &res[0], nil
 }
 
-//ForceStreamUpdate manually checks the status of a given stream and generates a streamonline or streamoffline event.
-func (t *EventSource) ForceStreamUpdate(twitchUID string) error {
-	stream, err := t.GetStream(twitchUID)
+//getStreams retrieves details on each of the provided streams which are currently live, keyed by broadcaster UID.
+//Streams which are not live will not have an entry in the returned map.
+func (t *EventSource) getStreams(twitchUIDs []string) (map[string]*helixStream, error) {
+	res := make(map[string]*helixStream, len(twitchUIDs))
+	//The API only accepts up to 100 user IDs per request
+	for start := 0; start < len(twitchUIDs); start += 100 {
+		end := start + 100
+		if end > len(twitchUIDs) {
+			end = len(twitchUIDs)
+		}
+		streams, err := t.helix.GetStreams(twitchUIDs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i := range streams {
+			res[streams[i].UserID] = &streams[i]
+		}
+	}
+	return res, nil
+}
+
+//ForceUpdate manually checks the status of a given stream and generates a streamonline or streamoffline event.
+func (t *EventSource) ForceUpdate(twitchUID string) error {
+	stream, err := t.getStream(twitchUID)
 	if err != nil {
 		return err
 	}
-	go func() {
-		//Prevent panic from crashing the whole bot
-		defer func() {
-			if r := recover(); r != nil {
-				logrus.Errorf("Bot handler thread panicked: %v", r)
-			}
-		}()
+	//ForceUpdate can be called from within other events, so it mustn't wait for room in the queue
+	return t.events.TrySubmit(streamEventKey(twitchUID), func() {
 		if stream == nil {
 			//assume stream is offline
-			t.handler.HandleTwitchStreamOffline(&messages.StreamOfflineEvent{
-				BroadcasterUID:       twitchUID,
-				BroadcasterUserLogin: "unknown",
-				BroadcasterUserName:  "unknown",
+			t.handler.HandleStreamOffline(&streaming.OfflineEvent{
+				Provider:  ProviderName,
+				ChannelID: twitchUID,
 			})
 		} else {
 			//stream is online
-			t.handler.HandleTwitchStreamOnline(&messages.StreamOnlineEvent{
-				BroadcasterUID:       twitchUID,
-				BroadcasterUserLogin: stream.UserLogin,
-				BroadcasterUserName:  stream.UserName,
-				Type:                 stream.Type,
-				StartedAt:            stream.StartedAt,
+			t.handler.HandleStreamOnline(&streaming.OnlineEvent{
+				Provider:    ProviderName,
+				ChannelID:   twitchUID,
+				ChannelName: stream.UserName,
 			})
 		}
-	}()
-	return nil
+	})
 }
 
 //refreshSubscriptions retrieves a new copy of the subscriptions list from the Twitch API, deleting and
 //recreating any non-active subscriptions
 func (t *EventSource) refreshSubscriptions() error {
-	subscriptionsIter := t.twitchClient.Subscriptions(restclient.SubscriptionsParams{})
-	for subscription := range subscriptionsIter {
-		if subscription.Err != nil {
-			return fmt.Errorf("failed to refresh subscriptions as subscription retrieval failed with error %v", subscription.Err)
+	subscriptions, err := t.transport.subscriptions()
+	if err != nil {
+		return fmt.Errorf("failed to refresh subscriptions as subscription retrieval failed with error %v", err)
+	}
+	t.subscriptionsLock.Lock()
+	defer t.subscriptionsLock.Unlock()
+	for _, subscription := range subscriptions {
+		_, isCommunityEvent := communityEventByType(subscription.Type)
+		if subscription.Type != "stream.online" && subscription.Type != "stream.offline" && !isCommunityEvent {
+			continue
 		}
-		subscriptionStatus := subscription.Subscription.Status
-		switch subscriptionStatus {
-		case "webhook_callback_verification_failed":
-			fallthrough
-		case "notification_failures_exceeded":
-			fallthrough
-		case "authorization_revoked":
-			fallthrough
-		case "user_removed":
-			logrus.Infof("Twitch event subscription %v has a non-active status. Recreating...", subscription.Subscription)
-			//Subscription is no longer live, so we should cancel it then recreate a new subscription if if is stream.online or stream.offline
-			switch subscription.Subscription.Type {
-			case "stream.online":
-				err := t.twitchClient.DeleteSubscription(subscription.Subscription.ID)
-				if err != nil {
-					logrus.Errorf("Failed to delete subscription %v whilst refreshing expired subscription due to error %v", subscription.Subscription, err)
-				}
-				condition := subscription.Subscription.Condition.(map[string]interface{})
-				bid := condition["broadcaster_user_id"].(string)
-				onlineSub, err := t.twitchClient.CreateSubscription(messages.ConditionStreamOnline{
-					BroadcasterUID: bid,
-				})
-				if err != nil {
-					logrus.Errorf("Failed to recreate subscription %v whilst refreshing expired subscription due to error %v", subscription.Subscription, err)
-				}
-				orig := t.liveSubscriptions[bid]
-				orig.StreamOnlineSub = onlineSub.Data[0].ID
-				t.liveSubscriptions[bid] = orig
-			case "stream.offline":
-				err := t.twitchClient.DeleteSubscription(subscription.Subscription.ID)
-				if err != nil {
-					logrus.Errorf("Failed to delete subscription %v whilst refreshing expired subscription due to error %v", subscription.Subscription, err)
-				}
-				condition := subscription.Subscription.Condition.(map[string]interface{})
-				bid := condition["broadcaster_user_id"].(string)
-				offlineSub, err := t.twitchClient.CreateSubscription(messages.ConditionStreamOffline{
-					BroadcasterUID: bid,
-				})
-				if err != nil {
-					logrus.Errorf("Failed to recreate subscription %v whilst refreshing expired subscription due to error %v", subscription.Subscription, err)
-				}
-				orig := t.liveSubscriptions[bid]
-				orig.StreamOfflineSub = offlineSub.Data[0].ID
-				t.liveSubscriptions[bid] = orig
-			}
-		case "enabled":
-			fallthrough
-		case "webhook_callback_verification_pending":
+		bid := subscriptionBroadcaster(subscription)
+		subID := subscription.ID
+		if subscriptionIsActive(subscription.Status) {
 			//If still live, we just need to add to map of subscriptions if necessary
-			logrus.Debugf("Adding already-live subscription %v to internal map", subscription.Subscription)
-			switch subscription.Subscription.Type {
-			case "stream.online":
-				condition := subscription.Subscription.Condition.(map[string]interface{})
-				bid := condition["broadcaster_user_id"].(string)
-				orig := t.liveSubscriptions[bid]
-				orig.StreamOnlineSub = subscription.Subscription.ID
-				t.liveSubscriptions[bid] = orig
-			case "stream.offline":
-				condition := subscription.Subscription.Condition.(map[string]interface{})
-				bid := condition["broadcaster_user_id"].(string)
-				orig := t.liveSubscriptions[bid]
-				orig.StreamOfflineSub = subscription.Subscription.ID
-				t.liveSubscriptions[bid] = orig
+			logrus.Debugf("Adding already-live subscription %v to internal map", subscription)
+		} else {
+			//Subscription is no longer live, so we should cancel it then recreate a new subscription
+			logrus.Infof("Twitch event subscription %v has a non-active status. Recreating...", subscription)
+			err := t.transport.deleteSubscription(subscription.ID)
+			if err != nil {
+				logrus.Errorf("Failed to delete subscription %v whilst refreshing expired subscription due to error %v", subscription, err)
+			}
+			subID, err = t.transport.createSubscription(subscription.Type, subscription.Condition)
+			if err != nil {
+				logrus.Errorf("Failed to recreate subscription %v whilst refreshing expired subscription due to error %v", subscription, err)
+				continue
+			}
+		}
+		if isCommunityEvent {
+			if t.communitySubscriptions[bid] == nil {
+				t.communitySubscriptions[bid] = make(map[string]string)
 			}
+			t.communitySubscriptions[bid][subscription.Type] = subID
+			continue
 		}
+		orig := t.liveSubscriptions[bid]
+		if subscription.Type == "stream.online" {
+			orig.StreamOnlineSub = subID
+		} else {
+			orig.StreamOfflineSub = subID
+		}
+		t.liveSubscriptions[bid] = orig
 	}
 	return nil
 }
 
+//resubscribeAll recreates every subscription after they were lost along with a websocket session, then checks the
+//state of each stream in case any events were missed whilst disconnected
+func (t *EventSource) resubscribeAll() {
+	t.subscriptionsLock.Lock()
+	previous := t.liveSubscriptions
+	previousCommunity := t.communitySubscriptions
+	t.liveSubscriptions = make(map[string]subscription, len(previous))
+	t.communitySubscriptions = make(map[string]map[string]string, len(previousCommunity))
+	t.subscriptionsLock.Unlock()
+	logrus.Infof("Recreating %v twitch eventsub subscriptions after session was lost", len(previous))
+	for uid, sub := range previous {
+		//The old subscriptions are disabled, but still count against our limits until deleted
+		t.transport.deleteSubscription(sub.StreamOnlineSub)
+		t.transport.deleteSubscription(sub.StreamOfflineSub)
+		for _, id := range previousCommunity[uid] {
+			t.transport.deleteSubscription(id)
+		}
+		err := t.Subscribe(uid)
+		if err != nil {
+			logrus.Errorf("Failed to recreate subscription to twitch UID %v due to error %v", uid, err)
+			continue
+		}
+		err = t.ForceUpdate(uid)
+		if err != nil {
+			logrus.Warnf("Failed to check state of twitch stream %v after reconnecting due to error %v", uid, err)
+		}
+	}
+}
+
 func (t *EventSource) SyncSubscriptions(desiredSubscriptionUIDs []string) error {
 	subs := make(map[string]struct {
 		IsRequested bool
 		IsLive      bool
 	}, len(desiredSubscriptionUIDs))
+	t.subscriptionsLock.Lock()
 	for k := range t.liveSubscriptions {
 		prev := subs[k]
 		prev.IsLive = true
 		subs[k] = prev
 	}
+	t.subscriptionsLock.Unlock()
 	for _, s := range desiredSubscriptionUIDs {
 		prev := subs[s]
 		prev.IsRequested = true
@@ -298,14 +381,14 @@ func (t *EventSource) SyncSubscriptions(desiredSubscriptionUIDs []string) error
 		case status.IsLive && !status.IsRequested:
 			//Is running but we don't want it, so delete the subscription
 			logrus.Debugf("Unsubscribing from twitch UID %v", uid)
-			err := t.UnsubscribeFromStream(uid)
+			err := t.Unsubscribe(uid)
 			if err != nil {
 				logrus.Errorf("Failed to remove no-longer-required subscription to twitch UID %v due to error %v", uid, err)
 			}
 		case !status.IsLive && status.IsRequested:
 			//Is not currently subscribed but we want notifications so create new subscription
 			logrus.Debugf("Adding subscription to twitch UID %v", uid)
-			err := t.SubscribeToStream(uid)
+			err := t.Subscribe(uid)
 			if err != nil {
 				logrus.Errorf("Failed to create subscription to twitch UID %v due to error %v", uid, err)
 			}
@@ -317,71 +400,83 @@ func (t *EventSource) SyncSubscriptions(desiredSubscriptionUIDs []string) error
 	return nil
 }
 
-func getOptsFromEnv() (*nazuna.NazunaOpts, error) {
-	clientID, exists := os.LookupEnv(twitchClientIDEnvVar)
-	if !exists {
-		logrus.Warnf("`%v` env variable was not set.", twitchClientIDEnvVar)
-		return nil, fmt.Errorf("`%v` env variable was not set", twitchClientIDEnvVar)
-	}
-	clientSecret, exists := os.LookupEnv(twitchClientSecretEnvVar)
-	if !exists {
-		logrus.Warnf("`%v` env variable was not set.", twitchClientSecretEnvVar)
-		return nil, fmt.Errorf("`%v` env variable was not set", twitchClientSecretEnvVar)
-	}
-	serverHostname, exists := os.LookupEnv(serverHostnameEnvVar)
-	if !exists {
-		logrus.Warnf("`%v` env variable was not set.", serverHostnameEnvVar)
-		return nil, fmt.Errorf("`%v` env variable was not set", serverHostnameEnvVar)
-	}
-	serverPort, exists := os.LookupEnv(serverPortEnvVar)
-	if !exists {
-		logrus.Warnf("`%v` env variable was not set.", serverHostnameEnvVar)
-		serverPort = ":8080"
-	}
-	permissive := false
-	logLevel, exists := os.LookupEnv("NIA_LOG_LEVEL")
-	if exists && (logLevel == "DEBUG" || logLevel == "TRACE") {
-		permissive = true
-	}
-	opts := nazuna.NazunaOpts{
-		WebhookPath:    "/twitchhook",
-		ListenOn:       serverPort,
-		ClientID:       clientID,
-		ClientSecret:   clientSecret,
-		Scopes:         nil,
-		Secret:         "",
-		ServerHostname: serverHostname,
-		Permissive:     permissive,
+//streamEvent contains the fields of stream.online and stream.offline notification events which the bot uses
+type streamEvent struct {
+	BroadcasterUID      string `json:"broadcaster_user_id"`
+	BroadcasterUserName string `json:"broadcaster_user_name"`
+}
+
+//dispatchNotification decodes a notification received over the websocket transport, or a community event received
+//by the webhook server, and passes it on to the matching dispatcher
+func (t *EventSource) dispatchNotification(s *eventsubSubscription, event json.RawMessage) {
+	switch s.Type {
+	case "stream.online", "stream.offline":
+		var ev streamEvent
+		err := json.Unmarshal(event, &ev)
+		if err != nil {
+			logrus.Errorf("Failed to decode %v event %v due to error %v", s.Type, string(event), err)
+			return
+		}
+		if s.Type == "stream.online" {
+			t.streamOnline(ev.BroadcasterUID, ev.BroadcasterUserName)
+		} else {
+			t.streamOffline(ev.BroadcasterUID, ev.BroadcasterUserName)
+		}
+	case "channel.raid", "channel.follow", "channel.subscribe":
+		t.submit("twitch/subscription/"+s.ID, func() {
+			t.dispatchCommunityEvent(s, event)
+		})
+	default:
+		logrus.Warnf("Ignoring notification for unhandled eventsub subscription type %v", s.Type)
 	}
-	return &opts, nil
 }
 
+//dispatchStreamOnlineEvent handles stream online notifications received by nazuna's webhook server
 func (t *EventSource) dispatchStreamOnlineEvent(s *messages.Subscription, ev *messages.StreamOnlineEvent) {
-	//Prevent panic from crashing the whole bot
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("Bot handler thread panicked: %v", r)
-		}
-	}()
+	t.streamOnline(ev.BroadcasterUID, ev.BroadcasterUserName)
+}
+
+//dispatchStreamOfflineEvent handles stream offline notifications received by nazuna's webhook server
+func (t *EventSource) dispatchStreamOfflineEvent(s *messages.Subscription, ev *messages.StreamOfflineEvent) {
+	t.streamOffline(ev.BroadcasterUID, ev.BroadcasterUserName)
+}
 
+func (t *EventSource) streamOnline(twitchUID, name string) {
 	//For debugging
-	logrus.Debugf("Got stream online alert for stream`%v`\n", ev.BroadcasterUserName)
+	logrus.Debugf("Got stream online alert for stream`%v`\n", name)
 
 	//Dispatch to bot handlers
-	t.handler.HandleTwitchStreamOnline(ev)
+	t.submit(streamEventKey(twitchUID), func() {
+		t.handler.HandleStreamOnline(&streaming.OnlineEvent{
+			Provider:    ProviderName,
+			ChannelID:   twitchUID,
+			ChannelName: name,
+		})
+	})
 }
 
-func (t *EventSource) dispatchStreamOfflineEvent(s *messages.Subscription, ev *messages.StreamOfflineEvent) {
-	//Prevent panic from crashing the whole bot
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("Bot handler thread panicked: %v", r)
-		}
-	}()
-
+func (t *EventSource) streamOffline(twitchUID, name string) {
 	//For debugging
-	logrus.Debugf("Got stream offline alert for stream`%v`\n", ev.BroadcasterUserName)
+	logrus.Debugf("Got stream offline alert for stream`%v`\n", name)
 
 	//Dispatch to bot handlers
-	t.handler.HandleTwitchStreamOffline(ev)
+	t.submit(streamEventKey(twitchUID), func() {
+		t.handler.HandleStreamOffline(&streaming.OfflineEvent{
+			Provider:  ProviderName,
+			ChannelID: twitchUID,
+		})
+	})
+}
+
+//submit queues an event to be passed on to the handler once any earlier events with the same key have been handled
+func (t *EventSource) submit(key string, event func()) {
+	err := t.events.Submit(key, event)
+	if err != nil {
+		logrus.Warnf("Dropping twitch event with key %v due to error %v", key, err)
+	}
+}
+
+//streamEventKey returns the key used to make sure events for a stream are handled in the order they were received
+func streamEventKey(twitchUID string) string {
+	return "twitch/" + twitchUID
 }
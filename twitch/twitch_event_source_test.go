@@ -0,0 +1,117 @@
+package twitch
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/dispatch"
+	"github.com/callummance/nia/streaming"
+	"github.com/callummance/nia/twitch/mockeventsub"
+)
+
+//testEventTimeout is how long tests wait for an event to reach the handler
+const testEventTimeout = 5 * time.Second
+
+//fakeHandler passes every event it receives to a channel, so that tests can wait for them
+type fakeHandler struct {
+	events chan interface{}
+
+	lock   sync.Mutex
+	secret *WebhookSecret
+}
+
+func newFakeHandler() *fakeHandler {
+	return &fakeHandler{events: make(chan interface{}, 100)}
+}
+
+func (h *fakeHandler) HandleStreamOnline(ev *streaming.OnlineEvent)   { h.events <- ev }
+func (h *fakeHandler) HandleStreamOffline(ev *streaming.OfflineEvent) { h.events <- ev }
+func (h *fakeHandler) HandleTwitchRaid(ev *RaidEvent)                 { h.events <- ev }
+func (h *fakeHandler) HandleTwitchFollow(ev *FollowEvent)             { h.events <- ev }
+func (h *fakeHandler) HandleTwitchSubscribe(ev *SubscribeEvent)       { h.events <- ev }
+
+func (h *fakeHandler) HandleTwitchSubscriptionFailure(failure *SubscriptionFailure) {
+	h.events <- failure
+}
+
+func (h *fakeHandler) HandleVerification(*streaming.Verification) (string, error) {
+	return "", nil
+}
+
+func (h *fakeHandler) LoadTwitchWebhookSecret() (*WebhookSecret, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.secret, nil
+}
+
+func (h *fakeHandler) SaveTwitchWebhookSecret(secret *WebhookSecret) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	saved := *secret
+	h.secret = &saved
+	return nil
+}
+
+//next waits for the handler to receive an event, failing the test if none arrives
+func (h *fakeHandler) next(t *testing.T) interface{} {
+	t.Helper()
+	select {
+	case ev := <-h.events:
+		return ev
+	case <-time.After(testEventTimeout):
+		t.Fatalf("timed out waiting for an event")
+		return nil
+	}
+}
+
+//expectNone fails the test if the handler receives an event within the provided duration
+func (h *fakeHandler) expectNone(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case ev := <-h.events:
+		t.Fatalf("got unexpected event %#v", ev)
+	case <-time.After(wait):
+	}
+}
+
+//startMockTwitch starts a mock eventsub server which is stopped once the test finishes
+func startMockTwitch(t *testing.T, keepalive time.Duration) (*mockeventsub.Server, *httptest.Server) {
+	mock := mockeventsub.New(keepalive)
+	srv := httptest.NewServer(mock)
+	t.Cleanup(func() {
+		mock.Close()
+		srv.Close()
+	})
+	return mock, srv
+}
+
+//newTestEventSource creates an EventSource without a transport, whose helix requests are sent to the provided mock
+//server
+func newTestEventSource(t *testing.T, srv *httptest.Server, handler *fakeHandler) *EventSource {
+	events := dispatch.New(2, 100)
+	t.Cleanup(events.Close)
+	return &EventSource{
+		liveSubscriptions:      make(map[string]subscription),
+		communitySubscriptions: make(map[string]map[string]string),
+		handler:                handler,
+		events:                 events,
+		monitor:                newSubscriptionMonitor(defaultMonitorInterval),
+		helix:                  newHelixClient(testConfig(srv)),
+	}
+}
+
+//testConfig returns the settings needed to use a mock eventsub server
+func testConfig(srv *httptest.Server) *twitchConfig {
+	return &twitchConfig{
+		clientID:        "test-client",
+		clientSecret:    "test-secret",
+		transport:       transportWebsocket,
+		websocketURL:    "ws" + srv.URL[len("http"):] + "/ws",
+		apiURL:          srv.URL + "/helix",
+		authURL:         srv.URL + "/oauth2",
+		userAccessToken: "test-token",
+		monitorInterval: defaultMonitorInterval,
+	}
+}
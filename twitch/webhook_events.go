@@ -0,0 +1,56 @@
+package twitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+const eventsubMessageTypeHeader = "Twitch-Eventsub-Message-Type"
+
+//webhookMessage is the body of an eventsub webhook request
+type webhookMessage struct {
+	//Challenge is only included in webhook_callback_verification requests
+	Challenge    string               `json:"challenge"`
+	Subscription eventsubSubscription `json:"subscription"`
+	Event        json.RawMessage      `json:"event"`
+}
+
+//handleCommunityEvents returns a handler which answers webhook requests for community event subscriptions itself,
+//passing any others on to next. nazuna only knows how to handle stream online and offline subscriptions. Requests
+//must already have been verified.
+func (t *EventSource) handleCommunityEvents(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		var msg webhookMessage
+		err = json.Unmarshal(body, &msg)
+		if _, isCommunityEvent := communityEventByType(msg.Subscription.Type); err != nil || !isCommunityEvent {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch messageType := r.Header.Get(eventsubMessageTypeHeader); messageType {
+		case "webhook_callback_verification":
+			logrus.Debugf("Confirming twitch %v subscription %v", msg.Subscription.Type, msg.Subscription.ID)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(msg.Challenge))
+		case "notification":
+			t.dispatchNotification(&msg.Subscription, msg.Event)
+			w.WriteHeader(http.StatusNoContent)
+		case "revocation":
+			logrus.Warnf("Twitch revoked eventsub subscription %v with status %v", msg.Subscription.ID, msg.Subscription.Status)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			logrus.Warnf("Ignoring twitch webhook request with unknown message type %v", messageType)
+			http.Error(w, "unknown message type", http.StatusBadRequest)
+		}
+	})
+}
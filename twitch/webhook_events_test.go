@@ -0,0 +1,128 @@
+package twitch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/twitch/mockeventsub"
+)
+
+//sendWebhook sends a signed eventsub webhook request with the provided message type to handler
+func sendWebhook(handler http.Handler, id, messageType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(body))
+	for k, v := range webhookHeader(id, time.Now(), testCurrentSecret, body) {
+		req.Header[k] = v
+	}
+	req.Header.Set(eventsubMessageTypeHeader, messageType)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+//newTestWebhookHandler returns the handler used for the webhook path, with requests for nazuna passed to a handler
+//which records their bodies instead
+func newTestWebhookHandler(t *testing.T) (http.Handler, *mockeventsub.Server, *fakeHandler, *[]string) {
+	mock, srv := startMockTwitch(t, time.Minute)
+	h := newFakeHandler()
+	source := newTestEventSource(t, srv, h)
+	source.verifier = newWebhookVerifier(false)
+	source.verifier.setSecrets(testCurrentSecret)
+	var passed []string
+	nazuna := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		passed = append(passed, string(body))
+	})
+	return source.verifier.wrap(source.handleCommunityEvents(nazuna)), mock, h, &passed
+}
+
+func TestWebhookCommunityChallenge(t *testing.T) {
+	handler, _, _, passed := newTestWebhookHandler(t)
+	body := `{"challenge":"pogchamp-kappa-360noscope-vohiyo","subscription":{"id":"sub-1","type":"channel.raid","version":"1","status":"webhook_callback_verification_pending","condition":{"from_broadcaster_user_id":"1001"}}}`
+	rec := sendWebhook(handler, "msg-1", "webhook_callback_verification", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, rec.Code)
+	}
+	if got := rec.Body.String(); got != "pogchamp-kappa-360noscope-vohiyo" {
+		t.Errorf("expected challenge to be echoed, got %q", got)
+	}
+	if len(*passed) != 0 {
+		t.Errorf("community event challenge was passed on to nazuna")
+	}
+}
+
+func TestWebhookCommunityNotifications(t *testing.T) {
+	handler, mock, h, passed := newTestWebhookHandler(t)
+	broadcaster := mock.AddUser("broadcaster")
+	mock.SetFollowers("broadcaster", 42)
+
+	rec := sendWebhook(handler, "msg-raid", "notification", `{"subscription":{"id":"sub-1","type":"channel.raid","version":"1","status":"enabled"},"event":{"from_broadcaster_user_id":"`+broadcaster.ID+`","from_broadcaster_user_name":"broadcaster","to_broadcaster_user_id":"2002","to_broadcaster_user_name":"target","viewers":9001}}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %v, got %v", http.StatusNoContent, rec.Code)
+	}
+	raid, ok := h.next(t).(*RaidEvent)
+	if !ok || raid.FromUID != broadcaster.ID || raid.ToUID != "2002" || raid.Viewers != 9001 {
+		t.Errorf("expected raid from %v to 2002 with 9001 viewers, got %#v", broadcaster.ID, raid)
+	}
+
+	sendWebhook(handler, "msg-follow", "notification", `{"subscription":{"id":"sub-2","type":"channel.follow","version":"2","status":"enabled"},"event":{"user_id":"3003","user_name":"follower","broadcaster_user_id":"`+broadcaster.ID+`","broadcaster_user_name":"broadcaster"}}`)
+	follow, ok := h.next(t).(*FollowEvent)
+	if !ok || follow.UserID != "3003" || follow.Followers != 42 {
+		t.Errorf("expected follow from 3003 with 42 followers, got %#v", follow)
+	}
+
+	sendWebhook(handler, "msg-subscribe", "notification", `{"subscription":{"id":"sub-3","type":"channel.subscribe","version":"1","status":"enabled"},"event":{"user_id":"4004","user_name":"subscriber","broadcaster_user_id":"`+broadcaster.ID+`","tier":"2000","is_gift":true}}`)
+	sub, ok := h.next(t).(*SubscribeEvent)
+	if !ok || sub.UserID != "4004" || sub.Tier != "2000" || !sub.IsGift {
+		t.Errorf("expected gifted tier 2 subscription from 4004, got %#v", sub)
+	}
+
+	if len(*passed) != 0 {
+		t.Errorf("community event notifications were passed on to nazuna")
+	}
+}
+
+func TestWebhookCommunityRevocation(t *testing.T) {
+	handler, _, h, passed := newTestWebhookHandler(t)
+	rec := sendWebhook(handler, "msg-1", "revocation", `{"subscription":{"id":"sub-1","type":"channel.follow","version":"2","status":"authorization_revoked"}}`)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %v, got %v", http.StatusNoContent, rec.Code)
+	}
+	if len(*passed) != 0 {
+		t.Errorf("community event revocation was passed on to nazuna")
+	}
+	h.expectNone(t, 100*time.Millisecond)
+}
+
+func TestWebhookStreamEventsPassedOn(t *testing.T) {
+	handler, _, h, passed := newTestWebhookHandler(t)
+	for _, messageType := range []string{"webhook_callback_verification", "notification"} {
+		rec := sendWebhook(handler, "msg-"+messageType, messageType, testMessageBody)
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected %v request to be passed on with status %v, got %v", messageType, http.StatusOK, rec.Code)
+		}
+	}
+	if len(*passed) != 2 || (*passed)[0] != testMessageBody || (*passed)[1] != testMessageBody {
+		t.Errorf("expected both stream.online requests to be passed on unchanged, got %q", *passed)
+	}
+	h.expectNone(t, 100*time.Millisecond)
+}
+
+func TestWebhookUnsignedCommunityEventRejected(t *testing.T) {
+	handler, _, h, _ := newTestWebhookHandler(t)
+	body := `{"subscription":{"id":"sub-1","type":"channel.raid","version":"1","status":"enabled"},"event":{"from_broadcaster_user_id":"1001","to_broadcaster_user_id":"2002","viewers":1}}`
+	req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(body))
+	for k, v := range webhookHeader("msg-1", time.Now(), testPreviousSecret, body) {
+		req.Header[k] = v
+	}
+	req.Header.Set(eventsubMessageTypeHeader, "notification")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %v, got %v", http.StatusForbidden, rec.Code)
+	}
+	h.expectNone(t, 100*time.Millisecond)
+}
@@ -0,0 +1,210 @@
+package twitch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//webhookSecretCheckInterval is how often the webhook secret is checked to see whether it is due to be rotated, or
+//whether subscriptions still need to be moved over to it
+const webhookSecretCheckInterval = 15 * time.Minute
+
+//WebhookSecret is the secret eventsub webhook notifications are signed with, along with the secret it replaced
+type WebhookSecret struct {
+	Current string
+	//Previous is the secret which was in use before Current. Notifications signed with it are accepted until every
+	//subscription has been recreated with Current.
+	Previous string
+	//CreatedAt is when Current was generated
+	CreatedAt time.Time
+	//Applied is set once every subscription has been recreated using Current
+	Applied bool
+}
+
+//WebhookSecretStore persists the eventsub webhook secret, so that notifications for subscriptions created before a
+//restart can still be verified
+type WebhookSecretStore interface {
+	LoadTwitchWebhookSecret() (*WebhookSecret, error)
+	SaveTwitchWebhookSecret(*WebhookSecret) error
+}
+
+//generateWebhookSecret creates a new random secret. Twitch accepts secrets of between 10 and 100 characters.
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+//loadWebhookSecret loads the persisted webhook secret, generating and saving a new one if there isn't one yet
+func (t *EventSource) loadWebhookSecret() error {
+	secret, err := t.handler.LoadTwitchWebhookSecret()
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Current == "" {
+		logrus.Infof("No twitch webhook secret has been saved yet, so a new one will be generated")
+		current, err := generateWebhookSecret()
+		if err != nil {
+			return err
+		}
+		//Any existing subscriptions were created without a known secret, so they all need recreating
+		secret = &WebhookSecret{
+			Current:   current,
+			CreatedAt: time.Now(),
+		}
+		err = t.handler.SaveTwitchWebhookSecret(secret)
+		if err != nil {
+			return err
+		}
+	}
+	t.setWebhookSecret(secret)
+	return nil
+}
+
+//setWebhookSecret starts using the provided secret to create and verify subscriptions
+func (t *EventSource) setWebhookSecret(secret *WebhookSecret) {
+	t.webhookSecretLock.Lock()
+	t.webhookSecret = *secret
+	t.webhookSecretLock.Unlock()
+	if secret.Applied {
+		t.verifier.setSecrets(secret.Current)
+	} else {
+		t.verifier.setSecrets(secret.Current, secret.Previous)
+	}
+}
+
+//currentWebhookSecret returns the secret which new subscriptions should be created with
+func (t *EventSource) currentWebhookSecret() string {
+	t.webhookSecretLock.Lock()
+	defer t.webhookSecretLock.Unlock()
+	return t.webhookSecret.Current
+}
+
+//manageWebhookSecret moves subscriptions over to the current webhook secret if they haven't been already, and rotates
+//the secret once it is older than rotateAfter. Rotation is disabled if rotateAfter is zero.
+func (t *EventSource) manageWebhookSecret(rotateAfter time.Duration) {
+	ticker := time.NewTicker(webhookSecretCheckInterval)
+	defer ticker.Stop()
+	for {
+		t.webhookSecretLock.Lock()
+		secret := t.webhookSecret
+		t.webhookSecretLock.Unlock()
+		if secret.Applied && rotateAfter > 0 && time.Since(secret.CreatedAt) > rotateAfter {
+			err := t.RotateWebhookSecret()
+			if err != nil {
+				logrus.Errorf("Failed to rotate twitch webhook secret due to error %v", err)
+			}
+		} else if !secret.Applied {
+			t.applyWebhookSecret()
+		}
+		select {
+		case <-t.monitor.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//RotateWebhookSecret generates a new webhook secret and recreates every subscription to use it. Notifications signed
+//with the old secret are still accepted until every subscription has been recreated.
+func (t *EventSource) RotateWebhookSecret() error {
+	current, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	t.webhookSecretLock.Lock()
+	secret := &WebhookSecret{
+		Current:   current,
+		Previous:  t.webhookSecret.Current,
+		CreatedAt: time.Now(),
+	}
+	t.webhookSecretLock.Unlock()
+	//Save the new secret before using it, so that it isn't lost if the bot restarts part way through
+	err = t.handler.SaveTwitchWebhookSecret(secret)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Rotating twitch webhook secret")
+	t.setWebhookSecret(secret)
+	t.applyWebhookSecret()
+	return nil
+}
+
+//applyWebhookSecret recreates every subscription using the current webhook secret. Once they have all been
+//recreated, the previous secret is forgotten.
+func (t *EventSource) applyWebhookSecret() {
+	failed := t.recreateSubscriptions()
+	if failed > 0 {
+		logrus.Warnf("Failed to recreate %d twitch eventsub subscription(s) with the new webhook secret; will try again later", failed)
+		return
+	}
+	t.webhookSecretLock.Lock()
+	t.webhookSecret.Previous = ""
+	t.webhookSecret.Applied = true
+	secret := t.webhookSecret
+	t.webhookSecretLock.Unlock()
+	err := t.handler.SaveTwitchWebhookSecret(&secret)
+	if err != nil {
+		//The subscriptions will just be recreated again after a restart
+		logrus.Warnf("Failed to save twitch webhook secret after recreating subscriptions due to error %v", err)
+	}
+	t.verifier.setSecrets(secret.Current)
+	logrus.Infof("Every twitch eventsub subscription now uses the current webhook secret")
+}
+
+//recreateSubscriptions replaces each subscription with a newly created one, returning the number which could not be
+//replaced. New subscriptions are created before the old ones are deleted so that no notifications are missed.
+func (t *EventSource) recreateSubscriptions() int {
+	t.subscriptionsLock.Lock()
+	defer t.subscriptionsLock.Unlock()
+	failed := 0
+	for uid, sub := range t.liveSubscriptions {
+		condition := map[string]string{"broadcaster_user_id": uid}
+		onlineSub, err := t.transport.createSubscription("stream.online", condition)
+		if err != nil {
+			logrus.Errorf("Failed to recreate stream.online subscription for twitch UID %v due to error %v", uid, err)
+			failed++
+			continue
+		}
+		offlineSub, err := t.transport.createSubscription("stream.offline", condition)
+		if err != nil {
+			logrus.Errorf("Failed to recreate stream.offline subscription for twitch UID %v due to error %v", uid, err)
+			t.transport.deleteSubscription(onlineSub)
+			failed++
+			continue
+		}
+		t.liveSubscriptions[uid] = subscription{
+			StreamOnlineSub:  onlineSub,
+			StreamOfflineSub: offlineSub,
+		}
+		for _, id := range []string{sub.StreamOnlineSub, sub.StreamOfflineSub} {
+			err := t.transport.deleteSubscription(id)
+			if err != nil {
+				logrus.Warnf("Failed to delete replaced subscription %v due to error %v", id, err)
+			}
+		}
+	}
+	for uid, subs := range t.communitySubscriptions {
+		for subType, oldID := range subs {
+			ev, _ := communityEventByType(subType)
+			id, err := t.transport.createSubscription(subType, ev.condition(uid))
+			if err != nil {
+				logrus.Errorf("Failed to recreate %v subscription for twitch UID %v due to error %v", subType, uid, err)
+				failed++
+				continue
+			}
+			subs[subType] = id
+			err = t.transport.deleteSubscription(oldID)
+			if err != nil {
+				logrus.Warnf("Failed to delete replaced subscription %v due to error %v", oldID, err)
+			}
+		}
+	}
+	return failed
+}
@@ -0,0 +1,180 @@
+package twitch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	eventsubMessageIDHeader        = "Twitch-Eventsub-Message-Id"
+	eventsubMessageTimestampHeader = "Twitch-Eventsub-Message-Timestamp"
+	eventsubMessageSignatureHeader = "Twitch-Eventsub-Message-Signature"
+	//webhookMaxMessageAge is how old a notification can be before it is rejected. Message IDs are remembered for this
+	//long, so replays of older messages are caught by their timestamp instead.
+	webhookMaxMessageAge = 10 * time.Minute
+	//webhookMaxBodySize limits how much of a request is read before its signature is checked
+	webhookMaxBodySize = 1 << 20
+)
+
+var (
+	errWebhookMissingHeaders = errors.New("missing eventsub message headers")
+	errWebhookBadTimestamp   = errors.New("message timestamp is invalid or too old")
+	errWebhookBadSignature   = errors.New("message signature does not match")
+	errWebhookReplayed       = errors.New("message has already been received")
+)
+
+var webhookRejections = expvar.NewMap("twitch_webhook_rejected_messages")
+
+//webhookVerifier checks the HMAC signature of every eventsub webhook request before passing it on, and drops any
+//which are too old or have been seen before
+type webhookVerifier struct {
+	//If permissive is set, requests which fail verification are logged but still passed on
+	permissive bool
+
+	secretsLock sync.RWMutex
+	//secrets contains every secret which subscriptions may currently have been created with, current secret first
+	secrets []string
+
+	seenLock sync.Mutex
+	//seen maps the IDs of recently received messages to when they were received
+	seen map[string]time.Time
+}
+
+func newWebhookVerifier(permissive bool) *webhookVerifier {
+	if permissive {
+		logrus.Warnf("`%v` is set, so twitch webhook requests which fail signature verification will still be accepted. This should only be used for testing.", webhookPermissiveEnvVar)
+	}
+	return &webhookVerifier{
+		permissive: permissive,
+		seen:       make(map[string]time.Time),
+	}
+}
+
+//setSecrets replaces the secrets which signatures are checked against. Empty secrets are ignored.
+func (v *webhookVerifier) setSecrets(secrets ...string) {
+	v.secretsLock.Lock()
+	defer v.secretsLock.Unlock()
+	v.secrets = v.secrets[:0]
+	for _, secret := range secrets {
+		if secret != "" {
+			v.secrets = append(v.secrets, secret)
+		}
+	}
+}
+
+//wrap returns a handler which verifies requests before passing them on to next
+func (v *webhookVerifier) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodySize))
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		err = v.verify(r.Header, body, time.Now())
+		switch {
+		case err == errWebhookReplayed:
+			//Twitch retries notifications it doesn't think were delivered, so this isn't necessarily an attack. Either
+			//way, the message has already been handled.
+			webhookRejections.Add("replayed", 1)
+			logrus.Debugf("Dropping twitch webhook message %v as it has already been received", r.Header.Get(eventsubMessageIDHeader))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case err != nil && v.permissive:
+			logrus.Warnf("Accepting twitch webhook request from %v despite verification failing due to error %v", r.RemoteAddr, err)
+		case err != nil:
+			webhookRejections.Add(rejectionReason(err), 1)
+			logrus.Warnf("Rejected twitch webhook request from %v due to error %v", r.RemoteAddr, err)
+			http.Error(w, "invalid eventsub message", http.StatusForbidden)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+//verify checks a request's signature against each of the current secrets, then checks that it is recent and hasn't
+//been seen before
+func (v *webhookVerifier) verify(header http.Header, body []byte, now time.Time) error {
+	id := header.Get(eventsubMessageIDHeader)
+	timestamp := header.Get(eventsubMessageTimestampHeader)
+	signature := header.Get(eventsubMessageSignatureHeader)
+	if id == "" || timestamp == "" || signature == "" {
+		return errWebhookMissingHeaders
+	}
+	if !v.validSignature(id, timestamp, signature, body) {
+		return errWebhookBadSignature
+	}
+	sent, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return errWebhookBadTimestamp
+	}
+	age := now.Sub(sent)
+	if age > webhookMaxMessageAge || age < -webhookMaxMessageAge {
+		return errWebhookBadTimestamp
+	}
+	return v.markSeen(id, now)
+}
+
+func (v *webhookVerifier) validSignature(id, timestamp, signature string, body []byte) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	v.secretsLock.RLock()
+	defer v.secretsLock.RUnlock()
+	for _, secret := range v.secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(id))
+		mac.Write([]byte(timestamp))
+		mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return true
+		}
+	}
+	return false
+}
+
+//markSeen records that a message has been received, returning errWebhookReplayed if it already had been. Messages
+//older than webhookMaxMessageAge are forgotten, as they will be rejected based on their timestamp anyway.
+func (v *webhookVerifier) markSeen(id string, now time.Time) error {
+	v.seenLock.Lock()
+	defer v.seenLock.Unlock()
+	if _, seen := v.seen[id]; seen {
+		return errWebhookReplayed
+	}
+	for seenID, received := range v.seen {
+		if now.Sub(received) > 2*webhookMaxMessageAge {
+			delete(v.seen, seenID)
+		}
+	}
+	v.seen[id] = now
+	return nil
+}
+
+func rejectionReason(err error) string {
+	switch err {
+	case errWebhookMissingHeaders:
+		return "missing_headers"
+	case errWebhookBadTimestamp:
+		return "bad_timestamp"
+	case errWebhookBadSignature:
+		return "bad_signature"
+	default:
+		return "other"
+	}
+}
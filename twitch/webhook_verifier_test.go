@@ -0,0 +1,225 @@
+package twitch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testCurrentSecret  = "current-secret-0123456789"
+	testPreviousSecret = "previous-secret-0123456789"
+	testMessageBody    = `{"subscription":{"type":"stream.online"},"event":{"broadcaster_user_id":"1001"}}`
+)
+
+//signWebhook returns the signature twitch would send for a message signed with the provided secret
+func signWebhook(secret, id, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//webhookHeader builds the eventsub headers for a message sent at the provided time and signed with secret
+func webhookHeader(id string, sent time.Time, secret, body string) http.Header {
+	timestamp := sent.Format(time.RFC3339Nano)
+	header := http.Header{}
+	header.Set(eventsubMessageIDHeader, id)
+	header.Set(eventsubMessageTimestampHeader, timestamp)
+	header.Set(eventsubMessageSignatureHeader, signWebhook(secret, id, timestamp, body))
+	return header
+}
+
+func TestWebhookVerify(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		secrets []string
+		header  http.Header
+		body    string
+		want    error
+	}{
+		{
+			name:    "ValidSignature",
+			secrets: []string{testCurrentSecret},
+			header:  webhookHeader("msg-1", now, testCurrentSecret, testMessageBody),
+			body:    testMessageBody,
+		},
+		{
+			name:    "BadSignature",
+			secrets: []string{testCurrentSecret},
+			header:  webhookHeader("msg-1", now, "not-the-secret-0123456789", testMessageBody),
+			body:    testMessageBody,
+			want:    errWebhookBadSignature,
+		},
+		{
+			name:    "ModifiedBody",
+			secrets: []string{testCurrentSecret},
+			header:  webhookHeader("msg-1", now, testCurrentSecret, testMessageBody),
+			body:    strings.Replace(testMessageBody, "1001", "1002", 1),
+			want:    errWebhookBadSignature,
+		},
+		{
+			name:    "MalformedSignature",
+			secrets: []string{testCurrentSecret},
+			header: func() http.Header {
+				h := webhookHeader("msg-1", now, testCurrentSecret, testMessageBody)
+				h.Set(eventsubMessageSignatureHeader, strings.TrimPrefix(h.Get(eventsubMessageSignatureHeader), "sha256="))
+				return h
+			}(),
+			body: testMessageBody,
+			want: errWebhookBadSignature,
+		},
+		{
+			name:    "MissingMessageID",
+			secrets: []string{testCurrentSecret},
+			header: func() http.Header {
+				h := webhookHeader("msg-1", now, testCurrentSecret, testMessageBody)
+				h.Del(eventsubMessageIDHeader)
+				return h
+			}(),
+			body: testMessageBody,
+			want: errWebhookMissingHeaders,
+		},
+		{
+			name:    "MissingTimestamp",
+			secrets: []string{testCurrentSecret},
+			header: func() http.Header {
+				h := webhookHeader("msg-1", now, testCurrentSecret, testMessageBody)
+				h.Del(eventsubMessageTimestampHeader)
+				return h
+			}(),
+			body: testMessageBody,
+			want: errWebhookMissingHeaders,
+		},
+		{
+			name:    "MissingSignature",
+			secrets: []string{testCurrentSecret},
+			header: func() http.Header {
+				h := webhookHeader("msg-1", now, testCurrentSecret, testMessageBody)
+				h.Del(eventsubMessageSignatureHeader)
+				return h
+			}(),
+			body: testMessageBody,
+			want: errWebhookMissingHeaders,
+		},
+		{
+			name:    "StaleTimestamp",
+			secrets: []string{testCurrentSecret},
+			header:  webhookHeader("msg-1", now.Add(-webhookMaxMessageAge-time.Second), testCurrentSecret, testMessageBody),
+			body:    testMessageBody,
+			want:    errWebhookBadTimestamp,
+		},
+		{
+			name:    "FutureTimestamp",
+			secrets: []string{testCurrentSecret},
+			header:  webhookHeader("msg-1", now.Add(webhookMaxMessageAge+time.Second), testCurrentSecret, testMessageBody),
+			body:    testMessageBody,
+			want:    errWebhookBadTimestamp,
+		},
+		{
+			name:    "PreviousSecretDuringRotation",
+			secrets: []string{testCurrentSecret, testPreviousSecret},
+			header:  webhookHeader("msg-1", now, testPreviousSecret, testMessageBody),
+			body:    testMessageBody,
+		},
+		{
+			name:    "PreviousSecretAfterRotation",
+			secrets: []string{testCurrentSecret},
+			header:  webhookHeader("msg-1", now, testPreviousSecret, testMessageBody),
+			body:    testMessageBody,
+			want:    errWebhookBadSignature,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := newWebhookVerifier(false)
+			v.setSecrets(test.secrets...)
+			err := v.verify(test.header, []byte(test.body), now)
+			if err != test.want {
+				t.Errorf("expected error %v, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestWebhookVerifyReplay(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	v := newWebhookVerifier(false)
+	v.setSecrets(testCurrentSecret)
+	header := webhookHeader("msg-1", now, testCurrentSecret, testMessageBody)
+	err := v.verify(header, []byte(testMessageBody), now)
+	if err != nil {
+		t.Fatalf("first delivery of message was rejected: %v", err)
+	}
+	err = v.verify(header, []byte(testMessageBody), now.Add(time.Minute))
+	if err != errWebhookReplayed {
+		t.Errorf("expected replayed message to be rejected with %v, got %v", errWebhookReplayed, err)
+	}
+	//Messages with other IDs are still accepted
+	err = v.verify(webhookHeader("msg-2", now, testCurrentSecret, testMessageBody), []byte(testMessageBody), now.Add(time.Minute))
+	if err != nil {
+		t.Errorf("message with a new ID was rejected: %v", err)
+	}
+	//Once a message ID has been forgotten, replays are rejected by their timestamp instead
+	err = v.verify(header, []byte(testMessageBody), now.Add(3*webhookMaxMessageAge))
+	if err != errWebhookBadTimestamp {
+		t.Errorf("expected old replayed message to be rejected with %v, got %v", errWebhookBadTimestamp, err)
+	}
+}
+
+func TestWebhookVerifierHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		permissive bool
+		secret     string
+		replay     bool
+		wantStatus int
+		wantPassed bool
+	}{
+		{name: "Valid", secret: testCurrentSecret, wantStatus: http.StatusOK, wantPassed: true},
+		{name: "Invalid", secret: testPreviousSecret, wantStatus: http.StatusForbidden},
+		{name: "Replayed", secret: testCurrentSecret, replay: true, wantStatus: http.StatusNoContent},
+		{name: "InvalidPermissive", permissive: true, secret: testPreviousSecret, wantStatus: http.StatusOK, wantPassed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := newWebhookVerifier(test.permissive)
+			v.setSecrets(testCurrentSecret)
+			var passed string
+			handler := v.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				passed = string(body)
+			}))
+			send := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(testMessageBody))
+				for k, v := range webhookHeader("msg-1", time.Now(), test.secret, testMessageBody) {
+					req.Header[k] = v
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+			rec := send()
+			if test.replay {
+				passed = ""
+				rec = send()
+			}
+			if rec.Code != test.wantStatus {
+				t.Errorf("expected status %v, got %v", test.wantStatus, rec.Code)
+			}
+			if test.wantPassed && passed != testMessageBody {
+				t.Errorf("expected request body %q to be passed on, got %q", testMessageBody, passed)
+			} else if !test.wantPassed && passed != "" {
+				t.Errorf("request was passed on despite failing verification")
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	websocketWelcomeTimeout   = 10 * time.Second
+	websocketDefaultKeepalive = 10 * time.Second
+	websocketMaxBackoff       = 2 * time.Minute
+	//websocketSeenMessageCount is the number of notification message IDs remembered in order to drop duplicates
+	websocketSeenMessageCount = 256
+)
+
+//websocketKeepaliveGrace is added to the keepalive timeout given by twitch to allow for network latency. Tests shorten
+//it so that they don't have to wait as long for a silent connection to time out.
+var websocketKeepaliveGrace = 5 * time.Second
+
+type websocketMessage struct {
+	Metadata struct {
+		MessageID        string    `json:"message_id"`
+		MessageType      string    `json:"message_type"`
+		MessageTimestamp time.Time `json:"message_timestamp"`
+		SubscriptionType string    `json:"subscription_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session      *websocketSession     `json:"session"`
+		Subscription *eventsubSubscription `json:"subscription"`
+		Event        json.RawMessage       `json:"event"`
+	} `json:"payload"`
+}
+
+type websocketSession struct {
+	ID                      string `json:"id"`
+	Status                  string `json:"status"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+//websocketTransport receives notifications over an eventsub websocket session. Subscriptions are managed through the
+//helix API using the session ID, and are recreated whenever the session is lost.
+type websocketTransport struct {
+	url            string
+	api            *helixClient
+	onNotification func(*eventsubSubscription, json.RawMessage)
+	//onSessionLost is called after a new session has been established to replace one which dropped without
+	//twitch asking us to reconnect, in which case all previous subscriptions will have been disabled.
+	onSessionLost func()
+	seen          *recentMessageIDs
+
+	lock      sync.Mutex
+	conn      *websocket.Conn
+	sessionID string
+	keepalive time.Duration
+	closed    bool
+}
+
+//startWebsocketTransport connects to the eventsub websocket server at the provided URL, returning once a session has
+//been established
+func startWebsocketTransport(url string, api *helixClient, onNotification func(*eventsubSubscription, json.RawMessage), onSessionLost func()) (*websocketTransport, error) {
+	w := &websocketTransport{
+		url:            url,
+		api:            api,
+		onNotification: onNotification,
+		onSessionLost:  onSessionLost,
+		seen:           newRecentMessageIDs(websocketSeenMessageCount),
+	}
+	conn, session, err := w.dial(url)
+	if err != nil {
+		return nil, err
+	}
+	w.setSession(conn, session)
+	go w.readLoop(conn)
+	return w, nil
+}
+
+func (w *websocketTransport) createSubscription(subType string, condition map[string]string) (string, error) {
+	w.lock.Lock()
+	sessionID := w.sessionID
+	w.lock.Unlock()
+	sub, err := w.api.createEventsubSubscription(createEventsubSubscriptionRequest{
+		Type:      subType,
+		Version:   subscriptionVersion(subType),
+		Condition: condition,
+		Transport: eventsubTransportRequest{
+			Method:    "websocket",
+			SessionID: sessionID,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return sub.ID, nil
+}
+
+func (w *websocketTransport) deleteSubscription(id string) error {
+	return w.api.deleteEventsubSubscription(id)
+}
+
+func (w *websocketTransport) subscriptions() ([]subscriptionInfo, error) {
+	return listSubscriptions(w.api)
+}
+
+func (w *websocketTransport) clearSubscriptions() error {
+	return clearSubscriptions(w.api)
+}
+
+func (w *websocketTransport) close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
+
+//dial opens a new websocket connection and waits for the session welcome message
+func (w *websocketTransport) dial(url string) (*websocket.Conn, *websocketSession, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(websocketWelcomeTimeout))
+	var msg websocketMessage
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to read welcome message from eventsub websocket due to error %v", err)
+	}
+	if msg.Metadata.MessageType != "session_welcome" || msg.Payload.Session == nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("expected welcome message from eventsub websocket but got %v", msg.Metadata.MessageType)
+	}
+	logrus.Infof("Connected to twitch eventsub websocket with session ID %v", msg.Payload.Session.ID)
+	return conn, msg.Payload.Session, nil
+}
+
+//setSession makes the provided connection the current one. Returns false (and closes the connection) if the
+//transport has already been closed.
+func (w *websocketTransport) setSession(conn *websocket.Conn, session *websocketSession) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		conn.Close()
+		return false
+	}
+	w.conn = conn
+	w.sessionID = session.ID
+	w.keepalive = time.Duration(session.KeepaliveTimeoutSeconds) * time.Second
+	if w.keepalive <= 0 {
+		w.keepalive = websocketDefaultKeepalive
+	}
+	return true
+}
+
+//isCurrent returns true if conn is the active connection and the transport has not been closed
+func (w *websocketTransport) isCurrent(conn *websocket.Conn) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return !w.closed && w.conn == conn
+}
+
+//readLoop handles messages from a connection until it is closed or replaced
+func (w *websocketTransport) readLoop(conn *websocket.Conn) {
+	for {
+		w.lock.Lock()
+		timeout := w.keepalive + websocketKeepaliveGrace
+		w.lock.Unlock()
+		//Twitch sends a keepalive whenever nothing else has been sent within the timeout, so silence for longer
+		//than that means the connection has died
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			if w.isCurrent(conn) {
+				logrus.Warnf("Lost connection to twitch eventsub websocket due to error %v. Reconnecting...", err)
+				w.reconnect()
+			}
+			return
+		}
+		var msg websocketMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			logrus.Errorf("Failed to decode eventsub websocket message %v due to error %v", string(data), err)
+			continue
+		}
+		switch msg.Metadata.MessageType {
+		case "session_keepalive":
+			logrus.Tracef("Got eventsub websocket keepalive")
+		case "notification":
+			if !w.seen.add(msg.Metadata.MessageID) {
+				logrus.Debugf("Ignoring duplicate eventsub notification %v", msg.Metadata.MessageID)
+				continue
+			}
+			if msg.Payload.Subscription == nil {
+				logrus.Warnf("Got eventsub notification %v without any subscription details", msg.Metadata.MessageID)
+				continue
+			}
+			w.onNotification(msg.Payload.Subscription, msg.Payload.Event)
+		case "session_reconnect":
+			if msg.Payload.Session == nil || msg.Payload.Session.ReconnectURL == "" {
+				logrus.Warnf("Got eventsub websocket reconnect message without a reconnect URL")
+				continue
+			}
+			logrus.Infof("Twitch requested that the eventsub websocket reconnects to %v", msg.Payload.Session.ReconnectURL)
+			if w.migrate(conn, msg.Payload.Session.ReconnectURL) {
+				return
+			}
+		case "revocation":
+			if msg.Payload.Subscription != nil {
+				logrus.Warnf("Twitch revoked eventsub subscription %v with status %v", msg.Payload.Subscription.ID, msg.Payload.Subscription.Status)
+			}
+		default:
+			logrus.Debugf("Ignoring eventsub websocket message with unknown type %v", msg.Metadata.MessageType)
+		}
+	}
+}
+
+//migrate moves to a new connection at the URL provided in a session_reconnect message. Subscriptions carry over to
+//the new connection, so they do not need to be recreated. Returns false if the new connection could not be made, in
+//which case the old one should keep being used until twitch closes it.
+func (w *websocketTransport) migrate(old *websocket.Conn, reconnectURL string) bool {
+	conn, session, err := w.dial(reconnectURL)
+	if err != nil {
+		logrus.Errorf("Failed to reconnect to eventsub websocket at %v due to error %v", reconnectURL, err)
+		return false
+	}
+	if w.setSession(conn, session) {
+		go w.readLoop(conn)
+	}
+	old.Close()
+	return true
+}
+
+//reconnect starts a new session after the previous connection was lost, retrying with exponential backoff
+func (w *websocketTransport) reconnect() {
+	backoff := time.Second
+	for {
+		conn, session, err := w.dial(w.url)
+		if err == nil {
+			if w.setSession(conn, session) {
+				go w.readLoop(conn)
+				w.onSessionLost()
+			}
+			return
+		}
+		logrus.Errorf("Failed to reconnect to eventsub websocket due to error %v. Retrying in %v", err, backoff)
+		time.Sleep(backoff)
+		w.lock.Lock()
+		closed := w.closed
+		w.lock.Unlock()
+		if closed {
+			return
+		}
+		backoff *= 2
+		if backoff > websocketMaxBackoff {
+			backoff = websocketMaxBackoff
+		}
+	}
+}
+
+//recentMessageIDs remembers a fixed number of the most recently seen message IDs
+type recentMessageIDs struct {
+	lock  sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	next  int
+}
+
+func newRecentMessageIDs(size int) *recentMessageIDs {
+	return &recentMessageIDs{
+		ids:   make(map[string]struct{}, size),
+		order: make([]string, size),
+	}
+}
+
+//add records a message ID, returning false if it was already present
+func (r *recentMessageIDs) add(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, exists := r.ids[id]; exists {
+		return false
+	}
+	delete(r.ids, r.order[r.next])
+	r.order[r.next] = id
+	r.ids[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.order)
+	return true
+}
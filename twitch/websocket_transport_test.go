@@ -0,0 +1,246 @@
+package twitch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/streaming"
+	"github.com/callummance/nia/twitch/mockeventsub"
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	//Don't wait as long for silent connections to time out
+	websocketKeepaliveGrace = 500 * time.Millisecond
+}
+
+//startWebsocketEventSource creates an EventSource which receives notifications from the mock server over the
+//websocket transport
+func startWebsocketEventSource(t *testing.T, srv *httptest.Server, h *fakeHandler, communityEvents ...string) *EventSource {
+	source := newTestEventSource(t, srv, h)
+	source.communityEvents = communityEvents
+	transport, err := startWebsocketTransport(testConfig(srv).websocketURL, source.helix, source.dispatchNotification, source.resubscribeAll)
+	if err != nil {
+		t.Fatalf("failed to connect to mock eventsub server: %v", err)
+	}
+	source.transport = transport
+	t.Cleanup(func() {
+		transport.close()
+	})
+	return source
+}
+
+//sessionID returns the ID of the transport's current session
+func sessionID(source *EventSource) string {
+	w := source.transport.(*websocketTransport)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.sessionID
+}
+
+//waitFor polls until cond returns true, failing the test if it doesn't within testEventTimeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testEventTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//subscriptionIDs returns the sorted IDs of every subscription on the mock server, failing the test if any of them
+//aren't enabled and attached to the provided session
+func subscriptionIDs(t *testing.T, mock *mockeventsub.Server, session string) []string {
+	t.Helper()
+	var ids []string
+	for _, sub := range mock.Subscriptions() {
+		if sub.Status != "enabled" || sub.Transport.SessionID != session {
+			t.Errorf("expected %v subscription %v to be enabled on session %v, but it is %v on session %v", sub.Type, sub.ID, session, sub.Status, sub.Transport.SessionID)
+		}
+		ids = append(ids, sub.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func expectOnline(t *testing.T, h *fakeHandler, uid string) {
+	t.Helper()
+	ev, ok := h.next(t).(*streaming.OnlineEvent)
+	if !ok || ev.ChannelID != uid {
+		t.Fatalf("expected stream online event for %v, got %#v", uid, ev)
+	}
+}
+
+func expectOffline(t *testing.T, h *fakeHandler, uid string) {
+	t.Helper()
+	ev, ok := h.next(t).(*streaming.OfflineEvent)
+	if !ok || ev.ChannelID != uid {
+		t.Fatalf("expected stream offline event for %v, got %#v", uid, ev)
+	}
+}
+
+func TestWebsocketWelcome(t *testing.T) {
+	mock, srv := startMockTwitch(t, time.Minute)
+	w, err := startWebsocketTransport(testConfig(srv).websocketURL+"?keepalive_timeout_seconds=30", newHelixClient(testConfig(srv)), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to connect to mock eventsub server: %v", err)
+	}
+	defer w.close()
+	if w.sessionID == "" {
+		t.Errorf("session ID from welcome message was not saved")
+	}
+	if w.keepalive != 30*time.Second {
+		t.Errorf("expected keepalive timeout of 30s from welcome message, got %v", w.keepalive)
+	}
+
+	_, err = w.createSubscription("stream.online", map[string]string{"broadcaster_user_id": "1001"})
+	if err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	subscriptionIDs(t, mock, w.sessionID)
+}
+
+func TestWebsocketWelcomeMissing(t *testing.T) {
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(map[string]interface{}{
+			"metadata": map[string]interface{}{"message_id": "1", "message_type": "session_keepalive"},
+			"payload":  map[string]interface{}{},
+		})
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+	_, err := startWebsocketTransport("ws"+strings.TrimPrefix(srv.URL, "http"), nil, nil, nil)
+	if err == nil {
+		t.Errorf("expected connecting to fail without a welcome message")
+	}
+}
+
+func TestWebsocketNotifications(t *testing.T) {
+	mock, srv := startMockTwitch(t, time.Minute)
+	h := newFakeHandler()
+	source := startWebsocketEventSource(t, srv, h, "channel.raid")
+	streamer := mock.AddUser("streamer")
+	err := source.Subscribe(streamer.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	mock.GoLive("streamer", mockeventsub.Stream{Title: "test stream"})
+	expectOnline(t, h, streamer.ID)
+	mock.Raid("streamer", "target", 12)
+	if raid, ok := h.next(t).(*RaidEvent); !ok || raid.FromUID != streamer.ID || raid.Viewers != 12 {
+		t.Errorf("expected raid from %v with 12 viewers, got %#v", streamer.ID, raid)
+	}
+	mock.GoOffline("streamer")
+	expectOffline(t, h, streamer.ID)
+}
+
+func TestWebsocketKeepaliveTimeout(t *testing.T) {
+	mock, srv := startMockTwitch(t, time.Second)
+	h := newFakeHandler()
+	source := startWebsocketEventSource(t, srv, h, "channel.raid")
+	streamer := mock.AddUser("streamer")
+	err := source.Subscribe(streamer.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	oldSession := sessionID(source)
+	oldIDs := subscriptionIDs(t, mock, oldSession)
+
+	mock.StallConnections()
+	//Once the new session has been set up, the state of each stream is checked in case anything was missed
+	expectOffline(t, h, streamer.ID)
+	newSession := sessionID(source)
+	if newSession == oldSession {
+		t.Fatalf("expected a new session after the keepalive timeout")
+	}
+	newIDs := subscriptionIDs(t, mock, newSession)
+	if len(newIDs) != len(oldIDs) {
+		t.Errorf("expected the %d old subscriptions to be replaced, but there are now %v", len(oldIDs), newIDs)
+	}
+	for _, id := range oldIDs {
+		for _, newID := range newIDs {
+			if id == newID {
+				t.Errorf("subscription %v from the lost session was not replaced", id)
+			}
+		}
+	}
+
+	mock.GoLive("streamer", mockeventsub.Stream{})
+	expectOnline(t, h, streamer.ID)
+}
+
+func TestWebsocketReconnect(t *testing.T) {
+	mock, srv := startMockTwitch(t, time.Minute)
+	h := newFakeHandler()
+	source := startWebsocketEventSource(t, srv, h, "channel.raid")
+	streamer := mock.AddUser("streamer")
+	err := source.Subscribe(streamer.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	oldSession := sessionID(source)
+	oldIDs := subscriptionIDs(t, mock, oldSession)
+
+	mock.RequestReconnect()
+	waitFor(t, "the transport to move to a new session", func() bool {
+		return sessionID(source) != oldSession
+	})
+	//The subscriptions should have moved over to the new session rather than being recreated
+	newIDs := subscriptionIDs(t, mock, sessionID(source))
+	if strings.Join(newIDs, ",") != strings.Join(oldIDs, ",") {
+		t.Errorf("expected subscriptions %v to carry over to the new session, got %v", oldIDs, newIDs)
+	}
+	h.expectNone(t, 200*time.Millisecond)
+
+	mock.GoLive("streamer", mockeventsub.Stream{})
+	expectOnline(t, h, streamer.ID)
+	mock.Raid("streamer", "target", 1)
+	if _, ok := h.next(t).(*RaidEvent); !ok {
+		t.Errorf("expected raid event after reconnecting")
+	}
+}
+
+func TestWebsocketRevocation(t *testing.T) {
+	mock, srv := startMockTwitch(t, time.Minute)
+	h := newFakeHandler()
+	source := startWebsocketEventSource(t, srv, h)
+	streamer := mock.AddUser("streamer")
+	err := source.Subscribe(streamer.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	session := sessionID(source)
+	source.subscriptionsLock.Lock()
+	onlineSub := source.liveSubscriptions[streamer.ID].StreamOnlineSub
+	source.subscriptionsLock.Unlock()
+
+	if !mock.Revoke(onlineSub, "authorization_revoked") {
+		t.Fatalf("mock server doesn't know about subscription %v", onlineSub)
+	}
+	//The session should be unaffected, with only the revoked subscription being lost
+	mock.GoLive("streamer", mockeventsub.Stream{})
+	mock.GoOffline("streamer")
+	expectOffline(t, h, streamer.ID)
+	if sessionID(source) != session {
+		t.Errorf("revocation caused the transport to change session")
+	}
+
+	//The subscription monitor should notice the revoked subscription and recreate it
+	source.checkSubscriptions()
+	subscriptionIDs(t, mock, session)
+	mock.GoLive("streamer", mockeventsub.Stream{})
+	expectOnline(t, h, streamer.ID)
+}
@@ -0,0 +1,274 @@
+package youtube
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiRequestTimeout = 15 * time.Second
+
+//The videos endpoint accepts at most 50 IDs per request
+const maxVideosPerRequest = 50
+
+//dataAPI is a minimal client for the parts of the YouTube Data API and the public channel feeds which nia uses
+type dataAPI struct {
+	apiURL     string
+	feedURL    string
+	apiKey     string
+	httpClient *http.Client
+
+	categoriesLock sync.Mutex
+	categories     map[string]string
+}
+
+//apiError is returned when the YouTube API responds with a non-success status code
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("youtube API returned status %d: %v", e.StatusCode, e.Message)
+}
+
+type ytThumbnail struct {
+	URL string `json:"url"`
+}
+
+type ytThumbnails struct {
+	Default *ytThumbnail `json:"default"`
+	High    *ytThumbnail `json:"high"`
+	Maxres  *ytThumbnail `json:"maxres"`
+}
+
+//largest returns the URL of the largest thumbnail available
+func (t ytThumbnails) largest() string {
+	for _, thumb := range []*ytThumbnail{t.Maxres, t.High, t.Default} {
+		if thumb != nil {
+			return thumb.URL
+		}
+	}
+	return ""
+}
+
+type ytChannel struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title      string       `json:"title"`
+		CustomURL  string       `json:"customUrl"`
+		Thumbnails ytThumbnails `json:"thumbnails"`
+	} `json:"snippet"`
+}
+
+type ytVideo struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		ChannelID            string       `json:"channelId"`
+		ChannelTitle         string       `json:"channelTitle"`
+		Title                string       `json:"title"`
+		CategoryID           string       `json:"categoryId"`
+		LiveBroadcastContent string       `json:"liveBroadcastContent"`
+		Thumbnails           ytThumbnails `json:"thumbnails"`
+	} `json:"snippet"`
+	ContentDetails struct {
+		ContentRating struct {
+			YtRating string `json:"ytRating"`
+		} `json:"contentRating"`
+	} `json:"contentDetails"`
+	LiveStreamingDetails *struct {
+		ActualStartTime   *time.Time `json:"actualStartTime"`
+		ActualEndTime     *time.Time `json:"actualEndTime"`
+		ConcurrentViewers string     `json:"concurrentViewers"`
+	} `json:"liveStreamingDetails"`
+}
+
+//isLive returns true if the video is a broadcast which has started and not yet ended
+func (v *ytVideo) isLive() bool {
+	if v.LiveStreamingDetails == nil {
+		return false
+	}
+	return v.LiveStreamingDetails.ActualStartTime != nil && v.LiveStreamingDetails.ActualEndTime == nil
+}
+
+type ytVideoCategory struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title string `json:"title"`
+	} `json:"snippet"`
+}
+
+//atomFeed is the format of both the public channel feeds and PubSubHubbub notifications
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+	Deleted []struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"deleted-entry"`
+}
+
+type atomEntry struct {
+	VideoID   string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	ChannelID string `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string `xml:"title"`
+}
+
+func newDataAPI(conf *youtubeConfig) *dataAPI {
+	return &dataAPI{
+		apiURL:     strings.TrimRight(conf.apiURL, "/"),
+		feedURL:    conf.feedURL,
+		apiKey:     conf.apiKey,
+		httpClient: &http.Client{Timeout: apiRequestTimeout},
+		categories: make(map[string]string),
+	}
+}
+
+//getChannel looks up a single channel by ID, handle or legacy username. Exactly one of the parameters should be
+//non-empty. Returns nil if no channel matched.
+func (a *dataAPI) getChannel(id, handle, username string) (*ytChannel, error) {
+	query := url.Values{"part": {"snippet"}}
+	switch {
+	case id != "":
+		query.Set("id", id)
+	case handle != "":
+		query.Set("forHandle", handle)
+	default:
+		query.Set("forUsername", username)
+	}
+	var resp struct {
+		Items []ytChannel `json:"items"`
+	}
+	err := a.get("/channels", query, &resp)
+	if err != nil {
+		return nil, err
+	} else if len(resp.Items) == 0 {
+		return nil, nil
+	}
+	return &resp.Items[0], nil
+}
+
+//getVideos retrieves details on each of the provided videos which exist
+func (a *dataAPI) getVideos(videoIDs []string) ([]ytVideo, error) {
+	var res []ytVideo
+	for start := 0; start < len(videoIDs); start += maxVideosPerRequest {
+		end := start + maxVideosPerRequest
+		if end > len(videoIDs) {
+			end = len(videoIDs)
+		}
+		query := url.Values{
+			"part": {"snippet,contentDetails,liveStreamingDetails"},
+			"id":   {strings.Join(videoIDs[start:end], ",")},
+		}
+		var resp struct {
+			Items []ytVideo `json:"items"`
+		}
+		err := a.get("/videos", query, &resp)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resp.Items...)
+	}
+	return res, nil
+}
+
+//categoryName returns the name of a video category, caching the result as they very rarely change
+func (a *dataAPI) categoryName(categoryID string) (string, error) {
+	if categoryID == "" {
+		return "", nil
+	}
+	a.categoriesLock.Lock()
+	name, cached := a.categories[categoryID]
+	a.categoriesLock.Unlock()
+	if cached {
+		return name, nil
+	}
+	var resp struct {
+		Items []ytVideoCategory `json:"items"`
+	}
+	err := a.get("/videoCategories", url.Values{"part": {"snippet"}, "id": {categoryID}}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Items) > 0 {
+		name = resp.Items[0].Snippet.Title
+	}
+	a.categoriesLock.Lock()
+	a.categories[categoryID] = name
+	a.categoriesLock.Unlock()
+	return name, nil
+}
+
+//recentVideoIDs returns the IDs of the most recent videos on a channel, including upcoming and live broadcasts. This
+//uses the public channel feed rather than the search API, as searches use up a large amount of API quota.
+func (a *dataAPI) recentVideoIDs(channelID string) ([]string, error) {
+	feedURL, err := url.Parse(a.feedURL)
+	if err != nil {
+		return nil, err
+	}
+	feedURL.RawQuery = url.Values{"channel_id": {channelID}}.Encode()
+	resp, err := a.httpClient.Get(feedURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &apiError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+	var feed atomFeed
+	err = xml.NewDecoder(resp.Body).Decode(&feed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode feed for channel %v: %v", channelID, err)
+	}
+	res := make([]string, 0, len(feed.Entries))
+	for _, entry := range feed.Entries {
+		res = append(res, entry.VideoID)
+	}
+	return res, nil
+}
+
+func (a *dataAPI) get(path string, query url.Values, out interface{}) error {
+	query.Set("key", a.apiKey)
+	resp, err := a.httpClient.Get(a.apiURL + path + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg := string(body)
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
+			msg = errResp.Error.Message
+		}
+		return &apiError{StatusCode: resp.StatusCode, Message: msg}
+	}
+	return json.Unmarshal(body, out)
+}
+
+//viewerCount parses the concurrent viewer count of a live video, which the API returns as a string
+func viewerCount(v *ytVideo) int {
+	if v.LiveStreamingDetails == nil {
+		return 0
+	}
+	count, err := strconv.Atoi(v.LiveStreamingDetails.ConcurrentViewers)
+	if err != nil {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,90 @@
+package youtube
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	apiKeyEnvVar       = "NIA_YOUTUBE_API_KEY"
+	apiURLEnvVar       = "NIA_YOUTUBE_API_URL"
+	feedURLEnvVar      = "NIA_YOUTUBE_FEED_URL"
+	hubURLEnvVar       = "NIA_YOUTUBE_HUB_URL"
+	callbackURLEnvVar  = "NIA_YOUTUBE_CALLBACK_URL"
+	listenAddrEnvVar   = "NIA_YOUTUBE_LISTEN_ADDR"
+	hubSecretEnvVar    = "NIA_YOUTUBE_HUB_SECRET"
+	pollIntervalEnvVar = "NIA_YOUTUBE_POLL_INTERVAL"
+)
+
+const (
+	defaultAPIURL       = "https://www.googleapis.com/youtube/v3"
+	defaultFeedURL      = "https://www.youtube.com/feeds/videos.xml"
+	defaultHubURL       = "https://pubsubhubbub.appspot.com/subscribe"
+	defaultListenAddr   = ":8082"
+	defaultPollInterval = 5 * time.Minute
+)
+
+//youtubeConfig holds the youtube settings read from the environment
+type youtubeConfig struct {
+	apiKey  string
+	apiURL  string
+	feedURL string
+	hubURL  string
+	//callbackURL is the public URL PubSubHubbub notifications should be sent to. If it is empty, live streams will
+	//only be detected by polling.
+	callbackURL  string
+	listenAddr   string
+	hubSecret    string
+	pollInterval time.Duration
+}
+
+func getConfigFromEnv() (*youtubeConfig, error) {
+	apiKey, exists := os.LookupEnv(apiKeyEnvVar)
+	if !exists {
+		logrus.Warnf("`%v` env variable was not set.", apiKeyEnvVar)
+		return nil, fmt.Errorf("`%v` env variable was not set", apiKeyEnvVar)
+	}
+	//Notifications can't be trusted without a secret, as anyone who knows the callback URL could send them
+	hubSecret, exists := os.LookupEnv(hubSecretEnvVar)
+	if !exists || hubSecret == "" {
+		logrus.Warnf("`%v` env variable was not set.", hubSecretEnvVar)
+		return nil, fmt.Errorf("`%v` env variable was not set", hubSecretEnvVar)
+	}
+	//The PubSubHubbub spec limits secrets to 200 bytes
+	if len(hubSecret) >= 200 {
+		return nil, fmt.Errorf("`%v` must be shorter than 200 bytes", hubSecretEnvVar)
+	}
+	conf := youtubeConfig{
+		apiKey:       apiKey,
+		apiURL:       envOrDefault(apiURLEnvVar, defaultAPIURL),
+		feedURL:      envOrDefault(feedURLEnvVar, defaultFeedURL),
+		hubURL:       envOrDefault(hubURLEnvVar, defaultHubURL),
+		callbackURL:  os.Getenv(callbackURLEnvVar),
+		listenAddr:   envOrDefault(listenAddrEnvVar, defaultListenAddr),
+		hubSecret:    hubSecret,
+		pollInterval: defaultPollInterval,
+	}
+	if interval, exists := os.LookupEnv(pollIntervalEnvVar); exists {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed <= 0 {
+			logrus.Warnf("`%v` should be a positive duration such as 5m, but was %v. Using default of %v.", pollIntervalEnvVar, interval, defaultPollInterval)
+		} else {
+			conf.pollInterval = parsed
+		}
+	}
+	if conf.callbackURL == "" {
+		logrus.Warnf("`%v` env variable was not set, so youtube streams will only be detected by polling every %v.", callbackURLEnvVar, conf.pollInterval)
+	}
+	return &conf, nil
+}
+
+func envOrDefault(envVar, def string) string {
+	val, exists := os.LookupEnv(envVar)
+	if !exists || val == "" {
+		return def
+	}
+	return val
+}
@@ -0,0 +1,493 @@
+//Package mockyoutube provides a small local stand-in for the parts of the YouTube Data API, the public channel feeds
+//and the PubSubHubbub hub that nia uses, so that the youtube provider can be run without access to YouTube.
+//
+//Channels are created automatically the first time they are looked up by handle, and broadcasts are started and
+//stopped either through the methods on Server or through the control endpoints under /mock:
+//
+//	POST /mock/live?handle=<handle>[&title=<title>&gaming=true&mature=true]
+//	POST /mock/offline?handle=<handle>
+package mockyoutube
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	categoryGaming = "20"
+	categoryPeople = "22"
+)
+
+//Channel is a youtube channel known to the mock server
+type Channel struct {
+	ID     string
+	Handle string
+	Title  string
+}
+
+//Broadcast contains the details of a live broadcast
+type Broadcast struct {
+	Title   string
+	Gaming  bool
+	Mature  bool
+	Viewers int
+}
+
+type video struct {
+	id        string
+	channel   *Channel
+	broadcast Broadcast
+	published time.Time
+	startedAt time.Time
+	endedAt   *time.Time
+}
+
+//HubSubscription is a PubSubHubbub subscription which has been verified by its subscriber
+type HubSubscription struct {
+	Callback string
+	Topic    string
+	Secret   string
+	Expires  time.Time
+}
+
+//Server is a mock youtube API, feed server and PubSubHubbub hub. It implements http.Handler.
+type Server struct {
+	mux        *http.ServeMux
+	httpClient *http.Client
+
+	lock          sync.Mutex
+	nextID        int
+	channels      map[string]*Channel
+	videos        []*video
+	subscriptions map[string]*HubSubscription
+}
+
+//New creates a mock youtube server
+func New() *Server {
+	s := &Server{
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		channels:      make(map[string]*Channel),
+		subscriptions: make(map[string]*HubSubscription),
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/youtube/v3/channels", s.requireKey(s.handleChannels))
+	s.mux.HandleFunc("/youtube/v3/videos", s.requireKey(s.handleVideos))
+	s.mux.HandleFunc("/youtube/v3/videoCategories", s.requireKey(s.handleCategories))
+	s.mux.HandleFunc("/feeds/videos.xml", s.handleFeed)
+	s.mux.HandleFunc("/hub", s.handleHub)
+	s.mux.HandleFunc("/mock/live", s.handleControlLive)
+	s.mux.HandleFunc("/mock/offline", s.handleControlOffline)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//AddChannel returns the channel with the provided handle, creating it if it doesn't already exist
+func (s *Server) AddChannel(handle string) Channel {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return *s.channelByHandle(handle)
+}
+
+//GoLive starts a new broadcast on a channel and notifies any hub subscribers
+func (s *Server) GoLive(handle string, broadcast Broadcast) Channel {
+	s.lock.Lock()
+	channel := s.channelByHandle(handle)
+	now := time.Now().UTC()
+	v := &video{
+		id:        fmt.Sprintf("vid%08d", s.newID()),
+		channel:   channel,
+		broadcast: broadcast,
+		published: now,
+		startedAt: now,
+	}
+	s.videos = append(s.videos, v)
+	s.lock.Unlock()
+	s.notify(v)
+	return *channel
+}
+
+//GoOffline ends any live broadcasts on a channel and notifies any hub subscribers
+func (s *Server) GoOffline(handle string) Channel {
+	s.lock.Lock()
+	channel := s.channelByHandle(handle)
+	now := time.Now().UTC()
+	var ended []*video
+	for _, v := range s.videos {
+		if v.channel == channel && v.endedAt == nil {
+			v.endedAt = &now
+			ended = append(ended, v)
+		}
+	}
+	s.lock.Unlock()
+	for _, v := range ended {
+		s.notify(v)
+	}
+	return *channel
+}
+
+//Subscriptions returns every verified hub subscription
+func (s *Server) Subscriptions() []HubSubscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([]HubSubscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		res = append(res, *sub)
+	}
+	return res
+}
+
+func (s *Server) newID() int {
+	s.nextID++
+	return s.nextID
+}
+
+//channelByHandle must be called with the lock held
+func (s *Server) channelByHandle(handle string) *Channel {
+	handle = strings.TrimPrefix(strings.ToLower(handle), "@")
+	for _, channel := range s.channels {
+		if channel.Handle == handle {
+			return channel
+		}
+	}
+	channel := &Channel{
+		ID:     fmt.Sprintf("UC%022d", s.newID()),
+		Handle: handle,
+		Title:  handle,
+	}
+	s.channels[channel.ID] = channel
+	return channel
+}
+
+func (s *Server) requireKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") == "" {
+			writeError(w, http.StatusForbidden, "The request is missing a valid API key.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.lock.Lock()
+	var channel *Channel
+	switch {
+	case query.Get("id") != "":
+		channel = s.channels[query.Get("id")]
+	case query.Get("forHandle") != "":
+		channel = s.channelByHandle(query.Get("forHandle"))
+	case query.Get("forUsername") != "":
+		channel = s.channelByHandle(query.Get("forUsername"))
+	}
+	items := []interface{}{}
+	if channel != nil {
+		items = append(items, channelResource(channel))
+	}
+	s.lock.Unlock()
+	writeJSON(w, map[string]interface{}{"items": items})
+}
+
+func (s *Server) handleVideos(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("id"), ",")
+	s.lock.Lock()
+	items := []interface{}{}
+	for _, id := range ids {
+		for _, v := range s.videos {
+			if v.id == id {
+				items = append(items, videoResource(v))
+			}
+		}
+	}
+	s.lock.Unlock()
+	writeJSON(w, map[string]interface{}{"items": items})
+}
+
+func (s *Server) handleCategories(w http.ResponseWriter, r *http.Request) {
+	titles := map[string]string{
+		categoryGaming: "Gaming",
+		categoryPeople: "People & Blogs",
+	}
+	items := []interface{}{}
+	for _, id := range strings.Split(r.URL.Query().Get("id"), ",") {
+		if title, exists := titles[id]; exists {
+			items = append(items, map[string]interface{}{
+				"id":      id,
+				"snippet": map[string]string{"title": title},
+			})
+		}
+	}
+	writeJSON(w, map[string]interface{}{"items": items})
+}
+
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	channelID := r.URL.Query().Get("channel_id")
+	s.lock.Lock()
+	channel, exists := s.channels[channelID]
+	if !exists {
+		s.lock.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var entries []*video
+	//Newest first, as in the real feed
+	for i := len(s.videos) - 1; i >= 0 && len(entries) < 15; i-- {
+		if s.videos[i].channel == channel {
+			entries = append(entries, s.videos[i])
+		}
+	}
+	body := atomFeed(channel, entries)
+	s.lock.Unlock()
+	w.Header().Set("Content-Type", "application/atom+xml")
+	w.Write(body)
+}
+
+//handleHub accepts subscription requests, verifying them with the subscriber in the background as the real hub does
+func (s *Server) handleHub(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	mode := r.PostForm.Get("hub.mode")
+	callback := r.PostForm.Get("hub.callback")
+	topic := r.PostForm.Get("hub.topic")
+	if (mode != "subscribe" && mode != "unsubscribe") || callback == "" || topic == "" {
+		http.Error(w, "hub.mode, hub.callback and hub.topic are required", http.StatusBadRequest)
+		return
+	}
+	lease, err := strconv.Atoi(r.PostForm.Get("hub.lease_seconds"))
+	if err != nil || lease <= 0 || lease > 432000 {
+		lease = 432000
+	}
+	sub := HubSubscription{
+		Callback: callback,
+		Topic:    topic,
+		Secret:   r.PostForm.Get("hub.secret"),
+		Expires:  time.Now().Add(time.Duration(lease) * time.Second),
+	}
+	w.WriteHeader(http.StatusAccepted)
+	go s.verify(mode, sub, lease)
+}
+
+func (s *Server) verify(mode string, sub HubSubscription, lease int) {
+	challenge := fmt.Sprintf("challenge%d", time.Now().UnixNano())
+	query := url.Values{
+		"hub.mode":          {mode},
+		"hub.topic":         {sub.Topic},
+		"hub.challenge":     {challenge},
+		"hub.lease_seconds": {strconv.Itoa(lease)},
+	}
+	verifyURL := sub.Callback
+	if strings.Contains(verifyURL, "?") {
+		verifyURL += "&" + query.Encode()
+	} else {
+		verifyURL += "?" + query.Encode()
+	}
+	resp, err := s.httpClient.Get(verifyURL)
+	if err != nil {
+		logrus.Warnf("Mock hub failed to verify %v of %v for %v due to error %v", mode, sub.Topic, sub.Callback, err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 || string(body) != challenge {
+		logrus.Warnf("Mock hub: subscriber %v did not confirm %v of %v", sub.Callback, mode, sub.Topic)
+		return
+	}
+	key := sub.Callback + " " + sub.Topic
+	s.lock.Lock()
+	if mode == "subscribe" {
+		s.subscriptions[key] = &sub
+	} else {
+		delete(s.subscriptions, key)
+	}
+	s.lock.Unlock()
+}
+
+//notify sends a notification for a video to every subscriber of its channel's topic
+func (s *Server) notify(v *video) {
+	s.lock.Lock()
+	body := atomFeed(v.channel, []*video{v})
+	var targets []HubSubscription
+	for _, sub := range s.subscriptions {
+		if topicChannelID(sub.Topic) == v.channel.ID && time.Now().Before(sub.Expires) {
+			targets = append(targets, *sub)
+		}
+	}
+	s.lock.Unlock()
+	for _, sub := range targets {
+		req, err := http.NewRequest(http.MethodPost, sub.Callback, strings.NewReader(string(body)))
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Content-Type", "application/atom+xml")
+		if sub.Secret != "" {
+			mac := hmac.New(sha1.New, []byte(sub.Secret))
+			mac.Write(body)
+			req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			logrus.Warnf("Mock hub failed to deliver notification to %v due to error %v", sub.Callback, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func (s *Server) handleControlLive(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	handle := query.Get("handle")
+	if handle == "" {
+		http.Error(w, "handle is required", http.StatusBadRequest)
+		return
+	}
+	broadcast := Broadcast{
+		Title:   query.Get("title"),
+		Gaming:  query.Get("gaming") == "true",
+		Mature:  query.Get("mature") == "true",
+		Viewers: 1,
+	}
+	if broadcast.Title == "" {
+		broadcast.Title = "Mock broadcast"
+	}
+	writeJSON(w, s.GoLive(handle, broadcast))
+}
+
+func (s *Server) handleControlOffline(w http.ResponseWriter, r *http.Request) {
+	handle := r.URL.Query().Get("handle")
+	if handle == "" {
+		http.Error(w, "handle is required", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.GoOffline(handle))
+}
+
+func topicChannelID(topic string) string {
+	parsed, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+	return parsed.Query().Get("channel_id")
+}
+
+func channelResource(channel *Channel) map[string]interface{} {
+	return map[string]interface{}{
+		"id": channel.ID,
+		"snippet": map[string]interface{}{
+			"title":     channel.Title,
+			"customUrl": "@" + channel.Handle,
+			"thumbnails": map[string]interface{}{
+				"high": map[string]string{"url": fmt.Sprintf("https://example.com/%v.png", channel.ID)},
+			},
+		},
+	}
+}
+
+func videoResource(v *video) map[string]interface{} {
+	category := categoryPeople
+	if v.broadcast.Gaming {
+		category = categoryGaming
+	}
+	liveContent := "live"
+	details := map[string]interface{}{
+		"actualStartTime":   v.startedAt,
+		"concurrentViewers": strconv.Itoa(v.broadcast.Viewers),
+	}
+	if v.endedAt != nil {
+		liveContent = "none"
+		details["actualEndTime"] = *v.endedAt
+		delete(details, "concurrentViewers")
+	}
+	rating := map[string]string{}
+	if v.broadcast.Mature {
+		rating["ytRating"] = "ytAgeRestricted"
+	}
+	return map[string]interface{}{
+		"id": v.id,
+		"snippet": map[string]interface{}{
+			"channelId":            v.channel.ID,
+			"channelTitle":         v.channel.Title,
+			"title":                v.broadcast.Title,
+			"categoryId":           category,
+			"liveBroadcastContent": liveContent,
+			"thumbnails": map[string]interface{}{
+				"high": map[string]string{"url": fmt.Sprintf("https://example.com/%v.jpg", v.id)},
+			},
+		},
+		"contentDetails":       map[string]interface{}{"contentRating": rating},
+		"liveStreamingDetails": details,
+	}
+}
+
+type feedEntry struct {
+	VideoID   string    `xml:"yt:videoId"`
+	ChannelID string    `xml:"yt:channelId"`
+	Title     string    `xml:"title"`
+	Published time.Time `xml:"published"`
+}
+
+type feed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	XmlnsYt string      `xml:"xmlns:yt,attr"`
+	Title   string      `xml:"title"`
+	Entries []feedEntry `xml:"entry"`
+}
+
+//atomFeed renders videos in the same format as the channel feeds and hub notifications
+func atomFeed(channel *Channel, videos []*video) []byte {
+	f := feed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		XmlnsYt: "http://www.youtube.com/xml/schemas/2015",
+		Title:   channel.Title,
+	}
+	for _, v := range videos {
+		f.Entries = append(f.Entries, feedEntry{
+			VideoID:   v.id,
+			ChannelID: channel.ID,
+			Title:     v.broadcast.Title,
+			Published: v.published,
+		})
+	}
+	body, err := xml.Marshal(f)
+	if err != nil {
+		logrus.Errorf("Mock youtube server failed to render feed due to error %v", err)
+		return nil
+	}
+	return append([]byte(xml.Header), body...)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "message": message},
+	})
+}
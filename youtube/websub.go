@@ -0,0 +1,172 @@
+package youtube
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const topicURLBase = "https://www.youtube.com/xml/feeds/videos.xml"
+
+//leaseSeconds is the subscription lease requested from the hub. The hub may grant a shorter one.
+const leaseSeconds = 10 * 24 * 60 * 60
+
+//leaseRenewMargin is how long before a lease expires that it will be renewed
+const leaseRenewMargin = 12 * time.Hour
+
+//subscribeRetryInterval is how long to wait before asking the hub again if it never confirmed a subscription
+const subscribeRetryInterval = time.Hour
+
+//maxNotificationSize is the largest notification body which will be read. Notifications normally contain a single
+//entry, so are only a few kilobytes.
+const maxNotificationSize = 1 << 20
+
+//maxNotificationChecks limits how many notified videos are looked up at once. Notifications arriving whilst this
+//many are already being checked are dropped, and any change they announced is picked up by the next poll instead.
+const maxNotificationChecks = 8
+
+//topicURL returns the PubSubHubbub topic for uploads and broadcasts on a channel
+func topicURL(channelID string) string {
+	return topicURLBase + "?" + url.Values{"channel_id": {channelID}}.Encode()
+}
+
+//topicChannelID extracts the channel ID from a topic URL
+func topicChannelID(topic string) string {
+	parsed, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+	return parsed.Query().Get("channel_id")
+}
+
+//requestHubSubscription asks the hub to start or stop sending notifications for a channel. The hub will confirm the
+//request asynchronously by calling handleVerification.
+func (y *EventSource) requestHubSubscription(channelID, mode string) error {
+	form := url.Values{
+		"hub.callback":      {y.conf.callbackURL},
+		"hub.topic":         {topicURL(channelID)},
+		"hub.mode":          {mode},
+		"hub.verify":        {"async"},
+		"hub.lease_seconds": {strconv.Itoa(leaseSeconds)},
+		"hub.secret":        {y.conf.hubSecret},
+	}
+	resp, err := y.api.httpClient.PostForm(y.conf.hubURL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("hub rejected %v request for channel %v with status %d: %v", mode, channelID, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+//ServeHTTP handles requests from the hub to the callback URL
+func (y *EventSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		y.handleVerification(w, r)
+	case http.MethodPost:
+		y.handleNotification(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//handleVerification confirms subscription requests we made with the hub, and takes note of how long they last
+func (y *EventSource) handleVerification(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode := query.Get("hub.mode")
+	channelID := topicChannelID(query.Get("hub.topic"))
+	y.lock.Lock()
+	state, wanted := y.channels[channelID]
+	switch {
+	case mode == "subscribe" && wanted:
+		lease, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil {
+			lease = leaseSeconds
+		}
+		state.leaseExpires = time.Now().Add(time.Duration(lease) * time.Second)
+	case mode == "unsubscribe" && !wanted:
+	case mode == "denied":
+		logrus.Warnf("PubSubHubbub hub denied subscription to youtube channel %v: %v", channelID, query.Get("hub.reason"))
+		y.lock.Unlock()
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		y.lock.Unlock()
+		logrus.Debugf("Refusing to confirm unexpected PubSubHubbub %v request for youtube channel %v", mode, channelID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	y.lock.Unlock()
+	logrus.Debugf("Confirmed PubSubHubbub %v request for youtube channel %v", mode, channelID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(query.Get("hub.challenge")))
+}
+
+//handleNotification handles a notification that a video on a subscribed channel has been published or updated
+func (y *EventSource) handleNotification(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxNotificationSize))
+	if err != nil {
+		logrus.Warnf("Failed to read PubSubHubbub notification from %v due to error %v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	//The hub expects a 2xx response even if the signature doesn't match, so that it can't be used to guess secrets
+	w.WriteHeader(http.StatusNoContent)
+	if !validSignature(y.conf.hubSecret, r.Header.Get("X-Hub-Signature"), body) {
+		logrus.Warnf("Ignoring PubSubHubbub notification with invalid signature from %v", r.RemoteAddr)
+		return
+	}
+	var feed atomFeed
+	err = xml.Unmarshal(body, &feed)
+	if err != nil {
+		logrus.Warnf("Failed to decode PubSubHubbub notification due to error %v", err)
+		return
+	}
+	for _, entry := range feed.Entries {
+		logrus.Debugf("Received PubSubHubbub notification for video %v on youtube channel %v", entry.VideoID, entry.ChannelID)
+		y.lock.Lock()
+		_, wanted := y.channels[entry.ChannelID]
+		y.lock.Unlock()
+		if !wanted {
+			continue
+		}
+		select {
+		case y.checks <- struct{}{}:
+			go func(channelID, videoID string) {
+				defer func() { <-y.checks }()
+				y.checkNotifiedVideo(channelID, videoID)
+			}(entry.ChannelID, entry.VideoID)
+		default:
+			logrus.Warnf("Too many youtube notifications are already being checked, so video %v on channel %v will be picked up by the next poll instead", entry.VideoID, entry.ChannelID)
+		}
+	}
+}
+
+//validSignature checks the X-Hub-Signature header of a notification, which contains an HMAC of the body
+func validSignature(secret, header string, body []byte) bool {
+	parts := strings.SplitN(header, "=", 2)
+	if len(parts) != 2 || parts[0] != "sha1" {
+		return false
+	}
+	sig, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
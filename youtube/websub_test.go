@@ -0,0 +1,337 @@
+package youtube
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/callummance/nia/dispatch"
+	"github.com/callummance/nia/streaming"
+	"github.com/callummance/nia/youtube/mockyoutube"
+)
+
+const (
+	testHubSecret    = "hub-secret-0123456789"
+	testEventTimeout = 5 * time.Second
+)
+
+//fakeHandler passes every event it receives to a channel, so that tests can wait for them
+type fakeHandler struct {
+	events chan interface{}
+}
+
+func (h *fakeHandler) HandleStreamOnline(ev *streaming.OnlineEvent)   { h.events <- ev }
+func (h *fakeHandler) HandleStreamOffline(ev *streaming.OfflineEvent) { h.events <- ev }
+
+//next waits for the handler to receive an event, failing the test if none arrives
+func (h *fakeHandler) next(t *testing.T) interface{} {
+	t.Helper()
+	select {
+	case ev := <-h.events:
+		return ev
+	case <-time.After(testEventTimeout):
+		t.Fatalf("timed out waiting for an event")
+		return nil
+	}
+}
+
+//expectNone fails the test if the handler receives an event within the provided duration
+func (h *fakeHandler) expectNone(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case ev := <-h.events:
+		t.Fatalf("got unexpected event %#v", ev)
+	case <-time.After(wait):
+	}
+}
+
+//testSource is an EventSource whose API requests and hub subscriptions go to a mock youtube server, and which
+//serves its callback URL on a local test server
+type testSource struct {
+	*EventSource
+	mock     *mockyoutube.Server
+	handler  *fakeHandler
+	callback string
+}
+
+func newTestSource(t *testing.T) *testSource {
+	mock := mockyoutube.New()
+	mockSrv := httptest.NewServer(mock)
+	h := &fakeHandler{events: make(chan interface{}, 100)}
+	events := dispatch.New(2, 100)
+	y := &EventSource{
+		conf: &youtubeConfig{
+			apiKey:       "test-key",
+			apiURL:       mockSrv.URL + "/youtube/v3",
+			feedURL:      mockSrv.URL + "/feeds/videos.xml",
+			hubURL:       mockSrv.URL + "/hub",
+			hubSecret:    testHubSecret,
+			pollInterval: defaultPollInterval,
+		},
+		handler:  h,
+		events:   events,
+		checks:   make(chan struct{}, maxNotificationChecks),
+		channels: make(map[string]*channelState),
+		stop:     make(chan struct{}),
+	}
+	callbackSrv := httptest.NewServer(y)
+	y.conf.callbackURL = callbackSrv.URL + "/websub"
+	y.api = newDataAPI(y.conf)
+	t.Cleanup(func() {
+		callbackSrv.Close()
+		events.Close()
+		mockSrv.Close()
+	})
+	return &testSource{EventSource: y, mock: mock, handler: h, callback: y.conf.callbackURL}
+}
+
+//notify posts a notification body to the callback URL, signed with the provided secret unless it is empty
+func (s *testSource) notify(t *testing.T, secret string, body []byte) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, s.callback, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("failed to create notification: %v", err)
+	}
+	if secret != "" {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+//watch starts watching a channel without asking the hub for notifications
+func (s *testSource) watch(channelID string) {
+	s.lock.Lock()
+	s.channels[channelID] = &channelState{}
+	s.lock.Unlock()
+}
+
+//liveVideoID returns the ID of the video a channel on the mock server is currently live with
+func (s *testSource) liveVideoID(t *testing.T, channelID string) string {
+	t.Helper()
+	video, err := s.liveVideo(channelID)
+	if err != nil || video == nil {
+		t.Fatalf("expected channel %v to be live, got %v, %v", channelID, video, err)
+	}
+	return video.ID
+}
+
+func notificationBody(channelID, videoID string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">
+  <title>YouTube video feed</title>
+  <entry>
+    <id>yt:video:` + videoID + `</id>
+    <yt:videoId>` + videoID + `</yt:videoId>
+    <yt:channelId>` + channelID + `</yt:channelId>
+    <title>Test broadcast</title>
+  </entry>
+</feed>`)
+}
+
+func TestHubSubscriptionChallenge(t *testing.T) {
+	s := newTestSource(t)
+	channel := s.mock.AddChannel("streamer")
+	err := s.Subscribe(channel.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	//The hub confirms the subscription by calling back with a challenge which must be echoed
+	deadline := time.Now().Add(testEventTimeout)
+	for len(s.mock.Subscriptions()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub never confirmed the subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sub := s.mock.Subscriptions()[0]
+	if sub.Secret != testHubSecret || topicChannelID(sub.Topic) != channel.ID {
+		t.Errorf("expected subscription to %v with the hub secret, got %+v", channel.ID, sub)
+	}
+	s.lock.Lock()
+	leaseExpires := s.channels[channel.ID].leaseExpires
+	s.lock.Unlock()
+	if leaseExpires.IsZero() {
+		t.Errorf("expected the lease granted by the hub to be recorded")
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		channelID  string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "Subscribe", mode: "subscribe", channelID: channel.ID, wantStatus: http.StatusOK, wantBody: "challenge123"},
+		{name: "SubscribeUnwatched", mode: "subscribe", channelID: "UC0000000000000000000000", wantStatus: http.StatusNotFound},
+		{name: "UnsubscribeWatched", mode: "unsubscribe", channelID: channel.ID, wantStatus: http.StatusNotFound},
+		{name: "UnsubscribeUnwatched", mode: "unsubscribe", channelID: "UC0000000000000000000000", wantStatus: http.StatusOK, wantBody: "challenge123"},
+		{name: "Denied", mode: "denied", channelID: channel.ID, wantStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{
+				"hub.mode":          {test.mode},
+				"hub.topic":         {topicURL(test.channelID)},
+				"hub.challenge":     {"challenge123"},
+				"hub.lease_seconds": {"3600"},
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/websub?"+query.Encode(), nil))
+			if rec.Code != test.wantStatus {
+				t.Errorf("expected status %v, got %v", test.wantStatus, rec.Code)
+			}
+			if rec.Body.String() != test.wantBody {
+				t.Errorf("expected body %q, got %q", test.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestNotificationSignature(t *testing.T) {
+	s := newTestSource(t)
+	channel := s.mock.GoLive("streamer", mockyoutube.Broadcast{Title: "Test broadcast"})
+	s.watch(channel.ID)
+	body := notificationBody(channel.ID, s.liveVideoID(t, channel.ID))
+
+	for name, secret := range map[string]string{"Unsigned": "", "WrongSecret": "not-the-hub-secret"} {
+		t.Run(name, func(t *testing.T) {
+			//The hub is told the notification was received even if it was rejected
+			if status := s.notify(t, secret, body); status != http.StatusNoContent {
+				t.Errorf("expected status %v, got %v", http.StatusNoContent, status)
+			}
+			s.handler.expectNone(t, 200*time.Millisecond)
+		})
+	}
+
+	s.notify(t, testHubSecret, body)
+	if ev, ok := s.handler.next(t).(*streaming.OnlineEvent); !ok || ev.ChannelID != channel.ID {
+		t.Errorf("expected online event for %v once the notification was signed, got %#v", channel.ID, ev)
+	}
+}
+
+func TestNotificationFeed(t *testing.T) {
+	s := newTestSource(t)
+	channel := s.mock.AddChannel("streamer")
+	err := s.Subscribe(channel.ID)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	deadline := time.Now().Add(testEventTimeout)
+	for len(s.mock.Subscriptions()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub never confirmed the subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//The hub sends the notifications itself, in the same format as the channel feed
+	s.mock.GoLive("streamer", mockyoutube.Broadcast{Title: "Test broadcast"})
+	if ev, ok := s.handler.next(t).(*streaming.OnlineEvent); !ok || ev.ChannelID != channel.ID || ev.ChannelName != channel.Title {
+		t.Errorf("expected online event for %v, got %#v", channel.ID, ev)
+	}
+	s.mock.GoOffline("streamer")
+	if ev, ok := s.handler.next(t).(*streaming.OfflineEvent); !ok || ev.ChannelID != channel.ID {
+		t.Errorf("expected offline event for %v, got %#v", channel.ID, ev)
+	}
+
+	//Notifications for channels which aren't watched are ignored
+	s.mock.GoLive("someone-else", mockyoutube.Broadcast{})
+	s.handler.expectNone(t, 200*time.Millisecond)
+}
+
+func TestParseNotification(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:at="http://purl.org/atompub/tombstones/1.0" xmlns="http://www.w3.org/2005/Atom">
+  <at:deleted-entry ref="yt:video:deleted01" when="2021-06-01T12:00:00+00:00"/>
+  <entry>
+    <id>yt:video:video0001</id>
+    <yt:videoId>video0001</yt:videoId>
+    <yt:channelId>UC0000000000000000000001</yt:channelId>
+    <title>First</title>
+  </entry>
+  <entry>
+    <id>yt:video:video0002</id>
+    <yt:videoId>video0002</yt:videoId>
+    <yt:channelId>UC0000000000000000000002</yt:channelId>
+    <title>Second</title>
+  </entry>
+</feed>`
+	var feed atomFeed
+	err := xml.Unmarshal([]byte(body), &feed)
+	if err != nil {
+		t.Fatalf("failed to parse notification: %v", err)
+	}
+	want := []atomEntry{
+		{VideoID: "video0001", ChannelID: "UC0000000000000000000001", Title: "First"},
+		{VideoID: "video0002", ChannelID: "UC0000000000000000000002", Title: "Second"},
+	}
+	if len(feed.Entries) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), feed.Entries)
+	}
+	for i := range want {
+		if feed.Entries[i] != want[i] {
+			t.Errorf("expected entry %d to be %+v, got %+v", i, want[i], feed.Entries[i])
+		}
+	}
+	if len(feed.Deleted) != 1 || feed.Deleted[0].Ref != "yt:video:deleted01" {
+		t.Errorf("expected deleted entry to be parsed, got %+v", feed.Deleted)
+	}
+}
+
+func TestNotificationTooLarge(t *testing.T) {
+	s := newTestSource(t)
+	channel := s.mock.GoLive("streamer", mockyoutube.Broadcast{})
+	s.watch(channel.ID)
+	body := notificationBody(channel.ID, s.liveVideoID(t, channel.ID))
+	body = append(body, []byte("<!--"+strings.Repeat("x", maxNotificationSize)+"-->")...)
+	if status := s.notify(t, testHubSecret, body); status != http.StatusBadRequest {
+		t.Errorf("expected status %v, got %v", http.StatusBadRequest, status)
+	}
+	s.handler.expectNone(t, 200*time.Millisecond)
+}
+
+func TestNotificationChecksBounded(t *testing.T) {
+	s := newTestSource(t)
+	channel := s.mock.GoLive("streamer", mockyoutube.Broadcast{})
+	s.watch(channel.ID)
+	body := notificationBody(channel.ID, s.liveVideoID(t, channel.ID))
+
+	//Whilst every check is in use, notifications are dropped rather than starting more
+	for i := 0; i < maxNotificationChecks; i++ {
+		s.checks <- struct{}{}
+	}
+	s.notify(t, testHubSecret, body)
+	s.handler.expectNone(t, 200*time.Millisecond)
+	if len(s.checks) != maxNotificationChecks {
+		t.Errorf("expected no checks to have started or finished, but %d are in use", len(s.checks))
+	}
+
+	for i := 0; i < maxNotificationChecks; i++ {
+		<-s.checks
+	}
+	s.notify(t, testHubSecret, body)
+	if _, ok := s.handler.next(t).(*streaming.OnlineEvent); !ok {
+		t.Errorf("expected online event once checks were available")
+	}
+	deadline := time.Now().Add(testEventTimeout)
+	for len(s.checks) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("check was never released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,380 @@
+package youtube
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/callummance/nia/dispatch"
+	"github.com/callummance/nia/streaming"
+	"github.com/sirupsen/logrus"
+)
+
+//ProviderName identifies youtube in the database and in commands
+const ProviderName = "youtube"
+
+const youtubeColourHex = 0xff0000
+
+var _ streaming.Provider = (*EventSource)(nil)
+
+var channelIDRegex = regexp.MustCompile(`^UC[a-zA-Z0-9_-]{22}$`)
+var channelRegex = regexp.MustCompile(`^(?:(?:https?://)?(?:(?:www|m)\.)?youtube\.com/)?(?:channel/(?P<id>UC[a-zA-Z0-9_-]{22})|user/(?P<user>[a-zA-Z0-9_.-]+)|(?:c/)?@?(?P<handle>[a-zA-Z0-9_.-]{3,30}))(?:/.*)?$`)
+
+//channelState contains what we know about a channel we are subscribed to
+type channelState struct {
+	//checked is set once the channel's live state has been checked at least once since starting
+	checked bool
+	//liveVideoID is the ID of the broadcast the channel is currently live with, if any
+	liveVideoID string
+	//leaseExpires is when the hub will stop sending notifications for this channel, or zero if it never confirmed
+	//our subscription
+	leaseExpires         time.Time
+	lastSubscribeRequest time.Time
+}
+
+//EventSource detects when youtube channels go live, using PubSubHubbub notifications where possible and polling the
+//channels' feeds to catch anything missed
+type EventSource struct {
+	conf    *youtubeConfig
+	api     *dataAPI
+	handler streaming.EventHandler
+	server  *http.Server
+	//events queues events so that those for the same channel are handled in order
+	events *dispatch.Dispatcher
+	//checks limits how many notified videos can be looked up at once
+	checks chan struct{}
+
+	lock     sync.Mutex
+	channels map[string]*channelState
+	stop     chan struct{}
+}
+
+//StartYoutubeListener starts watching the provided youtube channels for live broadcasts. Events are passed on to the
+//handler through the provided dispatcher.
+func StartYoutubeListener(handler streaming.EventHandler, initChannels []string, events *dispatch.Dispatcher) (*EventSource, error) {
+	logrus.Tracef("Starting youtube listener with requested channels %v", initChannels)
+	conf, err := getConfigFromEnv()
+	if err != nil {
+		logrus.Errorf("Failed to start youtube listener as %v", err)
+		return nil, err
+	}
+	res := &EventSource{
+		conf:     conf,
+		api:      newDataAPI(conf),
+		handler:  handler,
+		events:   events,
+		checks:   make(chan struct{}, maxNotificationChecks),
+		channels: make(map[string]*channelState, len(initChannels)),
+		stop:     make(chan struct{}),
+	}
+	if conf.callbackURL != "" {
+		callback, err := url.Parse(conf.callbackURL)
+		if err != nil {
+			return nil, fmt.Errorf("`%v` is not a valid URL: %v", callbackURLEnvVar, err)
+		}
+		path := callback.Path
+		if path == "" {
+			path = "/"
+		}
+		mux := http.NewServeMux()
+		mux.Handle(path, res)
+		res.server = &http.Server{Addr: conf.listenAddr, Handler: mux}
+		go func() {
+			err := res.server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logrus.Errorf("Youtube PubSubHubbub callback server stopped due to error %v", err)
+			}
+		}()
+	}
+	for _, channelID := range initChannels {
+		err := res.Subscribe(channelID)
+		if err != nil {
+			logrus.Warnf("Failed to subscribe to youtube channel %v due to error %v", channelID, err)
+		}
+	}
+	go res.poll()
+	return res, nil
+}
+
+//Close stops watching for youtube broadcasts
+func (y *EventSource) Close() error {
+	close(y.stop)
+	if y.server != nil {
+		return y.server.Close()
+	}
+	return nil
+}
+
+//Info returns static details about the youtube provider
+func (y *EventSource) Info() streaming.ProviderInfo {
+	return streaming.ProviderInfo{
+		Name:        ProviderName,
+		DisplayName: "YouTube",
+		Colour:      youtubeColourHex,
+	}
+}
+
+//ResolveChannel looks up a youtube channel from a channel ID, handle or channel URL
+func (y *EventSource) ResolveChannel(nameOrURL string) (*streaming.Channel, error) {
+	matches := channelRegex.FindStringSubmatch(strings.TrimSpace(nameOrURL))
+	if matches == nil {
+		return nil, fmt.Errorf("%v is not a youtube handle or channel URL", nameOrURL)
+	}
+	id := matches[channelRegex.SubexpIndex("id")]
+	user := matches[channelRegex.SubexpIndex("user")]
+	handle := matches[channelRegex.SubexpIndex("handle")]
+	if channelIDRegex.MatchString(handle) {
+		id, handle = handle, ""
+	} else if handle != "" {
+		handle = "@" + handle
+	}
+	channel, err := y.api.getChannel(id, handle, user)
+	if err != nil {
+		return nil, err
+	} else if channel == nil {
+		return nil, fmt.Errorf("no youtube channel found for %v", nameOrURL)
+	}
+	login := channel.Snippet.CustomURL
+	if login == "" {
+		login = channel.ID
+	}
+	return &streaming.Channel{
+		Provider:        ProviderName,
+		ID:              channel.ID,
+		Login:           login,
+		DisplayName:     channel.Snippet.Title,
+		URL:             channelURL(channel.ID),
+		ProfileImageURL: channel.Snippet.Thumbnails.largest(),
+	}, nil
+}
+
+//Subscribe starts watching a channel for live broadcasts
+func (y *EventSource) Subscribe(channelID string) error {
+	y.lock.Lock()
+	if _, exists := y.channels[channelID]; exists {
+		y.lock.Unlock()
+		return nil
+	}
+	state := &channelState{lastSubscribeRequest: time.Now()}
+	y.channels[channelID] = state
+	y.lock.Unlock()
+	if y.conf.callbackURL == "" {
+		return nil
+	}
+	return y.requestHubSubscription(channelID, "subscribe")
+}
+
+//Unsubscribe stops watching a channel
+func (y *EventSource) Unsubscribe(channelID string) error {
+	y.lock.Lock()
+	_, exists := y.channels[channelID]
+	delete(y.channels, channelID)
+	y.lock.Unlock()
+	if !exists || y.conf.callbackURL == "" {
+		return nil
+	}
+	return y.requestHubSubscription(channelID, "unsubscribe")
+}
+
+//GetLiveStreams retrieves details on each of the provided channels which are currently live, keyed by channel ID.
+//Channels which are not live will not have an entry in the returned map.
+func (y *EventSource) GetLiveStreams(channelIDs []string) (map[string]*streaming.LiveStream, error) {
+	res := make(map[string]*streaming.LiveStream, len(channelIDs))
+	for _, channelID := range channelIDs {
+		video, err := y.liveVideo(channelID)
+		if err != nil {
+			return nil, err
+		} else if video != nil {
+			res[channelID] = y.liveStream(video)
+		}
+	}
+	return res, nil
+}
+
+//ForceUpdate checks whether a channel is live and generates an online or offline event to match
+func (y *EventSource) ForceUpdate(channelID string) error {
+	video, err := y.liveVideo(channelID)
+	if err != nil {
+		return err
+	}
+	y.lock.Lock()
+	if state, exists := y.channels[channelID]; exists {
+		state.checked = true
+		state.liveVideoID = ""
+		if video != nil {
+			state.liveVideoID = video.ID
+		}
+	}
+	y.lock.Unlock()
+	//ForceUpdate can be called from within other events, so it mustn't wait for room in the queue
+	return y.events.TrySubmit(channelEventKey(channelID), y.event(channelID, video))
+}
+
+//liveVideo returns the broadcast a channel is currently live with, or nil if it is not live
+func (y *EventSource) liveVideo(channelID string) (*ytVideo, error) {
+	videoIDs, err := y.api.recentVideoIDs(channelID)
+	if err != nil || len(videoIDs) == 0 {
+		return nil, err
+	}
+	videos, err := y.api.getVideos(videoIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range videos {
+		if videos[i].isLive() {
+			return &videos[i], nil
+		}
+	}
+	return nil, nil
+}
+
+//poll periodically checks every channel in case any notifications were missed, and renews hub subscriptions
+func (y *EventSource) poll() {
+	y.pollChannels()
+	ticker := time.NewTicker(y.conf.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-y.stop:
+			return
+		case <-ticker.C:
+			y.pollChannels()
+		}
+	}
+}
+
+func (y *EventSource) pollChannels() {
+	y.lock.Lock()
+	channelIDs := make([]string, 0, len(y.channels))
+	var renew []string
+	now := time.Now()
+	for channelID, state := range y.channels {
+		channelIDs = append(channelIDs, channelID)
+		if y.conf.callbackURL == "" {
+			continue
+		}
+		expiring := !state.leaseExpires.IsZero() && state.leaseExpires.Sub(now) < leaseRenewMargin
+		unconfirmed := state.leaseExpires.IsZero() && now.Sub(state.lastSubscribeRequest) > subscribeRetryInterval
+		if expiring || unconfirmed {
+			state.lastSubscribeRequest = now
+			renew = append(renew, channelID)
+		}
+	}
+	y.lock.Unlock()
+
+	for _, channelID := range renew {
+		err := y.requestHubSubscription(channelID, "subscribe")
+		if err != nil {
+			logrus.Warnf("Failed to renew PubSubHubbub subscription for youtube channel %v due to error %v", channelID, err)
+		}
+	}
+	for _, channelID := range channelIDs {
+		video, err := y.liveVideo(channelID)
+		if err != nil {
+			logrus.Warnf("Failed to check live state of youtube channel %v due to error %v", channelID, err)
+			continue
+		}
+		y.updateChannel(channelID, video)
+	}
+}
+
+//checkNotifiedVideo checks a video we have been notified about, in case it is a new broadcast or one which has ended
+func (y *EventSource) checkNotifiedVideo(channelID, videoID string) {
+	videos, err := y.api.getVideos([]string{videoID})
+	if err != nil {
+		logrus.Warnf("Failed to look up notified youtube video %v due to error %v", videoID, err)
+		return
+	}
+	if len(videos) == 1 && videos[0].isLive() {
+		y.updateChannel(channelID, &videos[0])
+		return
+	}
+	//The video isn't live, but it might be the end of the broadcast the channel was live with
+	y.lock.Lock()
+	state, exists := y.channels[channelID]
+	ended := exists && state.liveVideoID == videoID
+	y.lock.Unlock()
+	if ended {
+		y.updateChannel(channelID, nil)
+	}
+}
+
+//updateChannel records the current live broadcast of a channel (nil if not live), generating an event if it has
+//changed since we last checked
+func (y *EventSource) updateChannel(channelID string, video *ytVideo) {
+	videoID := ""
+	if video != nil {
+		videoID = video.ID
+	}
+	y.lock.Lock()
+	state, exists := y.channels[channelID]
+	if !exists {
+		y.lock.Unlock()
+		return
+	}
+	changed := !state.checked || state.liveVideoID != videoID
+	state.checked = true
+	state.liveVideoID = videoID
+	y.lock.Unlock()
+	if changed {
+		err := y.events.Submit(channelEventKey(channelID), y.event(channelID, video))
+		if err != nil {
+			logrus.Warnf("Dropping youtube event for channel %v due to error %v", channelID, err)
+		}
+	}
+}
+
+//event returns a function which passes an online or offline event for a channel on to the handler
+func (y *EventSource) event(channelID string, video *ytVideo) func() {
+	return func() {
+		if video == nil {
+			y.handler.HandleStreamOffline(&streaming.OfflineEvent{
+				Provider:  ProviderName,
+				ChannelID: channelID,
+			})
+		} else {
+			y.handler.HandleStreamOnline(&streaming.OnlineEvent{
+				Provider:    ProviderName,
+				ChannelID:   channelID,
+				ChannelName: video.Snippet.ChannelTitle,
+			})
+		}
+	}
+}
+
+//channelEventKey returns the key used to make sure events for a channel are handled in the order they were found
+func channelEventKey(channelID string) string {
+	return "youtube/" + channelID
+}
+
+func (y *EventSource) liveStream(video *ytVideo) *streaming.LiveStream {
+	game, err := y.api.categoryName(video.Snippet.CategoryID)
+	if err != nil {
+		logrus.Warnf("Failed to look up youtube video category %v due to error %v", video.Snippet.CategoryID, err)
+	}
+	var startedAt time.Time
+	if video.LiveStreamingDetails.ActualStartTime != nil {
+		startedAt = *video.LiveStreamingDetails.ActualStartTime
+	}
+	return &streaming.LiveStream{
+		Provider:     ProviderName,
+		ChannelID:    video.Snippet.ChannelID,
+		ChannelName:  video.Snippet.ChannelTitle,
+		Title:        video.Snippet.Title,
+		Game:         game,
+		Viewers:      viewerCount(video),
+		IsMature:     video.ContentDetails.ContentRating.YtRating == "ytAgeRestricted",
+		StartedAt:    startedAt,
+		URL:          fmt.Sprintf("https://www.youtube.com/watch?v=%v", video.ID),
+		ChannelURL:   channelURL(video.Snippet.ChannelID),
+		ThumbnailURL: video.Snippet.Thumbnails.largest(),
+	}
+}
+
+func channelURL(channelID string) string {
+	return fmt.Sprintf("https://www.youtube.com/channel/%v", channelID)
+}